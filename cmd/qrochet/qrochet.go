@@ -7,14 +7,16 @@ import "io"
 import "log/slog"
 import "log/syslog"
 import "context"
-
-// import "os/signal"
+import "time"
+import "encoding/json"
+import "os/signal"
 
 import "aidanwoods.dev/go-paseto"
 
 import "github.com/qrochet/qrochet/pkg/app"
 import "github.com/qrochet/qrochet/pkg/env"
 import "github.com/qrochet/qrochet/pkg/mail"
+import "github.com/qrochet/qrochet/pkg/model"
 
 func setupSlog(level slog.Level, format, output, tag string) {
 	// Determine the log format
@@ -64,6 +66,139 @@ func key() {
 	os.Exit(0)
 }
 
+func reindex(ctx context.Context, q *app.Qrochet, args []string) {
+	defer q.Close()
+	if len(args) > 0 && args[0] == "queue" {
+		job, err := q.Repository.Jobs().Enqueue(ctx, model.JobReindex, nil)
+		if err != nil {
+			slog.Error("reindex", "err", err)
+			os.Exit(4)
+		}
+		fmt.Printf("Queued reindex job %s.\n", job.ID)
+		return
+	}
+	count, err := q.Reindex(ctx)
+	if err != nil {
+		slog.Error("reindex", "err", err)
+		os.Exit(4)
+	}
+	fmt.Printf("Indexed %d crafts.\n", count)
+}
+
+// parseTime parses a date or an RFC 3339 time for the audit command.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(time.DateOnly, s, time.Local)
+}
+
+func audit(ctx context.Context, q *app.Qrochet, args []string) {
+	defer q.Close()
+	var user, from, to string
+	var query model.AuditQuery
+	set := flag.NewFlagSet("audit", flag.ExitOnError)
+	set.StringVar(&user, "u", "", "email address or ID of the user")
+	set.StringVar(&from, "from", "", "date or RFC 3339 time to start at")
+	set.StringVar(&to, "to", "", "date or RFC 3339 time to end at")
+	set.IntVar(&query.Amount, "n", model.AuditAmount, "maximum amount of entries")
+	set.Parse(args)
+
+	var err error
+	query.From, err = parseTime(from)
+	if err == nil {
+		query.To, err = parseTime(to)
+	}
+	if err != nil {
+		slog.Error("audit", "err", err)
+		os.Exit(1)
+	}
+	if user != "" {
+		found, err := q.LookupUser(ctx, user)
+		if err != nil {
+			slog.Error("audit", "err", err, "user", user)
+			os.Exit(4)
+		}
+		query.UserID = found.ID
+	}
+
+	entries, err := q.Logic().QueryAudits(ctx, query)
+	if err != nil {
+		slog.Error("audit", "err", err)
+		os.Exit(4)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	for _, entry := range entries {
+		enc.Encode(entry)
+	}
+}
+
+func role(ctx context.Context, q *app.Qrochet, args []string) {
+	defer q.Close()
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s role EMAIL|ID ROLE\n", os.Args[0])
+		os.Exit(1)
+	}
+	user, err := q.LookupUser(ctx, args[0])
+	if err != nil {
+		slog.Error("role", "err", err, "user", args[0])
+		os.Exit(4)
+	}
+	var r model.Role
+	err = r.UnmarshalText([]byte(args[1]))
+	if err != nil {
+		slog.Error("role", "err", err, "role", args[1])
+		os.Exit(1)
+	}
+	changed, err := q.Logic().ChangeRole(ctx, "cli", user.ID, r)
+	if err != nil {
+		slog.Error("role", "err", err)
+		os.Exit(4)
+	}
+	fmt.Printf("Role of %s is now %s.\n", changed.Name, changed.Role)
+}
+
+func worker(ctx context.Context, q *app.Qrochet, args []string) {
+	defer q.Close()
+	if len(args) > 0 && args[0] == "dead" {
+		enc := json.NewEncoder(os.Stdout)
+		for job, err := range q.Repository.Jobs().Dead(ctx) {
+			if err != nil {
+				slog.Error("worker dead", "err", err)
+				os.Exit(4)
+			}
+			enc.Encode(job)
+		}
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+	go q.Relay(ctx)
+	err := q.Work(ctx)
+	if err != nil {
+		slog.Error("worker", "err", err)
+		os.Exit(4)
+	}
+}
+
+func logic(ctx context.Context, q *app.Qrochet) {
+	defer q.Close()
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	_, err := q.ServeLogic(ctx)
+	if err != nil {
+		slog.Error("logic", "err", err)
+		os.Exit(4)
+	}
+	<-ctx.Done()
+}
+
 func main() {
 	envErr := env.Read()
 
@@ -78,7 +213,17 @@ func main() {
 	flag.StringVar(&set.NATS, "n", env.String("QROCHET_NATS"), "QROCHET_NATS\tnats server to connect to, or nats+builtin:///path for a built in NATS server.")
 	flag.StringVar(&set.Addr, "a", env.String("QROCHET_ADDR"), "QROCHET_ADDR\taddress to listen on")
 	flag.StringVar(&set.Key, "k", env.String("QROCHET_PASETO"), "QROCHET_PASETO\tPASETO private key")
+	flag.StringVar(&set.URL, "u", env.String("QROCHET_URL"), "QROCHET_URL\tpublic URL of qrochet for links in mails, by default http:// with QROCHET_ADDR.")
+	flag.StringVar(&set.OIDCIssuer, "I", env.String("QROCHET_OIDC_ISSUER"), "QROCHET_OIDC_ISSUER\tissuer URL of an OpenID Connect provider to sign in with, or empty for none.")
+	flag.StringVar(&set.OIDCClientID, "i", env.String("QROCHET_OIDC_CLIENT_ID"), "QROCHET_OIDC_CLIENT_ID\tclient ID of qrochet at the OpenID Connect provider.")
+	flag.StringVar(&set.OIDCClientSecret, "s", env.String("QROCHET_OIDC_CLIENT_SECRET"), "QROCHET_OIDC_CLIENT_SECRET\tclient secret of qrochet at the OpenID Connect provider, or empty for a public client.")
+	flag.StringVar(&set.OIDCName, "N", env.String("QROCHET_OIDC_NAME"), "QROCHET_OIDC_NAME\tname of the OpenID Connect provider to show, by default the host of the issuer.")
 	flag.BoolVar(&set.Dev, "D", env.Bool("QROCHET_DEV"), "QROCHET_DEV\tset to true to enable dev mode and use local resources.")
+	flag.BoolVar(&set.NoWorker, "W", env.Bool("QROCHET_NO_WORKER"), "QROCHET_NO_WORKER\tset to true to not handle background jobs in the web server, run qrochet worker for them instead.")
+	flag.StringVar(&set.Censor, "C", env.String("QROCHET_CENSOR"), "QROCHET_CENSOR\tdirectory with extra censor word lists, one <lang>.txt file per language.")
+	flag.StringVar(&set.Proxies, "T", env.String("QROCHET_PROXIES"), "QROCHET_PROXIES\tcomma separated addresses or networks of trusted reverse proxies, whose X-Forwarded-For headers are believed.")
+	flag.BoolVar(&set.SharedRates, "R", env.Bool("QROCHET_SHARED_RATES"), "QROCHET_SHARED_RATES\tset to true to share rate limits between instances through NATS.")
+	flag.StringVar(&set.Challenge, "c", env.String("QROCHET_CHALLENGE"), "QROCHET_CHALLENGE\tchallenge of the registration form: pow (default), image or honeypot.")
 	flag.TextVar(&level, "L", slog.LevelInfo, "log level to use")
 	flag.StringVar(&SMTPServer, "M", SMTPServer, "SMTP_SERVER\tmail server to connect to, or empty to disable mailing.")
 	flag.StringVar(&SMTPUser, "U", SMTPUser, "SMTP_USER\tmail server user name")
@@ -104,6 +249,21 @@ func main() {
 		os.Exit(2)
 	}
 
+	if len(flag.Args()) > 0 && flag.Args()[0] == "audit" {
+		audit(ctx, q, flag.Args()[1:])
+		return
+	}
+
+	if len(flag.Args()) > 0 && flag.Args()[0] == "role" {
+		role(ctx, q, flag.Args()[1:])
+		return
+	}
+
+	if len(flag.Args()) > 0 && flag.Args()[0] == "reindex" {
+		reindex(ctx, q, flag.Args()[1:])
+		return
+	}
+
 	if SMTPServer != "" {
 		msrv := mail.NewServer(SMTPServer, SMTPUser, SMTPPass)
 		if err != nil {
@@ -113,6 +273,16 @@ func main() {
 		q.SetMailSender(msrv)
 	}
 
+	if len(flag.Args()) > 0 && flag.Args()[0] == "worker" {
+		worker(ctx, q, flag.Args()[1:])
+		return
+	}
+
+	if len(flag.Args()) > 0 && flag.Args()[0] == "logic" {
+		logic(ctx, q)
+		return
+	}
+
 	defer q.Close()
 
 	// ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
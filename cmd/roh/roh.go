@@ -0,0 +1,130 @@
+// roh is a command to list and call the methods of a roh server.
+//
+// Usage:
+//
+//	roh [-u url] [-t token] list
+//	roh [-u url] [-t token] call PATH [JSON]
+//
+// list lists the methods of the server with the schemas of their request
+// and response. call calls the method at PATH with the JSON request, or
+// with the JSON read from standard input if it is not given, and prints
+// the JSON response.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+import "github.com/qrochet/qrochet/pkg/env"
+import "github.com/qrochet/qrochet/pkg/roh"
+
+// defaultURL is the URL of the roh server if neither -u nor ROH_URL is set.
+// It is where a local Qrochet serves its methods.
+const defaultURL = "http://localhost:9637/roh"
+
+type rohc struct {
+	*roh.Client
+	timeout time.Duration
+}
+
+func (r rohc) fatal(form string, err error, args ...any) {
+	args = append(args, err)
+	fmt.Fprintf(os.Stderr, form+": %s\n", args...)
+	os.Exit(2)
+}
+
+func (r rohc) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), r.timeout)
+}
+
+func (r rohc) print(obj any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(obj)
+	if err != nil {
+		r.fatal("cannot print", err)
+	}
+}
+
+func (r rohc) list() {
+	ctx, cancel := r.context()
+	defer cancel()
+
+	disc, err := r.Discover(ctx)
+	if err != nil {
+		r.fatal("cannot list methods", err)
+	}
+	r.print(disc)
+}
+
+func (r rohc) call(path string, body string) {
+	if body == "" {
+		buf, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			r.fatal("cannot read request", err)
+		}
+		body = string(buf)
+	}
+	if !json.Valid([]byte(body)) {
+		r.fatal("request is not valid JSON", fmt.Errorf("%q", body))
+	}
+
+	ctx, cancel := r.context()
+	defer cancel()
+
+	res, err := roh.Call[json.RawMessage](ctx, r.Client, path, json.RawMessage(body))
+	if err != nil {
+		r.fatal("call %s failed", err, path)
+	}
+	r.print(res)
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] list|call PATH [JSON]\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	env.Read()
+
+	var target string
+	var token string
+	var r rohc
+	target = env.String("ROH_URL")
+	if target == "" {
+		target = defaultURL
+	}
+	flag.StringVar(&target, "u", target, "ROH_URL\tURL of the roh server")
+	flag.StringVar(&token, "t", env.String("ROH_TOKEN"), "ROH_TOKEN\tbearer token to authenticate with")
+	flag.DurationVar(&r.timeout, "T", roh.DefaultTimeout, "timeout of the call")
+	flag.Usage = usage
+	flag.Parse()
+
+	client, err := roh.NewClient(target)
+	if err != nil {
+		r.fatal("bad URL %s", err, target)
+	}
+	if token != "" {
+		client.SetToken(token)
+	}
+	r.Client = client
+
+	args := flag.Args()
+	switch {
+	case len(args) == 1 && args[0] == "list":
+		r.list()
+	case len(args) == 2 && args[0] == "call":
+		r.call(args[1], "")
+	case len(args) == 3 && args[0] == "call":
+		r.call(args[1], args[2])
+	default:
+		usage()
+		os.Exit(1)
+	}
+}
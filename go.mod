@@ -13,6 +13,7 @@ require (
 	github.com/tidwall/gjson v1.18.0
 	golang.org/x/crypto v0.37.0
 	golang.org/x/image v0.26.0
+	golang.org/x/text v0.24.0
 	golang.org/x/time v0.11.0
 )
 
@@ -33,5 +34,4 @@ require (
 	github.com/tidwall/sjson v1.2.5 // indirect
 	go.uber.org/automaxprocs v1.6.0 // indirect
 	golang.org/x/sys v0.32.0 // indirect
-	golang.org/x/text v0.24.0 // indirect
 )
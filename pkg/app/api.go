@@ -0,0 +1,394 @@
+package app
+
+import "encoding/json"
+import "io"
+import "log/slog"
+import "mime"
+import "errors"
+import "net/http"
+import "strconv"
+import "strings"
+
+import "github.com/qrochet/qrochet/pkg/model"
+import "github.com/qrochet/qrochet/pkg/roh"
+
+const (
+	// apiPrefix is the prefix of the paths of the JSON API.
+	apiPrefix = "/api/v1"
+
+	// apiMaxBody is the maximum size of a JSON request body.
+	apiMaxBody = 1 << 20
+
+	// apiMaxUpload is the maximum size of an uploaded image.
+	apiMaxUpload = 8 << 20
+)
+
+// apiCredentials is the body of a log in request.
+type apiCredentials struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Ticket   string `json:"ticket,omitempty"` // Ticket of the second step.
+	Code     string `json:"code,omitempty"`   // Code of the second step.
+}
+
+// apiDeleteAccount is the body of an account deletion request. Users
+// without password send an empty password, and need a fresh session.
+type apiDeleteAccount struct {
+	Password string `json:"password"`
+}
+
+// apiProfile is the body of a profile update request.
+type apiProfile struct {
+	Name  string      `json:"name"`
+	Theme model.Theme `json:"theme,omitempty"` // Theme is kept if empty.
+}
+
+// writeJSON writes obj as JSON with the status code.
+func writeJSON(wr http.ResponseWriter, status int, obj any) {
+	wr.Header().Set("Content-Type", "application/json")
+	wr.WriteHeader(status)
+	err := json.NewEncoder(wr).Encode(obj)
+	if err != nil {
+		slog.Error("writeJSON", "err", err)
+	}
+}
+
+// writeError writes the error as a model.Error JSON body. Errors of the
+// model get a matching status code, other errors are logged and answered
+// with a generic internal server error.
+func writeError(wr http.ResponseWriter, err error) {
+	merr, ok := model.AsError(err)
+	if !ok {
+		slog.Error("api", "err", err)
+	}
+	writeJSON(wr, merr.Code, merr)
+}
+
+// readJSON reads the JSON request body into obj. Bodies of other types
+// are refused, since browsers send those cross-site without asking.
+func readJSON(wr http.ResponseWriter, req *http.Request, obj any) error {
+	typ, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if typ != "application/json" {
+		return model.Error{Code: http.StatusUnsupportedMediaType, Message: "the request body must be application/json"}
+	}
+	rd := http.MaxBytesReader(wr, req.Body, apiMaxBody)
+	err := json.NewDecoder(rd).Decode(obj)
+	if err != nil {
+		return model.Error{Code: http.StatusBadRequest, Message: "invalid JSON body: " + err.Error()}
+	}
+	return nil
+}
+
+// apiView returns the view for the request with the session checked.
+// Unlike check it never writes an HTML page into the JSON response. The
+// session cookie is only accepted for requests that change nothing, since
+// browsers send it along with cross-site requests. Other requests need the
+// bearer token.
+func (q *Qrochet) apiView(wr http.ResponseWriter, req *http.Request) *view {
+	v := q.view()
+	err := v.authenticate(req)
+	if err != nil {
+		v.Session = nil
+	}
+	safe := req.Method == "GET" || req.Method == "HEAD"
+	if !safe && roh.BearerToken(req) == "" {
+		v.Session = nil
+	}
+	return v
+}
+
+// apiCheck is like apiView, but it writes an error and returns nil if the
+// role of the user requires two factor authentication that the user did
+// not set up yet, since the API cannot set it up.
+func (q *Qrochet) apiCheck(wr http.ResponseWriter, req *http.Request) *view {
+	v := q.apiView(wr, req)
+	if v.Session != nil && v.NeedsTOTP {
+		writeError(wr, model.ErrorTOTPRequired)
+		return nil
+	}
+	return v
+}
+
+// rangeQuery returns the range query from the first and amount parameters
+// of the request.
+func rangeQuery[T any](req *http.Request) model.RangeQuery[T] {
+	query := model.RangeQuery[T]{First: req.FormValue("first")}
+	query.Amount, _ = strconv.Atoi(req.FormValue("amount"))
+	return query
+}
+
+func (q *Qrochet) postAPILogin(wr http.ResponseWriter, req *http.Request) {
+	var creds apiCredentials
+	err := readJSON(wr, req, &creds)
+	if err != nil {
+		writeError(wr, err)
+		return
+	}
+
+	var user *model.User
+	var session *model.Session
+	if creds.Ticket != "" {
+		user, session, err = q.logic.LoginSecondFactor(req.Context(), creds.Ticket, creds.Code, sessionTimeout)
+	} else {
+		user, session, err = q.logic.Login(req.Context(), creds.Email, creds.Password, sessionTimeout)
+	}
+	var second *model.SecondFactorError
+	if errors.As(err, &second) {
+		writeJSON(wr, http.StatusAccepted, model.Accept{Ticket: second.Ticket})
+		return
+	}
+	if err != nil {
+		writeError(wr, err)
+		return
+	}
+	writeJSON(wr, http.StatusOK, model.Accept{Self: user.Redact(), Token: q.SessionToken(*session)})
+}
+
+func (q *Qrochet) postAPILogout(wr http.ResponseWriter, req *http.Request) {
+	v := q.apiView(wr, req)
+	err := q.logic.Logout(req.Context(), v.Session)
+	if err != nil {
+		writeError(wr, err)
+		return
+	}
+	wr.WriteHeader(http.StatusNoContent)
+}
+
+func (q *Qrochet) getAPIProfile(wr http.ResponseWriter, req *http.Request) {
+	v := q.apiCheck(wr, req)
+	if v == nil {
+		return
+	}
+	user, err := q.logic.Profile(req.Context(), v.Session)
+	if err != nil {
+		writeError(wr, err)
+		return
+	}
+	writeJSON(wr, http.StatusOK, user.Redact())
+}
+
+func (q *Qrochet) putAPIProfile(wr http.ResponseWriter, req *http.Request) {
+	v := q.apiCheck(wr, req)
+	if v == nil {
+		return
+	}
+	var profile apiProfile
+	err := readJSON(wr, req, &profile)
+	if err != nil {
+		writeError(wr, err)
+		return
+	}
+
+	var user *model.User
+	if profile.Theme != "" {
+		user, err = q.logic.UpdateProfile(req.Context(), v.Session, profile.Name, profile.Theme)
+	} else {
+		user, err = q.logic.UpdateName(req.Context(), v.Session, profile.Name)
+	}
+	if err != nil {
+		writeError(wr, err)
+		return
+	}
+	writeJSON(wr, http.StatusOK, user.Redact())
+}
+
+func (q *Qrochet) deleteAPIProfile(wr http.ResponseWriter, req *http.Request) {
+	v := q.apiCheck(wr, req)
+	if v == nil {
+		return
+	}
+	var body apiDeleteAccount
+	err := readJSON(wr, req, &body)
+	if err != nil {
+		writeError(wr, err)
+		return
+	}
+	err = q.logic.DeleteAccount(req.Context(), v.Session, body.Password)
+	if err != nil {
+		writeError(wr, err)
+		return
+	}
+	wr.WriteHeader(http.StatusNoContent)
+}
+
+func (q *Qrochet) getAPICrafts(wr http.ResponseWriter, req *http.Request) {
+	res, err := q.logic.PublicCrafts(req.Context(), rangeQuery[model.Craft](req))
+	if err != nil {
+		writeError(wr, err)
+		return
+	}
+	writeJSON(wr, http.StatusOK, res)
+}
+
+func (q *Qrochet) getAPIMyCrafts(wr http.ResponseWriter, req *http.Request) {
+	v := q.apiCheck(wr, req)
+	if v == nil {
+		return
+	}
+	crafts, err := q.logic.CraftsForSession(req.Context(), v.Session)
+	if err != nil {
+		writeError(wr, err)
+		return
+	}
+	res := model.Paginate(crafts, rangeQuery[model.Craft](req), func(c model.Craft) string { return c.ID })
+	writeJSON(wr, http.StatusOK, res)
+}
+
+func (q *Qrochet) getAPICraft(wr http.ResponseWriter, req *http.Request) {
+	craft, err := q.Repository.Craft().GetByID(req.Context(), req.PathValue("id"))
+	if err != nil || craft.Hidden {
+		writeError(wr, model.ErrorCraftNotFound)
+		return
+	}
+	writeJSON(wr, http.StatusOK, craft)
+}
+
+func (q *Qrochet) postAPICrafts(wr http.ResponseWriter, req *http.Request) {
+	v := q.apiCheck(wr, req)
+	if v == nil {
+		return
+	}
+	var craft model.Craft
+	err := readJSON(wr, req, &craft)
+	if err != nil {
+		writeError(wr, err)
+		return
+	}
+
+	created, err := q.logic.NewCraft(req.Context(), v.Session, craft)
+	if err != nil {
+		writeError(wr, err)
+		return
+	}
+	writeJSON(wr, http.StatusCreated, created)
+}
+
+func (q *Qrochet) putAPICraft(wr http.ResponseWriter, req *http.Request) {
+	v := q.apiCheck(wr, req)
+	if v == nil {
+		return
+	}
+	var craft model.Craft
+	err := readJSON(wr, req, &craft)
+	if err != nil {
+		writeError(wr, err)
+		return
+	}
+
+	craft.ID = req.PathValue("id")
+	updated, err := q.logic.UpdateCraft(req.Context(), v.Session, craft)
+	if err != nil {
+		writeError(wr, err)
+		return
+	}
+	writeJSON(wr, http.StatusOK, updated)
+}
+
+func (q *Qrochet) deleteAPICraft(wr http.ResponseWriter, req *http.Request) {
+	v := q.apiCheck(wr, req)
+	if v == nil {
+		return
+	}
+	err := q.logic.DeleteCraft(req.Context(), v.Session, req.PathValue("id"))
+	if err != nil {
+		writeError(wr, err)
+		return
+	}
+	wr.WriteHeader(http.StatusNoContent)
+}
+
+func (q *Qrochet) getAPIUploads(wr http.ResponseWriter, req *http.Request) {
+	v := q.apiCheck(wr, req)
+	if v == nil {
+		return
+	}
+	uploads, err := q.logic.Uploads(req.Context(), v.Session)
+	if err != nil {
+		writeError(wr, err)
+		return
+	}
+	res := model.Paginate(uploads, rangeQuery[model.Upload](req), func(u model.Upload) string { return string(u.ID) })
+	writeJSON(wr, http.StatusOK, res)
+}
+
+func (q *Qrochet) getAPIUpload(wr http.ResponseWriter, req *http.Request) {
+	v := q.apiCheck(wr, req)
+	if v == nil {
+		return
+	}
+	upload, err := q.logic.Upload(req.Context(), v.Session, req.PathValue("id"))
+	if err != nil {
+		writeError(wr, err)
+		return
+	}
+	writeJSON(wr, http.StatusOK, upload)
+}
+
+// postAPIUploads uploads an image, either as the request body or as the
+// image field of a multipart form. The title parameter is the title.
+func (q *Qrochet) postAPIUploads(wr http.ResponseWriter, req *http.Request) {
+	v := q.apiCheck(wr, req)
+	if v == nil {
+		return
+	}
+	if v.Session == nil {
+		writeError(wr, model.ErrorPleaseLogIn)
+		return
+	}
+
+	req.Body = http.MaxBytesReader(wr, req.Body, apiMaxUpload)
+	var rd io.Reader = req.Body
+	size := req.ContentLength
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
+		file, header, err := req.FormFile("image")
+		if err != nil {
+			writeError(wr, model.Error{Code: http.StatusBadRequest, Message: "image missing: " + err.Error()})
+			return
+		}
+		defer file.Close()
+		rd = file
+		size = header.Size
+	}
+
+	upload, err := q.logic.NewUpload(req.Context(), v.Session, req.FormValue("title"), rd, size)
+	if err != nil {
+		writeError(wr, err)
+		return
+	}
+	writeJSON(wr, http.StatusCreated, upload)
+}
+
+func (q *Qrochet) deleteAPIUpload(wr http.ResponseWriter, req *http.Request) {
+	v := q.apiCheck(wr, req)
+	if v == nil {
+		return
+	}
+	err := q.logic.DeleteUpload(req.Context(), v.Session, req.PathValue("id"))
+	if err != nil {
+		writeError(wr, err)
+		return
+	}
+	wr.WriteHeader(http.StatusNoContent)
+}
+
+// handleAPI registers the routes of the JSON API.
+func (q *Qrochet) handleAPI() {
+	q.ServeMux.HandleFunc("POST "+apiPrefix+"/login", q.postAPILogin)
+	q.ServeMux.HandleFunc("POST "+apiPrefix+"/logout", q.postAPILogout)
+	q.ServeMux.HandleFunc("GET "+apiPrefix+"/profile", q.getAPIProfile)
+	q.ServeMux.HandleFunc("PUT "+apiPrefix+"/profile", q.putAPIProfile)
+	q.ServeMux.HandleFunc("DELETE "+apiPrefix+"/profile", q.deleteAPIProfile)
+	q.ServeMux.HandleFunc("GET "+apiPrefix+"/crafts", q.getAPICrafts)
+	q.ServeMux.HandleFunc("POST "+apiPrefix+"/crafts", q.postAPICrafts)
+	q.ServeMux.HandleFunc("GET "+apiPrefix+"/crafts/{id}", q.getAPICraft)
+	q.ServeMux.HandleFunc("PUT "+apiPrefix+"/crafts/{id}", q.putAPICraft)
+	q.ServeMux.HandleFunc("DELETE "+apiPrefix+"/crafts/{id}", q.deleteAPICraft)
+	q.ServeMux.HandleFunc("GET "+apiPrefix+"/my/crafts", q.getAPIMyCrafts)
+	q.ServeMux.HandleFunc("GET "+apiPrefix+"/uploads", q.getAPIUploads)
+	q.ServeMux.HandleFunc("POST "+apiPrefix+"/uploads", q.postAPIUploads)
+	q.ServeMux.HandleFunc("GET "+apiPrefix+"/uploads/{id}", q.getAPIUpload)
+	q.ServeMux.HandleFunc("DELETE "+apiPrefix+"/uploads/{id}", q.deleteAPIUpload)
+	q.ServeMux.HandleFunc(apiPrefix+"/", func(wr http.ResponseWriter, req *http.Request) {
+		writeError(wr, model.Error{Code: http.StatusNotFound, Message: "no such API endpoint"})
+	})
+}
@@ -0,0 +1,323 @@
+package app
+
+import "bytes"
+import "context"
+import "encoding/json"
+import "errors"
+import "image"
+import "image/png"
+import "net/http"
+import "net/http/httptest"
+import "strings"
+import "testing"
+
+import "github.com/qrochet/qrochet/pkg/challenge"
+import "github.com/qrochet/qrochet/pkg/model"
+
+// newTestApp returns an app with a repository in a temporary directory and
+// the routes of the API.
+func newTestApp(t *testing.T) *Qrochet {
+	t.Helper()
+	q, err := New(context.Background(), Settings{
+		NATS:      "nats+builtin://" + t.TempDir(),
+		Addr:      "127.0.0.1:0",
+		Challenge: challenge.KindHoneypot,
+		NoWorker:  true,
+	})
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+	t.Cleanup(q.Repository.Close)
+	q.handleAPI()
+	return q
+}
+
+// apiDo does an API request with the JSON body and the bearer token, if
+// any, and decodes the JSON response into res, if not nil.
+func apiDo(t *testing.T, q *Qrochet, method, path, token string, body any, res any) int {
+	t.Helper()
+	var rd *bytes.Reader
+	switch b := body.(type) {
+	case nil:
+		rd = bytes.NewReader(nil)
+	case []byte:
+		rd = bytes.NewReader(b)
+	default:
+		buf, err := json.Marshal(b)
+		if err != nil {
+			t.Fatalf("json.Marshal: %s", err)
+		}
+		rd = bytes.NewReader(buf)
+	}
+	req := httptest.NewRequest(method, apiPrefix+path, rd)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	q.ServeMux.ServeHTTP(rec, req)
+
+	if rec.Body.Len() > 0 {
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s %s: content type %q: %s", method, path, ct, rec.Body)
+		}
+		if res != nil {
+			err := json.Unmarshal(rec.Body.Bytes(), res)
+			if err != nil {
+				t.Errorf("%s %s: %s: %s", method, path, err, rec.Body)
+			}
+		}
+	}
+	return rec.Code
+}
+
+// apiLogin registers a user and logs them in through the API.
+func apiLogin(t *testing.T, q *Qrochet, name, email string) (*model.User, string) {
+	t.Helper()
+	user, err := q.logic.Register(context.Background(), name, email, "hook and needle")
+	if err != nil {
+		t.Fatalf("Register: %s", err)
+	}
+	var accept model.Accept
+	status := apiDo(t, q, "POST", "/login", "", apiCredentials{Email: email, Password: "hook and needle"}, &accept)
+	if status != http.StatusOK || accept.Token == "" {
+		t.Fatalf("login: %d %+v", status, accept)
+	}
+	return user, accept.Token
+}
+
+func TestAPIAuth(t *testing.T) {
+	q := newTestApp(t)
+	_, token := apiLogin(t, q, "Alice", "alice@example.com")
+
+	var merr model.Error
+	status := apiDo(t, q, "POST", "/login", "", apiCredentials{Email: "alice@example.com", Password: "wrong password"}, &merr)
+	if status != http.StatusUnauthorized || merr.Code != status {
+		t.Errorf("login with a wrong password: %d %+v", status, merr)
+	}
+	status = apiDo(t, q, "GET", "/profile", "", nil, &merr)
+	if status != http.StatusUnauthorized {
+		t.Errorf("profile without token: %d", status)
+	}
+	status = apiDo(t, q, "GET", "/profile", "not a token", nil, &merr)
+	if status != http.StatusUnauthorized {
+		t.Errorf("profile with a bad token: %d", status)
+	}
+
+	var user model.User
+	status = apiDo(t, q, "GET", "/profile", token, nil, &user)
+	if status != http.StatusOK || user.Email != "alice@example.com" || strings.HasPrefix(user.Hash, "$") {
+		t.Errorf("profile: %d %+v", status, user)
+	}
+
+	status = apiDo(t, q, "PUT", "/profile", token, []byte("{broken"), &merr)
+	if status != http.StatusBadRequest {
+		t.Errorf("profile with broken JSON: %d", status)
+	}
+	status = apiDo(t, q, "PUT", "/profile", token, apiProfile{Name: " "}, &merr)
+	if status != http.StatusBadRequest || merr.Message != model.ErrorNameEmpty.Error() {
+		t.Errorf("profile with empty name: %d %+v", status, merr)
+	}
+	status = apiDo(t, q, "GET", "/crafts/nope", "", nil, &merr)
+	if status != http.StatusNotFound {
+		t.Errorf("missing craft: %d", status)
+	}
+	status = apiDo(t, q, "GET", "/nothing", "", nil, &merr)
+	if status != http.StatusNotFound {
+		t.Errorf("unknown endpoint: %d", status)
+	}
+
+	status = apiDo(t, q, "POST", "/logout", token, nil, nil)
+	if status != http.StatusNoContent {
+		t.Errorf("logout: %d", status)
+	}
+	status = apiDo(t, q, "GET", "/profile", token, nil, &merr)
+	if status != http.StatusUnauthorized {
+		t.Errorf("profile after logout: %d", status)
+	}
+}
+
+func TestAPITOTPRequired(t *testing.T) {
+	q := newTestApp(t)
+	staff, _ := apiLogin(t, q, "Dave", "dave@example.com")
+	_, token := apiLogin(t, q, "Erin", "erin@example.com")
+	ctx := context.Background()
+	_, err := q.logic.ChangeRole(ctx, "", staff.ID, model.RoleStaff)
+	if err != nil {
+		t.Fatalf("ChangeRole: %s", err)
+	}
+	_, err = q.logic.SetTOTPRequired(ctx, &model.Session{UserID: staff.ID}, model.RoleNone, true)
+	if err != nil {
+		t.Fatalf("SetTOTPRequired: %s", err)
+	}
+
+	var merr model.Error
+	for _, path := range []string{"/profile", "/my/crafts", "/uploads"} {
+		status := apiDo(t, q, "GET", path, token, nil, &merr)
+		if status != http.StatusForbidden || merr.Message != model.ErrorTOTPRequired.Error() {
+			t.Errorf("%s without TOTP: %d %+v", path, status, merr)
+		}
+	}
+	status := apiDo(t, q, "POST", "/crafts", token, model.Craft{Title: "Scarf"}, &merr)
+	if status != http.StatusForbidden {
+		t.Errorf("new craft without TOTP: %d %+v", status, merr)
+	}
+	status = apiDo(t, q, "POST", "/logout", token, nil, nil)
+	if status != http.StatusNoContent {
+		t.Errorf("logout without TOTP: %d", status)
+	}
+}
+
+func TestAPICSRF(t *testing.T) {
+	q := newTestApp(t)
+	_, token := apiLogin(t, q, "Alice", "alice@example.com")
+	do := func(method, path, contentType string, cookie bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, apiPrefix+path, strings.NewReader(`{"name":"Alicia"}`))
+		req.Header.Set("Content-Type", contentType)
+		if cookie {
+			req.AddCookie(&http.Cookie{Name: cookieName, Value: token})
+		} else {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		q.ServeMux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// A cross-site form can send a text/plain body with the cookie.
+	if rec := do("PUT", "/profile", "text/plain", false); rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("profile update with a text body: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do("PUT", "/profile", "application/json", true); rec.Code != http.StatusUnauthorized {
+		t.Errorf("profile update with the cookie: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do("GET", "/profile", "", true); rec.Code != http.StatusOK {
+		t.Errorf("profile with the cookie: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do("PUT", "/profile", "application/json; charset=utf-8", false); rec.Code != http.StatusOK {
+		t.Errorf("profile update: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestAPIInternalError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeError(rec, errors.New("nats: stream qro-user is broken"))
+	if rec.Code != http.StatusInternalServerError || strings.Contains(rec.Body.String(), "nats") {
+		t.Errorf("internal error: %d %s", rec.Code, rec.Body)
+	}
+}
+
+// apiCraft uploads an image and makes a craft with it through the API.
+func apiCraft(t *testing.T, q *Qrochet, token, title string) model.Craft {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	err := png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 4, 4)))
+	if err != nil {
+		t.Fatalf("png.Encode: %s", err)
+	}
+	var upload model.Upload
+	status := apiDo(t, q, "POST", "/uploads?title=image", token, buf.Bytes(), &upload)
+	if status != http.StatusCreated {
+		t.Fatalf("upload: %d %+v", status, upload)
+	}
+	var craft model.Craft
+	status = apiDo(t, q, "POST", "/crafts", token, model.Craft{Title: title, Image: upload.ID}, &craft)
+	if status != http.StatusCreated {
+		t.Fatalf("craft: %d %+v", status, craft)
+	}
+	return craft
+}
+
+func TestAPIDelete(t *testing.T) {
+	q := newTestApp(t)
+	ctx := context.Background()
+	alice, aliceToken := apiLogin(t, q, "Alice", "alice@example.com")
+	bob, bobToken := apiLogin(t, q, "Bob", "bob@example.com")
+	bobSession := &model.Session{UserID: bob.ID}
+	aliceSession := &model.Session{UserID: alice.ID}
+
+	scarf := apiCraft(t, q, aliceToken, "Scarf")
+	hat := apiCraft(t, q, aliceToken, "Hat")
+	mittens := apiCraft(t, q, bobToken, "Mittens")
+	for _, craft := range []model.Craft{scarf, hat} {
+		_, err := q.logic.SetLike(ctx, bobSession, craft.ID, true)
+		if err != nil {
+			t.Fatalf("SetLike: %s", err)
+		}
+		_, err = q.logic.NewComment(ctx, bobSession, craft.ID, "", "Lovely!")
+		if err != nil {
+			t.Fatalf("NewComment: %s", err)
+		}
+	}
+	_, err := q.logic.SetLike(ctx, aliceSession, mittens.ID, true)
+	if err != nil {
+		t.Fatalf("SetLike: %s", err)
+	}
+	_, err = q.logic.NewComment(ctx, aliceSession, mittens.ID, "", "Warm!")
+	if err != nil {
+		t.Fatalf("NewComment: %s", err)
+	}
+	err = q.logic.SetFollow(ctx, bobSession, alice.ID, true)
+	if err != nil {
+		t.Fatalf("SetFollow: %s", err)
+	}
+
+	var merr model.Error
+	status := apiDo(t, q, "DELETE", "/crafts/"+scarf.ID, bobToken, nil, &merr)
+	if status != http.StatusNotFound {
+		t.Errorf("delete the craft of another user: %d", status)
+	}
+	status = apiDo(t, q, "DELETE", "/crafts/"+scarf.ID, aliceToken, nil, nil)
+	if status != http.StatusNoContent {
+		t.Fatalf("delete craft: %d", status)
+	}
+	if n := q.logic.Likes(ctx, scarf.ID); n != 0 {
+		t.Errorf("%d likes left on the deleted craft", n)
+	}
+	comments, _ := q.logic.Comments(ctx, scarf.ID)
+	if len(comments) != 0 {
+		t.Errorf("%d comments left on the deleted craft", len(comments))
+	}
+	_, err = q.Repository.Image().Get(ctx, string(scarf.Image))
+	if err == nil {
+		t.Errorf("image of the deleted craft left")
+	}
+
+	status = apiDo(t, q, "DELETE", "/uploads/"+string(hat.Image), aliceToken, nil, &merr)
+	if status != http.StatusConflict {
+		t.Errorf("delete the image of a craft: %d %+v", status, merr)
+	}
+	status = apiDo(t, q, "DELETE", "/profile", aliceToken, apiDeleteAccount{Password: "wrong"}, &merr)
+	if status != http.StatusUnauthorized {
+		t.Errorf("delete account with a wrong password: %d %+v", status, merr)
+	}
+	status = apiDo(t, q, "DELETE", "/profile", aliceToken, apiDeleteAccount{Password: "hook and needle"}, nil)
+	if status != http.StatusNoContent {
+		t.Fatalf("delete account: %d", status)
+	}
+	_, err = q.Repository.Craft().GetByID(ctx, hat.ID)
+	if err == nil {
+		t.Errorf("craft of the deleted account left")
+	}
+	_, err = q.Repository.Image().Get(ctx, string(hat.Image))
+	if err == nil {
+		t.Errorf("image of the deleted account left")
+	}
+	if n := q.logic.Likes(ctx, mittens.ID); n != 0 {
+		t.Errorf("%d likes of the deleted account left", n)
+	}
+	comments, _ = q.logic.Comments(ctx, mittens.ID)
+	if len(comments) != 0 {
+		t.Errorf("%d comments of the deleted account left", len(comments))
+	}
+	following, _ := q.logic.Following(ctx, bobSession)
+	if len(following) != 0 {
+		t.Errorf("follows of the deleted account left: %v", following)
+	}
+	status = apiDo(t, q, "GET", "/profile", aliceToken, nil, &merr)
+	if status != http.StatusUnauthorized && status != http.StatusNotFound {
+		t.Errorf("profile of the deleted account: %d", status)
+	}
+}
@@ -9,13 +9,21 @@ import "io"
 import "log/slog"
 import "context"
 import "embed"
+import "fmt"
 import "html/template"
 import "aidanwoods.dev/go-paseto"
+import nats "github.com/nats-io/nats.go"
 
 import (
+	"github.com/qrochet/qrochet/pkg/censor"
+	"github.com/qrochet/qrochet/pkg/challenge"
 	"github.com/qrochet/qrochet/pkg/doc"
+	"github.com/qrochet/qrochet/pkg/limit"
 	"github.com/qrochet/qrochet/pkg/model"
+	"github.com/qrochet/qrochet/pkg/oidc"
 	"github.com/qrochet/qrochet/pkg/repo"
+	"github.com/qrochet/qrochet/pkg/roh"
+	"github.com/qrochet/qrochet/pkg/search"
 )
 
 var templateFuncs = template.FuncMap{
@@ -34,21 +42,55 @@ type Template interface {
 }
 
 type Settings struct {
-	NATS string
-	Addr string
-	Key  string
-	Dev  bool
+	NATS   string
+	Addr   string
+	Key    string
+	Dev    bool
+	Censor string // Censor is a directory with extra censor word lists.
+	// NoWorker disables handling background jobs in the web server,
+	// for when they are handled by qrochet worker processes instead.
+	NoWorker bool
+	// Proxies are the comma separated addresses or networks of the trusted
+	// reverse proxies, whose X-Forwarded-For headers are believed.
+	Proxies string
+	// SharedRates makes the rate limits hold for all instances that use
+	// the same NATS server, instead of for each instance on its own.
+	SharedRates bool
+	// Challenge is the kind of challenge of the registration form, see
+	// package challenge. The default is proof of work.
+	Challenge string
+	// URL is the public URL of Qrochet, for links in mails. The default is
+	// http:// with Addr.
+	URL string
+	// OIDCIssuer is the issuer URL of an OpenID Connect provider to sign in
+	// with, or empty for none. OIDCClientID and OIDCClientSecret are the
+	// client registered for Qrochet at the provider, with the redirect URL
+	// URL + "/login/oidc", and OIDCName is the name of the provider to show.
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCName         string
 }
 
 type Qrochet struct {
 	http.Server
-	*RemoteAddrRateLimiter
+	*limit.Limiter
 	*http.ServeMux
 	model.Repository
 	*template.Template
-	sub  fs.FS
-	Key  paseto.V4SymmetricKey
-	msrv model.Sender
+	sub       fs.FS
+	Key       paseto.V4SymmetricKey
+	msrv      model.Sender
+	logic     *model.Logic
+	events    *eventHub
+	search    *search.Index
+	challenge *challenge.Issuer // challenge checks that registrations are made by humans.
+	url       string
+	oidc      *oidc.Provider
+	oidcName  string
+	conn      *nats.Conn
+	worker    bool
+	roh       *roh.Server // roh serves the methods of Qrochet at rohPrefix.
 }
 
 func New(ctx context.Context, s Settings) (*Qrochet, error) {
@@ -64,6 +106,15 @@ func New(ctx context.Context, s Settings) (*Qrochet, error) {
 		}
 	}
 
+	if s.Challenge == "" {
+		s.Challenge = challenge.KindProofOfWork
+	}
+	c, err := challenge.New(s.Challenge)
+	if err != nil {
+		return nil, fmt.Errorf("challenge %q: %w", s.Challenge, err)
+	}
+	q.challenge = challenge.NewIssuer(c, q.Key)
+
 	q.Template = template.New("").Funcs(templateFuncs)
 
 	if s.Dev {
@@ -78,10 +129,18 @@ func New(ctx context.Context, s Settings) (*Qrochet, error) {
 		}
 	}
 
-	q.RemoteAddrRateLimiter = NewRemoteAddrRateLimiter(1, 4)
+	q.worker = !s.NoWorker
+	q.events = newEventHub()
+	proxies, err := limit.ParseProxies(s.Proxies)
+	if err != nil {
+		return nil, err
+	}
+	q.Limiter = limit.New(RatePolicies, proxies)
 	q.Server.Addr = s.Addr
+	q.url = publicURL(s.URL, s.Addr)
+	q.oidc, q.oidcName = newOIDCProvider(s, q.url)
 	q.ServeMux = http.NewServeMux()
-	q.Server.Handler = q.RemoteAddrRateLimiter.Middleware(q.ServeMux)
+	q.Server.Handler = q.withClient(q.Limiter.Middleware(q.ServeMux))
 	if s.Dev {
 		q.sub = os.DirFS("pkg/app/web")
 	} else {
@@ -96,11 +155,44 @@ func New(ctx context.Context, s Settings) (*Qrochet, error) {
 		return nil
 	})
 
-	q.Repository, err = repo.Open(s.NATS)
+	r, err := repo.Open(s.NATS)
 	if err != nil {
 		return nil, err
 	}
+	q.Repository = r
+	q.conn = r.Conn
+	if s.SharedRates {
+		q.Limiter.Store = r.Rates()
+	}
+	q.challenge.Nonces = r.Nonces()
 	slog.Info("NATS connected", "URL", s.NATS)
+
+	if s.Censor != "" {
+		err = censor.Default.LoadDir(s.Censor)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("censor word lists loaded", "dir", s.Censor)
+	}
+
+	kv, err := r.Bucket(ctx, "censor")
+	if err != nil {
+		return nil, err
+	}
+	err = censor.Default.LoadKeyValue(ctx, kv)
+	if err != nil {
+		return nil, err
+	}
+
+	kv, err = r.Bucket(ctx, "search")
+	if err != nil {
+		return nil, err
+	}
+	q.search = search.New(kv)
+
+	q.logic = model.NewLogic(q.Repository, nil)
+	q.roh = q.newRoh()
+	q.handleRoh()
 	return q, nil
 }
 
@@ -110,10 +202,16 @@ func (q *Qrochet) Close() {
 	q.Server.Close()
 }
 
+// Logic returns the business logic of Qrochet, for the commands.
+func (q *Qrochet) Logic() *model.Logic {
+	return q.logic
+}
+
 // SetMailSender sets the mail sender to use.
 // If not set or nil, no mails will be sent.
 func (q *Qrochet) SetMailSender(msrv model.Sender) {
 	q.msrv = msrv
+	q.logic.Sender = msrv
 	if q.msrv == nil {
 		slog.Warn("sending of mails disabled")
 	} else {
@@ -131,7 +229,7 @@ func (q *Qrochet) index(wr http.ResponseWriter, req *http.Request) {
 	slog.Info("index")
 	err := q.Template.ExecuteTemplate(wr, "index.tmpl.html", view)
 	if err != nil {
-		slog.Error("index", err)
+		slog.Error("index", "err", err)
 	}
 }
 
@@ -142,16 +240,55 @@ func (q *Qrochet) ListenAndServe(ctx context.Context) {
 	}
 	q.ServeMux.HandleFunc("/", q.index)
 	q.ServeMux.HandleFunc("/register", q.register)
+	q.ServeMux.HandleFunc("GET /challenge/image", q.getChallengeImage)
 	q.ServeMux.HandleFunc("/login", q.login)
+	q.ServeMux.HandleFunc("GET /login/link", q.getLoginLink)
+	q.ServeMux.HandleFunc("POST /login/link", q.postLoginLink)
+	q.ServeMux.HandleFunc("GET /login/oidc", q.getLoginOIDC)
 	q.ServeMux.HandleFunc("/logout", q.logout)
 	q.ServeMux.HandleFunc("GET /my/craft", q.getMyCraft)
 	q.ServeMux.HandleFunc("GET /my/crafts", q.getMyCrafts)
 	q.ServeMux.HandleFunc("POST /my/craft", q.postMyCraft)
 	q.ServeMux.HandleFunc("GET /upload/{id}", q.getUpload)
+	q.ServeMux.HandleFunc("GET /gallery", q.getGallery)
+	q.ServeMux.HandleFunc("POST /craft/{id}/like", q.postLike)
+	q.ServeMux.HandleFunc("GET /my/favourites", q.getMyFavourites)
+	q.ServeMux.HandleFunc("GET /my/profile", q.getMyProfile)
+	q.ServeMux.HandleFunc("POST /my/profile", q.postMyProfile)
+	q.ServeMux.HandleFunc("GET /my/email", q.getMyEmail)
+	q.ServeMux.HandleFunc("GET /avatar/{id}", q.getAvatar)
+	q.ServeMux.HandleFunc("GET /my/twofactor", q.getMyTwoFactor)
+	q.ServeMux.HandleFunc("POST /my/twofactor", q.postMyTwoFactor)
+	q.ServeMux.HandleFunc("GET /craft/{id}/comments", q.getComments)
+	q.ServeMux.HandleFunc("POST /craft/{id}/comments", q.postComments)
+	q.ServeMux.HandleFunc("POST /user/{id}/follow", q.postFollow)
+	q.ServeMux.HandleFunc("GET /feed", q.getFeed)
+	q.ServeMux.HandleFunc("GET /feed/next", q.getFeedNext)
+	q.ServeMux.HandleFunc("GET /events", q.getEvents)
+	q.ServeMux.HandleFunc("GET /search", q.getSearch)
+	q.handleAPI()
+	q.ServeMux.HandleFunc("GET /report", q.getReport)
+	q.ServeMux.HandleFunc("POST /report", q.postReport)
+	q.ServeMux.HandleFunc("GET /staff/reports", q.getStaffReports)
+	q.ServeMux.HandleFunc("POST /staff/reports", q.postStaffReports)
+	q.ServeMux.HandleFunc("GET /staff/audit", q.getStaffAudit)
+	q.ServeMux.HandleFunc("POST /staff/audit", q.postStaffAudit)
 	q.ServeMux.Handle("/web/",
 		http.StripPrefix("/web/", http.FileServer(http.FS(q.sub))),
 	)
 
+	q.watchEvents(ctx)
+	go q.watchSearch(ctx)
+	go q.Relay(ctx)
+	if q.worker {
+		go func() {
+			err := q.Work(ctx)
+			if err != nil {
+				slog.Error("Work", "err", err)
+			}
+		}()
+	}
+
 	defer func() {
 		<-ctx.Done()
 		slog.Info("qrochet interrupted")
@@ -0,0 +1,176 @@
+package app
+
+import "log/slog"
+import "net/http"
+import "strconv"
+import "strings"
+import "time"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+// auditDays is how many days back the audit page shows by default.
+const auditDays = 7
+
+// auditDate is the layout of the dates of the audit form.
+const auditDate = "2006-01-02"
+
+// audit is the audit log page of the staff area.
+type audit struct {
+	User    string // User is the email address or ID of the user to show.
+	From    string
+	To      string
+	Role    string
+	Entries []model.AuditEntry
+	Roles   []model.Role
+	Policy  model.Policy
+}
+
+// withClient puts the client of the request in its context, for the
+// audit log.
+func (q *Qrochet) withClient(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+		client := model.Client{IP: q.Limiter.Proxies.ClientIP(req), UserAgent: req.UserAgent()}
+		next.ServeHTTP(wr, req.WithContext(model.WithClient(req.Context(), client)))
+	})
+}
+
+// LookupUser returns the user with the email address or ID.
+func (q *Qrochet) LookupUser(ctx model.Context, emailOrID string) (*model.User, error) {
+	if strings.Contains(emailOrID, "@") {
+		user, err := q.Repository.User().GetByEmail(ctx, emailOrID)
+		if err != nil || user == nil {
+			return nil, model.ErrorUserNotFound
+		}
+		return user, nil
+	}
+	user, err := q.Repository.User().Get(ctx, emailOrID)
+	if err != nil {
+		return nil, model.ErrorUserNotFound
+	}
+	return &user, nil
+}
+
+// auditQuery returns the query of the audit form of the request.
+func (q *Qrochet) auditQuery(v *view, req *http.Request) (model.AuditQuery, error) {
+	query := model.AuditQuery{}
+	v.Audit.User = strings.TrimSpace(req.FormValue("user"))
+	v.Audit.From = req.FormValue("from")
+	v.Audit.To = req.FormValue("to")
+	if v.Audit.From == "" {
+		v.Audit.From = time.Now().AddDate(0, 0, -auditDays).Format(auditDate)
+	}
+
+	var err error
+	query.From, err = time.ParseInLocation(auditDate, v.Audit.From, time.Local)
+	if err != nil {
+		return query, err
+	}
+	if v.Audit.To != "" {
+		query.To, err = time.ParseInLocation(auditDate, v.Audit.To, time.Local)
+		if err != nil {
+			return query, err
+		}
+		// Up to and including the day.
+		query.To = query.To.AddDate(0, 0, 1)
+	}
+	query.Amount, _ = strconv.Atoi(req.FormValue("amount"))
+
+	if v.Audit.User != "" {
+		user, err := q.LookupUser(req.Context(), v.Audit.User)
+		if err != nil {
+			return query, err
+		}
+		query.UserID = user.ID
+	}
+	return query, nil
+}
+
+// displayAudit shows the audit log with the query of the request.
+func (q *Qrochet) displayAudit(wr http.ResponseWriter, req *http.Request, v *view) {
+	v.Audit.Roles = []model.Role{model.RoleNone, model.RoleGuest, model.RoleStart,
+		model.RoleHobby, model.RolePro, model.RoleStaff}
+	v.Audit.Policy = q.logic.Policy(req.Context())
+
+	query, err := q.auditQuery(v, req)
+	if err != nil {
+		v.DisplayError(wr, req, "%s", err)
+		return
+	}
+	v.Audit.Entries, err = q.logic.AuditLog(req.Context(), v.Session, query)
+	if err != nil {
+		v.DisplayError(wr, req, "%s", err)
+		return
+	}
+	v.Display(wr, req)
+}
+
+func (q *Qrochet) getStaffAudit(wr http.ResponseWriter, req *http.Request) {
+	v := q.view()
+	if !v.IsLoggedIn(wr, req) {
+		v.DisplayError(wr, req, "Please log in.")
+		return
+	}
+	q.displayAudit(wr, req, v)
+}
+
+// postStaffAudit changes the role of the user of the audit page.
+func (q *Qrochet) postStaffAudit(wr http.ResponseWriter, req *http.Request) {
+	v := q.view()
+	if !v.IsLoggedIn(wr, req) {
+		v.DisplayError(wr, req, "Please log in.")
+		return
+	}
+
+	err := req.ParseMultipartForm(mpfMaxMemory)
+	if err != nil {
+		slog.Error("postStaffAudit req.ParseForm", "err", err)
+		v.DisplayError(wr, req, "Form error.")
+		return
+	}
+
+	if totp := req.FormValue("totp"); totp != "" {
+		q.postStaffPolicy(wr, req, v, totp == "require")
+		return
+	}
+
+	user, err := q.LookupUser(req.Context(), strings.TrimSpace(req.FormValue("user")))
+	if err != nil {
+		v.DisplayError(wr, req, "%s", err)
+		return
+	}
+	var role model.Role
+	err = role.UnmarshalText([]byte(req.FormValue("role")))
+	if err != nil {
+		v.DisplayError(wr, req, "%s", err)
+		return
+	}
+
+	changed, err := q.logic.SetRole(req.Context(), v.Session, user.ID, role)
+	if err != nil {
+		v.Error("%s", err)
+	} else {
+		v.Message("Role of %s is now %s.", changed.Name, changed.Role)
+	}
+	q.displayAudit(wr, req, v)
+}
+
+// postStaffPolicy sets whether the role of the form requires two factor
+// authentication.
+func (q *Qrochet) postStaffPolicy(wr http.ResponseWriter, req *http.Request, v *view, required bool) {
+	var role model.Role
+	err := role.UnmarshalText([]byte(req.FormValue("role")))
+	if err != nil {
+		v.DisplayError(wr, req, "%s", err)
+		return
+	}
+
+	_, err = q.logic.SetTOTPRequired(req.Context(), v.Session, role, required)
+	if err != nil {
+		v.Error("%s", err)
+	} else if required {
+		v.Message("Role %s now requires two factor authentication.", role)
+	} else {
+		v.Message("Role %s no longer requires two factor authentication.", role)
+	}
+	q.displayAudit(wr, req, v)
+}
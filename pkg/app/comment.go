@@ -0,0 +1,107 @@
+package app
+
+import "net/http"
+import "log/slog"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+// commentNode is a comment in a thread as shown to the user of the view.
+type commentNode struct {
+	model.Comment
+	CanDelete bool
+	Replies   []commentNode
+}
+
+type comment struct {
+	Craft    model.Craft
+	Threads  []commentNode
+	ParentID string
+	Text     string
+}
+
+func (v *view) commentNodes(threads []model.CommentThread) []commentNode {
+	var res []commentNode
+	for _, thread := range threads {
+		node := commentNode{Comment: thread.Comment}
+		node.CanDelete = model.CanDeleteComment(v.Session, thread.Comment)
+		node.Replies = v.commentNodes(thread.Replies)
+		res = append(res, node)
+	}
+	return res
+}
+
+// loadComments loads the craft and its comments into the view.
+func (v *view) loadComments(req *http.Request, craftID string) error {
+	var err error
+
+	v.Comment.Craft, err = v.app.Repository.Craft().GetByID(req.Context(), craftID)
+	if err != nil || (v.Comment.Craft.Hidden && !v.IsStaff()) {
+		slog.Error("Craft.GetByID", "err", err, "craft", craftID)
+		return model.ErrorCraftNotFound
+	}
+
+	threads, err := v.app.logic.Comments(req.Context(), v.Comment.Craft.ID)
+	if err != nil {
+		return err
+	}
+	v.Comment.Threads = v.commentNodes(threads)
+	return nil
+}
+
+func (q *Qrochet) getComments(wr http.ResponseWriter, req *http.Request) {
+	v := q.view()
+	v.check(wr, req)
+
+	err := v.loadComments(req, req.PathValue("id"))
+	if err != nil {
+		v.DisplayError(wr, req, "%s", err)
+		return
+	}
+
+	v.Display(wr, req)
+}
+
+func (q *Qrochet) postComments(wr http.ResponseWriter, req *http.Request) {
+	var err error
+
+	v := q.view()
+	if !v.IsLoggedIn(wr, req) {
+		v.DisplayError(wr, req, "Please log in.")
+		return
+	}
+
+	err = req.ParseMultipartForm(mpfMaxMemory)
+	if err != nil {
+		slog.Error("postComments req.ParseForm", "err", err)
+		v.DisplayError(wr, req, "Form error.")
+		return
+	}
+
+	craftID := req.PathValue("id")
+	switch req.FormValue("action") {
+	case "delete":
+		err = q.logic.DeleteComment(req.Context(), v.Session, craftID, req.FormValue("comment"))
+		if err == nil {
+			v.Message("Comment deleted.")
+		}
+	default:
+		v.Comment.ParentID = req.FormValue("parent")
+		v.Comment.Text = req.FormValue("text")
+		_, err = q.logic.NewComment(req.Context(), v.Session, craftID, v.Comment.ParentID, v.Comment.Text)
+		if err == nil {
+			v.Comment.ParentID = ""
+			v.Comment.Text = ""
+		}
+	}
+	if err != nil {
+		v.Error("%s", err)
+	}
+
+	err = v.loadComments(req, craftID)
+	if err != nil {
+		v.DisplayError(wr, req, "%s", err)
+		return
+	}
+
+	v.Display(wr, req)
+}
@@ -18,7 +18,7 @@ type craft struct {
 	Image       multipart.File
 	Header      *multipart.FileHeader
 	OK          bool
-	All         chan model.Craft
+	All         []model.Craft
 }
 
 func (q *Qrochet) getMyCraft(wr http.ResponseWriter, req *http.Request) {
@@ -29,7 +29,7 @@ func (q *Qrochet) getMyCraft(wr http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	v.Craft.All, err = q.Repository.Craft().AllForUserID(req.Context(), v.Session.UserID)
+	v.Craft.All, err = model.Collect(q.Repository.Craft().AllForUserID(req.Context(), v.Session.UserID))
 	if err != nil {
 		slog.Error("getMyCraft", "err", err)
 		v.DisplayError(wr, req, "No crafts.")
@@ -62,7 +62,7 @@ func (q *Qrochet) postMyCraft(wr http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	v.Craft.Name = censor.Replace(req.FormValue("name"))
+	v.Craft.Name = req.FormValue("name")
 	v.Craft.Description = censor.Replace(req.FormValue("description"))
 	v.Craft.Submit, _ = strconv.ParseBool(req.FormValue("submit"))
 	v.Craft.Image, v.Craft.Header, err = req.FormFile("image")
@@ -72,6 +72,11 @@ func (q *Qrochet) postMyCraft(wr http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if censor.Check(v.Craft.Name) != nil {
+		v.DisplayError(wr, req, "Please choose a friendlier title.")
+		return
+	}
+
 	if v.Craft.Header.Size > maxImageSize {
 		slog.Error("createCraft Size > maxImageSize", "err", err)
 		v.DisplayError(wr, req, "Image too large, maximum 4 MiB.")
@@ -99,17 +104,16 @@ func (q *Qrochet) postMyCraft(wr http.ResponseWriter, req *http.Request) {
 			v.DisplayError(wr, req, "File save failed")
 			return
 		}
+		q.logic.QueueRendition(ctx, upload)
 
-		craft := &model.Craft{}
-		craft.ID = ulid.Make().String()
+		craft := model.Craft{}
 		craft.Title = v.Craft.Name
 		craft.Detail = v.Craft.Description
 		craft.Image = upload.ID
-		craft.UserID = v.Session.UserID
 
-		created, err := v.app.Repository.Craft().Put(ctx, craft.ID, *craft)
+		created, err := q.logic.NewCraft(ctx, v.Session, craft)
 		if err != nil {
-			slog.Error("Craft.Put", "err", err)
+			slog.Error("Logic.NewCraft", "err", err)
 			v.DisplayError(wr, req, "Failed to create craft.")
 			return
 		}
@@ -132,7 +136,7 @@ func (q *Qrochet) getMyCrafts(wr http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	v.Craft.All, err = q.Repository.Craft().AllForUserID(req.Context(), v.Session.UserID)
+	v.Craft.All, err = model.Collect(q.Repository.Craft().AllForUserID(req.Context(), v.Session.UserID))
 	if err != nil {
 		slog.Error("getMyCrafts", "err", err)
 		v.DisplayError(wr, req, "No crafts.")
@@ -0,0 +1,268 @@
+package app
+
+import "net/http"
+import "strings"
+import "strconv"
+import "encoding/json"
+import "bytes"
+import "fmt"
+import "log/slog"
+import "sync"
+import "time"
+import "context"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+const (
+	// eventBuffer is how many events a client may lag behind before it is
+	// disconnected. Browsers reconnect by themselves, so slow clients
+	// can never block the other clients.
+	eventBuffer = 32
+
+	// eventPing is how often a comment is sent to keep the connection open.
+	eventPing = 25 * time.Second
+)
+
+// event is an event that is streamed to the browsers.
+type event struct {
+	Type    string // Type is craft, comment or like.
+	CraftID string // CraftID is the craft the event is about.
+	UserID  string // UserID is the user who owns the craft.
+	Data    []byte // Data is the JSON data of the event.
+}
+
+// eventFilter selects the events a client wants.
+type eventFilter struct {
+	types   map[string]bool
+	craftID string
+	userID  string
+	users   map[string]bool // users is set for a feed to the followed users.
+}
+
+// match returns whether the client wants the event. The followed users of a
+// feed limit events of all types, by the owner of their craft.
+func (f eventFilter) match(ev event) bool {
+	if len(f.types) > 0 && !f.types[ev.Type] {
+		return false
+	}
+	if f.craftID != "" && f.craftID != ev.CraftID {
+		return false
+	}
+	if f.userID != "" && f.userID != ev.UserID {
+		return false
+	}
+	if f.users != nil && !f.users[ev.UserID] {
+		return false
+	}
+	return true
+}
+
+// eventClient is a browser that is connected to /events.
+type eventClient struct {
+	filter eventFilter
+	ch     chan event
+}
+
+// eventHub fans out events from the repository watches to the clients.
+type eventHub struct {
+	mu      sync.Mutex
+	clients map[*eventClient]bool
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{clients: map[*eventClient]bool{}}
+}
+
+func (h *eventHub) subscribe(filter eventFilter) *eventClient {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c := &eventClient{filter: filter, ch: make(chan event, eventBuffer)}
+	h.clients[c] = true
+	return c
+}
+
+func (h *eventHub) unsubscribe(c *eventClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.clients[c] {
+		delete(h.clients, c)
+		close(c.ch)
+	}
+}
+
+// publish sends the event to all clients that want it without blocking.
+// Clients that are too slow to keep up are disconnected.
+func (h *eventHub) publish(ev event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		if !c.filter.match(ev) {
+			continue
+		}
+		select {
+		case c.ch <- ev:
+		default:
+			slog.Warn("events client too slow, disconnecting")
+			delete(h.clients, c)
+			close(c.ch)
+		}
+	}
+}
+
+// render renders the named template with data to a string for an event.
+func (q *Qrochet) render(name string, data any) string {
+	buf := &bytes.Buffer{}
+	err := q.Template.ExecuteTemplate(buf, name, data)
+	if err != nil {
+		slog.Error("render", "name", name, "err", err)
+	}
+	return buf.String()
+}
+
+func (q *Qrochet) publishEvent(typ, craftID, userID string, data any) {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		slog.Error("publishEvent", "err", err)
+		return
+	}
+	q.events.publish(event{Type: typ, CraftID: craftID, UserID: userID, Data: buf})
+}
+
+// visible returns whether the craft of a comment or like is visible, so
+// events about it may be published.
+func (q *Qrochet) visible(ctx context.Context, craftID, ownerID string) bool {
+	craft, err := q.Repository.Craft().GetForUserID(ctx, craftID, ownerID)
+	if err != nil {
+		slog.Error("Craft.GetForUserID", "err", err, "craft", craftID)
+		return false
+	}
+	return !craft.Hidden
+}
+
+// watchEvents watches the repository for new crafts, comments and likes
+// and publishes them to the event hub until the context is done.
+// Deletes are not watched, so unlikes are only seen on the next like.
+// Only new crafts are published, not hidden, restored or edited ones, and
+// comments and likes only if their craft is visible.
+func (q *Qrochet) watchEvents(ctx context.Context) {
+	go func() {
+		for craft, err := range model.NewCrafts(q.Repository.Craft().Watch(ctx)) {
+			if err != nil {
+				slog.Error("watchEvents crafts", "err", err)
+				continue
+			}
+			q.publishEvent("craft", craft.ID, craft.UserID, map[string]string{
+				"id":   craft.ID,
+				"html": q.render("feed_craft", craft),
+			})
+		}
+	}()
+
+	go func() {
+		for comment, err := range q.Repository.Comment().Watch(ctx) {
+			if err != nil {
+				slog.Error("watchEvents comments", "err", err)
+				continue
+			}
+			if !q.visible(ctx, comment.CraftID, comment.OwnerID) {
+				continue
+			}
+			q.publishEvent("comment", comment.CraftID, comment.OwnerID, map[string]string{
+				"id":       comment.ID,
+				"craft_id": comment.CraftID,
+				"html":     q.render("comment_thread", commentNode{Comment: comment}),
+			})
+		}
+	}()
+
+	go func() {
+		for like, err := range q.Repository.Like().Watch(ctx) {
+			if err != nil {
+				slog.Error("watchEvents likes", "err", err)
+				continue
+			}
+			if !q.visible(ctx, like.CraftID, like.OwnerID) {
+				continue
+			}
+			q.publishEvent("like", like.CraftID, like.OwnerID, map[string]any{
+				"craft_id": like.CraftID,
+				"count":    q.logic.Likes(ctx, like.CraftID),
+			})
+		}
+	}()
+}
+
+// eventFilterFor returns the filter that the request asks for.
+// The query may have types, a comma separated list of craft, comment
+// and like, craft, a craft ID, user, a user ID and feed, which limits
+// events to the crafts of the users that the user of the view follows.
+func (v *view) eventFilterFor(req *http.Request) (eventFilter, error) {
+	filter := eventFilter{types: map[string]bool{}}
+	for _, typ := range strings.Split(req.FormValue("types"), ",") {
+		if typ != "" {
+			filter.types[typ] = true
+		}
+	}
+	filter.craftID = req.FormValue("craft")
+	filter.userID = req.FormValue("user")
+
+	feed, _ := strconv.ParseBool(req.FormValue("feed"))
+	if feed {
+		following, err := v.app.logic.Following(req.Context(), v.Session)
+		if err != nil {
+			return filter, err
+		}
+		filter.users = map[string]bool{}
+		for _, userID := range following {
+			filter.users[userID] = true
+		}
+	}
+	return filter, nil
+}
+
+// getEvents streams events as Server-Sent Events.
+func (q *Qrochet) getEvents(wr http.ResponseWriter, req *http.Request) {
+	v := q.view()
+	v.check(wr, req)
+
+	flusher, ok := wr.(http.Flusher)
+	if !ok {
+		http.Error(wr, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	filter, err := v.eventFilterFor(req)
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	client := q.events.subscribe(filter)
+	defer q.events.unsubscribe(client)
+
+	wr.Header().Set("Content-Type", "text/event-stream")
+	wr.Header().Set("Cache-Control", "no-cache")
+	wr.Header().Set("Connection", "keep-alive")
+	wr.WriteHeader(http.StatusOK)
+	fmt.Fprintf(wr, "retry: 5000\n\n")
+	flusher.Flush()
+
+	ping := time.NewTicker(eventPing)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-ping.C:
+			fmt.Fprintf(wr, ": ping\n\n")
+			flusher.Flush()
+		case ev, ok := <-client.ch:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(wr, "event: %s\ndata: %s\n\n", ev.Type, ev.Data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package app
+
+import "testing"
+
+func TestEventFilter(t *testing.T) {
+	feed := eventFilter{users: map[string]bool{"bob": true}}
+	tests := []struct {
+		filter eventFilter
+		ev     event
+		want   bool
+	}{
+		{eventFilter{}, event{Type: "like", UserID: "carol"}, true},
+		{feed, event{Type: "craft", UserID: "bob"}, true},
+		{feed, event{Type: "craft", UserID: "carol"}, false},
+		{feed, event{Type: "comment", UserID: "carol"}, false},
+		{feed, event{Type: "like", UserID: "carol"}, false},
+		{feed, event{Type: "like", UserID: "bob"}, true},
+		{eventFilter{types: map[string]bool{"craft": true}}, event{Type: "like"}, false},
+		{eventFilter{craftID: "c1"}, event{Type: "comment", CraftID: "c2"}, false},
+	}
+	for i, test := range tests {
+		got := test.filter.match(test.ev)
+		if got != test.want {
+			t.Errorf("%d: match %+v = %v, want %v", i, test.ev, got, test.want)
+		}
+	}
+}
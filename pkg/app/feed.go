@@ -0,0 +1,121 @@
+package app
+
+import "net/http"
+import "strconv"
+import "log/slog"
+import "time"
+import "context"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+// feedWait is how long /feed/next waits for a new craft.
+const feedWait = 30 * time.Second
+
+type feed struct {
+	Result *model.RangeResult[model.Craft]
+	New    *model.Craft
+}
+
+type follow struct {
+	UserID    string
+	Following bool
+	Self      bool
+	LoggedIn  bool
+}
+
+// FollowOf returns the follow state of the user for the user of the view.
+func (v *view) FollowOf(userID string) follow {
+	res := follow{UserID: userID}
+	res.LoggedIn = v.Session != nil
+	res.Self = v.Session != nil && v.Session.UserID == userID
+	res.Following = v.app.logic.Follows(v.context(), v.Session, userID)
+	return res
+}
+
+func (q *Qrochet) postFollow(wr http.ResponseWriter, req *http.Request) {
+	var err error
+
+	v := q.view()
+	if !v.IsLoggedIn(wr, req) {
+		v.DisplayError(wr, req, "Please log in.")
+		return
+	}
+
+	err = req.ParseMultipartForm(mpfMaxMemory)
+	if err != nil {
+		slog.Error("postFollow req.ParseForm", "err", err)
+		v.DisplayError(wr, req, "Form error.")
+		return
+	}
+
+	v.Follow.UserID = req.PathValue("id")
+	v.Follow.LoggedIn = true
+	v.Follow.Following, _ = strconv.ParseBool(req.FormValue("follow"))
+
+	err = q.logic.SetFollow(req.Context(), v.Session, v.Follow.UserID, v.Follow.Following)
+	if err != nil {
+		v.Follow.Following = !v.Follow.Following
+		v.Follow.Self = err == model.ErrorFollowSelf
+		v.DisplayError(wr, req, "%s", err)
+		return
+	}
+
+	v.Display(wr, req)
+}
+
+func (q *Qrochet) getFeed(wr http.ResponseWriter, req *http.Request) {
+	var err error
+
+	v := q.view()
+	if !v.IsLoggedIn(wr, req) {
+		v.DisplayError(wr, req, "Please log in.")
+		return
+	}
+
+	query := model.RangeQuery[model.Craft]{}
+	query.First = req.FormValue("first")
+	query.Amount, _ = strconv.Atoi(req.FormValue("amount"))
+
+	v.Feed.Result, err = q.logic.Feed(req.Context(), v.Session, query)
+	if err != nil {
+		slog.Error("getFeed", "err", err)
+		v.DisplayError(wr, req, "No feed.")
+		return
+	}
+
+	v.Display(wr, req)
+}
+
+// getFeedNext waits for the next new craft in the feed of the user.
+func (q *Qrochet) getFeedNext(wr http.ResponseWriter, req *http.Request) {
+	v := q.view()
+	if !v.IsLoggedIn(wr, req) {
+		v.DisplayError(wr, req, "Please log in.")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), feedWait)
+	defer cancel()
+
+	crafts, err := q.logic.WatchFeed(ctx, v.Session)
+	if err != nil {
+		v.DisplayError(wr, req, "%s", err)
+		return
+	}
+
+	for craft, err := range crafts {
+		if err != nil {
+			slog.Error("WatchFeed", "err", err)
+			continue
+		}
+		if craft.Hidden {
+			continue
+		}
+		v.Feed.New = &craft
+		v.Display(wr, req)
+		return
+	}
+
+	v.Message("No new crafts yet.")
+	v.Display(wr, req)
+}
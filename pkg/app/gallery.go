@@ -0,0 +1,21 @@
+package app
+
+import "net/http"
+import "log/slog"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+func (q *Qrochet) getGallery(wr http.ResponseWriter, req *http.Request) {
+	var err error
+	v := q.view()
+	v.check(wr, req)
+
+	v.Craft.All, err = model.Collect(q.Repository.Craft().All(req.Context()))
+	if err != nil {
+		slog.Error("getGallery", "err", err)
+		v.DisplayError(wr, req, "No crafts.")
+		return
+	}
+
+	v.Display(wr, req)
+}
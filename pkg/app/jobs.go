@@ -0,0 +1,35 @@
+package app
+
+import "context"
+import "log/slog"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+// jobHandlers returns the handlers for all background jobs of Qrochet.
+func (q *Qrochet) jobHandlers() map[string]model.JobHandler {
+	handlers := q.logic.JobHandlers()
+	handlers[model.JobReindex] = func(ctx context.Context, job model.Job) error {
+		count, err := q.Reindex(ctx)
+		if err != nil {
+			return err
+		}
+		slog.Info("reindexed", "crafts", count, "job", job.ID)
+		return nil
+	}
+	return handlers
+}
+
+// Work handles background jobs until ctx is done.
+func (q *Qrochet) Work(ctx context.Context) error {
+	slog.Info("worker started")
+	return q.Repository.Jobs().Work(ctx, q.jobHandlers())
+}
+
+// Relay publishes the domain events of the outbox until ctx is done.
+// It is safe to relay in several processes at once.
+func (q *Qrochet) Relay(ctx context.Context) {
+	err := q.Repository.Outbox().Relay(ctx, q.logic.CheckEvent)
+	if err != nil {
+		slog.Error("Outbox.Relay", "err", err)
+	}
+}
@@ -0,0 +1,77 @@
+package app
+
+import "net/http"
+import "strconv"
+import "log/slog"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+type like struct {
+	CraftID    string
+	Count      int
+	Liked      bool
+	Own        bool
+	LoggedIn   bool
+	Favourites []model.Craft
+}
+
+// LikeOf returns the like state of the craft for the user of the view.
+func (v *view) LikeOf(craft model.Craft) like {
+	res := like{CraftID: craft.ID}
+	res.Count = v.app.logic.Likes(v.context(), craft.ID)
+	res.LoggedIn = v.Session != nil
+	res.Own = v.Session != nil && v.Session.UserID == craft.UserID
+	res.Liked = v.app.logic.Liked(v.context(), v.Session, craft.ID)
+	return res
+}
+
+func (q *Qrochet) postLike(wr http.ResponseWriter, req *http.Request) {
+	var err error
+
+	v := q.view()
+	if !v.IsLoggedIn(wr, req) {
+		v.DisplayError(wr, req, "Please log in.")
+		return
+	}
+
+	err = req.ParseMultipartForm(mpfMaxMemory)
+	if err != nil {
+		slog.Error("postLike req.ParseForm", "err", err)
+		v.DisplayError(wr, req, "Form error.")
+		return
+	}
+
+	v.Like.CraftID = req.PathValue("id")
+	v.Like.LoggedIn = true
+	v.Like.Liked, _ = strconv.ParseBool(req.FormValue("like"))
+
+	v.Like.Count, err = q.logic.SetLike(req.Context(), v.Session, v.Like.CraftID, v.Like.Liked)
+	if err != nil {
+		v.Like.Liked = !v.Like.Liked
+		v.Like.Own = err == model.ErrorLikeOwn
+		v.Like.Count = q.logic.Likes(req.Context(), v.Like.CraftID)
+		v.DisplayError(wr, req, "%s", err)
+		return
+	}
+
+	v.Display(wr, req)
+}
+
+func (q *Qrochet) getMyFavourites(wr http.ResponseWriter, req *http.Request) {
+	var err error
+
+	v := q.view()
+	if !v.IsLoggedIn(wr, req) {
+		v.DisplayError(wr, req, "Please log in.")
+		return
+	}
+
+	v.Like.Favourites, err = q.logic.Favourites(req.Context(), v.Session)
+	if err != nil {
+		slog.Error("getMyFavourites", "err", err)
+		v.DisplayError(wr, req, "No favourites.")
+		return
+	}
+
+	v.Display(wr, req)
+}
@@ -0,0 +1,28 @@
+package app
+
+import "testing"
+
+import "github.com/qrochet/qrochet/pkg/limit"
+
+func TestRatePolicies(t *testing.T) {
+	limiter := limit.New(RatePolicies, nil)
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/login", "/login"},
+		{"/api/v1/login", "/api/v1/login"},
+		{"/api/v1/crafts", "/"},
+		{"/register", "/register"},
+		{"/upload/01abc.jpeg", "/upload/"},
+		{"/avatar/alice", "/avatar/"},
+		{"/web/style.css", "/web/"},
+		{"/gallery", "/"},
+	}
+	for _, test := range tests {
+		policy, ok := limiter.Policy(test.path)
+		if !ok || policy.Prefix != test.want {
+			t.Errorf("Policy(%q) = %q %v, want %q", test.path, policy.Prefix, ok, test.want)
+		}
+	}
+}
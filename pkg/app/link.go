@@ -0,0 +1,126 @@
+package app
+
+import "errors"
+import "net"
+import "net/http"
+import "strings"
+import "time"
+import "log/slog"
+
+import "github.com/qrochet/qrochet/pkg/model"
+import "github.com/qrochet/qrochet/pkg/roh"
+
+const (
+	// linkSubject prefixes the subject of the tokens of log in links, so
+	// they cannot be mistaken for session tokens and the other way around.
+	linkSubject = "link:"
+
+	// linkDelay is the least time postLoginLink takes.
+	linkDelay = 250 * time.Millisecond
+)
+
+// loginLink is the view of the log in links.
+type loginLink struct {
+	Email  string
+	Ticket string // Ticket is the ticket of the second step of the log in.
+	Sent   bool
+}
+
+// publicURL returns the public URL of Qrochet. Without a configured URL
+// it is http:// with the address Qrochet listens on. The Host header of
+// requests is never used, because it can be chosen by the client.
+func publicURL(url, addr string) string {
+	if url != "" {
+		return strings.TrimSuffix(url, "/")
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "http://" + addr
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// loginLinkURL returns the URL of the log in link with the ID.
+func (q *Qrochet) loginLinkURL(id string) string {
+	token := roh.PASETO{Key: q.Key}.Token(linkSubject+id, model.LoginLinkTTL)
+	return q.url + "/login/link?token=" + token
+}
+
+// getLoginLink shows the form to request a log in link, or logs in with
+// the log in link in the token parameter.
+func (q *Qrochet) getLoginLink(wr http.ResponseWriter, req *http.Request) {
+	v := q.view()
+	v.check(wr, req)
+
+	token := req.FormValue("token")
+	if token == "" {
+		v.Display(wr, req)
+		return
+	}
+
+	sub, err := roh.PASETO{Key: q.Key}.Authenticate(req.Context(), token)
+	id, ok := strings.CutPrefix(sub, linkSubject)
+	if err != nil || !ok {
+		slog.Error("log in link token", "err", err)
+		v.DisplayError(wr, req, "%s", model.ErrorLoginLink)
+		return
+	}
+
+	user, err := q.logic.UseLoginLink(req.Context(), id)
+	var second *model.SecondFactorError
+	if errors.As(err, &second) {
+		v.Link.Ticket = second.Ticket
+		v.Display(wr, req)
+		return
+	}
+	if err != nil {
+		slog.Error("Logic.UseLoginLink", "err", err)
+		v.DisplayError(wr, req, "%s", err)
+		return
+	}
+
+	err = v.newSession(wr, req, *user)
+	if err != nil {
+		slog.Error("newSession", "err", err)
+		v.DisplayError(wr, req, "Session creation failed")
+		return
+	}
+	http.Redirect(wr, req, "/", http.StatusSeeOther)
+}
+
+// postLoginLink mails a log in link.
+func (q *Qrochet) postLoginLink(wr http.ResponseWriter, req *http.Request) {
+	v := q.view()
+	v.check(wr, req)
+
+	err := req.ParseMultipartForm(mpfMaxMemory)
+	if err != nil {
+		slog.Error("LoginLink req.ParseForm", "err", err)
+		v.DisplayError(wr, req, "Form error.")
+		return
+	}
+	v.Link.Email = req.FormValue("email")
+
+	// Wait a little, so the time of the answer does not give away
+	// whether the address is registered.
+	start := time.Now()
+	err = q.logic.RequestLoginLink(req.Context(), v.Link.Email, q.loginLinkURL)
+	time.Sleep(time.Until(start.Add(linkDelay)))
+	if err != nil {
+		slog.Error("Logic.RequestLoginLink", "err", err)
+		switch err {
+		case model.ErrorEmailNotValid:
+			v.DisplayError(wr, req, "Email is not valid.")
+		case model.ErrorLoginLinkThrottled:
+			v.DisplayError(wr, req, "%s", err)
+		default:
+			v.DisplayError(wr, req, "The log in link could not be sent, please try again later.")
+		}
+		return
+	}
+	v.Link.Sent = true
+	v.Display(wr, req)
+}
@@ -1,13 +1,17 @@
 package app
 
+import "errors"
 import "net/http"
-import "net/mail"
 import "strconv"
 import "log/slog"
 
+import "github.com/qrochet/qrochet/pkg/model"
+
 type login struct {
 	Email  string
 	Pass   string
+	Ticket string // Ticket is the ticket of the second step of the log in.
+	Code   string // Code is the code of the second step of the log in.
 	Submit bool
 	OK     bool
 }
@@ -32,36 +36,44 @@ func (q *Qrochet) login(wr http.ResponseWriter, req *http.Request) {
 	}
 	v.Login.Email = req.FormValue("email")
 	v.Login.Pass = req.FormValue("pass")
+	v.Login.Ticket = req.FormValue("ticket")
+	v.Login.Code = req.FormValue("code")
 	v.Login.Submit, _ = strconv.ParseBool(req.FormValue("submit"))
 
 	if v.Login.Submit {
-		_, err = mail.ParseAddress(v.Login.Email)
-		if err != nil {
-			slog.Error("mail.ParseAddress", "err", err, "v.Login.Email", v.Login.Email)
-			v.DisplayError(wr, req, "Email is not valid.")
-			return
+		var user *model.User
+		var session *model.Session
+		if v.Login.Ticket != "" {
+			user, session, err = q.logic.LoginSecondFactor(req.Context(), v.Login.Ticket, v.Login.Code, sessionTimeout)
+		} else {
+			user, session, err = q.logic.Login(req.Context(), v.Login.Email, v.Login.Pass, sessionTimeout)
 		}
-
-		existing, err := v.app.Repository.User().GetByEmail(req.Context(), v.Login.Email)
-		if err != nil || existing == nil || existing.Email != v.Login.Email {
-			slog.Error("User.GetForEmail", "err", err)
-			v.DisplayError(wr, req, "This email address is not registered yet or the password is not correct")
+		var second *model.SecondFactorError
+		if errors.As(err, &second) {
+			v.Login.Ticket = second.Ticket
+			v.Display(wr, req)
 			return
 		}
-
-		err = existing.CheckPassword(v.Login.Pass)
-		if err != nil {
-			slog.Error("User.CheckPassword", "err", err)
-			v.DisplayError(wr, req, "This email address is not registered yet or the password is not correct")
-			return
-		}
-
-		err = v.newSession(wr, req, *existing)
 		if err != nil {
-			slog.Error("User.Put", "err", err)
-			v.DisplayError(wr, req, "Session creation failed")
+			slog.Error("Logic.Login", "err", err)
+			switch err {
+			case model.ErrorSecondFactorWrong:
+				v.DisplayError(wr, req, "%s", err)
+			case model.ErrorLoginTicket:
+				v.Login.Ticket = ""
+				v.DisplayError(wr, req, "%s", err)
+			case model.ErrorEmailNotValid:
+				v.DisplayError(wr, req, "Email is not valid.")
+			case model.ErrorLoginThrottled, model.ErrorAccountLocked:
+				v.DisplayError(wr, req, "%s", err)
+			case model.ErrorSessionNotCreated:
+				v.DisplayError(wr, req, "Session creation failed")
+			default:
+				v.DisplayError(wr, req, "This email address is not registered yet or the password is not correct")
+			}
 			return
 		}
+		v.setSession(wr, session, *user)
 		v.Message("Log in OK")
 		v.Login.OK = true
 		v.Display(wr, req)
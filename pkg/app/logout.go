@@ -5,6 +5,8 @@ import "time"
 import "log/slog"
 import "strconv"
 
+import "github.com/qrochet/qrochet/pkg/model"
+
 type logout struct {
 	Submit bool
 	OK     bool
@@ -37,6 +39,7 @@ func (q *Qrochet) logout(wr http.ResponseWriter, req *http.Request) {
 		if err != nil {
 			slog.Error("could not delete session", "err", err)
 		}
+		q.logic.Audit(req.Context(), model.AuditLogout, v.Session.UserID, "")
 
 		cookie := http.Cookie{}
 		cookie.Secure = true
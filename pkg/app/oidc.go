@@ -0,0 +1,194 @@
+package app
+
+import "errors"
+import "net/http"
+import "net/url"
+import "time"
+import "log/slog"
+
+import "aidanwoods.dev/go-paseto"
+
+import "github.com/qrochet/qrochet/pkg/model"
+import "github.com/qrochet/qrochet/pkg/oidc"
+
+const (
+	// oidcCookieName is the name of the cookie that keeps the state of a
+	// sign in with the OpenID Connect provider until its callback.
+	oidcCookieName = "QROCHET_OIDC"
+
+	// oidcTTL is how long a sign in with the provider may take.
+	oidcTTL = 10 * time.Minute
+
+	// oidcClaim is the claim of the oidcState in the cookie token.
+	oidcClaim = "oidc"
+
+	// oidcTimeout is the timeout of requests to the provider.
+	oidcTimeout = 10 * time.Second
+)
+
+// oidcState is the state of a sign in with the provider. It is kept in an
+// encrypted cookie, so it cannot be read or changed by the client, and the
+// callback only works in the browser that started the sign in.
+type oidcState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// newOIDCProvider returns the provider of the settings, or nil if no
+// provider is configured, and the name to show for it.
+func newOIDCProvider(s Settings, base string) (*oidc.Provider, string) {
+	if s.OIDCIssuer == "" {
+		return nil, ""
+	}
+	p := &oidc.Provider{
+		Issuer:       s.OIDCIssuer,
+		ClientID:     s.OIDCClientID,
+		ClientSecret: s.OIDCClientSecret,
+		RedirectURL:  base + "/login/oidc",
+		Client:       &http.Client{Timeout: oidcTimeout},
+	}
+	name := s.OIDCName
+	if name == "" {
+		name = s.OIDCIssuer
+		if u, err := url.Parse(s.OIDCIssuer); err == nil && u.Host != "" {
+			name = u.Host
+		}
+	}
+	return p, name
+}
+
+// OIDCName returns the name of the OpenID Connect provider, or "" if there
+// is none.
+func (v *view) OIDCName() string {
+	if v.app.oidc == nil {
+		return ""
+	}
+	return v.app.oidcName
+}
+
+func (q *Qrochet) oidcCookie(state oidcState) (*http.Cookie, error) {
+	tok := paseto.NewToken()
+	tok.SetNotBefore(time.Now())
+	tok.SetExpiration(time.Now().Add(oidcTTL))
+	err := tok.Set(oidcClaim, state)
+	if err != nil {
+		return nil, err
+	}
+	cookie := &http.Cookie{}
+	cookie.Name = oidcCookieName
+	cookie.Value = tok.V4Encrypt(q.Key, []byte{})
+	cookie.Path = "/login/oidc"
+	cookie.MaxAge = int(oidcTTL.Seconds())
+	cookie.Secure = true
+	cookie.HttpOnly = true
+	// The callback is a navigation from the site of the provider, so the
+	// cookie must not be strict.
+	cookie.SameSite = http.SameSiteLaxMode
+	return cookie, nil
+}
+
+// oidcState returns the state of the sign in from the cookie, and deletes
+// the cookie, so it is used once.
+func (q *Qrochet) oidcState(wr http.ResponseWriter, req *http.Request) (oidcState, error) {
+	var state oidcState
+	cookie, err := req.Cookie(oidcCookieName)
+	if err != nil {
+		return state, err
+	}
+	http.SetCookie(wr, &http.Cookie{Name: oidcCookieName, Path: "/login/oidc", MaxAge: -1, Secure: true, HttpOnly: true})
+
+	tok, err := paseto.NewParser().ParseV4Local(q.Key, cookie.Value, []byte{})
+	if err != nil {
+		return state, err
+	}
+	err = tok.Get(oidcClaim, &state)
+	return state, err
+}
+
+// getLoginOIDC starts a sign in with the OpenID Connect provider, or
+// handles its callback.
+func (q *Qrochet) getLoginOIDC(wr http.ResponseWriter, req *http.Request) {
+	v := q.view()
+	v.check(wr, req)
+	if q.oidc == nil {
+		v.DisplayError(wr, req, "Sign in with a provider is not available.")
+		return
+	}
+
+	query := req.URL.Query()
+	if query.Has("code") || query.Has("error") {
+		q.oidcCallback(v, wr, req)
+		return
+	}
+
+	state := oidcState{State: oidc.Random(), Nonce: oidc.Random(), Verifier: oidc.Random()}
+	authURL, err := q.oidc.AuthURL(req.Context(), state.State, state.Nonce, state.Verifier)
+	if err != nil {
+		slog.Error("oidc.AuthURL", "err", err)
+		v.DisplayError(wr, req, "%s is not available, please try again later.", q.oidcName)
+		return
+	}
+	cookie, err := q.oidcCookie(state)
+	if err != nil {
+		slog.Error("oidcCookie", "err", err)
+		v.DisplayError(wr, req, "Sign in failed.")
+		return
+	}
+	http.SetCookie(wr, cookie)
+	http.Redirect(wr, req, authURL, http.StatusSeeOther)
+}
+
+// oidcCallback logs in the user that the provider signed in.
+func (q *Qrochet) oidcCallback(v *view, wr http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	state, err := q.oidcState(wr, req)
+	if err != nil || query.Get("state") != state.State {
+		slog.Error("oidc state", "err", err)
+		v.DisplayError(wr, req, "The sign in expired or was started elsewhere, please try again.")
+		return
+	}
+	if query.Get("error") != "" {
+		slog.Warn("oidc callback", "error", query.Get("error"), "description", query.Get("error_description"))
+		v.DisplayError(wr, req, "%s did not sign you in.", q.oidcName)
+		return
+	}
+
+	claims, err := q.oidc.Callback(req.Context(), query.Get("code"), state.Verifier, state.Nonce)
+	if err != nil {
+		slog.Error("oidc.Callback", "err", err)
+		v.DisplayError(wr, req, "Sign in with %s failed, please try again.", q.oidcName)
+		return
+	}
+
+	user, err := q.logic.LoginExternal(req.Context(), model.ExternalUser{
+		Identity:      model.Identity{Issuer: claims.Issuer, Subject: claims.Subject},
+		Email:         claims.Email,
+		EmailVerified: claims.Verified(),
+		Name:          claims.Name,
+	})
+	var second *model.SecondFactorError
+	if errors.As(err, &second) {
+		v.Login.Ticket = second.Ticket
+		v.Display(wr, req)
+		return
+	}
+	if err != nil {
+		slog.Error("Logic.LoginExternal", "err", err)
+		switch err {
+		case model.ErrorEmailNotValid, model.ErrorEmailNotVerified, model.ErrorIdentityMismatch:
+			v.DisplayError(wr, req, "%s", err)
+		default:
+			v.DisplayError(wr, req, "Sign in with %s failed, please try again.", q.oidcName)
+		}
+		return
+	}
+
+	err = v.newSession(wr, req, *user)
+	if err != nil {
+		slog.Error("newSession", "err", err)
+		v.DisplayError(wr, req, "Session creation failed")
+		return
+	}
+	http.Redirect(wr, req, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,168 @@
+package app
+
+import "io"
+import "log/slog"
+import "net/http"
+import "strings"
+
+import "github.com/qrochet/qrochet/pkg/model"
+import "github.com/qrochet/qrochet/pkg/roh"
+
+// emailSubject prefixes the subject of the tokens of email confirmation
+// links, like linkSubject.
+const emailSubject = "email:"
+
+// profile is the page where users change their profile.
+type profile struct {
+	Name     string
+	Email    string
+	Theme    model.Theme
+	Avatar   model.Reference
+	Password bool // Password is true if the user has a password.
+	Themes   []model.Theme
+}
+
+// Theme returns the theme of the user, whose stylesheet and logo the pages
+// use.
+func (v *view) Theme() model.Theme {
+	if v.User == nil {
+		return model.DefaultTheme
+	}
+	return v.User.Theme.OrDefault()
+}
+
+// emailConfirmURL returns the URL of the link to confirm the email change
+// with the ID.
+func (q *Qrochet) emailConfirmURL(id string) string {
+	token := roh.PASETO{Key: q.Key}.Token(emailSubject+id, model.EmailChangeTTL)
+	return q.url + "/my/email?token=" + token
+}
+
+// displayProfile shows the profile page with the state of the user.
+func (q *Qrochet) displayProfile(wr http.ResponseWriter, req *http.Request, v *view) {
+	user, err := q.logic.Profile(req.Context(), v.Session)
+	if err != nil {
+		v.DisplayError(wr, req, "%s", err)
+		return
+	}
+	v.User = user
+	v.Profile.Name = user.Name
+	v.Profile.Email = user.Email
+	v.Profile.Theme = user.Theme.OrDefault()
+	v.Profile.Avatar = user.Avatar
+	v.Profile.Password = user.Hash != ""
+	v.Profile.Themes = model.Themes
+	v.Display(wr, req)
+}
+
+func (q *Qrochet) getMyProfile(wr http.ResponseWriter, req *http.Request) {
+	v := q.view()
+	if !v.IsLoggedIn(wr, req) {
+		v.DisplayError(wr, req, "Please log in.")
+		return
+	}
+	q.displayProfile(wr, req, v)
+}
+
+// postMyProfile changes the name and theme, the avatar, the email address
+// or the password of the user, depending on the action of the form.
+func (q *Qrochet) postMyProfile(wr http.ResponseWriter, req *http.Request) {
+	v := q.view()
+	if !v.IsLoggedIn(wr, req) {
+		v.DisplayError(wr, req, "Please log in.")
+		return
+	}
+
+	err := req.ParseMultipartForm(mpfMaxMemory)
+	if err != nil {
+		slog.Error("postMyProfile req.ParseForm", "err", err)
+		v.DisplayError(wr, req, "Form error.")
+		return
+	}
+
+	ctx := req.Context()
+	switch req.FormValue("action") {
+	case "profile":
+		var user *model.User
+		theme := v.Theme()
+		user, err = q.logic.UpdateProfile(ctx, v.Session, req.FormValue("name"), model.Theme(req.FormValue("theme")))
+		if err == nil {
+			v.Message("Profile saved.")
+			if user.Theme != theme {
+				v.Message("Go back to the top to see the new theme.")
+			}
+		}
+	case "avatar":
+		file, header, ferr := req.FormFile("avatar")
+		if ferr != nil {
+			slog.Error("postMyProfile req.FormFile", "err", ferr)
+			v.Error("File upload failed")
+			break
+		}
+		_, err = q.logic.SetAvatar(ctx, v.Session, file, header.Size)
+		file.Close()
+		if err == nil {
+			v.Message("Avatar saved.")
+		}
+	case "email":
+		email := req.FormValue("email")
+		err = q.logic.RequestEmailChange(ctx, v.Session, req.FormValue("current"), email, q.emailConfirmURL)
+		if err == nil {
+			v.Message("A link to confirm the address was mailed to %s.", email)
+		}
+	case "password":
+		if req.FormValue("password") != req.FormValue("repeat") {
+			v.Error("The new passwords are not the same.")
+			break
+		}
+		err = q.logic.ChangePassword(ctx, v.Session, req.FormValue("current"), req.FormValue("password"))
+		if err == nil {
+			v.Message("Password changed.")
+		}
+	default:
+		v.Error("Unknown action.")
+	}
+	if err != nil {
+		slog.Error("postMyProfile", "action", req.FormValue("action"), "err", err)
+		v.Error("%s", err)
+	}
+	q.displayProfile(wr, req, v)
+}
+
+// getMyEmail confirms an email change with the token of the link that was
+// mailed to the new address.
+func (q *Qrochet) getMyEmail(wr http.ResponseWriter, req *http.Request) {
+	v := q.view()
+	v.check(wr, req)
+
+	sub, err := roh.PASETO{Key: q.Key}.Authenticate(req.Context(), req.FormValue("token"))
+	id, ok := strings.CutPrefix(sub, emailSubject)
+	if err != nil || !ok {
+		slog.Error("email confirmation token", "err", err)
+		v.DisplayError(wr, req, "%s", model.ErrorEmailChange)
+		return
+	}
+	user, err := q.logic.ConfirmEmailChange(req.Context(), id)
+	if err != nil {
+		slog.Error("Logic.ConfirmEmailChange", "err", err)
+		v.DisplayError(wr, req, "%s", err)
+		return
+	}
+	v.Message("Your email address is now %s.", user.Email)
+	v.Display(wr, req)
+}
+
+// getAvatar shows the avatar image with the ID.
+func (q *Qrochet) getAvatar(wr http.ResponseWriter, req *http.Request) {
+	avatar, err := q.Repository.Avatar().Get(req.Context(), req.PathValue("id"))
+	if err != nil {
+		slog.Error("getAvatar", "err", err)
+		wr.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer avatar.ReadCloser.Close()
+	wr.Header().Set("Content-Type", "image/jpeg")
+	// A new avatar gets a new ID, so they never change.
+	wr.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	io.Copy(wr, avatar.ReadCloser)
+}
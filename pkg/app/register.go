@@ -4,77 +4,30 @@ import "net/http"
 import "net/mail"
 import "strconv"
 import "log/slog"
-import "math/rand/v2"
 
-import "github.com/qrochet/qrochet/pkg/model"
-import mailer "github.com/qrochet/qrochet/pkg/mail"
-import "github.com/oklog/ulid/v2"
-
-var stupidCAPTCHA = []struct {
-	q string
-	a int
-}{
-	{q: "Two plus three is?",
-		a: 5},
-	{q: "Nine minus seven is?",
-		a: 2},
-	{q: "Three times three?",
-		a: 9},
-	{q: "Six divided by two?",
-		a: 3},
-	{q: "One times one?",
-		a: 1},
-	{q: "Lucky seven?",
-		a: 7},
-	{q: "Two pairs?",
-		a: 4},
-	{q: "Next number after five?",
-		a: 6},
-	{q: "Two times two times two?",
-		a: 8},
-}
+import "github.com/qrochet/qrochet/pkg/challenge"
 
 type register struct {
-	Name     string
-	Email    string
-	Pass     string
-	CAPTCHAQ string
-	CAPTCHA  int
-	CAPTCHAI int
-	Submit   bool
-	OK       bool
-}
-
-func (r *register) regenerate() {
-	r.CAPTCHA = 0
-	r.CAPTCHAI = rand.IntN(len(stupidCAPTCHA))
-	r.CAPTCHAQ = stupidCAPTCHA[r.CAPTCHAI].q
+	Name      string
+	Email     string
+	Pass      string
+	Challenge challenge.Puzzle
+	Token     string // Token is the token of the challenge.
+	Submit    bool
+	OK        bool
 }
 
-const mpfMaxMemory = 0
-
-func (q *Qrochet) sendRegistrationMail(user model.User) error {
-	if q.msrv == nil {
-		slog.Warn("Mailer not available will not send registration email.")
-		return nil
-	}
-
-	msg := mailer.Mail{}
-	msg.To = user.Name + "<" + user.Email + ">"
-
-	msg.Printf("Dear %s, welcome to Qrochet\n\n", user.Name)
-	msg.Println("Thank you for registering with Qrochet, the website for chroochet and hand crafts.")
-	msg.Println("This is a message to confirm your registration. You do not have to reply to it.")
-	msg.Println("Kind regards, Qrochet.")
-
-	err := q.msrv.Send(msg)
+// regenerate issues a new challenge for the registration form.
+func (r *register) regenerate(issuer *challenge.Issuer) {
+	var err error
+	r.Challenge, r.Token, err = issuer.Issue()
 	if err != nil {
-		slog.Error("While sending mail", "err", err)
-		return err
+		slog.Error("Issuer.Issue", "err", err)
 	}
-	return nil
 }
 
+const mpfMaxMemory = 0
+
 func (q *Qrochet) register(wr http.ResponseWriter, req *http.Request) {
 	var err error
 
@@ -97,62 +50,37 @@ func (q *Qrochet) register(wr http.ResponseWriter, req *http.Request) {
 	v.Register.Email = req.FormValue("email")
 	v.Register.Pass = req.FormValue("pass")
 	v.Register.Submit, _ = strconv.ParseBool(req.FormValue("submit"))
-	v.Register.CAPTCHA, _ = strconv.Atoi(req.FormValue("captcha"))
-	v.Register.CAPTCHAI, _ = strconv.Atoi(req.FormValue("captchai"))
 
 	if v.Register.Submit {
 		_, err = mail.ParseAddress(v.Register.Email)
 		if err != nil {
 			slog.Error("mail.ParseAddress", "err", err, "v.Register.Email", v.Register.Email)
-			v.Register.regenerate()
+			v.Register.regenerate(q.challenge)
 			v.DisplayError(wr, req, "Email is not valid.")
 			return
 		}
 
-		if v.Register.CAPTCHAI < 0 || v.Register.CAPTCHAI >= len(stupidCAPTCHA) ||
-			stupidCAPTCHA[v.Register.CAPTCHAI].a != v.Register.CAPTCHA {
-			slog.Error("Register CAPTCHA not correct", "captchai", v.Register.CAPTCHAI, "captcha", v.Register.CAPTCHA)
-			v.Register.regenerate()
-			v.DisplayError(wr, req, "Question answer not correct. Please check again.")
-			return
-		}
-
-		user := model.User{}
-		user.ID = ulid.Make().String()
-		user.Email = v.Register.Email
-		user.Name = v.Register.Name
-		user.SetPassword(v.Register.Pass)
-
-		existing, err := v.app.Repository.User().GetByEmail(req.Context(), user.Email)
+		err = q.challenge.Verify(req.Context(), req)
 		if err != nil {
-			slog.Error("User.GetByEmail", "err", err)
-			v.Register.regenerate()
-			v.DisplayError(wr, req, "Error getting user by email address")
-			return
-		}
-
-		if existing != nil && existing.Email == user.Email {
-			slog.Error("User.GetByEmail", "err", err)
-			v.Register.regenerate()
-			v.DisplayError(wr, req, "This email address is already registered")
+			slog.Error("Register challenge not passed", "err", err, "kind", q.challenge.Kind())
+			v.Register.regenerate(q.challenge)
+			v.DisplayError(wr, req, "%s", err)
 			return
 		}
 
-		created, err := v.app.Repository.User().Put(req.Context(), user.ID, user)
+		created, err := q.logic.Register(req.Context(), v.Register.Name, v.Register.Email, v.Register.Pass)
 		if err != nil {
-			slog.Error("User.Put", "err", err)
-			v.Register.regenerate()
-			v.DisplayError(wr, req, "Registration failed")
+			v.Register.regenerate(q.challenge)
+			v.DisplayError(wr, req, "%s", err)
 			return
 		}
-		err = v.newSession(wr, req, created)
+		err = v.newSession(wr, req, *created)
 		if err != nil {
 			slog.Error("User.Put", "err", err)
-			v.Register.regenerate()
+			v.Register.regenerate(q.challenge)
 			v.DisplayError(wr, req, "Session creation failed")
 			return
 		}
-		go q.sendRegistrationMail(created)
 
 		v.Message("Registration OK")
 		v.Register.OK = true
@@ -160,8 +88,21 @@ func (q *Qrochet) register(wr http.ResponseWriter, req *http.Request) {
 		return
 	} else {
 		slog.Error("Not submitted?", "form", req.Form, "post", req.PostForm)
-		v.Register.regenerate()
+		v.Register.regenerate(q.challenge)
 		v.Display(wr, req)
 		return
 	}
 }
+
+// getChallengeImage shows the image of an image challenge.
+func (q *Qrochet) getChallengeImage(wr http.ResponseWriter, req *http.Request) {
+	svg, err := q.challenge.Render(req.FormValue("token"))
+	if err != nil {
+		slog.Error("Issuer.Render", "err", err)
+		http.Error(wr, "Challenge expired.", http.StatusNotFound)
+		return
+	}
+	wr.Header().Set("Content-Type", "image/svg+xml")
+	wr.Header().Set("Cache-Control", "no-store")
+	wr.Write(svg)
+}
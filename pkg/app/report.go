@@ -0,0 +1,119 @@
+package app
+
+import "net/http"
+import "strconv"
+import "log/slog"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+type report struct {
+	CraftID string
+	Image   string
+	Reason  string
+	Submit  bool
+	OK      bool
+	All     []model.Report
+}
+
+func (q *Qrochet) getReport(wr http.ResponseWriter, req *http.Request) {
+	v := q.view()
+	if !v.IsLoggedIn(wr, req) {
+		v.DisplayError(wr, req, "Please log in.")
+		return
+	}
+
+	v.Report.CraftID = req.FormValue("craft")
+	v.Report.Image = req.FormValue("image")
+	v.Display(wr, req)
+}
+
+func (q *Qrochet) postReport(wr http.ResponseWriter, req *http.Request) {
+	var err error
+
+	v := q.view()
+	if !v.IsLoggedIn(wr, req) {
+		v.DisplayError(wr, req, "Please log in.")
+		return
+	}
+
+	err = req.ParseMultipartForm(mpfMaxMemory)
+	if err != nil {
+		slog.Error("postReport req.ParseForm", "err", err)
+		v.DisplayError(wr, req, "Form error.")
+		return
+	}
+
+	v.Report.CraftID = req.FormValue("craft")
+	v.Report.Image = req.FormValue("image")
+	v.Report.Reason = req.FormValue("reason")
+	v.Report.Submit, _ = strconv.ParseBool(req.FormValue("submit"))
+
+	if !v.Report.Submit {
+		v.Display(wr, req)
+		return
+	}
+
+	_, err = q.logic.NewReport(req.Context(), v.Session,
+		v.Report.CraftID, model.Reference(v.Report.Image), v.Report.Reason)
+	if err != nil {
+		v.DisplayError(wr, req, "%s", err)
+		return
+	}
+
+	v.Message("Thank you, our staff will look at your report.")
+	v.Report.OK = true
+	v.Display(wr, req)
+}
+
+func (q *Qrochet) getStaffReports(wr http.ResponseWriter, req *http.Request) {
+	var err error
+
+	v := q.view()
+	if !v.IsLoggedIn(wr, req) {
+		v.DisplayError(wr, req, "Please log in.")
+		return
+	}
+
+	v.Report.All, err = q.logic.Reports(req.Context(), v.Session)
+	if err != nil {
+		v.DisplayError(wr, req, "%s", err)
+		return
+	}
+
+	v.Display(wr, req)
+}
+
+func (q *Qrochet) postStaffReports(wr http.ResponseWriter, req *http.Request) {
+	var err error
+
+	v := q.view()
+	if !v.IsLoggedIn(wr, req) {
+		v.DisplayError(wr, req, "Please log in.")
+		return
+	}
+
+	err = req.ParseMultipartForm(mpfMaxMemory)
+	if err != nil {
+		slog.Error("postStaffReports req.ParseForm", "err", err)
+		v.DisplayError(wr, req, "Form error.")
+		return
+	}
+
+	id := req.FormValue("id")
+	action := model.ReportStatus(req.FormValue("action"))
+
+	handled, err := q.logic.Moderate(req.Context(), v.Session, id, action)
+	if err != nil {
+		v.Error("%s", err)
+	} else {
+		v.Message("Report %s: %s", handled.ID, handled.Status)
+	}
+
+	v.Report.All, err = q.logic.Reports(req.Context(), v.Session)
+	if err != nil {
+		v.DisplayError(wr, req, "%s", err)
+		return
+	}
+
+	v.Display(wr, req)
+}
@@ -0,0 +1,98 @@
+package app
+
+import "context"
+import "net/http"
+
+import "github.com/qrochet/qrochet/pkg/model"
+import "github.com/qrochet/qrochet/pkg/roh"
+
+// rohPrefix is the prefix of the paths of the roh methods. The methods
+// are listed at rohPrefix + roh.WellKnown.
+const rohPrefix = "/roh"
+
+// rohRange is the request of the roh methods that list a page.
+type rohRange struct {
+	First  string `json:"first"`
+	Amount int    `json:"amount"`
+}
+
+// rohID is the request of the roh methods that take an ID.
+type rohID struct {
+	ID string `json:"id"`
+}
+
+// newRoh returns the roh server with the methods of Qrochet. Every method
+// needs the bearer token of a session, as the JSON API returns it on log
+// in, so the server only serves its handler on q.ServeMux and does not
+// listen itself.
+func (q *Qrochet) newRoh() *roh.Server {
+	s := roh.NewServer("")
+	s.MaxBody = apiMaxBody
+	roh.Register(s, "/crafts", q.rohCrafts)
+	roh.Register(s, "/craft", q.rohCraft)
+	roh.Register(s, "/my/crafts", q.rohMyCrafts)
+	roh.Register(s, "/profile", q.rohProfile)
+	s.Use(roh.Auth(q))
+	return s
+}
+
+// handleRoh mounts the roh server at rohPrefix.
+func (q *Qrochet) handleRoh() {
+	q.ServeMux.Handle(rohPrefix+"/", http.StripPrefix(rohPrefix, q.roh.Server.Handler))
+}
+
+// rohSession returns the session of the subject of the request. Like
+// apiCheck it refuses users that still have to set up two factor
+// authentication.
+func (q *Qrochet) rohSession(ctx context.Context) (*model.Session, error) {
+	session, err := q.Repository.Session().Get(ctx, roh.Subject(ctx))
+	if err != nil {
+		return nil, model.ErrorPleaseLogIn
+	}
+	user, err := q.Repository.User().Get(ctx, session.UserID)
+	if err != nil {
+		return nil, model.ErrorPleaseLogIn
+	}
+	if q.logic.RequiresTOTP(ctx, user) {
+		return nil, model.ErrorTOTPRequired
+	}
+	return &session, nil
+}
+
+func (q *Qrochet) rohCrafts(ctx context.Context, req rohRange) (*model.RangeResult[model.Craft], error) {
+	return q.logic.PublicCrafts(ctx, model.RangeQuery[model.Craft]{First: req.First, Amount: req.Amount})
+}
+
+func (q *Qrochet) rohCraft(ctx context.Context, req rohID) (*model.Craft, error) {
+	craft, err := q.Repository.Craft().GetByID(ctx, req.ID)
+	if err != nil || craft.Hidden {
+		return nil, model.ErrorCraftNotFound
+	}
+	return &craft, nil
+}
+
+func (q *Qrochet) rohMyCrafts(ctx context.Context, req rohRange) (*model.RangeResult[model.Craft], error) {
+	session, err := q.rohSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	crafts, err := q.logic.CraftsForSession(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	query := model.RangeQuery[model.Craft]{First: req.First, Amount: req.Amount}
+	return model.Paginate(crafts, query, func(c model.Craft) string { return c.ID }), nil
+}
+
+func (q *Qrochet) rohProfile(ctx context.Context, _ struct{}) (*model.User, error) {
+	session, err := q.rohSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	user, err := q.logic.Profile(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	redacted := user.Redact()
+	return &redacted, nil
+}
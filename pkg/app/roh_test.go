@@ -0,0 +1,55 @@
+package app
+
+import "context"
+import "net/http"
+import "net/http/httptest"
+import "strings"
+import "testing"
+
+import "github.com/qrochet/qrochet/pkg/model"
+import "github.com/qrochet/qrochet/pkg/roh"
+
+func TestRoh(t *testing.T) {
+	q := newTestApp(t)
+	_, token := apiLogin(t, q, "Alice", "alice@example.com")
+	srv := httptest.NewServer(q.ServeMux)
+	defer srv.Close()
+	ctx := context.Background()
+
+	client, err := roh.NewClient(srv.URL + rohPrefix)
+	if err != nil {
+		t.Fatalf("NewClient: %s", err)
+	}
+	disc, err := client.Discover(ctx)
+	if err != nil {
+		t.Fatalf("Discover: %s", err)
+	}
+	paths := map[string]bool{}
+	for _, method := range disc.Methods {
+		paths[method.Path] = true
+	}
+	for _, path := range []string{"/crafts", "/craft", "/my/crafts", "/profile"} {
+		if !paths[path] {
+			t.Errorf("Discover: method %s missing in %+v", path, disc.Methods)
+		}
+	}
+
+	_, err = roh.Call[model.User](ctx, client, "/profile", struct{}{})
+	if merr, ok := err.(model.Error); !ok || merr.Code != http.StatusUnauthorized {
+		t.Errorf("profile without token: %v", err)
+	}
+
+	client.SetToken(token)
+	user, err := roh.Call[model.User](ctx, client, "/profile", struct{}{})
+	if err != nil || user.Email != "alice@example.com" || strings.HasPrefix(user.Hash, "$") {
+		t.Errorf("profile: %v %+v", err, user)
+	}
+	_, err = roh.Call[model.Craft](ctx, client, "/craft", rohID{ID: "nope"})
+	if merr, ok := err.(model.Error); !ok || merr.Code != http.StatusNotFound {
+		t.Errorf("missing craft: %v", err)
+	}
+	crafts, err := roh.Call[model.RangeResult[model.Craft]](ctx, client, "/my/crafts", rohRange{})
+	if err != nil || len(crafts.Items) != 0 {
+		t.Errorf("my crafts: %v %+v", err, crafts)
+	}
+}
@@ -0,0 +1,81 @@
+package app
+
+import "context"
+import "errors"
+import "net/http"
+import "log/slog"
+
+import "github.com/qrochet/qrochet/pkg/model"
+import "github.com/qrochet/qrochet/pkg/search"
+
+// searchAmount is the maximum amount of crafts on the search page.
+const searchAmount = 50
+
+type searchView struct {
+	Query   string
+	Results []model.Craft
+}
+
+// watchSearch keeps the search index up to date until the context is done.
+// If the index is empty, for example on first use, all crafts are indexed.
+func (q *Qrochet) watchSearch(ctx context.Context) {
+	count, err := q.search.Count(ctx)
+	if err != nil {
+		slog.Error("search.Count", "err", err)
+	}
+	rebuild := err == nil && count == 0
+	if rebuild {
+		slog.Info("search index empty, indexing all crafts")
+	}
+	q.search.Watch(ctx, q.Repository.Craft(), rebuild)
+}
+
+// Reindex rebuilds the search index from scratch.
+// It returns the amount of indexed crafts.
+func (q *Qrochet) Reindex(ctx context.Context) (int, error) {
+	count, err := q.search.Rebuild(ctx, q.Repository.Craft())
+	if err != nil {
+		return count, err
+	}
+	slog.Info("search index rebuilt", "crafts", count)
+	return count, nil
+}
+
+func (q *Qrochet) getSearch(wr http.ResponseWriter, req *http.Request) {
+	v := q.view()
+	v.check(wr, req)
+
+	v.Search.Query = req.FormValue("q")
+	if v.Search.Query == "" {
+		v.Display(wr, req)
+		return
+	}
+
+	results, err := q.search.Search(req.Context(), v.Search.Query, searchAmount)
+	if errors.Is(err, search.ErrorQueryEmpty) {
+		v.DisplayError(wr, req, "%s", err)
+		return
+	}
+	if err != nil {
+		slog.Error("getSearch", "err", err)
+		v.DisplayError(wr, req, "Search failed.")
+		return
+	}
+
+	for _, res := range results {
+		craft, err := q.Repository.Craft().GetForUserID(req.Context(), res.ID, res.UserID)
+		if err != nil {
+			slog.Warn("getSearch Craft.GetForUserID", "err", err, "craft", res.ID)
+			continue
+		}
+		if craft.Hidden {
+			continue
+		}
+		v.Search.Results = append(v.Search.Results, craft)
+	}
+
+	if len(v.Search.Results) == 0 {
+		v.Message("No crafts found.")
+	}
+	v.Display(wr, req)
+}
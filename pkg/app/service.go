@@ -0,0 +1,19 @@
+package app
+
+import "context"
+import "log/slog"
+
+import "github.com/qrochet/qrochet/pkg/service"
+
+var _ service.Auth = &Qrochet{}
+
+// ServeLogic serves the logic of Qrochet as a NATS micro service on the
+// NATS connection of the repository until ctx is done.
+func (q *Qrochet) ServeLogic(ctx context.Context) (*service.Service, error) {
+	svc, err := service.New(ctx, q.conn, q.logic, q)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("logic service started", "name", service.Name, "id", svc.Info().ID, "prefix", service.Prefix)
+	return svc, nil
+}
@@ -0,0 +1,91 @@
+package app
+
+import "html/template"
+import "log/slog"
+import "net/http"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+// twoFactor is the page where users set up two factor authentication.
+type twoFactor struct {
+	Enabled  bool
+	Required bool         // Required is true if the role of the user requires it.
+	Left     int          // Left is the amount of unused recovery codes.
+	Secret   string       // Secret is the TOTP secret that is being set up.
+	URI      template.URL // URI is the provisioning URI of the secret.
+	Codes    []string     // Codes are new recovery codes, shown only once.
+}
+
+// hasSession checks the session of the request like IsLoggedIn, but also
+// accepts users that still have to set up two factor authentication.
+func (v *view) hasSession(wr http.ResponseWriter, req *http.Request) bool {
+	return v.check(wr, req) == nil && v.Session != nil
+}
+
+// displayTwoFactor shows the two factor page with the state of the user.
+func (q *Qrochet) displayTwoFactor(wr http.ResponseWriter, req *http.Request, v *view) {
+	user, err := q.Repository.User().Get(req.Context(), v.Session.UserID)
+	if err != nil {
+		v.DisplayError(wr, req, "%s", model.ErrorPleaseLogIn)
+		return
+	}
+	v.TwoFactor.Enabled = user.HasTOTP()
+	v.TwoFactor.Required = q.logic.Policy(req.Context()).RequiresTOTP(user.Role)
+	v.TwoFactor.Left = len(user.Recovery)
+	v.Display(wr, req)
+}
+
+func (q *Qrochet) getMyTwoFactor(wr http.ResponseWriter, req *http.Request) {
+	v := q.view()
+	if !v.hasSession(wr, req) {
+		v.DisplayError(wr, req, "Please log in.")
+		return
+	}
+	q.displayTwoFactor(wr, req, v)
+}
+
+// postMyTwoFactor sets up, confirms or disables two factor authentication,
+// or makes new recovery codes, depending on the action of the form.
+func (q *Qrochet) postMyTwoFactor(wr http.ResponseWriter, req *http.Request) {
+	v := q.view()
+	if !v.hasSession(wr, req) {
+		v.DisplayError(wr, req, "Please log in.")
+		return
+	}
+
+	err := req.ParseMultipartForm(mpfMaxMemory)
+	if err != nil {
+		slog.Error("postMyTwoFactor req.ParseForm", "err", err)
+		v.DisplayError(wr, req, "Form error.")
+		return
+	}
+
+	ctx := req.Context()
+	code := req.FormValue("code")
+	switch req.FormValue("action") {
+	case "enroll":
+		var uri string
+		v.TwoFactor.Secret, uri, err = q.logic.EnrollTOTP(ctx, v.Session)
+		// The otpauth scheme is not known to html/template, but it is safe.
+		v.TwoFactor.URI = template.URL(uri)
+	case "confirm":
+		v.TwoFactor.Codes, err = q.logic.ConfirmTOTP(ctx, v.Session, code)
+		if err == nil {
+			v.NeedsTOTP = false
+			v.Message("Two factor authentication is enabled.")
+		}
+	case "recovery":
+		v.TwoFactor.Codes, err = q.logic.NewRecoveryCodes(ctx, v.Session, code)
+	case "disable":
+		err = q.logic.DisableTOTP(ctx, v.Session, code)
+		if err == nil {
+			v.Message("Two factor authentication is disabled.")
+		}
+	default:
+		v.Error("Unknown action.")
+	}
+	if err != nil {
+		v.Error("%s", err)
+	}
+	q.displayTwoFactor(wr, req, v)
+}
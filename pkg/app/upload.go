@@ -54,6 +54,23 @@ func (q *Qrochet) getUpload(wr http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// Hidden images are only visible to staff for moderation.
+	if image.Hidden && !v.IsStaff() {
+		image.ReadCloser.Close()
+		wr.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// The thumbnail is rendered by a worker, so until it is there, the
+	// image itself is shown.
+	if req.FormValue("size") == "thumb" {
+		thumb, err := q.Repository.Thumbnail().Get(req.Context(), id)
+		if err == nil {
+			image.ReadCloser.Close()
+			image = thumb
+		}
+	}
+
 	// XXX Also support other uploads.
 	wr.Header().Set("Content-Type", "image/jpeg")
 	// XXX: Should provide the size. wr.Header().Set("Content-Length", image.Size)
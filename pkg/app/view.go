@@ -1,27 +1,44 @@
 package app
 
 import "net/http"
+import "context"
+import "errors"
 import "path"
 import "fmt"
 import "time"
 import "log/slog"
-import "aidanwoods.dev/go-paseto"
 
 import "github.com/qrochet/qrochet/pkg/model"
+import "github.com/qrochet/qrochet/pkg/roh"
 
 // view is the view of state the current (autheticated) user
 type view struct {
-	app      *Qrochet
-	Register register
-	Login    login
-	Logout   logout
-	Craft    craft
+	app       *Qrochet
+	ctx       model.Context
+	Register  register
+	Login     login
+	Link      loginLink
+	Logout    logout
+	Craft     craft
+	Report    report
+	Like      like
+	Comment   comment
+	Feed      feed
+	Follow    follow
+	Search    searchView
+	Audit     audit
+	TwoFactor twoFactor
+	Profile   profile
 
 	Messages []string // Messages to the user.
 	Errors   []string // Error messages to the user.
 
 	Session *model.Session
 	User    *model.User
+
+	// NeedsTOTP is true if the role of the user requires two factor
+	// authentication, but the user did not set it up yet.
+	NeedsTOTP bool
 }
 
 func (v *view) Message(form string, args ...any) {
@@ -36,58 +53,115 @@ func (v *view) Error(form string, args ...any) {
 
 const cookieName = "QROCHET_SESSION"
 
-func (v *view) check(wr http.ResponseWriter, req *http.Request) error {
-	cookie, err := req.Cookie(cookieName)
-	if err != nil {
-		slog.Error("Error parsing cookie", "err", err)
-		// Cookie is not ok but it is the same as not being logged in.
-		return nil
-	}
-	if cookie == nil {
-		// Not logged in yet.
-		return nil
+// context returns the context of the request of the view.
+func (v *view) context() model.Context {
+	if v.ctx == nil {
+		return context.Background()
 	}
+	return v.ctx
+}
 
-	if err = cookie.Valid(); err != nil {
-		slog.Error("Cookie not valid", "err", err)
-		return nil
-	}
+// SessionToken returns a new PASETO token for the session.
+func (q *Qrochet) SessionToken(session model.Session) string {
+	return roh.PASETO{Key: q.Key}.Token(session.UserID, time.Until(session.End))
+}
+
+// errSessionExpired means the session of a token has expired.
+var errSessionExpired = errors.New("session expired")
 
-	tok, err := paseto.NewParser().ParseV4Local(v.app.Key, cookie.Value, []byte{})
+// tokenSession returns the session of the PASETO token.
+func (q *Qrochet) tokenSession(ctx model.Context, token string) (*model.Session, error) {
+	sub, err := roh.PASETO{Key: q.Key}.Authenticate(ctx, token)
 	if err != nil {
 		slog.Error("PASETO token not secure", "err", err)
-		return err
+		return nil, err
 	}
 
-	sub, err := tok.GetSubject()
-	if err != nil {
-		slog.Error("PASETO token subject", "err", err)
-		return err
-	}
-	session, err := v.app.Repository.Session().Get(req.Context(), sub)
+	session, err := q.Repository.Session().Get(ctx, sub)
 	if err != nil {
 		slog.Error("Session expired", "err", err)
-		return err
+		return nil, err
 	}
 
 	if session.End.Before(time.Now()) {
-		err = v.app.Repository.Session().Delete(req.Context(), sub)
+		err = q.Repository.Session().Delete(ctx, sub)
 		if err != nil {
 			slog.Error("Could not delete expired session", "err", err)
 		}
 		slog.Error("session expired")
+		return nil, errSessionExpired
+	}
+	return &session, nil
+}
+
+// Authenticate returns the ID of the user of the session of the PASETO
+// token, if the session is still valid. It is a roh.Authenticator, so
+// internal services can accept the tokens of logged in users.
+func (q *Qrochet) Authenticate(ctx context.Context, token string) (string, error) {
+	session, err := q.tokenSession(ctx, token)
+	if err != nil {
+		return "", err
+	}
+	return session.UserID, nil
+}
+
+var _ roh.Authenticator = &Qrochet{}
+
+// errSessionUser means the user of a valid session could not be loaded.
+var errSessionUser = errors.New("cannot get user for session")
+
+// check checks the session of the request like authenticate, and shows an
+// error page if the user of the session cannot be loaded.
+func (v *view) check(wr http.ResponseWriter, req *http.Request) error {
+	err := v.authenticate(req)
+	if errors.Is(err, errSessionUser) {
+		v.DisplayError(wr, req, "Cannot get user for session.")
+	}
+	return err
+}
+
+// authenticate checks the session of the request without writing to the
+// response. The PASETO token of the session may be in a bearer token or in
+// the session cookie.
+func (v *view) authenticate(req *http.Request) error {
+	v.ctx = req.Context()
+	token := roh.BearerToken(req)
+	if token == "" {
+		cookie, err := req.Cookie(cookieName)
+		if err != nil {
+			slog.Error("Error parsing cookie", "err", err)
+			// Cookie is not ok but it is the same as not being logged in.
+			return nil
+		}
+		if cookie == nil {
+			// Not logged in yet.
+			return nil
+		}
+
+		if err = cookie.Valid(); err != nil {
+			slog.Error("Cookie not valid", "err", err)
+			return nil
+		}
+		token = cookie.Value
+	}
+
+	session, err := v.app.tokenSession(req.Context(), token)
+	if errors.Is(err, errSessionExpired) {
 		return nil
 	}
+	if err != nil {
+		return err
+	}
 
 	user, err := v.app.Repository.User().Get(req.Context(), session.UserID)
 	if err != nil {
 		slog.Error("User.Get", "err", err)
-		v.DisplayError(wr, req, "Cannot get user for session.")
-		return err
+		return fmt.Errorf("%w: %w", errSessionUser, err)
 	}
 	v.User = &user
+	v.NeedsTOTP = v.app.logic.RequiresTOTP(req.Context(), user)
 
-	v.Session = &session
+	v.Session = session
 	return nil
 }
 
@@ -95,38 +169,31 @@ const sessionTimeoutSeconds = 60 * 60 * 24
 const sessionTimeout = time.Second * sessionTimeoutSeconds
 
 func (v *view) newSession(wr http.ResponseWriter, req *http.Request, user model.User) error {
-	var err error
-	session := model.Session{
-		UserID: user.ID,
-		Start:  time.Now(),
-		End:    time.Now().Add(sessionTimeout),
+	if v == nil || v.app == nil || v.app.Repository == nil {
+		return fmt.Errorf("newSession nil %v", v)
 	}
 
-	// Delete any existing sessions and do not care about errors.
-	if v == nil || v.app == nil || v.app.Repository == nil || v.app.Repository.Session == nil {
-		return fmt.Errorf("newSession nil %v %v %v %v", v, v.app, v.app.Repository, v.app.Repository.Session)
-	}
-
-	if v.Session != nil {
+	// Delete the session of another user that was logged in and do not
+	// care about errors. NewSession deletes the old session of the user.
+	if v.Session != nil && v.Session.UserID != user.ID {
 		v.app.Repository.Session().Delete(req.Context(), v.Session.UserID)
-	} else {
-		v.app.Repository.Session().Delete(req.Context(), user.ID)
 	}
 
-	session, err = v.app.Repository.Session().Put(req.Context(), user.ID, session)
+	session, err := v.app.logic.NewSession(req.Context(), user, sessionTimeout)
 	if err != nil {
 		v.Session = nil
-		slog.Error("Cannot save session", "err", err)
 		return err
 	}
-	v.Session = &session
+	v.setSession(wr, session, user)
+	return nil
+}
 
-	tok := paseto.NewToken()
-	tok.SetNotBefore(time.Now())
-	tok.SetExpiration(time.Now().Add(sessionTimeout))
-	tok.SetSubject(v.Session.UserID)
+// setSession sets the session cookie for a session of the user that was
+// already created.
+func (v *view) setSession(wr http.ResponseWriter, session *model.Session, user model.User) {
+	v.Session = session
 
-	encrypted := tok.V4Encrypt(v.app.Key, []byte{})
+	encrypted := v.app.SessionToken(*session)
 	cookie := http.Cookie{}
 	cookie.Secure = true
 	cookie.HttpOnly = true
@@ -137,18 +204,40 @@ func (v *view) newSession(wr http.ResponseWriter, req *http.Request, user model.
 	http.SetCookie(wr, &cookie)
 
 	v.User = &user
-
-	return nil
 }
 
+// IsLoggedIn returns whether the request has a session. Users that still
+// have to set up two factor authentication for their role are not logged
+// in yet, except on the pages that use hasSession.
 func (v *view) IsLoggedIn(wr http.ResponseWriter, req *http.Request) bool {
 	err := v.check(wr, req)
 	if err != nil {
 		return false
 	}
+	if v.Session != nil && v.NeedsTOTP {
+		v.Error("%s", model.ErrorTOTPRequired)
+		return false
+	}
 	return v.Session != nil
 }
 
+// PasswordMinLength returns the minimum length of passwords, for forms.
+func (v *view) PasswordMinLength() int {
+	return model.PasswordMinLength
+}
+
+// FreshLoginMinutes returns how many minutes after logging in users without
+// password can change their email address, for forms.
+func (v *view) FreshLoginMinutes() int {
+	return int(model.FreshLoginAge.Minutes())
+}
+
+// IsStaff returns true if the user of the view is staff. Staff that still
+// has to set up two factor authentication is not shown staff pages yet.
+func (v *view) IsStaff() bool {
+	return v.User != nil && v.User.Role >= model.RoleStaff && !v.NeedsTOTP
+}
+
 // Displays the template for the path or the request with this view.
 func (v *view) Display(wr http.ResponseWriter, req *http.Request) {
 	name := path.Base(req.URL.Path) + ".tmpl.html"
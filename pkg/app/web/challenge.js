@@ -0,0 +1,43 @@
+// Proof of work challenges for Qrochet forms.
+// When a form with a data-pow attribute is submitted, this finds a number
+// whose SHA-256 hash, appended to the data, starts with data-difficulty
+// zero bits, puts it in the answer field and then submits the form.
+(function () {
+    const encoder = new TextEncoder();
+
+    function zeros(hash) {
+        let count = 0;
+        for (const b of new Uint8Array(hash)) {
+            if (b !== 0) {
+                return count + Math.clz32(b) - 24;
+            }
+            count += 8;
+        }
+        return count;
+    }
+
+    async function solve(data, difficulty) {
+        for (let i = 0; ; i++) {
+            const hash = await crypto.subtle.digest("SHA-256", encoder.encode(data + i));
+            if (zeros(hash) >= difficulty) {
+                return String(i);
+            }
+        }
+    }
+
+    document.addEventListener("submit", async function (e) {
+        const form = e.target;
+        if (!form.dataset || !form.dataset.pow) {
+            return;
+        }
+        e.preventDefault();
+        const button = form.querySelector("button[type=submit]");
+        if (button) {
+            button.disabled = true;
+        }
+        const answer = await solve(form.dataset.pow, Number(form.dataset.difficulty));
+        form.querySelector("[name=answer]").value = answer;
+        delete form.dataset.pow;
+        form.submit();
+    });
+})();
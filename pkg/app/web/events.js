@@ -0,0 +1,52 @@
+// Live updates for Qrochet over Server-Sent Events.
+// When the dialog has an element with a data-events attribute, this
+// connects to the URL in that attribute, and inserts new crafts and
+// comments at the start of that element, and updates like counts,
+// without reloading the page.
+(function () {
+    let source = null;
+    let url = null;
+
+    function insert(e) {
+        const data = JSON.parse(e.data);
+        const live = document.querySelector("[data-events]");
+        if (!live) {
+            return;
+        }
+        if (document.getElementById("craft-" + data.id) || document.getElementById("comment-" + data.id)) {
+            return;
+        }
+        live.insertAdjacentHTML("afterbegin", data.html);
+    }
+
+    function like(e) {
+        const data = JSON.parse(e.data);
+        const selector = "#like-" + data.craft_id + " button, #like-" + data.craft_id + " span";
+        document.querySelectorAll(selector).forEach(function (el) {
+            el.textContent = el.textContent.replace(/\d+\s*$/, data.count);
+        });
+    }
+
+    function connect() {
+        const live = document.querySelector("[data-events]");
+        const next = live ? live.dataset.events : null;
+        if (next === url) {
+            return;
+        }
+        if (source) {
+            source.close();
+            source = null;
+        }
+        url = next;
+        if (!url) {
+            return;
+        }
+        source = new EventSource(url);
+        source.addEventListener("craft", insert);
+        source.addEventListener("comment", insert);
+        source.addEventListener("like", like);
+    }
+
+    new MutationObserver(connect).observe(document.body, { childList: true, subtree: true });
+    connect();
+})();
@@ -1,93 +1,386 @@
-// Package censor helps censoring profanties with a strings.Replacer.
+// Package censor helps censoring profanities.
+//
+// Text is split into words, and every word is normalised before it is
+// compared with the word lists: Unicode compatibility characters and
+// accents are folded, the word is lower cased and leetspeak such as
+// "$h1t" is folded to plain letters. Only whole words are matched, so
+// innocent words such as "class" or "cocktail" are left alone, unless a
+// list entry explicitly asks for a prefix or infix match.
 package censor
 
+import "bufio"
+import "context"
+import "embed"
+import "errors"
+import "io"
+import "io/fs"
+import "os"
+import "path"
 import "strings"
+import "sync"
+import "unicode"
 
-var Replacer = strings.NewReplacer(
-	"anal", "*",
-	"anus", "*",
-	"arse", "*",
-	"ass", "*",
-	"ballsack", "*",
-	"balls", "*",
-	"bastard", "*",
-	"bitch", "*",
-	"btch", "*",
-	"biatch", "*",
-	"blowjob", "*",
-	"bollock", "*",
-	"bollok", "*",
-	"boner", "*",
-	"boob", "*",
-	"bugger", "*",
-	"butt", "*",
-	"choad", "*",
-	"clitoris", "*",
-	"cock", "*",
-	"coon", "*",
-	"crap", "*",
-	"cum", "*",
-	"cunt", "*",
-	"dick", "*",
-	"dildo", "*",
-	"douchebag", "*",
-	"dyke", "*",
-	"fag", "*",
-	"feck", "*",
-	"fellate", "*",
-	"fellatio", "*",
-	"felching", "*",
-	"fuck", "*",
-	"fudgepacker", "*",
-	"flange", "*",
-	"gtfo", "*",
-	"gyat", "*",
-	"horny", "*",
-	"incest", "*",
-	"jerk", "*",
-	"jizz", "*",
-	"labia", "*",
-	"masturbat", "*",
-	"muff", "*",
-	"naked", "*",
-	"nazi", "*",
-	"nigga", "*",
-	"niggu", "*",
-	"nipple", "*",
-	"nips", "*",
-	"nude", "*",
-	"pedophile", "*",
-	"penis", "*",
-	"piss", "*",
-	"poop", "*",
-	"porn", "*",
-	"prick", "*",
-	"prostitut", "*",
-	"pube", "*",
-	"pussie", "*",
-	"pussy", "*",
-	"queer", "*",
-	"rape", "*",
-	"rapist", "*",
-	"retard", "*",
-	"rimjob", "*",
-	"scrotum", "*",
-	"sex", "*",
-	"shit", "*",
-	"slut", "*",
-	"spunk", "*",
-	"stfu", "*",
-	"suckmy", "*",
-	"tits", "*",
-	"tittie", "*",
-	"titty", "*",
-	"turd", "*",
-	"twat", "*",
-	"vagina", "*",
-	"wank", "*",
-	"whore", "*",
-)
+import "golang.org/x/text/unicode/norm"
+import "github.com/nats-io/nats.go/jetstream"
 
+//go:embed words
+var words embed.FS
+
+// AllowKey is the key or file name of the allow-list when loading
+// word lists from a directory or a key value bucket.
+const AllowKey = "allow"
+
+// Mask is what Replace replaces censored words with.
+const Mask = "*"
+
+// Match is a censored word that was found in a text.
+type Match struct {
+	Word  string // Word is the word as found in the text.
+	Term  string // Term is the entry of the word list that matched.
+	Lang  string // Lang is the language of the word list that matched.
+	Start int    // Start is the byte offset of the word in the text.
+	End   int    // End is the byte offset just after the word in the text.
+}
+
+// list is a word list for one language.
+type list struct {
+	exact  map[string]bool
+	prefix []string
+	infix  []string
+}
+
+func newList() *list {
+	return &list{exact: map[string]bool{}}
+}
+
+func (l *list) add(term string) {
+	term = strings.TrimSpace(term)
+	if strings.HasPrefix(term, "*") && strings.HasSuffix(term, "*") && len(term) > 2 {
+		l.infix = append(l.infix, Normalize(term[1:len(term)-1]))
+	} else if strings.HasSuffix(term, "*") && len(term) > 1 {
+		l.prefix = append(l.prefix, Normalize(term[:len(term)-1]))
+	} else if term != "" {
+		l.exact[Normalize(term)] = true
+	}
+}
+
+// match returns the term that matches the normalised word, or "" if none.
+func (l *list) match(word string) string {
+	if l.exact[word] {
+		return word
+	}
+	for _, suffix := range []string{"s", "es"} {
+		stem, ok := strings.CutSuffix(word, suffix)
+		if ok && l.exact[stem] {
+			return stem
+		}
+	}
+	for _, prefix := range l.prefix {
+		if strings.HasPrefix(word, prefix) {
+			return prefix + "*"
+		}
+	}
+	for _, infix := range l.infix {
+		if strings.Contains(word, infix) {
+			return "*" + infix + "*"
+		}
+	}
+	return ""
+}
+
+// Engine is a censor engine with word lists per language and an
+// allow-list. It is safe for concurrent use.
+type Engine struct {
+	mu    sync.RWMutex
+	lists map[string]*list
+	allow map[string]bool
+}
+
+// New returns a new, empty censor engine.
+func New() *Engine {
+	return &Engine{lists: map[string]*list{}, allow: map[string]bool{}}
+}
+
+// NewDefault returns a new censor engine with the built in word lists.
+func NewDefault() *Engine {
+	e := New()
+	err := e.LoadFS(words, "words")
+	if err != nil {
+		panic("censor: built in word lists broken: " + err.Error())
+	}
+	return e
+}
+
+// Default is the default engine used by Replace and Check.
+var Default = NewDefault()
+
+// Add adds terms to the word list for the language.
+// A term ending in * matches all words that start with the term,
+// a term that starts and ends with * matches all words that contain it.
+func (e *Engine) Add(lang string, terms ...string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	l, ok := e.lists[lang]
+	if !ok {
+		l = newList()
+		e.lists[lang] = l
+	}
+	for _, term := range terms {
+		l.add(term)
+	}
+}
+
+// Allow adds words to the allow-list. Allowed words are never censored.
+func (e *Engine) Allow(allowed ...string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, word := range allowed {
+		word = strings.TrimSpace(word)
+		if word != "" {
+			e.allow[Normalize(word)] = true
+		}
+	}
+}
+
+// Reset removes all word lists and the allow-list.
+func (e *Engine) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.lists = map[string]*list{}
+	e.allow = map[string]bool{}
+}
+
+// readLines reads the terms from rd, one per line, skipping # comments
+// and empty lines.
+func readLines(rd io.Reader) ([]string, error) {
+	var res []string
+	s := bufio.NewScanner(rd)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		res = append(res, line)
+	}
+	return res, s.Err()
+}
+
+// Load loads a word list for the language from rd.
+// If lang is AllowKey the words are added to the allow-list instead.
+func (e *Engine) Load(lang string, rd io.Reader) error {
+	terms, err := readLines(rd)
+	if err != nil {
+		return err
+	}
+	if lang == AllowKey {
+		e.Allow(terms...)
+	} else {
+		e.Add(lang, terms...)
+	}
+	return nil
+}
+
+// LoadFile loads a word list for the language from the named file.
+func (e *Engine) LoadFile(lang, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return e.Load(lang, f)
+}
+
+// LoadFS loads all *.txt files in dir of fsys. The name of the file
+// without the extension is the language, for example en.txt, and
+// allow.txt is the allow-list.
+func (e *Engine) LoadFS(fsys fs.FS, dir string) error {
+	names, err := fs.Glob(fsys, path.Join(dir, "*.txt"))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		f, err := fsys.Open(name)
+		if err != nil {
+			return err
+		}
+		lang := strings.TrimSuffix(path.Base(name), ".txt")
+		err = e.Load(lang, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadDir loads all *.txt files in the directory like LoadFS does.
+func (e *Engine) LoadDir(dir string) error {
+	return e.LoadFS(os.DirFS(dir), ".")
+}
+
+// LoadKeyValue loads the word lists from a NATS key value bucket.
+// Each key is a language, and the value is the word list, one word per line.
+// The key AllowKey is the allow-list.
+func (e *Engine) LoadKeyValue(ctx context.Context, kv jetstream.KeyValue) error {
+	lister, err := kv.ListKeys(ctx)
+	if err != nil {
+		return err
+	}
+	defer lister.Stop()
+
+	for key := range lister.Keys() {
+		entry, err := kv.Get(ctx, key)
+		if err != nil {
+			if errors.Is(err, jetstream.ErrKeyNotFound) {
+				continue
+			}
+			return err
+		}
+		err = e.Load(key, strings.NewReader(string(entry.Value())))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// leet maps leetspeak characters to the letters they stand for.
+var leet = map[rune]rune{
+	'0': 'o',
+	'1': 'i',
+	'3': 'e',
+	'4': 'a',
+	'5': 's',
+	'7': 't',
+	'8': 'b',
+	'9': 'g',
+	'@': 'a',
+	'$': 's',
+	'!': 'i',
+	'|': 'i',
+}
+
+// Normalize normalises a word for matching: compatibility characters are
+// decomposed, accents are removed, letters are lower cased and leetspeak
+// characters are folded to the letters they stand for.
+func Normalize(word string) string {
+	b := strings.Builder{}
+	for _, r := range norm.NFKD.String(word) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if l, ok := leet[r]; ok {
+			r = l
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+func isWordRune(r rune) bool {
+	if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) {
+		return true
+	}
+	_, ok := leet[r]
+	return ok
+}
+
+// isEdgeRune returns true for leetspeak characters that are
+// more likely to be punctuation at the start or end of a word.
+func isEdgeRune(r rune) bool {
+	return r == '!' || r == '|'
+}
+
+// split splits the text into words and returns their byte offsets.
+func split(in string) [][2]int {
+	var res [][2]int
+	start := -1
+	for i, r := range in {
+		if isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			res = append(res, [2]int{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		res = append(res, [2]int{start, len(in)})
+	}
+
+	// Trim punctuation like leetspeak characters from the edges.
+	for i := range res {
+		for res[i][0] < res[i][1] && isEdgeRune(rune(in[res[i][0]])) {
+			res[i][0]++
+		}
+		for res[i][1] > res[i][0] && isEdgeRune(rune(in[res[i][1]-1])) {
+			res[i][1]--
+		}
+	}
+	return res
+}
+
+// Check returns all censored words in the text, in order.
+// It returns nil if the text is clean.
+func (e *Engine) Check(in string) []Match {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	var res []Match
+	for _, span := range split(in) {
+		if span[0] >= span[1] {
+			continue
+		}
+		word := in[span[0]:span[1]]
+		normal := Normalize(word)
+		if e.allow[normal] {
+			continue
+		}
+		for lang, l := range e.lists {
+			term := l.match(normal)
+			if term == "" {
+				continue
+			}
+			res = append(res, Match{
+				Word:  word,
+				Term:  term,
+				Lang:  lang,
+				Start: span[0],
+				End:   span[1],
+			})
+			break
+		}
+	}
+	return res
+}
+
+// Replace replaces all censored words in the text with Mask.
+func (e *Engine) Replace(in string) string {
+	matches := e.Check(in)
+	if len(matches) == 0 {
+		return in
+	}
+
+	b := strings.Builder{}
+	last := 0
+	for _, m := range matches {
+		b.WriteString(in[last:m.Start])
+		b.WriteString(Mask)
+		last = m.End
+	}
+	b.WriteString(in[last:])
+	return b.String()
+}
+
+// Replace replaces all censored words in the text using the Default engine.
 func Replace(in string) string {
-	return Replacer.Replace(in)
+	return Default.Replace(in)
+}
+
+// Check checks the text for censored words using the Default engine.
+func Check(in string) []Match {
+	return Default.Check(in)
 }
@@ -0,0 +1,96 @@
+package censor
+
+import "strings"
+import "testing"
+
+func TestReplaceInnocent(t *testing.T) {
+	innocent := []string{
+		"class",
+		"Scunthorpe",
+		"cocktail",
+		"assistant",
+		"butterfly",
+		"Two balls of yarn for a butter yellow cocktail dress.",
+		"cumin",
+		"mishit",
+	}
+	for _, in := range innocent {
+		out := Replace(in)
+		if out != in {
+			t.Errorf("Replace(%q) = %q, expected unchanged", in, out)
+		}
+	}
+}
+
+func TestReplaceProfane(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{"what the fuck", "what the *"},
+		{"Fucking yarn!", "* yarn!"},
+		{"$h1t happens", "* happens"},
+		{"you ａｓｓ", "you *"},
+		{"sh!t", "*"},
+		{"shît", "*"},
+		{"asses and butts", "* and *"},
+		{"masturbation", "*"},
+	}
+	for _, c := range cases {
+		out := Replace(c.in)
+		if out != c.out {
+			t.Errorf("Replace(%q) = %q, expected %q", c.in, out, c.out)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	in := "A nice scarf, crap."
+	matches := Check(in)
+	if len(matches) != 1 {
+		t.Fatalf("Check(%q): %v", in, matches)
+	}
+	m := matches[0]
+	if m.Word != "crap" || m.Term != "crap" || m.Lang != "en" || in[m.Start:m.End] != "crap" {
+		t.Errorf("Check(%q): %+v", in, m)
+	}
+
+	if matches := Check("A nice scarf."); matches != nil {
+		t.Errorf("Check clean text: %v", matches)
+	}
+}
+
+func TestEngineLoad(t *testing.T) {
+	e := New()
+	err := e.Load("nl", strings.NewReader("# Dutch\nkut\nklote*\n"))
+	if err != nil {
+		t.Fatalf("Load: %s", err)
+	}
+	err = e.Load(AllowKey, strings.NewReader("kutje\n"))
+	if err != nil {
+		t.Fatalf("Load: %s", err)
+	}
+	e.Add("nl", "*rotzak*")
+
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{"kut", "*"},
+		{"kloteweer", "*"},
+		{"rotzakken", "*"},
+		{"kutje", "kutje"},
+		{"kutten", "kutten"},
+	}
+	for _, c := range cases {
+		out := e.Replace(c.in)
+		if out != c.out {
+			t.Errorf("Replace(%q) = %q, expected %q", c.in, out, c.out)
+		}
+	}
+
+	matches := e.Check("kut")
+	if len(matches) != 1 || matches[0].Lang != "nl" {
+		t.Errorf("Check: %v", matches)
+	}
+}
@@ -0,0 +1,225 @@
+// Package challenge checks that forms such as the registration form are
+// filled in by humans and not by bots.
+//
+// An Issuer issues a Puzzle of its Challenge together with a PASETO token
+// that is encrypted and signed with a server key and that expires. The
+// token holds the answer of the puzzle, so the server does not have to
+// remember issued puzzles, and clients cannot pick a puzzle whose answer
+// they already know. The form sends the token back with the answer, and
+// the Issuer verifies both. Tokens have their own implicit assertion, so
+// they cannot be mistaken for other tokens made with the same key.
+package challenge
+
+import "context"
+import "crypto/rand"
+import "encoding/hex"
+import "errors"
+import "sync"
+import "time"
+
+import "aidanwoods.dev/go-paseto"
+
+// Kinds of challenges.
+const (
+	KindProofOfWork = "pow"
+	KindHoneypot    = "honeypot"
+	KindImage       = "image"
+)
+
+// Form fields of challenges.
+const (
+	// FieldToken is the field of the challenge token.
+	FieldToken = "challenge"
+	// FieldAnswer is the field of the answer.
+	FieldAnswer = "answer"
+	// FieldHoneypot is a field that humans do not see and leave empty,
+	// but that bots fill in.
+	FieldHoneypot = "website"
+)
+
+// TTL is how long a challenge token is valid by default.
+const TTL = 30 * time.Minute
+
+// claim is the name of the claim of the puzzle in the token.
+const claim = "challenge"
+
+// implicit is the implicit assertion of challenge tokens. Tokens made with
+// the same key for other purposes, such as sessions, do not have it.
+var implicit = []byte("qrochet challenge")
+
+var (
+	// ErrorChallengeKind means the kind of challenge is not known.
+	ErrorChallengeKind = errors.New("unknown kind of challenge")
+
+	// ErrorChallengeToken means the challenge token is not valid or
+	// expired.
+	ErrorChallengeToken = errors.New("the question expired, please answer the new one")
+
+	// ErrorChallengeUsed means the challenge token was already used.
+	ErrorChallengeUsed = errors.New("the question was already answered, please answer the new one")
+
+	// ErrorChallengeAnswer means the answer to the challenge is wrong.
+	ErrorChallengeAnswer = errors.New("question answer not correct, please check again")
+
+	// ErrorChallengeTooFast means the form was sent faster than a human
+	// can fill it in.
+	ErrorChallengeTooFast = errors.New("that was very fast, please check the form and send it again")
+
+	// ErrorChallengeStore means it cannot be checked whether the challenge
+	// token was already used.
+	ErrorChallengeStore = errors.New("the answer cannot be checked right now, please try again")
+)
+
+// Puzzle is an issued challenge. Answer is secret and only kept in the
+// token; the other fields are for showing the puzzle.
+type Puzzle struct {
+	Kind       string    `json:"kind"`
+	Nonce      string    `json:"nonce"`
+	Issued     time.Time `json:"issued"`
+	Question   string    `json:"question,omitempty"`
+	Options    []string  `json:"options,omitempty"`
+	Data       string    `json:"data,omitempty"`
+	Difficulty int       `json:"difficulty,omitempty"`
+	Answer     string    `json:"answer,omitempty"`
+}
+
+// Form is a submitted form, such as an *http.Request.
+type Form interface {
+	FormValue(key string) string
+}
+
+// Challenge is a kind of challenge.
+type Challenge interface {
+	// Kind returns the kind of the challenge.
+	Kind() string
+	// New returns a new puzzle. The Issuer sets its Kind, Nonce and Issued.
+	New() (Puzzle, error)
+	// Check checks the answer to the puzzle in the form.
+	Check(puzzle Puzzle, form Form) error
+}
+
+// New returns the challenge of the kind with default settings.
+func New(kind string) (Challenge, error) {
+	switch kind {
+	case KindProofOfWork:
+		return &ProofOfWork{Difficulty: Difficulty}, nil
+	case KindHoneypot:
+		return &Honeypot{MinTime: MinTime}, nil
+	case KindImage:
+		return &Image{}, nil
+	default:
+		return nil, ErrorChallengeKind
+	}
+}
+
+// NonceStore remembers the nonces of used tokens for all instances that
+// share it, such as repo.NonceStore. It must remember them for at least
+// the TTL of the Issuer.
+type NonceStore interface {
+	// Use marks the nonce as used, and returns false if it already was.
+	Use(ctx context.Context, nonce string) (bool, error)
+}
+
+// Issuer issues puzzles of a challenge with tokens and verifies answers.
+// It remembers the nonces of verified tokens until they expire, so each
+// token can be used only once. With Nonces, they are remembered for all
+// instances that share the store, otherwise only by this Issuer.
+type Issuer struct {
+	Challenge
+	Key    paseto.V4SymmetricKey
+	TTL    time.Duration
+	Nonces NonceStore
+	mu     sync.Mutex
+	used   map[string]time.Time
+}
+
+// NewIssuer returns an issuer for the challenge with tokens made with key.
+func NewIssuer(c Challenge, key paseto.V4SymmetricKey) *Issuer {
+	return &Issuer{Challenge: c, Key: key, TTL: TTL, used: map[string]time.Time{}}
+}
+
+// nonce returns a random nonce.
+func nonce() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// Issue returns a new puzzle without its answer, and its token.
+func (i *Issuer) Issue() (Puzzle, string, error) {
+	puzzle, err := i.Challenge.New()
+	if err != nil {
+		return Puzzle{}, "", err
+	}
+	now := time.Now()
+	puzzle.Kind = i.Challenge.Kind()
+	puzzle.Nonce = nonce()
+	puzzle.Issued = now
+
+	tok := paseto.NewToken()
+	tok.SetIssuedAt(now)
+	tok.SetNotBefore(now)
+	tok.SetExpiration(now.Add(i.TTL))
+	err = tok.Set(claim, puzzle)
+	if err != nil {
+		return Puzzle{}, "", err
+	}
+
+	puzzle.Answer = ""
+	return puzzle, tok.V4Encrypt(i.Key, implicit), nil
+}
+
+// Puzzle returns the puzzle of the token, with its answer.
+func (i *Issuer) Puzzle(token string) (Puzzle, error) {
+	var puzzle Puzzle
+	tok, err := paseto.NewParser().ParseV4Local(i.Key, token, implicit)
+	if err != nil {
+		return puzzle, ErrorChallengeToken
+	}
+	err = tok.Get(claim, &puzzle)
+	if err != nil || puzzle.Kind != i.Challenge.Kind() {
+		return puzzle, ErrorChallengeToken
+	}
+	return puzzle, nil
+}
+
+// Verify verifies the token and the answer in the form. A token can only
+// be verified once, whether the answer is correct or not, so answers cannot
+// be guessed with the same token.
+func (i *Issuer) Verify(ctx context.Context, form Form) error {
+	puzzle, err := i.Puzzle(form.FormValue(FieldToken))
+	if err != nil {
+		return err
+	}
+	if i.Nonces != nil {
+		fresh, err := i.Nonces.Use(ctx, puzzle.Nonce)
+		if err != nil {
+			return ErrorChallengeStore
+		}
+		if !fresh {
+			return ErrorChallengeUsed
+		}
+	} else if !i.use(puzzle) {
+		return ErrorChallengeUsed
+	}
+	return i.Challenge.Check(puzzle, form)
+}
+
+// use marks the nonce of the puzzle as used in the memory of the Issuer,
+// and returns false if it already was.
+func (i *Issuer) use(puzzle Puzzle) bool {
+	now := time.Now()
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for nonce, expires := range i.used {
+		if now.After(expires) {
+			delete(i.used, nonce)
+		}
+	}
+	if _, ok := i.used[puzzle.Nonce]; ok {
+		return false
+	}
+	i.used[puzzle.Nonce] = puzzle.Issued.Add(i.TTL)
+	return true
+}
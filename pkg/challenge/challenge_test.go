@@ -0,0 +1,152 @@
+package challenge
+
+import "context"
+import "net/url"
+import "strings"
+import "testing"
+import "time"
+
+import "aidanwoods.dev/go-paseto"
+
+// form is a Form of url.Values.
+type form url.Values
+
+func (f form) FormValue(key string) string {
+	return url.Values(f).Get(key)
+}
+
+var ctx = context.Background()
+
+// nonces is a NonceStore in memory.
+type nonces map[string]bool
+
+func (n nonces) Use(ctx context.Context, nonce string) (bool, error) {
+	if n[nonce] {
+		return false, nil
+	}
+	n[nonce] = true
+	return true, nil
+}
+
+func TestProofOfWork(t *testing.T) {
+	issuer := NewIssuer(&ProofOfWork{Difficulty: 8}, paseto.NewV4SymmetricKey())
+	puzzle, token, err := issuer.Issue()
+	if err != nil {
+		t.Fatalf("Issue: %s", err)
+	}
+	if puzzle.Data == "" || puzzle.Difficulty != 8 {
+		t.Fatalf("Issue puzzle: %+v", puzzle)
+	}
+
+	values := form{FieldToken: {token}, FieldAnswer: {Solve(puzzle)}}
+	if err = issuer.Verify(ctx, values); err != nil {
+		t.Errorf("Verify: %s", err)
+	}
+	if err = issuer.Verify(ctx, values); err != ErrorChallengeUsed {
+		t.Errorf("Verify again: %v", err)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	issuer := NewIssuer(&Image{}, paseto.NewV4SymmetricKey())
+	_, token, err := issuer.Issue()
+	if err != nil {
+		t.Fatalf("Issue: %s", err)
+	}
+	if strings.Contains(token, "yarn") || strings.Contains(token, "hook") {
+		t.Errorf("Issue token not encrypted: %s", token)
+	}
+
+	other := NewIssuer(&Image{}, paseto.NewV4SymmetricKey())
+	if err = other.Verify(ctx, form{FieldToken: {token}}); err != ErrorChallengeToken {
+		t.Errorf("Verify other key: %v", err)
+	}
+
+	pot := NewIssuer(&Honeypot{}, issuer.Key)
+	if err = pot.Verify(ctx, form{FieldToken: {token}}); err != ErrorChallengeToken {
+		t.Errorf("Verify other kind: %v", err)
+	}
+
+	// Tokens made with the key for something else are not challenges,
+	// and challenge tokens are not valid for something else.
+	tok := paseto.NewToken()
+	tok.SetExpiration(time.Now().Add(time.Minute))
+	full, _ := issuer.Puzzle(token)
+	tok.Set(claim, full)
+	if err = issuer.Verify(ctx, form{FieldToken: {tok.V4Encrypt(issuer.Key, []byte{})}}); err != ErrorChallengeToken {
+		t.Errorf("Verify token without implicit assertion: %v", err)
+	}
+	if _, err = paseto.NewParser().ParseV4Local(issuer.Key, token, []byte{}); err == nil {
+		t.Errorf("challenge token parsed without implicit assertion")
+	}
+
+	issuer.TTL = -time.Minute
+	_, token, _ = issuer.Issue()
+	if err = issuer.Verify(ctx, form{FieldToken: {token}}); err != ErrorChallengeToken {
+		t.Errorf("Verify expired: %v", err)
+	}
+}
+
+func TestImage(t *testing.T) {
+	issuer := NewIssuer(&Image{}, paseto.NewV4SymmetricKey())
+	puzzle, token, err := issuer.Issue()
+	if err != nil {
+		t.Fatalf("Issue: %s", err)
+	}
+	if puzzle.Answer != "" || len(puzzle.Options) != len(pictures) {
+		t.Fatalf("Issue puzzle: %+v", puzzle)
+	}
+
+	first, err := issuer.Render(token)
+	if err != nil || !strings.HasPrefix(string(first), "<svg") {
+		t.Fatalf("Render: %v %s", err, first)
+	}
+	second, _ := issuer.Render(token)
+	if string(first) == string(second) {
+		t.Errorf("Render twice: same image")
+	}
+
+	full, _ := issuer.Puzzle(token)
+	if err = issuer.Verify(ctx, form{FieldToken: {token}, FieldAnswer: {pictures[full.Data]}}); err != nil {
+		t.Errorf("Verify: %s", err)
+	}
+}
+
+func TestHoneypot(t *testing.T) {
+	issuer := NewIssuer(&Honeypot{MinTime: time.Hour}, paseto.NewV4SymmetricKey())
+	_, token, _ := issuer.Issue()
+	if err := issuer.Verify(ctx, form{FieldToken: {token}}); err != ErrorChallengeTooFast {
+		t.Errorf("Verify too fast: %v", err)
+	}
+
+	issuer.Challenge = &Honeypot{}
+	_, token, _ = issuer.Issue()
+	if err := issuer.Verify(ctx, form{FieldToken: {token}, FieldHoneypot: {"http://spam.example.com"}}); err != ErrorChallengeAnswer {
+		t.Errorf("Verify with honey: %v", err)
+	}
+	_, token, _ = issuer.Issue()
+	if err := issuer.Verify(ctx, form{FieldToken: {token}}); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
+
+func TestNonceStore(t *testing.T) {
+	key := paseto.NewV4SymmetricKey()
+	used := nonces{}
+	first := NewIssuer(&Honeypot{}, key)
+	first.Nonces = used
+	second := NewIssuer(&Honeypot{}, key)
+	second.Nonces = used
+
+	// A token used with one instance cannot be used with another.
+	_, token, _ := first.Issue()
+	if err := first.Verify(ctx, form{FieldToken: {token}}); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if err := second.Verify(ctx, form{FieldToken: {token}}); err != ErrorChallengeUsed {
+		t.Errorf("Verify with other issuer: %v", err)
+	}
+	if len(first.used) != 0 {
+		t.Errorf("nonces kept in memory with a store")
+	}
+}
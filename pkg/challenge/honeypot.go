@@ -0,0 +1,32 @@
+package challenge
+
+import "time"
+
+// MinTime is the default minimum time between issuing a honeypot
+// challenge and answering it.
+const MinTime = 3 * time.Second
+
+// Honeypot is a challenge without a question. The form has a FieldHoneypot
+// field that is hidden from humans, so it must be empty, and it must take
+// at least MinTime to fill in the form, which bots do instantly.
+type Honeypot struct {
+	MinTime time.Duration
+}
+
+func (h *Honeypot) Kind() string {
+	return KindHoneypot
+}
+
+func (h *Honeypot) New() (Puzzle, error) {
+	return Puzzle{}, nil
+}
+
+func (h *Honeypot) Check(puzzle Puzzle, form Form) error {
+	if form.FormValue(FieldHoneypot) != "" {
+		return ErrorChallengeAnswer
+	}
+	if time.Since(puzzle.Issued) < h.MinTime {
+		return ErrorChallengeTooFast
+	}
+	return nil
+}
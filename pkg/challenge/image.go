@@ -0,0 +1,82 @@
+package challenge
+
+import "bytes"
+import "embed"
+import "fmt"
+import "math/rand/v2"
+import "slices"
+
+//go:embed images
+var images embed.FS
+
+// pictures are the names of the things in the images, by file name.
+var pictures = map[string]string{
+	"button":   "button",
+	"hook":     "crochet hook",
+	"needles":  "knitting needles",
+	"scissors": "scissors",
+	"yarn":     "ball of yarn",
+}
+
+// Image is a challenge that shows an image of a crochet or sewing thing and
+// asks what it is. The image is served with Render by the token, so its URL
+// does not give away the answer. Only the background and the position of
+// the picture change every time; the picture itself is always one of the
+// same few, so a bot that learned them recognises it, and a random guess
+// is right one time in len(pictures). It only keeps out simple bots, which
+// is why proof of work is the default challenge.
+type Image struct{}
+
+func (c *Image) Kind() string {
+	return KindImage
+}
+
+func (c *Image) New() (Puzzle, error) {
+	names := make([]string, 0, len(pictures))
+	options := make([]string, 0, len(pictures))
+	for name, option := range pictures {
+		names = append(names, name)
+		options = append(options, option)
+	}
+	slices.Sort(options)
+	return Puzzle{
+		Question: "What is shown in the picture?",
+		Options:  options,
+		Data:     names[rand.IntN(len(names))],
+	}, nil
+}
+
+func (c *Image) Check(puzzle Puzzle, form Form) error {
+	if form.FormValue(FieldAnswer) != pictures[puzzle.Data] {
+		return ErrorChallengeAnswer
+	}
+	return nil
+}
+
+// Render returns the SVG image of the puzzle of the token.
+func (i *Issuer) Render(token string) ([]byte, error) {
+	puzzle, err := i.Puzzle(token)
+	if err != nil {
+		return nil, err
+	}
+	if puzzle.Kind != KindImage {
+		return nil, ErrorChallengeKind
+	}
+	picture, err := images.ReadFile("images/" + puzzle.Data + ".svg")
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 120 120" width="240" height="240">`)
+	fmt.Fprintf(buf, `<rect width="120" height="120" fill="hsl(%d, 60%%, 90%%)"/>`, rand.IntN(360))
+	for range 12 + rand.IntN(12) {
+		fmt.Fprintf(buf, `<circle cx="%d" cy="%d" r="%d" fill="hsl(%d, 50%%, 75%%)"/>`,
+			rand.IntN(120), rand.IntN(120), 1+rand.IntN(4), rand.IntN(360))
+	}
+	fmt.Fprintf(buf, `<g transform="rotate(%d 60 60) translate(%d %d)">`,
+		rand.IntN(61)-30, 5+rand.IntN(11), 5+rand.IntN(11))
+	buf.Write(picture)
+	fmt.Fprintf(buf, `</g></svg>`)
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,59 @@
+package challenge
+
+import "crypto/sha256"
+import "math/bits"
+import "strconv"
+
+// Difficulty is the default difficulty of proof of work challenges: the
+// amount of leading zero bits the hash must have. A browser needs about
+// 2^Difficulty hashes to find an answer.
+const Difficulty = 16
+
+// ProofOfWork is a challenge that makes the browser find a number whose
+// SHA-256 hash, appended to the data of the puzzle, starts with Difficulty
+// zero bits. This is cheap for one registration, but expensive for many.
+type ProofOfWork struct {
+	Difficulty int
+}
+
+func (p *ProofOfWork) Kind() string {
+	return KindProofOfWork
+}
+
+func (p *ProofOfWork) New() (Puzzle, error) {
+	return Puzzle{Data: nonce(), Difficulty: p.Difficulty}, nil
+}
+
+func (p *ProofOfWork) Check(puzzle Puzzle, form Form) error {
+	if !Solves(puzzle, form.FormValue(FieldAnswer)) {
+		return ErrorChallengeAnswer
+	}
+	return nil
+}
+
+// Solves returns whether the answer solves the proof of work puzzle.
+func Solves(puzzle Puzzle, answer string) bool {
+	if answer == "" {
+		return false
+	}
+	sum := sha256.Sum256([]byte(puzzle.Data + answer))
+	zeros := 0
+	for _, b := range sum {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return zeros >= puzzle.Difficulty
+}
+
+// Solve solves the proof of work puzzle like a browser does, for tests
+// and clients.
+func Solve(puzzle Puzzle) string {
+	for i := 0; ; i++ {
+		answer := strconv.Itoa(i)
+		if Solves(puzzle, answer) {
+			return answer
+		}
+	}
+}
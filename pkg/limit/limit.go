@@ -0,0 +1,279 @@
+// limit limits the rate of requests per client, for pkg/app and pkg/roh.
+package limit
+
+import "container/list"
+import "context"
+import "log/slog"
+import "math"
+import "net"
+import "net/http"
+import "net/netip"
+import "strconv"
+import "strings"
+import "sync"
+import "time"
+
+import "golang.org/x/time/rate"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+const (
+	// limiterMax is the maximum amount of limiters a Limiter remembers.
+	// When there are more, the least recently used ones are forgotten.
+	limiterMax = 10000
+
+	// limiterTTL is how long a Limiter remembers an unused limiter.
+	limiterTTL = 10 * time.Minute
+
+	// limiterTimeout is how long a Limiter waits for its store before
+	// it falls back to its own limiters.
+	limiterTimeout = 250 * time.Millisecond
+)
+
+// Policy is the rate limit for the requests to the paths that start
+// with Prefix: Rate requests per second, with bursts of Burst.
+type Policy struct {
+	Prefix string
+	Rate   rate.Limit
+	Burst  int
+}
+
+// Proxies are the networks of the trusted reverse proxies in front of
+// Qrochet, whose X-Forwarded-For headers are believed.
+type Proxies []netip.Prefix
+
+// ParseProxies parses a comma separated list of IP addresses or networks
+// in CIDR notation.
+func ParseProxies(s string) (Proxies, error) {
+	var proxies Proxies
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, err
+			}
+			proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+// Trusts returns whether the address is one of a trusted proxy.
+func (p Proxies) Trusts(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientAddr returns the IP address of the client of the request. If the
+// request comes from a trusted proxy, this is the last address in
+// X-Forwarded-For that is not a trusted proxy itself.
+func (p Proxies) ClientAddr(req *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addr = addr.Unmap()
+	if !p.Trusts(addr) {
+		return addr, true
+	}
+
+	hops := strings.Split(strings.Join(req.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// Anything before a bad entry cannot be trusted.
+			break
+		}
+		addr = hop.Unmap()
+		if !p.Trusts(addr) {
+			break
+		}
+	}
+	return addr, true
+}
+
+// ClientIP returns the IP address of the client of the request as a
+// string, or the remote address if it is not an IP address.
+func (p Proxies) ClientIP(req *http.Request) string {
+	addr, ok := p.ClientAddr(req)
+	if !ok {
+		return req.RemoteAddr
+	}
+	return addr.String()
+}
+
+// Store keeps token buckets for rate limiting that are shared by all
+// instances of Qrochet, such as repo.RateStore.
+type Store interface {
+	// Take takes a token from the bucket with the key, which refills at r
+	// tokens per second up to burst tokens. If there is no token, it
+	// returns how long to wait for one.
+	Take(ctx model.Context, key string, r rate.Limit, burst int) (time.Duration, error)
+}
+
+// limiterEntry is a limiter in the LRU list of a Limiter.
+type limiterEntry struct {
+	key     string
+	limiter *rate.Limiter
+	seen    time.Time
+}
+
+// Limiter limits the rate of requests per client IP address, with a
+// policy per path. IPv6 clients are limited per /64 network, since they
+// usually have all of it. It remembers at most limiterMax limiters, and
+// forgets the ones that were not used for limiterTTL.
+//
+// If it has a Store, the limits hold for all instances that share the
+// store, and its own limiters are only used when the store fails, for
+// example because NATS is not available.
+type Limiter struct {
+	Policies []Policy
+	Proxies  Proxies
+	Store    Store
+	mu       sync.Mutex
+	entries  map[string]*list.Element
+	lru      *list.List
+}
+
+// New returns a rate limiter with the policies, that trusts the
+// proxies. The policies are matched in order, so the first one whose prefix
+// matches the path is used.
+func New(policies []Policy, proxies Proxies) *Limiter {
+	return &Limiter{
+		Policies: policies,
+		Proxies:  proxies,
+		entries:  map[string]*list.Element{},
+		lru:      list.New(),
+	}
+}
+
+// Policy returns the policy for the path, or false if no policy matches.
+func (i *Limiter) Policy(path string) (Policy, bool) {
+	for _, policy := range i.Policies {
+		if strings.HasPrefix(path, policy.Prefix) {
+			return policy, true
+		}
+	}
+	return Policy{}, false
+}
+
+// limiterKey returns the key of the limiter of the client address.
+func limiterKey(policy Policy, addr netip.Addr) string {
+	if addr.Is6() {
+		addr = netip.PrefixFrom(addr, 64).Masked().Addr()
+	}
+	return policy.Prefix + " " + addr.String()
+}
+
+// GetLimiter returns the limiter of the policy for the client address,
+// creating it if needed.
+func (i *Limiter) GetLimiter(policy Policy, addr netip.Addr) *rate.Limiter {
+	key := limiterKey(policy, addr)
+	now := time.Now()
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.expire(now)
+	if elem, ok := i.entries[key]; ok {
+		entry := elem.Value.(*limiterEntry)
+		entry.seen = now
+		i.lru.MoveToFront(elem)
+		return entry.limiter
+	}
+
+	entry := &limiterEntry{key: key, limiter: rate.NewLimiter(policy.Rate, policy.Burst), seen: now}
+	i.entries[key] = i.lru.PushFront(entry)
+	for i.lru.Len() > limiterMax {
+		i.remove(i.lru.Back())
+	}
+	return entry.limiter
+}
+
+// expire forgets the limiters that were not used for limiterTTL.
+// The lock must be held.
+func (i *Limiter) expire(now time.Time) {
+	for back := i.lru.Back(); back != nil; back = i.lru.Back() {
+		if now.Sub(back.Value.(*limiterEntry).seen) < limiterTTL {
+			return
+		}
+		i.remove(back)
+	}
+}
+
+// remove forgets a limiter. The lock must be held.
+func (i *Limiter) remove(elem *list.Element) {
+	i.lru.Remove(elem)
+	delete(i.entries, elem.Value.(*limiterEntry).key)
+}
+
+// Len returns the amount of limiters the rate limiter remembers.
+func (i *Limiter) Len() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.lru.Len()
+}
+
+// Reserve takes a token of the policy for the client address, and returns
+// how long to wait before trying again if there was none.
+func (i *Limiter) Reserve(ctx model.Context, policy Policy, addr netip.Addr) time.Duration {
+	if i.Store != nil {
+		ctx, cancel := context.WithTimeout(ctx, limiterTimeout)
+		defer cancel()
+		wait, err := i.Store.Take(ctx, limiterKey(policy, addr), policy.Rate, policy.Burst)
+		if err == nil {
+			return wait
+		}
+		slog.Warn("Store.Take failed, using own limiter", "err", err)
+	}
+
+	reservation := i.GetLimiter(policy, addr).Reserve()
+	if !reservation.OK() {
+		return limiterTTL
+	}
+	wait := reservation.Delay()
+	if wait > 0 {
+		reservation.Cancel()
+	}
+	return wait
+}
+
+// Middleware limits the rate of requests. Too many requests are answered
+// with status 429 and a Retry-After header that says how many seconds the
+// client should wait.
+func (i *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy, ok := i.Policy(r.URL.Path)
+		addr, known := i.Proxies.ClientAddr(r)
+		if !ok || !known {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if wait := i.Reserve(r.Context(), policy, addr); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
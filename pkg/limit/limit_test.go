@@ -0,0 +1,169 @@
+package limit
+
+import "net/http"
+import "net/http/httptest"
+import "net/netip"
+import "strconv"
+import "testing"
+import "time"
+
+func TestParseProxies(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+		ok   bool
+	}{
+		{"", nil, true},
+		{" , ", nil, true},
+		{"10.0.0.1", []string{"10.0.0.1/32"}, true},
+		{"10.0.0.1/8, ::1", []string{"10.0.0.0/8", "::1/128"}, true},
+		{"fd00::1/64", []string{"fd00::/64"}, true},
+		{"10.0.0.256", nil, false},
+		{"10.0.0.0/33", nil, false},
+		{"proxy.example.com", nil, false},
+	}
+	for _, test := range tests {
+		proxies, err := ParseProxies(test.in)
+		if (err == nil) != test.ok {
+			t.Errorf("ParseProxies(%q): %v", test.in, err)
+			continue
+		}
+		var got []string
+		for _, prefix := range proxies {
+			got = append(got, prefix.String())
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("ParseProxies(%q) = %v, want %v", test.in, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("ParseProxies(%q) = %v, want %v", test.in, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestClientAddr(t *testing.T) {
+	proxies, err := ParseProxies("10.0.0.0/8, fd00::/64")
+	if err != nil {
+		t.Fatalf("ParseProxies: %s", err)
+	}
+	tests := []struct {
+		remote string
+		xff    []string
+		want   string
+	}{
+		{"192.0.2.1:1234", nil, "192.0.2.1"},
+		// Untrusted clients cannot choose their address.
+		{"192.0.2.1:1234", []string{"198.51.100.7"}, "192.0.2.1"},
+		{"[::ffff:192.0.2.1]:1234", nil, "192.0.2.1"},
+		{"10.0.0.1:1234", []string{"198.51.100.7"}, "198.51.100.7"},
+		// The last address that is not a trusted proxy is the client,
+		// whatever the client put in front of it.
+		{"10.0.0.1:1234", []string{"203.0.113.9, 198.51.100.7, 10.0.0.2"}, "198.51.100.7"},
+		{"10.0.0.1:1234", []string{"203.0.113.9", "198.51.100.7, 10.0.0.2"}, "198.51.100.7"},
+		{"[fd00::1]:1234", []string{"2001:db8::5"}, "2001:db8::5"},
+		// Nothing before a bad entry is believed.
+		{"10.0.0.1:1234", []string{"198.51.100.7, bad, 10.0.0.2"}, "10.0.0.2"},
+		// Only proxies: the first one is the best guess.
+		{"10.0.0.1:1234", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"10.0.0.1:1234", nil, "10.0.0.1"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = test.remote
+		for _, xff := range test.xff {
+			req.Header.Add("X-Forwarded-For", xff)
+		}
+		addr, ok := proxies.ClientAddr(req)
+		if !ok || addr.String() != test.want {
+			t.Errorf("ClientAddr %s %v = %s %v, want %s", test.remote, test.xff, addr, ok, test.want)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "not an address"
+	if _, ok := proxies.ClientAddr(req); ok {
+		t.Errorf("ClientAddr of a bad remote address succeeded")
+	}
+}
+
+func TestRateLimiterEviction(t *testing.T) {
+	policy := Policy{Prefix: "/", Rate: 1, Burst: 1}
+	limiter := New([]Policy{policy}, nil)
+	addr := func(i int) netip.Addr {
+		return netip.AddrFrom4([4]byte{10, byte(i >> 16), byte(i >> 8), byte(i)})
+	}
+
+	// IPv6 clients share the limiter of their /64 network.
+	first := limiter.GetLimiter(policy, netip.MustParseAddr("2001:db8::1"))
+	if limiter.GetLimiter(policy, netip.MustParseAddr("2001:db8::2")) != first {
+		t.Errorf("IPv6 addresses of one /64 have different limiters")
+	}
+
+	// The least recently used limiters are forgotten first.
+	oldest := limiter.GetLimiter(policy, addr(0))
+	for i := 1; i <= limiterMax; i++ {
+		limiter.GetLimiter(policy, addr(i))
+		if i == 1 {
+			// Use the first one again, so it is not the least recently used.
+			limiter.GetLimiter(policy, netip.MustParseAddr("2001:db8::1"))
+		}
+	}
+	if limiter.Len() != limiterMax {
+		t.Errorf("Len = %d, want %d", limiter.Len(), limiterMax)
+	}
+	if limiter.GetLimiter(policy, netip.MustParseAddr("2001:db8::1")) != first {
+		t.Errorf("recently used limiter forgotten")
+	}
+	if limiter.GetLimiter(policy, addr(0)) == oldest {
+		t.Errorf("least recently used limiter not forgotten")
+	}
+
+	// Limiters that were not used for limiterTTL are forgotten.
+	limiter = New([]Policy{policy}, nil)
+	stale := limiter.GetLimiter(policy, addr(1))
+	limiter.GetLimiter(policy, addr(2))
+	limiter.lru.Back().Value.(*limiterEntry).seen = time.Now().Add(-limiterTTL)
+	limiter.GetLimiter(policy, addr(3))
+	if limiter.Len() != 2 {
+		t.Errorf("Len after expiry = %d, want 2", limiter.Len())
+	}
+	if limiter.GetLimiter(policy, addr(1)) == stale {
+		t.Errorf("expired limiter not forgotten")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	limiter := New([]Policy{{Prefix: "/login", Rate: 0.2, Burst: 2}}, nil)
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	do := func(path, remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, nil)
+		req.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for range 2 {
+		if rec := do("/login", "192.0.2.1:1234"); rec.Code != http.StatusNoContent {
+			t.Fatalf("request within burst: %d", rec.Code)
+		}
+	}
+	rec := do("/login", "192.0.2.1:1234")
+	retry, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+	if rec.Code != http.StatusTooManyRequests || err != nil || retry < 1 || retry > 5 {
+		t.Errorf("request over the limit: %d Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	if rec := do("/login", "192.0.2.2:1234"); rec.Code != http.StatusNoContent {
+		t.Errorf("request of another client: %d", rec.Code)
+	}
+	if rec := do("/gallery", "192.0.2.1:1234"); rec.Code != http.StatusNoContent {
+		t.Errorf("request without policy: %d", rec.Code)
+	}
+}
@@ -0,0 +1,209 @@
+package model
+
+import "context"
+import "errors"
+import "iter"
+import "log/slog"
+import "strings"
+import "time"
+
+import "github.com/oklog/ulid/v2"
+
+// AuditAction is a security relevant action that is audited.
+type AuditAction string
+
+// Audited actions.
+const (
+	AuditLogin          AuditAction = "login"
+	AuditLoginFailed    AuditAction = "login.failed"
+	AuditLockout        AuditAction = "lockout"
+	AuditLoginLink      AuditAction = "login.link"
+	AuditIdentityLink   AuditAction = "oidc.link"
+	AuditLogout         AuditAction = "logout"
+	AuditRegister       AuditAction = "register"
+	AuditPasswordChange AuditAction = "password.change"
+	AuditEmailChange    AuditAction = "email.change"
+	AuditProfileChange  AuditAction = "profile.change"
+	AuditRoleChange     AuditAction = "role.change"
+	AuditModerate       AuditAction = "moderate"
+	AuditTOTPEnable     AuditAction = "totp.enable"
+	AuditTOTPDisable    AuditAction = "totp.disable"
+	AuditRecoveryCodes  AuditAction = "recovery.new"
+	AuditRecoveryUse    AuditAction = "recovery.use"
+	AuditPolicyChange   AuditAction = "policy.change"
+	AuditAccountDelete  AuditAction = "account.delete"
+)
+
+const (
+	// AuditAmount is the default amount of audit entries of a query.
+	AuditAmount = 100
+
+	// AuditAnonymous is the user ID of audit entries without a user,
+	// such as failed logins for addresses that are not registered.
+	AuditAnonymous = "anonymous"
+)
+
+var (
+	// ErrorAuditQuery means querying the audit log failed.
+	ErrorAuditQuery = errors.New("audit log query failed")
+
+	// ErrorRoleChange means changing the role of a user failed.
+	ErrorRoleChange = errors.New("role change failed")
+)
+
+// Client is the client that made a request: its IP address and user
+// agent.
+type Client struct {
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+}
+
+type clientKey struct{}
+
+// WithClient returns a context with the client of the request.
+func WithClient(ctx Context, client Client) Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// ClientOf returns the client of the request of the context, if any.
+func ClientOf(ctx Context) Client {
+	client, _ := ctx.Value(clientKey{}).(Client)
+	return client
+}
+
+// AuditEntry is an entry of the audit log. UserID is the user the action
+// is about, ActorID the user that did it, which differs for staff actions.
+// Detail must never contain secrets, and email addresses in it are
+// redacted with RedactEmail.
+type AuditEntry struct {
+	ID        string      `json:"id"`
+	Time      time.Time   `json:"time"`
+	Action    AuditAction `json:"action"`
+	UserID    string      `json:"user_id"`
+	ActorID   string      `json:"actor_id"`
+	IP        string      `json:"ip"`
+	UserAgent string      `json:"user_agent"`
+	Detail    string      `json:"detail,omitempty"`
+}
+
+// Implement LogValuer on the audit entry, like on User.
+func (e AuditEntry) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", e.ID),
+		slog.String("action", string(e.Action)),
+		slog.String("user", e.UserID),
+		slog.String("actor", e.ActorID),
+		slog.String("ip", e.IP),
+	)
+}
+
+// AuditQuery is a query of the audit log for the entries of a user, or of
+// all users if UserID is empty, between From and To, oldest first.
+type AuditQuery struct {
+	UserID string    `json:"user_id"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	Amount int       `json:"amount"`
+}
+
+// AuditLog is an append only log of security relevant actions.
+type AuditLog interface {
+	// Append appends the entry to the log.
+	Append(ctx Context, entry AuditEntry) error
+	// Query returns an iterator over the entries that match the query.
+	Query(ctx Context, query AuditQuery) iter.Seq2[AuditEntry, error]
+}
+
+// RedactEmail redacts an email address for logs, keeping only the first
+// letter of the local part and the domain.
+func RedactEmail(email string) string {
+	local, domain, ok := strings.Cut(strings.TrimSpace(email), "@")
+	if !ok || local == "" {
+		return "*REDACTED*"
+	}
+	return local[:1] + "***@" + domain
+}
+
+// Audit appends an action of the user to the audit log, with the client
+// of the context. Failing to audit is logged.
+func (l *Logic) Audit(ctx Context, action AuditAction, userID, detail string) {
+	l.auditBy(ctx, action, userID, userID, detail)
+}
+
+// auditBy appends an action of the actor about the user to the audit log.
+func (l *Logic) auditBy(ctx Context, action AuditAction, actorID, userID, detail string) {
+	if userID == "" {
+		userID = AuditAnonymous
+	}
+	if actorID == "" {
+		actorID = AuditAnonymous
+	}
+	client := ClientOf(ctx)
+	entry := AuditEntry{
+		ID:        ulid.Make().String(),
+		Time:      time.Now(),
+		Action:    action,
+		UserID:    userID,
+		ActorID:   actorID,
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+		Detail:    detail,
+	}
+	err := l.Audits().Append(ctx, entry)
+	if err != nil {
+		slog.Error("Audits.Append", "err", err, "entry", entry)
+	}
+}
+
+// AuditLog returns the entries of the audit log that match the query.
+// Only staff may query the audit log.
+func (l *Logic) AuditLog(ctx Context, session *Session, query AuditQuery) ([]AuditEntry, error) {
+	_, err := l.Staff(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	return l.QueryAudits(ctx, query)
+}
+
+// QueryAudits returns the entries of the audit log that match the query,
+// for trusted callers such as the command line.
+func (l *Logic) QueryAudits(ctx Context, query AuditQuery) ([]AuditEntry, error) {
+	if query.Amount <= 0 {
+		query.Amount = AuditAmount
+	}
+	entries, err := Collect(l.Audits().Query(ctx, query))
+	if err != nil {
+		slog.Error("Audits.Query", "err", err)
+		return nil, ErrorAuditQuery
+	}
+	return entries, nil
+}
+
+// SetRole changes the role of a user. Only staff may change roles.
+func (l *Logic) SetRole(ctx Context, session *Session, userID string, role Role) (*User, error) {
+	staff, err := l.Staff(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	return l.ChangeRole(ctx, staff.ID, userID, role)
+}
+
+// ChangeRole changes the role of a user on behalf of the actor, for
+// trusted callers such as the command line.
+func (l *Logic) ChangeRole(ctx Context, actorID, userID string, role Role) (*User, error) {
+	if _, err := role.MarshalText(); err != nil {
+		return nil, err
+	}
+
+	var old Role
+	user, err := l.updateUser(ctx, userID, ErrorRoleChange, func(user *User) error {
+		old = user.Role
+		user.Role = role
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	l.auditBy(ctx, AuditRoleChange, actorID, user.ID, old.String()+" to "+role.String())
+	return user, nil
+}
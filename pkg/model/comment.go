@@ -0,0 +1,187 @@
+package model
+
+import (
+	"errors"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+import (
+	"github.com/oklog/ulid/v2"
+	"github.com/qrochet/qrochet/pkg/censor"
+)
+
+var (
+	// ErrorCommentEmpty means the comment has no text.
+	ErrorCommentEmpty = errors.New("please write a comment")
+
+	// ErrorCommentCreate means creating a comment failed.
+	ErrorCommentCreate = errors.New("comment create failed")
+
+	// ErrorCommentNotFound means the comment could not be found.
+	ErrorCommentNotFound = errors.New("comment not found")
+
+	// ErrorCommentDelete means the comment could not be deleted.
+	ErrorCommentDelete = errors.New("comment delete failed")
+
+	// ErrorCommentNotAllowed means the user may not delete the comment.
+	ErrorCommentNotAllowed = errors.New("only the author or the craft owner may delete a comment")
+)
+
+// CommentThread is a comment with its replies.
+type CommentThread struct {
+	Comment
+	Replies []CommentThread
+}
+
+// Thread arranges comments in threads of replies, oldest first.
+// Replies to comments that were deleted are shown as top level comments.
+func Thread(comments []Comment) []CommentThread {
+	sort.Slice(comments, func(i, j int) bool { return comments[i].ID < comments[j].ID })
+
+	ids := map[string]bool{}
+	children := map[string][]Comment{}
+	for _, c := range comments {
+		ids[c.ID] = true
+	}
+	for _, c := range comments {
+		parent := c.ParentID
+		if !ids[parent] {
+			parent = ""
+		}
+		children[parent] = append(children[parent], c)
+	}
+
+	var build func(parent string) []CommentThread
+	build = func(parent string) []CommentThread {
+		var res []CommentThread
+		for _, c := range children[parent] {
+			res = append(res, CommentThread{Comment: c, Replies: build(c.ID)})
+		}
+		return res
+	}
+	return build("")
+}
+
+// commentMail returns the mail that tells the owner of a craft about a comment.
+func commentMail(owner User, craft Craft, comment Comment) Mail {
+	msg := Mail{}
+	msg.To = owner.Name + "<" + owner.Email + ">"
+	msg.Subject = "New comment on your craft " + craft.Title
+
+	msg.Printf("Dear %s,\n\n", owner.Name)
+	msg.Printf("%s commented on your craft %s:\n\n", comment.UserName, craft.Title)
+	msg.Println(comment.Text)
+	msg.Println()
+	msg.Println("Kind regards, Qrochet.")
+
+	return msg
+}
+
+// NewComment adds a comment by the user of the session to the craft.
+// If parentID is not empty the comment is a reply to that comment.
+// The text is filtered through the censor, and the owner of the craft
+// gets a mail about the comment.
+func (l *Logic) NewComment(ctx Context, session *Session, craftID, parentID, text string) (*Comment, error) {
+	if session == nil || session.UserID == "" {
+		return nil, ErrorPleaseLogIn
+	}
+
+	text = strings.TrimSpace(censor.Replace(text))
+	if text == "" {
+		return nil, ErrorCommentEmpty
+	}
+
+	craft, err := l.Craft().GetByID(ctx, craftID)
+	if err != nil || craft.Hidden {
+		slog.Error("Craft.GetByID", "err", err, "craft", craftID)
+		return nil, ErrorCraftNotFound
+	}
+
+	if parentID != "" {
+		_, err = l.Comment().GetComment(ctx, craft.ID, parentID)
+		if err != nil {
+			slog.Error("Comment.GetComment", "err", err, "comment", parentID)
+			return nil, ErrorCommentNotFound
+		}
+	}
+
+	user, err := l.User().Get(ctx, session.UserID)
+	if err != nil {
+		slog.Error("User.Get", "err", err, "user", session.UserID)
+		return nil, ErrorPleaseLogIn
+	}
+
+	comment := Comment{}
+	comment.ID = ulid.Make().String()
+	comment.CraftID = craft.ID
+	comment.OwnerID = craft.UserID
+	comment.UserID = user.ID
+	comment.UserName = user.Name
+	comment.ParentID = parentID
+	comment.Text = text
+	comment.Created = time.Now()
+
+	created, err := l.Comment().PutComment(ctx, comment)
+	if err != nil {
+		slog.Error("Comment.PutComment", "err", err)
+		return nil, ErrorCommentCreate
+	}
+
+	if craft.UserID != user.ID {
+		owner, err := l.User().Get(ctx, craft.UserID)
+		if err != nil {
+			slog.Error("User.Get", "err", err, "user", craft.UserID)
+		} else {
+			l.QueueMail(ctx, commentMail(owner, craft, created))
+		}
+	}
+
+	return &created, nil
+}
+
+// Comments returns the comments on the craft in threads.
+func (l *Logic) Comments(ctx Context, craftID string) ([]CommentThread, error) {
+	comments, err := Collect(l.Comment().AllForCraftID(ctx, craftID))
+	if err != nil {
+		slog.Error("Comment.AllForCraftID", "err", err, "craft", craftID)
+		return nil, err
+	}
+	return Thread(comments), nil
+}
+
+// CanDeleteComment returns true if the user of the session may delete the
+// comment, that is, if they are the author or the owner of the craft.
+func CanDeleteComment(session *Session, comment Comment) bool {
+	if session == nil || session.UserID == "" {
+		return false
+	}
+	return session.UserID == comment.UserID || session.UserID == comment.OwnerID
+}
+
+// DeleteComment deletes a comment on a craft.
+// Only the author of the comment and the owner of the craft may do so.
+func (l *Logic) DeleteComment(ctx Context, session *Session, craftID, commentID string) error {
+	if session == nil || session.UserID == "" {
+		return ErrorPleaseLogIn
+	}
+
+	comment, err := l.Comment().GetComment(ctx, craftID, commentID)
+	if err != nil {
+		slog.Error("Comment.GetComment", "err", err, "comment", commentID)
+		return ErrorCommentNotFound
+	}
+
+	if !CanDeleteComment(session, comment) {
+		return ErrorCommentNotAllowed
+	}
+
+	err = l.Comment().DeleteComment(ctx, craftID, commentID)
+	if err != nil {
+		slog.Error("Comment.DeleteComment", "err", err, "comment", commentID)
+		return ErrorCommentDelete
+	}
+	return nil
+}
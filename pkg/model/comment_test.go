@@ -0,0 +1,96 @@
+package model_test
+
+import "testing"
+
+import "github.com/oklog/ulid/v2"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+func TestComments(t *testing.T) {
+	logic, ctx := newLogic(t)
+	alice, aliceSession := register(t, logic, ctx, "Alice", "alice@example.com")
+	_, bob := register(t, logic, ctx, "Bob", "bob@example.com")
+	_, carol := register(t, logic, ctx, "Carol", "carol@example.com")
+	_, dave := register(t, logic, ctx, "Dave", "dave@example.com")
+
+	craft := model.Craft{ID: ulid.Make().String(), UserID: alice.ID, Title: "Scarf"}
+	hidden := model.Craft{ID: ulid.Make().String(), UserID: alice.ID, Title: "Hat", Hidden: true}
+	for _, c := range []model.Craft{craft, hidden} {
+		_, err := logic.Craft().Put(ctx, c.ID, c)
+		if err != nil {
+			t.Fatalf("Put: %s", err)
+		}
+	}
+
+	_, err := logic.NewComment(ctx, nil, craft.ID, "", "Nice")
+	if err != model.ErrorPleaseLogIn {
+		t.Errorf("NewComment without session: %v", err)
+	}
+	_, err = logic.NewComment(ctx, bob, craft.ID, "", "  ")
+	if err != model.ErrorCommentEmpty {
+		t.Errorf("NewComment empty: %v", err)
+	}
+	_, err = logic.NewComment(ctx, bob, hidden.ID, "", "Nice")
+	if err != model.ErrorCraftNotFound {
+		t.Errorf("NewComment on hidden craft: %v", err)
+	}
+	_, err = logic.NewComment(ctx, bob, craft.ID, ulid.Make().String(), "Nice")
+	if err != model.ErrorCommentNotFound {
+		t.Errorf("NewComment reply to unknown comment: %v", err)
+	}
+
+	first, err := logic.NewComment(ctx, bob, craft.ID, "", "what the fuck, so nice")
+	if err != nil || first.Text != "what the *, so nice" || first.OwnerID != alice.ID || first.UserName != "Bob" {
+		t.Fatalf("NewComment: %v %+v", err, first)
+	}
+	reply, err := logic.NewComment(ctx, carol, craft.ID, first.ID, "Agreed")
+	if err != nil || reply.ParentID != first.ID {
+		t.Fatalf("NewComment reply: %v %+v", err, reply)
+	}
+	second, err := logic.NewComment(ctx, aliceSession, craft.ID, "", "Thanks")
+	if err != nil {
+		t.Fatalf("NewComment by owner: %v", err)
+	}
+
+	threads, err := logic.Comments(ctx, craft.ID)
+	if err != nil || len(threads) != 2 || threads[0].ID != first.ID || threads[1].ID != second.ID {
+		t.Fatalf("Comments: %v %+v", err, threads)
+	}
+	if len(threads[0].Replies) != 1 || threads[0].Replies[0].ID != reply.ID {
+		t.Errorf("Comments replies: %+v", threads[0].Replies)
+	}
+
+	// Only the author and the owner of the craft delete comments.
+	if model.CanDeleteComment(dave, *first) || model.CanDeleteComment(nil, *first) {
+		t.Errorf("CanDeleteComment by other user")
+	}
+	if !model.CanDeleteComment(bob, *first) || !model.CanDeleteComment(aliceSession, *first) {
+		t.Errorf("CanDeleteComment by author or owner")
+	}
+	err = logic.DeleteComment(ctx, dave, craft.ID, first.ID)
+	if err != model.ErrorCommentNotAllowed {
+		t.Errorf("DeleteComment by other user: %v", err)
+	}
+	err = logic.DeleteComment(ctx, carol, craft.ID, second.ID)
+	if err != model.ErrorCommentNotAllowed {
+		t.Errorf("DeleteComment of other comment: %v", err)
+	}
+	err = logic.DeleteComment(ctx, aliceSession, craft.ID, first.ID)
+	if err != nil {
+		t.Errorf("DeleteComment by owner: %v", err)
+	}
+	err = logic.DeleteComment(ctx, aliceSession, craft.ID, first.ID)
+	if err != model.ErrorCommentNotFound {
+		t.Errorf("DeleteComment again: %v", err)
+	}
+
+	// Replies to deleted comments become top level comments.
+	threads, err = logic.Comments(ctx, craft.ID)
+	if err != nil || len(threads) != 2 || threads[0].ID != reply.ID || threads[1].ID != second.ID {
+		t.Fatalf("Comments after delete: %v %+v", err, threads)
+	}
+	err = logic.DeleteComment(ctx, carol, craft.ID, reply.ID)
+	if err != nil {
+		t.Errorf("DeleteComment by author: %v", err)
+	}
+}
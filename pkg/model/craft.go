@@ -0,0 +1,460 @@
+package model
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+import (
+	"github.com/oklog/ulid/v2"
+)
+
+import (
+	"github.com/qrochet/qrochet/pkg/censor"
+)
+
+var (
+	// ErrorNotOwner means the user tried to change something that is not theirs.
+	ErrorNotOwner = errors.New("this is not yours")
+
+	// ErrorTitleEmpty means a craft has no title.
+	ErrorTitleEmpty = errors.New("please enter a title")
+
+	// ErrorImageNotFound means the image of a craft does not exist.
+	ErrorImageNotFound = errors.New("image not found")
+
+	// ErrorImageDelete means deleting an uploaded image failed.
+	ErrorImageDelete = errors.New("image delete failed")
+
+	// ErrorImageInUse means the user tried to delete an uploaded image
+	// that a craft still shows.
+	ErrorImageInUse = errors.New("this image is used by a craft, please delete the craft first")
+
+	// ErrorCraftUpdate means updating a craft failed.
+	ErrorCraftUpdate = errors.New("craft update failed")
+
+	// ErrorCraftDelete means deleting a craft failed.
+	ErrorCraftDelete = errors.New("craft delete failed")
+
+	// ErrorNameEmpty means the user tried to set an empty name.
+	ErrorNameEmpty = errors.New("please enter a name")
+
+	// ErrorNameNotAllowed means the name contains censored words.
+	ErrorNameNotAllowed = errors.New("please choose a friendlier name")
+
+	// ErrorProfileUpdate means updating the profile of the user failed.
+	ErrorProfileUpdate = errors.New("profile update failed")
+
+	// ErrorAccountDelete means deleting the account of the user failed.
+	ErrorAccountDelete = errors.New("account delete failed")
+)
+
+// NewUpload scales down the image read from rd to the size used on
+// Qrochet and stores it for the user of the session.
+func (l *Logic) NewUpload(ctx Context, session *Session, title string, rd io.Reader, size int64) (*Upload, error) {
+	if session == nil || session.UserID == "" {
+		return nil, ErrorPleaseLogIn
+	}
+
+	if size > maxImageSize {
+		slog.Error("NewUpload Size > maxImageSize", "size", size)
+		return nil, ErrorImageTooLarge
+	}
+
+	resized, err := resizeImageJPEG(rd, 640, 640, 90)
+	if err != nil {
+		slog.Error("Image resizing failed", "err", err)
+		return nil, ErrorImageResize
+	}
+
+	upload := &Upload{
+		ID:         Reference(ulid.Make().String() + ".jpeg"),
+		Title:      censor.Replace(title),
+		UserID:     session.UserID,
+		MIME:       "image/jpeg",
+		ReadCloser: io.NopCloser(resized),
+	}
+
+	upload, err = l.Image().Put(ctx, upload)
+	if err != nil {
+		slog.Error("Image upload failed", "err", err)
+		return nil, ErrorImageUpload
+	}
+	upload.ReadCloser = nil
+	l.QueueRendition(ctx, upload)
+	return upload, nil
+}
+
+// Upload returns the information about an upload of the user of the
+// session, without its contents.
+func (l *Logic) Upload(ctx Context, session *Session, id string) (*Upload, error) {
+	if session == nil || session.UserID == "" {
+		return nil, ErrorPleaseLogIn
+	}
+
+	upload, err := l.Image().Get(ctx, id)
+	if err != nil {
+		slog.Error("Image.Get", "err", err, "image", id)
+		return nil, ErrorImageNotFound
+	}
+	upload.ReadCloser.Close()
+	upload.ReadCloser = nil
+
+	if upload.UserID != session.UserID {
+		return nil, ErrorNotOwner
+	}
+	return upload, nil
+}
+
+// Uploads returns the information about all uploads of the user of the
+// session, without their contents.
+func (l *Logic) Uploads(ctx Context, session *Session) ([]Upload, error) {
+	if session == nil || session.UserID == "" {
+		return nil, ErrorPleaseLogIn
+	}
+
+	var res []Upload
+	for id, err := range l.Image().List(ctx, session.UserID) {
+		if err != nil {
+			slog.Error("Image.List", "err", err)
+			return nil, ErrorImageGet
+		}
+		upload, err := l.Upload(ctx, session, id)
+		if err != nil {
+			continue
+		}
+		res = append(res, *upload)
+	}
+	return res, nil
+}
+
+// DeleteUpload deletes an upload of the user of the session. Uploads that
+// are the image or pattern of a craft cannot be deleted.
+func (l *Logic) DeleteUpload(ctx Context, session *Session, id string) error {
+	_, err := l.Upload(ctx, session, id)
+	if err != nil {
+		return err
+	}
+	for craft, err := range l.Craft().AllForUserID(ctx, session.UserID) {
+		if err != nil {
+			slog.Error("Craft.AllForUserID", "err", err, "user", session.UserID)
+			return ErrorImageDelete
+		}
+		if string(craft.Image) == id || string(craft.Pattern) == id {
+			return ErrorImageInUse
+		}
+	}
+
+	err = l.Image().Delete(ctx, id)
+	if err != nil {
+		slog.Error("Image.Delete", "err", err, "image", id)
+		return ErrorImageDelete
+	}
+	// The thumbnail may not have been rendered yet.
+	_ = l.Thumbnail().Delete(ctx, id)
+	return nil
+}
+
+// checkCraft checks and cleans up the title, details, tags and image of a
+// craft of the user of the session.
+func (l *Logic) checkCraft(ctx Context, session *Session, craft *Craft) error {
+	craft.Title = strings.TrimSpace(craft.Title)
+	if craft.Title == "" {
+		return ErrorTitleEmpty
+	}
+	if censor.Check(craft.Title) != nil {
+		return ErrorTitleNotAllowed
+	}
+	craft.Detail = censor.Replace(craft.Detail)
+
+	var tags []string
+	for _, tag := range craft.Tags {
+		tag = strings.TrimSpace(tag)
+		if tag != "" && censor.Check(tag) == nil {
+			tags = append(tags, tag)
+		}
+	}
+	craft.Tags = tags
+
+	for _, ref := range []Reference{craft.Image, craft.Pattern} {
+		if ref == "" {
+			continue
+		}
+		upload, err := l.Upload(ctx, session, string(ref))
+		if err != nil {
+			return err
+		}
+		if upload.Hidden {
+			return ErrorImageNotFound
+		}
+	}
+	if craft.Image == "" {
+		return ErrorImageNotFound
+	}
+	return nil
+}
+
+// NewCraft creates a new craft for the user of the session. The image of
+// the craft must be an earlier upload of the user.
+func (l *Logic) NewCraft(ctx Context, session *Session, craft Craft) (*Craft, error) {
+	if session == nil || session.UserID == "" {
+		return nil, ErrorPleaseLogIn
+	}
+
+	err := l.checkCraft(ctx, session, &craft)
+	if err != nil {
+		return nil, err
+	}
+
+	craft.ID = ulid.Make().String()
+	craft.UserID = session.UserID
+	craft.Hidden = false
+	var created Craft
+	err = l.Record(ctx, EventCraftCreated, craft.UserID, CraftCreated{Craft: craft}, func() (err error) {
+		created, err = l.Craft().Put(ctx, craft.ID, craft)
+		return err
+	})
+	if err != nil {
+		slog.Error("Craft.Put", "err", err)
+		return nil, ErrorCraftCreate
+	}
+	return &created, nil
+}
+
+// UpdateCraft updates the title, details, tags, image and pattern of a
+// craft of the user of the session.
+func (l *Logic) UpdateCraft(ctx Context, session *Session, craft Craft) (*Craft, error) {
+	if session == nil || session.UserID == "" {
+		return nil, ErrorPleaseLogIn
+	}
+
+	old, err := l.Craft().GetForUserID(ctx, craft.ID, session.UserID)
+	if err != nil {
+		return nil, ErrorCraftNotFound
+	}
+
+	err = l.checkCraft(ctx, session, &craft)
+	if err != nil {
+		return nil, err
+	}
+
+	old.Title = craft.Title
+	old.Detail = craft.Detail
+	old.Tags = craft.Tags
+	old.Image = craft.Image
+	old.Pattern = craft.Pattern
+	updated, err := l.Craft().Put(ctx, old.ID, old)
+	if err != nil {
+		slog.Error("Craft.Put", "err", err, "craft", old.ID)
+		return nil, ErrorCraftUpdate
+	}
+	return &updated, nil
+}
+
+// DeleteCraft deletes a craft of the user of the session, with its likes
+// and comments, and its images unless another craft of the user uses them.
+func (l *Logic) DeleteCraft(ctx Context, session *Session, craftID string) error {
+	if session == nil || session.UserID == "" {
+		return ErrorPleaseLogIn
+	}
+
+	craft, err := l.Craft().GetForUserID(ctx, craftID, session.UserID)
+	if err != nil {
+		return ErrorCraftNotFound
+	}
+
+	err = l.Record(ctx, EventCraftDeleted, session.UserID, CraftDeleted{UserID: session.UserID, CraftID: craftID}, func() error {
+		return l.Craft().Delete(ctx, session.UserID+"."+craftID)
+	})
+	if err != nil {
+		slog.Error("Craft.Delete", "err", err, "craft", craftID)
+		return ErrorCraftDelete
+	}
+	l.deleteCraftData(ctx, craft)
+	return nil
+}
+
+// deleteCraftData deletes the likes, comments and unused images of a
+// craft that was deleted. Errors are only logged, since the craft is
+// already gone.
+func (l *Logic) deleteCraftData(ctx Context, craft Craft) {
+	for like, err := range l.Like().All(ctx, craft.ID+".*") {
+		if err == nil {
+			err = l.Like().DeleteLike(ctx, like.CraftID, like.UserID)
+		}
+		if err != nil {
+			slog.Error("delete like", "err", err, "craft", craft.ID)
+		}
+	}
+	for comment, err := range l.Comment().AllForCraftID(ctx, craft.ID) {
+		if err == nil {
+			err = l.Comment().DeleteComment(ctx, craft.ID, comment.ID)
+		}
+		if err != nil {
+			slog.Error("delete comment", "err", err, "craft", craft.ID)
+		}
+	}
+
+	used := map[Reference]bool{}
+	for other, err := range l.Craft().AllForUserID(ctx, craft.UserID) {
+		if err != nil {
+			slog.Error("Craft.AllForUserID", "err", err, "user", craft.UserID)
+			return
+		}
+		used[other.Image] = true
+		used[other.Pattern] = true
+	}
+	for _, ref := range []Reference{craft.Image, craft.Pattern} {
+		if ref == "" || used[ref] {
+			continue
+		}
+		err := l.Image().Delete(ctx, string(ref))
+		if err != nil {
+			slog.Error("Image.Delete", "err", err, "image", ref)
+		}
+		_ = l.Thumbnail().Delete(ctx, string(ref))
+	}
+}
+
+// PublicCrafts returns a page of the crafts of all users that are not
+// hidden, newest first, like Feed does. query.Amount is the amount of
+// crafts on the page, or PageAmount if not positive. Only the crafts of the
+// page are loaded.
+func (l *Logic) PublicCrafts(ctx Context, query RangeQuery[Craft]) (*RangeResult[Craft], error) {
+	if query.Amount <= 0 {
+		query.Amount = PageAmount
+	}
+	query.Amount = min(query.Amount, PageMaximum)
+	return l.craftPage(ctx, query)
+}
+
+// Profile returns the user of the session.
+func (l *Logic) Profile(ctx Context, session *Session) (*User, error) {
+	if session == nil || session.UserID == "" {
+		return nil, ErrorPleaseLogIn
+	}
+
+	user, err := l.User().Get(ctx, session.UserID)
+	if err != nil {
+		slog.Error("User.Get", "err", err, "user", session.UserID)
+		return nil, ErrorUserNotFound
+	}
+	return &user, nil
+}
+
+// UpdateName changes the name of the user of the session.
+func (l *Logic) UpdateName(ctx Context, session *Session, name string) (*User, error) {
+	name, err := checkName(name)
+	if err != nil {
+		return nil, err
+	}
+	return l.updateSessionUser(ctx, session, ErrorProfileUpdate, func(user *User) error {
+		user.Name = name
+		return nil
+	})
+}
+
+// DeleteAccount deletes the user of the session together with their
+// crafts, uploads, likes, comments, follows and session. The user has to
+// reauthenticate with the current password first.
+func (l *Logic) DeleteAccount(ctx Context, session *Session, current string) error {
+	user, err := l.Profile(ctx, session)
+	if err != nil {
+		return err
+	}
+	err = l.reauthenticate(ctx, session, user, current, "wrong password for account deletion")
+	if err != nil {
+		return err
+	}
+
+	crafts, err := Collect(l.Craft().AllForUserID(ctx, user.ID))
+	if err != nil {
+		slog.Error("Craft.AllForUserID", "err", err, "user", user.ID)
+		return ErrorAccountDelete
+	}
+	for _, craft := range crafts {
+		err = l.DeleteCraft(ctx, session, craft.ID)
+		if err != nil {
+			return ErrorAccountDelete
+		}
+	}
+
+	uploads, err := l.Uploads(ctx, session)
+	if err != nil {
+		return ErrorAccountDelete
+	}
+	for _, upload := range uploads {
+		err = l.DeleteUpload(ctx, session, string(upload.ID))
+		if err != nil {
+			return ErrorAccountDelete
+		}
+	}
+
+	if user.Avatar != "" {
+		_ = l.Avatar().Delete(ctx, string(user.Avatar))
+	}
+
+	err = l.deleteUserData(ctx, user.ID)
+	if err != nil {
+		slog.Error("deleteUserData", "err", err, "user", user.ID)
+		return ErrorAccountDelete
+	}
+
+	err = l.User().Delete(ctx, user.ID)
+	if err != nil {
+		slog.Error("User.Delete", "err", err, "user", user.ID)
+		return ErrorAccountDelete
+	}
+	_ = l.Session().Delete(ctx, user.ID)
+	slog.Info("DeleteAccount", "user", user.ID)
+	l.Audit(ctx, AuditAccountDelete, user.ID, RedactEmail(user.Email))
+	return nil
+}
+
+// deleteUserData deletes the likes and comments of the user on the crafts
+// of others, and the follows of and on the user.
+func (l *Logic) deleteUserData(ctx Context, userID string) error {
+	likes, err := Collect(l.Like().AllForUserID(ctx, userID))
+	if err != nil {
+		return err
+	}
+	for _, like := range likes {
+		err = l.Like().DeleteLike(ctx, like.CraftID, like.UserID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Comments are keyed by craft, so all of them are searched.
+	comments, err := Collect(l.Comment().All(ctx))
+	if err != nil {
+		return err
+	}
+	for _, comment := range comments {
+		if comment.UserID != userID {
+			continue
+		}
+		err = l.Comment().DeleteComment(ctx, comment.CraftID, comment.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Follows are keyed by userID.followID. The filters may not overlap,
+	// so the follows of and on the user are listed one after the other.
+	for _, filter := range []string{userID + ".*", "*." + userID} {
+		follows, err := Collect(l.Follow().All(ctx, filter))
+		if err != nil {
+			return err
+		}
+		for _, follow := range follows {
+			err = l.Follow().DeleteFollow(ctx, follow.UserID, follow.FollowID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package model
+
+import "encoding/json"
+import "fmt"
+import "log/slog"
+import "time"
+
+import "github.com/oklog/ulid/v2"
+
+// EventType is the type of a domain event.
+type EventType string
+
+// Types of domain events.
+const (
+	EventUserRegistered EventType = "user.registered"
+	EventSessionStarted EventType = "session.started"
+	EventCraftCreated   EventType = "craft.created"
+	EventCraftDeleted   EventType = "craft.deleted"
+)
+
+// Event is a domain event: a change of state in the model. Data is the
+// JSON of the payload, which depends on the Type.
+type Event struct {
+	ID      string          `json:"id"`
+	Type    EventType       `json:"type"`
+	Time    time.Time       `json:"time"`
+	UserID  string          `json:"user_id"`
+	Data    json.RawMessage `json:"data"`
+	Pending bool            `json:"pending,omitempty"` // Not known yet if the change was made.
+}
+
+// Decode decodes the data of the event into the payload.
+func (e Event) Decode(payload any) error {
+	return json.Unmarshal(e.Data, payload)
+}
+
+// UserRegistered is the payload of EventUserRegistered.
+type UserRegistered struct {
+	UserID string `json:"user_id"`
+	Name   string `json:"name"`
+}
+
+// SessionStarted is the payload of EventSessionStarted.
+type SessionStarted struct {
+	UserID string    `json:"user_id"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+}
+
+// CraftCreated is the payload of EventCraftCreated.
+type CraftCreated struct {
+	Craft Craft `json:"craft"`
+}
+
+// CraftDeleted is the payload of EventCraftDeleted.
+type CraftDeleted struct {
+	UserID  string `json:"user_id"`
+	CraftID string `json:"craft_id"`
+}
+
+// EventHandler handles a domain event. If it returns an error, the event
+// is handled again later.
+type EventHandler func(ctx Context, event Event) error
+
+// EventCheck returns whether the change of state a pending event is about
+// was made.
+type EventCheck func(ctx Context, event Event) (bool, error)
+
+// Outbox records domain events and publishes them. An event is recorded
+// as pending before the change of state it is about, and committed or
+// cancelled after it, so that it is neither lost nor published for a
+// change that failed, even if the process stops in between.
+type Outbox interface {
+	// Record stores the pending event in the outbox.
+	Record(ctx Context, event Event) error
+	// Commit marks the event as made, to be published by Relay.
+	Commit(ctx Context, event Event) error
+	// Cancel removes the event from the outbox.
+	Cancel(ctx Context, event Event) error
+	// Relay publishes the committed events until ctx is done. Events
+	// that stay pending too long are published or dropped by the check.
+	// Every event is published once, even if several relays run.
+	Relay(ctx Context, check EventCheck) error
+	// Subscribe calls the handler for every published event, starting
+	// where the subscriber with the same name stopped, until ctx is done.
+	Subscribe(ctx Context, name string, handler EventHandler) error
+}
+
+// Record makes a change of state with the change function, and records
+// the domain event about it with the payload for the user. The event is
+// stored before the change and committed after it, so it is published if
+// and only if the change was made. If the event cannot be stored, the
+// change is not made and the error is returned.
+func (l *Logic) Record(ctx Context, typ EventType, userID string, payload any, change func() error) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("Record event", "err", err, "type", typ)
+		return err
+	}
+
+	event := Event{
+		ID:      ulid.Make().String(),
+		Type:    typ,
+		Time:    time.Now(),
+		UserID:  userID,
+		Data:    data,
+		Pending: true,
+	}
+	err = l.Outbox().Record(ctx, event)
+	if err != nil {
+		slog.Error("Outbox.Record", "err", err, "type", typ, "event", event.ID)
+		return err
+	}
+
+	err = change()
+	if err != nil {
+		cerr := l.Outbox().Cancel(ctx, event)
+		if cerr != nil {
+			// The relay drops it once it finds the change was not made.
+			slog.Error("Outbox.Cancel", "err", cerr, "type", typ, "event", event.ID)
+		}
+		return err
+	}
+
+	err = l.Outbox().Commit(ctx, event)
+	if err != nil {
+		// The relay publishes it once it finds the change was made.
+		slog.Error("Outbox.Commit", "err", err, "type", typ, "event", event.ID)
+	}
+	return nil
+}
+
+// CheckEvent is the EventCheck of the events of Logic. It returns whether
+// the change of state of the event can be found in the repository.
+func (l *Logic) CheckEvent(ctx Context, event Event) (bool, error) {
+	switch event.Type {
+	case EventUserRegistered:
+		return exists[User](ctx, l.User(), event.UserID)
+	case EventSessionStarted:
+		var started SessionStarted
+		err := event.Decode(&started)
+		if err != nil {
+			return false, err
+		}
+		found, err := exists[Session](ctx, l.Session(), started.UserID)
+		if !found || err != nil {
+			return false, err
+		}
+		session, err := l.Session().Get(ctx, started.UserID)
+		if err != nil {
+			return false, err
+		}
+		return session.Start.Equal(started.Start), nil
+	case EventCraftCreated:
+		var created CraftCreated
+		err := event.Decode(&created)
+		if err != nil {
+			return false, err
+		}
+		return exists[Craft](ctx, l.Craft(), created.Craft.UserID+"."+created.Craft.ID)
+	case EventCraftDeleted:
+		var deleted CraftDeleted
+		err := event.Decode(&deleted)
+		if err != nil {
+			return false, err
+		}
+		found, err := exists[Craft](ctx, l.Craft(), deleted.UserID+"."+deleted.CraftID)
+		return !found, err
+	}
+	return false, fmt.Errorf("unknown event type %q", event.Type)
+}
+
+// exists returns whether the mapper has an entry with the key.
+func exists[T any](ctx Context, mapper BasicMapper[T], key string) (bool, error) {
+	for _, err := range mapper.Keys(ctx, key) {
+		return err == nil, err
+	}
+	return false, nil
+}
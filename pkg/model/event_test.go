@@ -0,0 +1,47 @@
+package model_test
+
+import "encoding/json"
+import "testing"
+
+import "github.com/oklog/ulid/v2"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+func TestCheckEvent(t *testing.T) {
+	logic, ctx := newLogic(t)
+	alice, _ := register(t, logic, ctx, "Alice", "alice@example.com")
+
+	craft := model.Craft{ID: ulid.Make().String(), UserID: alice.ID, Title: "Scarf"}
+	_, err := logic.Craft().Put(ctx, craft.ID, craft)
+	if err != nil {
+		t.Fatalf("Put: %s", err)
+	}
+	gone := model.Craft{ID: ulid.Make().String(), UserID: alice.ID, Title: "Hat"}
+
+	event := func(typ model.EventType, userID string, payload any) model.Event {
+		data, _ := json.Marshal(payload)
+		return model.Event{Type: typ, UserID: userID, Data: data}
+	}
+	tests := []struct {
+		event model.Event
+		made  bool
+	}{
+		{event(model.EventUserRegistered, alice.ID, model.UserRegistered{UserID: alice.ID}), true},
+		{event(model.EventUserRegistered, "nobody", model.UserRegistered{UserID: "nobody"}), false},
+		{event(model.EventCraftCreated, alice.ID, model.CraftCreated{Craft: craft}), true},
+		{event(model.EventCraftCreated, alice.ID, model.CraftCreated{Craft: gone}), false},
+		{event(model.EventCraftDeleted, alice.ID, model.CraftDeleted{UserID: alice.ID, CraftID: craft.ID}), false},
+		{event(model.EventCraftDeleted, alice.ID, model.CraftDeleted{UserID: alice.ID, CraftID: gone.ID}), true},
+	}
+	for _, test := range tests {
+		made, err := logic.CheckEvent(ctx, test.event)
+		if err != nil || made != test.made {
+			t.Errorf("CheckEvent %s %s: %v %t", test.event.Type, test.event.Data, err, made)
+		}
+	}
+
+	_, err = logic.CheckEvent(ctx, model.Event{Type: "nope"})
+	if err == nil {
+		t.Errorf("CheckEvent of unknown type passed")
+	}
+}
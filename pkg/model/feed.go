@@ -0,0 +1,229 @@
+package model
+
+import (
+	"errors"
+	"iter"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+const (
+	// FeedAmount is the default amount of crafts on a page of the feed.
+	FeedAmount = 20
+
+	// NewCraftWindow is how old a craft may be for a change of it to
+	// count as a new craft in NewCrafts.
+	NewCraftWindow = time.Minute
+)
+
+var (
+	// ErrorFollowSelf means a user tried to follow themselves.
+	ErrorFollowSelf = errors.New("you cannot follow yourself")
+
+	// ErrorFollow means following or unfollowing a user failed.
+	ErrorFollow = errors.New("follow failed")
+
+	// ErrorUserNotFound means the user could not be found.
+	ErrorUserNotFound = errors.New("user not found")
+
+	// ErrorFeedEmpty means the user does not follow anyone yet.
+	ErrorFeedEmpty = errors.New("you do not follow anyone yet")
+)
+
+// SetFollow sets whether the user of the session follows the user
+// with the given ID. Like SetLike this may safely be repeated.
+func (l *Logic) SetFollow(ctx Context, session *Session, followID string, follow bool) error {
+	if session == nil || session.UserID == "" {
+		return ErrorPleaseLogIn
+	}
+
+	if followID == session.UserID {
+		return ErrorFollowSelf
+	}
+
+	_, err := l.User().Get(ctx, followID)
+	if err != nil {
+		slog.Error("User.Get", "err", err, "user", followID)
+		return ErrorUserNotFound
+	}
+
+	has, err := l.Follow().Has(ctx, session.UserID, followID)
+	if err != nil {
+		slog.Error("Follow.Has", "err", err, "user", followID)
+		return ErrorFollow
+	}
+
+	if follow && !has {
+		_, err = l.Follow().PutFollow(ctx, Follow{
+			UserID:   session.UserID,
+			FollowID: followID,
+			Created:  time.Now(),
+		})
+	} else if !follow && has {
+		err = l.Follow().DeleteFollow(ctx, session.UserID, followID)
+	}
+	if err != nil {
+		slog.Error("Follow", "err", err, "user", followID, "follow", follow)
+		return ErrorFollow
+	}
+	return nil
+}
+
+// Follows returns true if the user of the session follows the user.
+func (l *Logic) Follows(ctx Context, session *Session, followID string) bool {
+	if session == nil || session.UserID == "" {
+		return false
+	}
+	has, err := l.Follow().Has(ctx, session.UserID, followID)
+	if err != nil {
+		slog.Error("Follow.Has", "err", err, "user", followID)
+		return false
+	}
+	return has
+}
+
+// Following returns the IDs of the users that the user of the session follows.
+func (l *Logic) Following(ctx Context, session *Session) ([]string, error) {
+	if session == nil || session.UserID == "" {
+		return nil, ErrorPleaseLogIn
+	}
+
+	var res []string
+	for follow, err := range l.Follow().AllForUserID(ctx, session.UserID) {
+		if err != nil {
+			slog.Error("Follow.AllForUserID", "err", err)
+			return nil, err
+		}
+		res = append(res, follow.FollowID)
+	}
+	return res, nil
+}
+
+// Feed returns a page of the crafts of the users that the user of the
+// session follows, newest first in ULID order. query.First is the ID of
+// the last craft of the previous page, or empty for the first page.
+// query.Amount is the amount of crafts on the page, or FeedAmount if not
+// positive. Only the keys of the crafts are listed, and only the crafts
+// of the page are loaded.
+func (l *Logic) Feed(ctx Context, session *Session, query RangeQuery[Craft]) (*RangeResult[Craft], error) {
+	following, err := l.Following(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	if query.Amount <= 0 {
+		query.Amount = FeedAmount
+	}
+	query.Amount = min(query.Amount, PageMaximum)
+	if len(following) == 0 {
+		return &RangeResult[Craft]{}, nil
+	}
+
+	filters := make([]string, 0, len(following))
+	for _, userID := range following {
+		filters = append(filters, userID+".*")
+	}
+	return l.craftPage(ctx, query, filters...)
+}
+
+// craftPage returns the page of the crafts that are not hidden with the
+// keys that match the filters, or of all crafts if there are none, newest
+// first. query.Amount must be positive. Only the keys of the crafts are
+// listed, and only the crafts of the page are loaded.
+func (l *Logic) craftPage(ctx Context, query RangeQuery[Craft], filters ...string) (*RangeResult[Craft], error) {
+	// Crafts are keyed by userID.craftID.
+	var keys []string
+	for key, err := range l.Craft().Keys(ctx, filters...) {
+		if err != nil {
+			slog.Error("Craft.Keys", "err", err)
+			return nil, err
+		}
+		_, id, _ := strings.Cut(key, ".")
+		if query.First == "" || id < query.First {
+			keys = append(keys, key)
+		}
+	}
+	craftID := func(key string) string {
+		_, id, _ := strings.Cut(key, ".")
+		return id
+	}
+	sort.Slice(keys, func(i, j int) bool { return craftID(keys[i]) > craftID(keys[j]) })
+
+	var crafts []Craft
+	for _, key := range keys {
+		if len(crafts) == query.Amount {
+			break
+		}
+		craft, err := l.Craft().Get(ctx, key)
+		if err != nil {
+			slog.Error("Craft.Get", "err", err, "craft", key)
+			continue
+		}
+		if craft.Hidden {
+			continue
+		}
+		crafts = append(crafts, craft)
+	}
+	return Paginate(crafts, RangeQuery[Craft]{Amount: query.Amount}, func(c Craft) string { return c.ID }), nil
+}
+
+// WatchFeed watches for new crafts of the users that the user of the
+// session follows, until the context is done or the loop ends. Changes of
+// older crafts are skipped, see NewCrafts.
+func (l *Logic) WatchFeed(ctx Context, session *Session) (iter.Seq2[Craft, error], error) {
+	following, err := l.Following(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	if len(following) == 0 {
+		return nil, ErrorFeedEmpty
+	}
+
+	keys := make([]string, 0, len(following))
+	for _, userID := range following {
+		keys = append(keys, userID+".>")
+	}
+	return NewCrafts(l.Craft().Watch(ctx, keys...)), nil
+}
+
+// NewCrafts filters a watch of crafts to the crafts that were just
+// created, once each. A craft is new if its ULID is at most
+// NewCraftWindow old, so hiding, restoring or editing an older craft is
+// not seen as a new craft. Hidden crafts are skipped, errors are passed on.
+func NewCrafts(crafts iter.Seq2[Craft, error]) iter.Seq2[Craft, error] {
+	return func(yield func(Craft, error) bool) {
+		seen := map[string]time.Time{}
+		for craft, err := range crafts {
+			if err != nil {
+				if !yield(craft, err) {
+					return
+				}
+				continue
+			}
+			id, err := ulid.ParseStrict(craft.ID)
+			if err != nil || craft.Hidden {
+				continue
+			}
+			now := time.Now()
+			created := ulid.Time(id.Time())
+			if now.Sub(created) > NewCraftWindow {
+				continue
+			}
+			if _, ok := seen[craft.ID]; ok {
+				continue
+			}
+			for key, t := range seen {
+				if now.Sub(t) > NewCraftWindow {
+					delete(seen, key)
+				}
+			}
+			seen[craft.ID] = created
+			if !yield(craft, nil) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package model_test
+
+import "iter"
+import "testing"
+import "time"
+
+import "github.com/oklog/ulid/v2"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+func TestFeed(t *testing.T) {
+	logic, ctx := newLogic(t)
+	_, alice := register(t, logic, ctx, "Alice", "alice@example.com")
+	bob, _ := register(t, logic, ctx, "Bob", "bob@example.com")
+	carol, _ := register(t, logic, ctx, "Carol", "carol@example.com")
+
+	err := logic.SetFollow(ctx, alice, bob.ID, true)
+	if err != nil {
+		t.Fatalf("SetFollow: %s", err)
+	}
+	var ids []string
+	for i := range 5 {
+		for _, user := range []*model.User{bob, carol} {
+			craft := model.Craft{ID: ulid.Make().String(), UserID: user.ID, Title: "Scarf", Hidden: i == 2}
+			_, err = logic.Craft().Put(ctx, craft.ID, craft)
+			if err != nil {
+				t.Fatalf("Put: %s", err)
+			}
+			if user == bob && !craft.Hidden {
+				ids = append(ids, craft.ID)
+			}
+		}
+	}
+
+	first, err := logic.Feed(ctx, alice, model.RangeQuery[model.Craft]{Amount: 3})
+	if err != nil || first.Amount != 3 || first.First != ids[3] || first.Last != ids[1] {
+		t.Fatalf("Feed first page: %v %+v", err, first)
+	}
+	second, err := logic.Feed(ctx, alice, model.RangeQuery[model.Craft]{First: first.Last, Amount: 3})
+	if err != nil || second.Amount != 1 || second.First != ids[0] {
+		t.Fatalf("Feed second page: %v %+v", err, second)
+	}
+}
+
+func TestPublicCrafts(t *testing.T) {
+	logic, ctx := newLogic(t)
+	bob, _ := register(t, logic, ctx, "Bob", "bob@example.com")
+	carol, _ := register(t, logic, ctx, "Carol", "carol@example.com")
+
+	var ids []string
+	for i := range 4 {
+		for _, user := range []*model.User{bob, carol} {
+			craft := model.Craft{ID: ulid.Make().String(), UserID: user.ID, Title: "Scarf", Hidden: i == 1}
+			_, err := logic.Craft().Put(ctx, craft.ID, craft)
+			if err != nil {
+				t.Fatalf("Put: %s", err)
+			}
+			if !craft.Hidden {
+				ids = append(ids, craft.ID)
+			}
+		}
+	}
+
+	first, err := logic.PublicCrafts(ctx, model.RangeQuery[model.Craft]{Amount: 4})
+	if err != nil || first.Amount != 4 || first.First != ids[5] || first.Last != ids[2] {
+		t.Fatalf("PublicCrafts first page: %v %+v", err, first)
+	}
+	second, err := logic.PublicCrafts(ctx, model.RangeQuery[model.Craft]{First: first.Last, Amount: 4})
+	if err != nil || second.Amount != 2 || second.First != ids[1] || second.Last != ids[0] {
+		t.Fatalf("PublicCrafts second page: %v %+v", err, second)
+	}
+}
+
+func TestNewCrafts(t *testing.T) {
+	old := ulid.MustNew(ulid.Timestamp(time.Now().Add(-time.Hour)), nil).String()
+	fresh := ulid.Make().String()
+	hidden := ulid.Make().String()
+	watch := []model.Craft{
+		{ID: fresh},
+		{ID: old},                  // An older craft that was restored.
+		{ID: hidden, Hidden: true}, // A new craft that was hidden.
+		{ID: fresh},                // An edit of the new craft.
+	}
+	seq := func(yield func(model.Craft, error) bool) {
+		for _, craft := range watch {
+			if !yield(craft, nil) {
+				return
+			}
+		}
+	}
+
+	got, err := model.Collect(model.NewCrafts(iter.Seq2[model.Craft, error](seq)))
+	if err != nil || len(got) != 1 || got[0].ID != fresh {
+		t.Errorf("NewCrafts: %v %+v", err, got)
+	}
+}
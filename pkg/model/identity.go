@@ -0,0 +1,164 @@
+package model
+
+import "errors"
+import "log/slog"
+import "net/mail"
+import "strings"
+
+import "github.com/oklog/ulid/v2"
+
+// externalDefaultName is the name of a new user of an OpenID Connect
+// provider when neither the provider's name nor the email address make an
+// allowed name.
+const externalDefaultName = "Crocheter"
+
+var (
+	// ErrorEmailNotVerified means the OpenID Connect provider did not
+	// verify the email address, so it cannot be linked to a user.
+	ErrorEmailNotVerified = errors.New("the email address is not verified by the provider")
+
+	// ErrorIdentityMismatch means the user with the email address is
+	// already linked to another account at the same provider.
+	ErrorIdentityMismatch = errors.New("this email address is linked to another account of the provider")
+)
+
+// Identity is the account of a user at an OpenID Connect provider.
+type Identity struct {
+	Issuer  string `json:"issuer"`
+	Subject string `json:"subject"`
+}
+
+// ExternalUser is a user as an OpenID Connect provider knows them, from the
+// claims of a verified ID token.
+type ExternalUser struct {
+	Identity
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// identity returns the identity of the user at the issuer, if any.
+func (u User) identity(issuer string) (Identity, bool) {
+	for _, id := range u.Identities {
+		if id.Issuer == issuer {
+			return id, true
+		}
+	}
+	return Identity{}, false
+}
+
+// LoginExternal logs in a user that an OpenID Connect provider
+// authenticated, and returns the user, for whom the caller then creates a
+// session. The user is found by the identity at the provider, so that it
+// still finds them after they changed their email address. Otherwise the
+// user is found by the verified email address, and the identity is linked
+// to the user. If there is no user with the address yet, a user without
+// password is registered. If the user has
+// two factor authentication enabled, it returns a *SecondFactorError like
+// Login.
+func (l *Logic) LoginExternal(ctx Context, ext ExternalUser) (*User, error) {
+	_, err := mail.ParseAddress(ext.Email)
+	if err != nil {
+		return nil, ErrorEmailNotValid
+	}
+	if !ext.EmailVerified {
+		l.Audit(ctx, AuditLoginFailed, "", "oidc email not verified: "+RedactEmail(ext.Email))
+		return nil, ErrorEmailNotVerified
+	}
+
+	user, err := l.User().GetByIdentity(ctx, ext.Identity)
+	if err != nil {
+		slog.Error("User.GetByIdentity", "err", err)
+		return nil, ErrorGetEmail
+	}
+	if user != nil {
+		return l.loginExternal(ctx, user, ext)
+	}
+	user, err = l.User().GetByEmail(ctx, ext.Email)
+	if err != nil {
+		slog.Error("User.GetByEmail", "err", err)
+		return nil, ErrorGetEmail
+	}
+	if user == nil || user.Email != ext.Email {
+		user, err = l.registerExternal(ctx, ext)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	userID := user.ID
+	linked := false
+	user, err = l.updateUser(ctx, userID, ErrorUserNotSaved, func(user *User) error {
+		id, found := user.identity(ext.Issuer)
+		if found && id.Subject != ext.Subject {
+			return ErrorIdentityMismatch
+		}
+		linked = !found
+		if linked {
+			user.Identities = append(user.Identities, ext.Identity)
+		}
+		return nil
+	})
+	if err == ErrorIdentityMismatch {
+		slog.Warn("identity mismatch", "user", userID, "issuer", ext.Issuer)
+		l.Audit(ctx, AuditLoginFailed, userID, "oidc identity mismatch: "+ext.Issuer)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	if linked {
+		l.Audit(ctx, AuditIdentityLink, user.ID, ext.Issuer)
+	}
+	return l.loginExternal(ctx, user, ext)
+}
+
+// loginExternal completes the log in of a user with a linked identity.
+func (l *Logic) loginExternal(ctx Context, user *User, ext ExternalUser) (*User, error) {
+	if user.HasTOTP() {
+		return user, l.secondFactor(ctx, *user)
+	}
+	l.resetAttempts(ctx, user.Email)
+	l.Audit(ctx, AuditLogin, user.ID, "oidc: "+ext.Issuer)
+	return user, nil
+}
+
+// registerExternal registers a new user without password for a user of
+// an OpenID Connect provider. They can log in with the provider or with a
+// log in link.
+func (l *Logic) registerExternal(ctx Context, ext ExternalUser) (*User, error) {
+	user := User{}
+	user.ID = ulid.Make().String()
+	user.Email = ext.Email
+	user.Name = externalName(ext)
+
+	var created User
+	err := l.Record(ctx, EventUserRegistered, user.ID, UserRegistered{UserID: user.ID, Name: user.Name}, func() (err error) {
+		created, err = l.User().Put(ctx, user.ID, user)
+		return err
+	})
+	if err != nil {
+		slog.Error("User.Put", "err", err)
+		return nil, ErrorRegistrationFailed
+	}
+	l.Audit(ctx, AuditRegister, created.ID, "oidc: "+ext.Issuer)
+	l.QueueRegistrationMail(ctx, created)
+	return &created, nil
+}
+
+// externalName returns a display name for a new user of an OpenID Connect
+// provider. The provider's name is checked like any other, and replaced by
+// the local part of the email address, or else by a generic name, if it is
+// not allowed.
+func externalName(ext ExternalUser) string {
+	name, err := checkName(ext.Name)
+	if err == nil {
+		return name
+	}
+	local, _, _ := strings.Cut(ext.Email, "@")
+	name, err = checkName(local)
+	if err == nil {
+		return name
+	}
+	return externalDefaultName
+}
@@ -0,0 +1,82 @@
+package model_test
+
+import "strings"
+import "testing"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+func TestLoginExternal(t *testing.T) {
+	logic, ctx := newLogic(t)
+	bob, _ := register(t, logic, ctx, "Bob", "bob@example.com")
+
+	ext := model.ExternalUser{
+		Identity: model.Identity{Issuer: "https://id.example.com", Subject: "42"},
+		Email:    "bob@example.com",
+	}
+	_, err := logic.LoginExternal(ctx, ext)
+	if err != model.ErrorEmailNotVerified {
+		t.Errorf("LoginExternal not verified: %v", err)
+	}
+
+	ext.EmailVerified = true
+	user, err := logic.LoginExternal(ctx, ext)
+	if err != nil || user.ID != bob.ID || len(user.Identities) != 1 {
+		t.Fatalf("LoginExternal: %v %v", err, user)
+	}
+
+	ext.Subject = "666"
+	_, err = logic.LoginExternal(ctx, ext)
+	if err != model.ErrorIdentityMismatch {
+		t.Errorf("LoginExternal other subject: %v", err)
+	}
+
+	ext = model.ExternalUser{
+		Identity:      model.Identity{Issuer: "https://id.example.com", Subject: "7"},
+		Email:         "ann@example.com",
+		EmailVerified: true,
+	}
+	user, err = logic.LoginExternal(ctx, ext)
+	if err != nil || user.ID == bob.ID || user.Name != "ann" {
+		t.Errorf("LoginExternal new user: %v %v", err, user)
+	}
+
+	// The provider's name is checked like any other.
+	ext = model.ExternalUser{
+		Identity:      model.Identity{Issuer: "https://id.example.com", Subject: "8"},
+		Email:         "cat@example.com",
+		EmailVerified: true,
+		Name:          strings.Repeat("Cat", model.NameMaxLength),
+	}
+	user, err = logic.LoginExternal(ctx, ext)
+	if err != nil || user.Name != "cat" {
+		t.Errorf("LoginExternal long name: %v %v", err, user)
+	}
+
+	// The identity still finds the user after they changed their address.
+	_, session := register(t, logic, ctx, "Dan", "dan@example.com")
+	ext = model.ExternalUser{
+		Identity:      model.Identity{Issuer: "https://id.example.com", Subject: "9"},
+		Email:         "dan@example.com",
+		EmailVerified: true,
+	}
+	dan, err := logic.LoginExternal(ctx, ext)
+	if err != nil {
+		t.Fatalf("LoginExternal Dan: %v", err)
+	}
+	id := ""
+	err = logic.RequestEmailChange(ctx, session, "hook and needle", "daniel@example.com", func(changeID string) string {
+		id = changeID
+		return changeID
+	})
+	if err != nil {
+		t.Fatalf("RequestEmailChange: %v", err)
+	}
+	_, err = logic.ConfirmEmailChange(ctx, id)
+	if err != nil {
+		t.Fatalf("ConfirmEmailChange: %v", err)
+	}
+	user, err = logic.LoginExternal(ctx, ext)
+	if err != nil || user.ID != dan.ID || user.Email != "daniel@example.com" {
+		t.Errorf("LoginExternal after email change: %v %v", err, user)
+	}
+}
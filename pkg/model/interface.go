@@ -2,6 +2,9 @@
 package model
 
 import "context"
+import "errors"
+import "iter"
+import "log/slog"
 
 // Context is an alias for context.Context.
 type Context = context.Context
@@ -12,22 +15,89 @@ type Repository interface {
 	User() UserMapper
 	// Session returns the session mapper for this repository.
 	Session() SessionMapper
+	// Attempt returns the mapper for failed log in attempts.
+	Attempt() AttemptMapper
+	// Ticket returns the mapper for log ins that wait for their second step.
+	Ticket() TicketMapper
+	// Links returns the mapper for log in links.
+	Links() LinkMapper
+	// LinkRate returns the mapper for the counts of log in links.
+	LinkRate() LinkRateMapper
+	// EmailChange returns the mapper for email changes that wait for
+	// confirmation.
+	EmailChange() EmailChangeMapper
+	// Policy returns the mapper for the security policy.
+	Policy() PolicyMapper
 	// Craft returns the craft mapper for this repository.
 	Craft() CraftMapper
 	// Image returns the image mapper for this repository.
 	Image() UploadMapper
+	// Thumbnail returns the mapper for the thumbnails of the images.
+	Thumbnail() UploadMapper
+	// Avatar returns the upload mapper for the avatars of users.
+	Avatar() UploadMapper
+	// Report returns the report mapper for this repository.
+	Report() ReportMapper
+	// Like returns the like mapper for this repository.
+	Like() LikeMapper
+	// Comment returns the comment mapper for this repository.
+	Comment() CommentMapper
+	// Follow returns the follow mapper for this repository.
+	Follow() FollowMapper
+	// Jobs returns the queue of background jobs.
+	Jobs() JobQueue
+	// Outbox returns the outbox of domain events.
+	Outbox() Outbox
+	// Audits returns the audit log.
+	Audits() AuditLog
 	// Close closes the repository.
 	Close()
 }
 
+// ErrorDecode means a stored value cannot be decoded. Mappers wrap it in
+// the errors they yield for such values.
+var ErrorDecode = errors.New("value cannot be decoded")
+
+// Collect collects the values of the iterator in a slice. Values that
+// cannot be decoded are logged and skipped, so one broken value does not
+// hide the others. It stops at the first other error and returns it.
+func Collect[T any](seq iter.Seq2[T, error]) ([]T, error) {
+	var res []T
+	for obj, err := range seq {
+		if errors.Is(err, ErrorDecode) {
+			slog.Error("Collect", "err", err)
+			continue
+		}
+		if err != nil {
+			return res, err
+		}
+		res = append(res, obj)
+	}
+	return res, nil
+}
+
+// Change is a change of a value of a mapper. If the value was deleted,
+// Deleted is true and Value is the zero value.
+type Change[T any] struct {
+	Key     string
+	Value   T
+	Deleted bool
+}
+
 // BasicMapper is a basic data mapper for one type T.
+// Keys, Watch, Changes and All return iterators that clean up after
+// themselves when the loop over them ends, and yield errors rather than
+// dropping them. Update changes a value without losing the changes that
+// others make to it at the same time.
 type BasicMapper[T any] interface {
 	Get(ctx Context, key string) (T, error)
 	Put(ctx Context, key string, obj T) (T, error)
+	Update(ctx Context, key string, update func(obj *T, found bool) error) (T, error)
 	Purge(ctx Context, key string) error
-	Keys(ctx Context, keys ...string) (chan (string), error)
-	Watch(ctx Context, keys ...string) (chan (T), error)
-	All(ctx Context, keys ...string) (chan (T), error)
+	Keys(ctx Context, keys ...string) iter.Seq2[string, error]
+	Watch(ctx Context, keys ...string) iter.Seq2[T, error]
+	Changes(ctx Context, current bool, keys ...string) iter.Seq2[Change[T], error]
+	All(ctx Context, keys ...string) iter.Seq2[T, error]
 	Delete(ctx Context, key string) error
 	GetFirstMatch(ctx Context, matcher func(t *T) bool) (*T, error)
 }
@@ -42,8 +112,9 @@ type UploadMapper interface {
 	Get(ctx Context, key string) (*Upload, error)
 	Put(ctx Context, up *Upload) (*Upload, error)
 	Delete(ctx Context, key string) error
-	List(ctx Context, userId string) (chan (string), error)
-	Watch(ctx Context) (chan (*Upload), error)
+	List(ctx Context, userId string) iter.Seq2[string, error]
+	Watch(ctx Context) iter.Seq2[*Upload, error]
+	Hide(ctx Context, key string, hidden bool) error
 }
 
 // CraftMapper is a mapper for cafts.
@@ -51,7 +122,8 @@ type CraftMapper interface {
 	// Inherit from BasicMapper
 	BasicMapper[Craft]
 	GetForUserID(ctx Context, key string, UserID string) (Craft, error)
-	AllForUserID(ctx Context, UserID string) (chan Craft, error)
+	AllForUserID(ctx Context, UserID string) iter.Seq2[Craft, error]
+	GetByID(ctx Context, key string) (Craft, error)
 }
 
 // UserMapper is a mapper for users.
@@ -59,6 +131,45 @@ type UserMapper interface {
 	// Inherit from BasicMapper
 	BasicMapper[User]
 	GetByEmail(ctx Context, email string) (*User, error)
+	// GetByIdentity returns the user linked to the account of an OpenID
+	// Connect provider, or nil if there is none.
+	GetByIdentity(ctx Context, id Identity) (*User, error)
+}
+
+// ReportMapper is a data mapper for reports.
+type ReportMapper interface {
+	BasicMapper[Report]
+}
+
+// LikeMapper is a data mapper for likes.
+// Likes are keyed by craftID.userID so counting and listing are cheap.
+type LikeMapper interface {
+	BasicMapper[Like]
+	PutLike(ctx Context, like Like) (Like, error)
+	DeleteLike(ctx Context, craftID, userID string) error
+	Has(ctx Context, craftID, userID string) (bool, error)
+	Count(ctx Context, craftID string) (int, error)
+	AllForUserID(ctx Context, userID string) iter.Seq2[Like, error]
+}
+
+// CommentMapper is a data mapper for comments.
+// Comments are keyed by craftID.commentID.
+type CommentMapper interface {
+	BasicMapper[Comment]
+	PutComment(ctx Context, comment Comment) (Comment, error)
+	GetComment(ctx Context, craftID, commentID string) (Comment, error)
+	DeleteComment(ctx Context, craftID, commentID string) error
+	AllForCraftID(ctx Context, craftID string) iter.Seq2[Comment, error]
+}
+
+// FollowMapper is a data mapper for follows.
+// Follows are keyed by userID.followID.
+type FollowMapper interface {
+	BasicMapper[Follow]
+	PutFollow(ctx Context, follow Follow) (Follow, error)
+	DeleteFollow(ctx Context, userID, followID string) error
+	Has(ctx Context, userID, followID string) (bool, error)
+	AllForUserID(ctx Context, userID string) iter.Seq2[Follow, error]
 }
 
 // Sender can send emails or simulates doing that.
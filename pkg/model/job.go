@@ -0,0 +1,149 @@
+package model
+
+import "encoding/json"
+import "errors"
+import "io"
+import "iter"
+import "log/slog"
+import "time"
+
+// Kinds of background jobs.
+const (
+	// JobMail sends a Mail.
+	JobMail = "mail"
+	// JobRendition renders the thumbnail of an upload.
+	JobRendition = "rendition"
+	// JobReindex rebuilds the search index.
+	JobReindex = "reindex"
+)
+
+const (
+	// thumbnailSize is the width and height of a thumbnail rendition.
+	thumbnailSize = 240
+)
+
+var (
+	// ErrorJobPermanent means a job failed in a way that retrying
+	// does not fix, so it goes to the dead letters right away.
+	ErrorJobPermanent = errors.New("job failed permanently")
+
+	// ErrorJobEnqueue means a job could not be queued.
+	ErrorJobEnqueue = errors.New("job could not be queued")
+)
+
+// Job is a background job. Data is the JSON of the data of the job,
+// which depends on its Kind.
+type Job struct {
+	ID       string          `json:"id"`
+	Kind     string          `json:"kind"`
+	Data     json.RawMessage `json:"data"`
+	Created  time.Time       `json:"created"`
+	Attempts int             `json:"attempts,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// JobHandler handles a job. If it returns an error, the job is tried again
+// later, unless the error is ErrorJobPermanent or the job was tried too
+// often already.
+type JobHandler func(ctx Context, job Job) error
+
+// JobQueue is a durable queue of background jobs.
+type JobQueue interface {
+	// Enqueue queues a job of the kind with data encoded as JSON.
+	Enqueue(ctx Context, kind string, data any) (*Job, error)
+	// Work handles queued jobs with the handler for their kind until
+	// the context is done.
+	Work(ctx Context, handlers map[string]JobHandler) error
+	// Dead returns an iterator over the jobs that failed permanently.
+	Dead(ctx Context) iter.Seq2[Job, error]
+}
+
+// Rendition is the data of a JobRendition.
+type Rendition struct {
+	ID     Reference `json:"id"`
+	UserID string    `json:"user_id"`
+}
+
+// QueueMail queues a mail to be sent by a worker. If that fails, the mail
+// is sent right away in the background.
+func (l *Logic) QueueMail(ctx Context, msg Mail) {
+	_, err := l.Jobs().Enqueue(ctx, JobMail, msg)
+	if err != nil {
+		slog.Error("Jobs.Enqueue mail", "err", err, "to", msg.To)
+		go l.sendMail(msg)
+	}
+}
+
+// QueueRendition queues the rendering of the thumbnail of an upload.
+func (l *Logic) QueueRendition(ctx Context, upload *Upload) {
+	_, err := l.Jobs().Enqueue(ctx, JobRendition, Rendition{ID: upload.ID, UserID: upload.UserID})
+	if err != nil {
+		slog.Error("Jobs.Enqueue rendition", "err", err, "upload", upload.ID)
+	}
+}
+
+// JobHandlers returns the handlers for the jobs of the logic.
+func (l *Logic) JobHandlers() map[string]JobHandler {
+	return map[string]JobHandler{
+		JobMail:      l.handleMail,
+		JobRendition: l.handleRendition,
+	}
+}
+
+func (l *Logic) sendMail(msg Mail) error {
+	if l.Sender == nil {
+		slog.Warn("Mailer not available will not send email.", "subject", msg.Subject)
+		return nil
+	}
+
+	err := l.Sender.Send(msg)
+	if err != nil {
+		slog.Error("While sending mail", "err", err)
+		return err
+	}
+	return nil
+}
+
+func (l *Logic) handleMail(ctx Context, job Job) error {
+	var msg Mail
+	err := json.Unmarshal(job.Data, &msg)
+	if err != nil {
+		return errors.Join(ErrorJobPermanent, err)
+	}
+	return l.sendMail(msg)
+}
+
+func (l *Logic) handleRendition(ctx Context, job Job) error {
+	var rendition Rendition
+	err := json.Unmarshal(job.Data, &rendition)
+	if err != nil {
+		return errors.Join(ErrorJobPermanent, err)
+	}
+
+	original, err := l.Image().Get(ctx, string(rendition.ID))
+	if err != nil {
+		// The upload may have been deleted in the mean time.
+		return errors.Join(ErrorJobPermanent, err)
+	}
+	defer original.ReadCloser.Close()
+
+	resized, err := resizeImageJPEG(original, thumbnailSize, thumbnailSize, 85)
+	if err != nil {
+		return errors.Join(ErrorJobPermanent, err)
+	}
+
+	_, err = l.Thumbnail().Put(ctx, &Upload{
+		ID:         rendition.ID,
+		Title:      original.Title,
+		UserID:     original.UserID,
+		MIME:       "image/jpeg",
+		ReadCloser: io.NopCloser(resized),
+	})
+	if err != nil {
+		return err
+	}
+	if original.Hidden {
+		return l.Thumbnail().Hide(ctx, string(rendition.ID), true)
+	}
+	return nil
+}
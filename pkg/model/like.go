@@ -0,0 +1,111 @@
+package model
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+)
+
+var (
+	// ErrorLikeOwn means a user tried to like their own craft.
+	ErrorLikeOwn = errors.New("you cannot like your own craft")
+
+	// ErrorLike means liking or unliking a craft failed.
+	ErrorLike = errors.New("like failed")
+)
+
+// SetLike sets whether the user of the session likes the craft with the given ID.
+// Liking a craft that is already liked, or unliking a craft that is not liked,
+// does nothing, so this may safely be repeated.
+// It returns the new amount of likes of the craft.
+func (l *Logic) SetLike(ctx Context, session *Session, craftID string, like bool) (int, error) {
+	if session == nil || session.UserID == "" {
+		return 0, ErrorPleaseLogIn
+	}
+
+	craft, err := l.Craft().GetByID(ctx, craftID)
+	if err != nil {
+		slog.Error("Craft.GetByID", "err", err, "craft", craftID)
+		return 0, ErrorCraftNotFound
+	}
+	// Crafts that moderation hid cannot be found, so cannot be liked.
+	if craft.Hidden {
+		return 0, ErrorCraftNotFound
+	}
+
+	if craft.UserID == session.UserID {
+		return 0, ErrorLikeOwn
+	}
+
+	has, err := l.Like().Has(ctx, craft.ID, session.UserID)
+	if err != nil {
+		slog.Error("Like.Has", "err", err, "craft", craftID)
+		return 0, ErrorLike
+	}
+
+	if like && !has {
+		_, err = l.Like().PutLike(ctx, Like{
+			CraftID: craft.ID,
+			OwnerID: craft.UserID,
+			UserID:  session.UserID,
+			Created: time.Now(),
+		})
+	} else if !like && has {
+		err = l.Like().DeleteLike(ctx, craft.ID, session.UserID)
+	}
+	if err != nil {
+		slog.Error("Like", "err", err, "craft", craftID, "like", like)
+		return 0, ErrorLike
+	}
+
+	return l.Likes(ctx, craft.ID), nil
+}
+
+// Likes returns the amount of likes of the craft, or 0 on error.
+func (l *Logic) Likes(ctx Context, craftID string) int {
+	count, err := l.Like().Count(ctx, craftID)
+	if err != nil {
+		slog.Error("Like.Count", "err", err, "craft", craftID)
+		return 0
+	}
+	return count
+}
+
+// Liked returns true if the user of the session likes the craft.
+func (l *Logic) Liked(ctx Context, session *Session, craftID string) bool {
+	if session == nil || session.UserID == "" {
+		return false
+	}
+	has, err := l.Like().Has(ctx, craftID, session.UserID)
+	if err != nil {
+		slog.Error("Like.Has", "err", err, "craft", craftID)
+		return false
+	}
+	return has
+}
+
+// Favourites returns the crafts that the user of the session likes.
+// Crafts that were hidden by staff are left out.
+func (l *Logic) Favourites(ctx Context, session *Session) ([]Craft, error) {
+	if session == nil || session.UserID == "" {
+		return nil, ErrorPleaseLogIn
+	}
+
+	var res []Craft
+	for like, err := range l.Like().AllForUserID(ctx, session.UserID) {
+		if err != nil {
+			slog.Error("Like.AllForUserID", "err", err)
+			return nil, err
+		}
+		craft, err := l.Craft().GetForUserID(ctx, like.CraftID, like.OwnerID)
+		if err != nil {
+			slog.Warn("Craft.GetForUserID", "err", err, "craft", like.CraftID)
+			continue
+		}
+		if craft.Hidden {
+			continue
+		}
+		res = append(res, craft)
+	}
+	return res, nil
+}
@@ -0,0 +1,66 @@
+package model_test
+
+import "testing"
+
+import "github.com/oklog/ulid/v2"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+func TestSetLike(t *testing.T) {
+	logic, ctx := newLogic(t)
+	alice, aliceSession := register(t, logic, ctx, "Alice", "alice@example.com")
+	_, bob := register(t, logic, ctx, "Bob", "bob@example.com")
+	_, carol := register(t, logic, ctx, "Carol", "carol@example.com")
+
+	craft := model.Craft{ID: ulid.Make().String(), UserID: alice.ID, Title: "Scarf"}
+	hidden := model.Craft{ID: ulid.Make().String(), UserID: alice.ID, Title: "Hat", Hidden: true}
+	for _, c := range []model.Craft{craft, hidden} {
+		_, err := logic.Craft().Put(ctx, c.ID, c)
+		if err != nil {
+			t.Fatalf("Put: %s", err)
+		}
+	}
+
+	_, err := logic.SetLike(ctx, nil, craft.ID, true)
+	if err != model.ErrorPleaseLogIn {
+		t.Errorf("SetLike without session: %v", err)
+	}
+	_, err = logic.SetLike(ctx, aliceSession, craft.ID, true)
+	if err != model.ErrorLikeOwn {
+		t.Errorf("SetLike own craft: %v", err)
+	}
+	_, err = logic.SetLike(ctx, bob, hidden.ID, true)
+	if err != model.ErrorCraftNotFound {
+		t.Errorf("SetLike hidden craft: %v", err)
+	}
+	_, err = logic.SetLike(ctx, bob, ulid.Make().String(), true)
+	if err != model.ErrorCraftNotFound {
+		t.Errorf("SetLike unknown craft: %v", err)
+	}
+
+	// Liking and unliking again changes nothing.
+	tests := []struct {
+		session *model.Session
+		like    bool
+		count   int
+	}{
+		{bob, true, 1},
+		{bob, true, 1},
+		{carol, true, 2},
+		{bob, false, 1},
+		{bob, false, 1},
+		{carol, false, 0},
+	}
+	for i, test := range tests {
+		count, err := logic.SetLike(ctx, test.session, craft.ID, test.like)
+		if err != nil || count != test.count {
+			t.Errorf("SetLike %d: %v %d, want %d", i, err, count, test.count)
+		}
+		if logic.Likes(ctx, craft.ID) != test.count {
+			t.Errorf("Likes %d: %d, want %d", i, logic.Likes(ctx, craft.ID), test.count)
+		}
+		if logic.Liked(ctx, test.session, craft.ID) != test.like {
+			t.Errorf("Liked %d: %t", i, !test.like)
+		}
+	}
+}
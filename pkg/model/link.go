@@ -0,0 +1,154 @@
+package model
+
+import "errors"
+import "log/slog"
+import "net/mail"
+import "time"
+
+import "github.com/oklog/ulid/v2"
+
+const (
+	// LoginLinkTTL is how long a log in link can be used.
+	LoginLinkTTL = 15 * time.Minute
+
+	// LoginLinkWindow is the window in which at most LoginLinkMax log in
+	// links are sent to an email address.
+	LoginLinkWindow = time.Hour
+
+	// LoginLinkMax is the maximum amount of log in links that are sent to
+	// an email address in LoginLinkWindow.
+	LoginLinkMax = 3
+)
+
+var (
+	// ErrorLoginLinkThrottled means too many log in links were requested
+	// for the email address recently.
+	ErrorLoginLinkThrottled = errors.New("too many log in links were sent to this address, please wait a while or use your password")
+
+	// ErrorLoginLink means the log in link is not valid, expired or was
+	// already used.
+	ErrorLoginLink = errors.New("this log in link expired or was already used, please request a new one")
+)
+
+// LoginLink is a log in link that was mailed to a user. Its ID is in the
+// token of the link, and it can be used once.
+type LoginLink struct {
+	ID      string    `json:"id"`
+	UserID  string    `json:"user_id"`
+	Expires time.Time `json:"expires"`
+}
+
+// LinkMapper is a data mapper for log in links. Its entries expire after
+// LoginLinkWindow.
+type LinkMapper interface {
+	BasicMapper[LoginLink]
+	// Take gets the link and deletes it, so it can only be taken once,
+	// even by requests at the same time.
+	Take(ctx Context, id string) (LoginLink, error)
+}
+
+// LinkRate counts the log in links sent to an email address.
+type LinkRate struct {
+	Count int       `json:"count"`
+	Since time.Time `json:"since"`
+}
+
+// LinkRateMapper is a data mapper for the counts of log in links per
+// email address. Its entries expire after LoginLinkWindow.
+type LinkRateMapper interface {
+	BasicMapper[LinkRate]
+}
+
+// loginLinkMail returns the mail with a log in link.
+func loginLinkMail(user User, link string) Mail {
+	msg := Mail{}
+	msg.To = user.Name + "<" + user.Email + ">"
+	msg.Subject = "Your Qrochet log in link"
+
+	msg.Printf("Dear %s,\n\n", user.Name)
+	msg.Println("Open this link to log in to Qrochet:")
+	msg.Println()
+	msg.Println(link)
+	msg.Println()
+	msg.Printf("The link works once, for %d minutes.\n", int(LoginLinkTTL.Minutes()))
+	msg.Println("If you did not ask for it, you can ignore this mail.")
+	msg.Println()
+	msg.Println("Kind regards, Qrochet.")
+	return msg
+}
+
+// countLoginLink counts a log in link for the email address, and returns
+// an error if too many were sent recently.
+func (l *Logic) countLoginLink(ctx Context, email string) error {
+	_, err := l.LinkRate().Update(ctx, attemptKey(email), func(rate *LinkRate, found bool) error {
+		now := time.Now()
+		if now.Sub(rate.Since) >= LoginLinkWindow {
+			*rate = LinkRate{Since: now}
+		}
+		if rate.Count >= LoginLinkMax {
+			return ErrorLoginLinkThrottled
+		}
+		rate.Count++
+		return nil
+	})
+	if errors.Is(err, ErrorLoginLinkThrottled) {
+		return err
+	}
+	if err != nil {
+		slog.Error("LinkRate.Update", "err", err)
+	}
+	return nil
+}
+
+// RequestLoginLink mails a log in link to the user with the email address.
+// The link function returns the URL of the link with a token for the ID of
+// the link. To not give away which addresses are registered, there is no
+// error if the address is not registered, but such requests are also
+// rate limited per address.
+func (l *Logic) RequestLoginLink(ctx Context, email string, link func(id string) string) error {
+	_, err := mail.ParseAddress(email)
+	if err != nil {
+		return ErrorEmailNotValid
+	}
+	err = l.countLoginLink(ctx, email)
+	if err != nil {
+		l.Audit(ctx, AuditLoginLink, "", "throttled: "+RedactEmail(email))
+		return err
+	}
+
+	user, err := l.User().GetByEmail(ctx, email)
+	if err != nil || user == nil || user.Email != email {
+		l.Audit(ctx, AuditLoginLink, "", "not registered: "+RedactEmail(email))
+		return nil
+	}
+
+	entry := LoginLink{ID: ulid.Make().String(), UserID: user.ID, Expires: time.Now().Add(LoginLinkTTL)}
+	_, err = l.Links().Put(ctx, entry.ID, entry)
+	if err != nil {
+		slog.Error("Links.Put", "err", err)
+		return ErrorSessionNotCreated
+	}
+	l.QueueMail(ctx, loginLinkMail(*user, link(entry.ID)))
+	l.Audit(ctx, AuditLoginLink, user.ID, "sent")
+	return nil
+}
+
+// UseLoginLink uses the log in link with the ID, and returns its user,
+// for whom the caller then creates a session. If the user has two factor
+// authentication enabled, it returns a *SecondFactorError like Login.
+func (l *Logic) UseLoginLink(ctx Context, id string) (*User, error) {
+	link, err := l.Links().Take(ctx, id)
+	if err != nil || time.Now().After(link.Expires) {
+		return nil, ErrorLoginLink
+	}
+	user, err := l.User().Get(ctx, link.UserID)
+	if err != nil {
+		return nil, ErrorLoginLink
+	}
+	if user.HasTOTP() {
+		return &user, l.secondFactor(ctx, user)
+	}
+	l.resetAttempts(ctx, user.Email)
+	l.Audit(ctx, AuditLogin, user.ID, "log in link")
+	return &user, nil
+}
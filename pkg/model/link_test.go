@@ -0,0 +1,39 @@
+package model_test
+
+import "testing"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+func TestLoginLink(t *testing.T) {
+	logic, ctx := newLogic(t)
+	register(t, logic, ctx, "Bob", "bob@example.com")
+
+	id := ""
+	link := func(linkID string) string {
+		id = linkID
+		return "http://localhost/login/link?token=" + linkID
+	}
+	err := logic.RequestLoginLink(ctx, "bob@example.com", link)
+	if err != nil || id == "" {
+		t.Fatalf("RequestLoginLink: %v %q", err, id)
+	}
+	user, err := logic.UseLoginLink(ctx, id)
+	if err != nil || user.Email != "bob@example.com" {
+		t.Fatalf("UseLoginLink: %v %v", err, user)
+	}
+	_, err = logic.UseLoginLink(ctx, id)
+	if err != model.ErrorLoginLink {
+		t.Errorf("UseLoginLink again: %v", err)
+	}
+
+	for range model.LoginLinkMax - 1 {
+		err = logic.RequestLoginLink(ctx, "bob@example.com", link)
+		if err != nil {
+			t.Fatalf("RequestLoginLink: %v", err)
+		}
+	}
+	err = logic.RequestLoginLink(ctx, "bob@example.com", link)
+	if err != model.ErrorLoginLinkThrottled {
+		t.Errorf("RequestLoginLink while throttled: %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+package model
+
+import "crypto/sha256"
+import "encoding/hex"
+import "errors"
+import "log/slog"
+import "strings"
+import "time"
+
+const (
+	// LoginFreeAttempts is the amount of failed log in attempts for an
+	// email address before further attempts are throttled.
+	LoginFreeAttempts = 3
+
+	// LoginLockoutAttempts is the amount of failed log in attempts after
+	// which the account is locked.
+	LoginLockoutAttempts = 10
+
+	// LoginBackoff is the delay after the first throttled attempt. It
+	// doubles for every failed attempt after that, up to LoginMaxBackoff.
+	LoginBackoff = 2 * time.Second
+
+	// LoginMaxBackoff is the longest delay between throttled attempts.
+	LoginMaxBackoff = 5 * time.Minute
+
+	// LoginLockout is how long an account stays locked.
+	LoginLockout = time.Hour
+
+	// LoginAttemptTTL is how long failed attempts are remembered.
+	LoginAttemptTTL = 24 * time.Hour
+)
+
+var (
+	// ErrorLoginThrottled means there were too many failed log in attempts
+	// for the email address recently.
+	ErrorLoginThrottled = errors.New("too many failed log in attempts, please wait a moment and try again")
+
+	// ErrorAccountLocked means the account is locked after too many failed
+	// log in attempts.
+	ErrorAccountLocked = errors.New("too many failed log in attempts, this account is locked for a while")
+)
+
+// LoginAttempt counts the failed log in attempts for an email address.
+type LoginAttempt struct {
+	Failures int       `json:"failures"`
+	Last     time.Time `json:"last"`
+	Next     time.Time `json:"next"`   // Next is when the next attempt is allowed.
+	Locked   time.Time `json:"locked"` // Locked is when the lockout ends.
+}
+
+// AttemptMapper is a data mapper for log in attempts. Its entries expire
+// after LoginAttemptTTL.
+type AttemptMapper interface {
+	BasicMapper[LoginAttempt]
+}
+
+// attemptKey returns the key of the log in attempts for an email address.
+// It is a hash, so the addresses are not stored, and so that any address
+// is a valid key.
+func attemptKey(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return hex.EncodeToString(sum[:])
+}
+
+// loginBackoff returns the delay before the next attempt after failures.
+func loginBackoff(failures int) time.Duration {
+	if failures < LoginFreeAttempts {
+		return 0
+	}
+	delay := LoginBackoff
+	for i := LoginFreeAttempts; i < failures && delay < LoginMaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, LoginMaxBackoff)
+}
+
+// checkAttempts returns an error if log in for the email address is
+// throttled or locked. Otherwise it counts the attempt as failed until
+// resetAttempts forgets it after a log in, so that attempts at the same
+// time cannot all pass before one of them failed. The count is updated
+// with compare and swap, so attempts at the same time are all counted.
+func (l *Logic) checkAttempts(ctx Context, email string) error {
+	_, err := l.Attempt().Update(ctx, attemptKey(email), func(attempt *LoginAttempt, found bool) error {
+		now := time.Now()
+		if now.Before(attempt.Locked) {
+			return ErrorAccountLocked
+		}
+		if !attempt.Locked.IsZero() {
+			// Start counting again after a lockout ended.
+			*attempt = LoginAttempt{}
+		}
+		if now.Before(attempt.Next) {
+			return ErrorLoginThrottled
+		}
+		attempt.Failures++
+		attempt.Last = now
+		attempt.Next = now.Add(loginBackoff(attempt.Failures))
+		return nil
+	})
+	if errors.Is(err, ErrorAccountLocked) || errors.Is(err, ErrorLoginThrottled) {
+		return err
+	}
+	if err != nil {
+		// The attempt could not be counted, so do not allow it.
+		slog.Error("Attempt.Update", "err", err)
+		return ErrorLoginThrottled
+	}
+	return nil
+}
+
+// errNotLocked stops failAttempt from updating attempts that do not lock
+// the account.
+var errNotLocked = errors.New("not locked")
+
+// failAttempt locks the account if there were too many failed log in
+// attempts for the email address, including the one checkAttempts counted
+// just before. The user is nil if the address is not registered.
+func (l *Logic) failAttempt(ctx Context, email string, user *User) {
+	attempt, err := l.Attempt().Update(ctx, attemptKey(email), func(attempt *LoginAttempt, found bool) error {
+		if attempt.Failures < LoginLockoutAttempts || !attempt.Locked.IsZero() {
+			return errNotLocked
+		}
+		attempt.Locked = time.Now().Add(LoginLockout)
+		return nil
+	})
+	if errors.Is(err, errNotLocked) {
+		return
+	}
+	if err != nil {
+		slog.Error("Attempt.Update", "err", err)
+		return
+	}
+
+	userID := ""
+	if user != nil {
+		userID = user.ID
+		l.QueueMail(ctx, lockoutMail(*user, attempt))
+	}
+	l.Audit(ctx, AuditLockout, userID, "locked until "+attempt.Locked.Format(time.RFC3339)+": "+RedactEmail(email))
+}
+
+// resetAttempts forgets the failed log in attempts after a log in that
+// is complete, including its second factor if the user has one.
+func (l *Logic) resetAttempts(ctx Context, email string) {
+	_ = l.Attempt().Delete(ctx, attemptKey(email))
+}
+
+// lockoutMail returns the mail that tells a user their account is locked.
+func lockoutMail(user User, attempt LoginAttempt) Mail {
+	msg := Mail{}
+	msg.To = user.Name + "<" + user.Email + ">"
+	msg.Subject = "Your Qrochet account was locked"
+
+	msg.Printf("Dear %s,\n\n", user.Name)
+	msg.Printf("There were %d failed attempts to log in to your account on Qrochet,\n", attempt.Failures)
+	msg.Printf("so we locked it until %s.\n\n", attempt.Locked.Format(time.RFC1123))
+	msg.Println("If this was not you, someone may be trying to guess your password.")
+	msg.Println("Your password was not changed. You can log in again after the lock ends.")
+	msg.Println()
+	msg.Println("Kind regards, Qrochet.")
+	return msg
+}
@@ -0,0 +1,163 @@
+package model_test
+
+import "errors"
+import "sync"
+import "sync/atomic"
+import "testing"
+import "time"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+func TestLoginAttemptsConcurrent(t *testing.T) {
+	logic, ctx := newLogic(t)
+	register(t, logic, ctx, "Alice", "alice@example.com")
+
+	// Attempts at the same time are counted before the password is
+	// checked, so no more than the free attempts get to check it.
+	var wg sync.WaitGroup
+	var checked, throttled atomic.Int32
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := logic.Login(ctx, "alice@example.com", "wrong password", time.Hour)
+			switch {
+			case errors.Is(err, model.ErrorEmailNotRegistered):
+				checked.Add(1)
+			case errors.Is(err, model.ErrorLoginThrottled):
+				throttled.Add(1)
+			default:
+				t.Errorf("Login: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if checked.Load() == 0 || checked.Load() > model.LoginFreeAttempts {
+		t.Errorf("%d attempts checked the password, %d throttled", checked.Load(), throttled.Load())
+	}
+
+	_, _, err := logic.Login(ctx, "alice@example.com", "hook and needle", time.Hour)
+	if !errors.Is(err, model.ErrorLoginThrottled) {
+		t.Errorf("Login after concurrent attempts: %v", err)
+	}
+}
+
+func TestLoginSecondFactorLockout(t *testing.T) {
+	logic, ctx := newLogic(t)
+	_, session := register(t, logic, ctx, "Carol", "carol@example.com")
+	secret, _, err := logic.EnrollTOTP(ctx, session)
+	if err != nil {
+		t.Fatalf("EnrollTOTP: %s", err)
+	}
+	code, _ := model.TOTPCode(secret, model.TOTPStep(time.Now()))
+	_, err = logic.ConfirmTOTP(ctx, session, code)
+	if err != nil {
+		t.Fatalf("ConfirmTOTP: %s", err)
+	}
+
+	// skipBackoff pretends the backoff after the failed attempts passed.
+	skipBackoff := func() {
+		for key, err := range logic.Attempt().Keys(ctx) {
+			if err != nil {
+				t.Fatalf("Keys: %s", err)
+			}
+			attempt, _ := logic.Attempt().Get(ctx, key)
+			attempt.Next = time.Time{}
+			logic.Attempt().Put(ctx, key, attempt)
+		}
+	}
+
+	// The right password does not forget the wrong codes before it, so
+	// logging in again and again to guess codes ends in a lockout.
+	for cycle := 0; ; cycle++ {
+		if cycle > model.LoginLockoutAttempts {
+			t.Fatalf("no lockout after %d cycles", cycle)
+		}
+		skipBackoff()
+		_, _, err = logic.Login(ctx, "carol@example.com", "hook and needle", time.Hour)
+		if errors.Is(err, model.ErrorAccountLocked) {
+			break
+		}
+		var second *model.SecondFactorError
+		if !errors.As(err, &second) {
+			t.Fatalf("Login: %v", err)
+		}
+		for range model.LoginTicketTries {
+			skipBackoff()
+			_, _, err = logic.LoginSecondFactor(ctx, second.Ticket, "wrong", time.Hour)
+			if !errors.Is(err, model.ErrorSecondFactorWrong) && !errors.Is(err, model.ErrorAccountLocked) {
+				t.Fatalf("LoginSecondFactor: %v", err)
+			}
+		}
+	}
+
+	_, _, err = logic.Login(ctx, "carol@example.com", "hook and needle", time.Hour)
+	if !errors.Is(err, model.ErrorAccountLocked) {
+		t.Errorf("Login while locked: %v", err)
+	}
+}
+
+func TestLoginSecondFactorConcurrent(t *testing.T) {
+	logic, ctx := newLogic(t)
+	_, session := register(t, logic, ctx, "Dave", "dave@example.com")
+	secret, _, err := logic.EnrollTOTP(ctx, session)
+	if err != nil {
+		t.Fatalf("EnrollTOTP: %s", err)
+	}
+	code, _ := model.TOTPCode(secret, model.TOTPStep(time.Now()))
+	recovery, err := logic.ConfirmTOTP(ctx, session, code)
+	if err != nil {
+		t.Fatalf("ConfirmTOTP: %s", err)
+	}
+
+	// clearAttempts forgets the log ins that did not finish yet, so that
+	// starting several does not throttle them.
+	clearAttempts := func() {
+		for key, err := range logic.Attempt().Keys(ctx) {
+			if err != nil {
+				t.Fatalf("Keys: %s", err)
+			}
+			logic.Attempt().Delete(ctx, key)
+		}
+	}
+
+	var tickets []string
+	for range 4 {
+		clearAttempts()
+		_, _, err = logic.Login(ctx, "dave@example.com", "hook and needle", time.Hour)
+		var second *model.SecondFactorError
+		if !errors.As(err, &second) {
+			t.Fatalf("Login: %v", err)
+		}
+		tickets = append(tickets, second.Ticket)
+	}
+	clearAttempts()
+
+	// The recovery code is checked and used up in one compare and swap,
+	// so only one of the log ins at the same time gets a session with it.
+	var wg sync.WaitGroup
+	var sessions atomic.Int32
+	for _, ticket := range tickets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := logic.LoginSecondFactor(ctx, ticket, recovery[0], time.Hour)
+			switch {
+			case err == nil:
+				sessions.Add(1)
+			case errors.Is(err, model.ErrorSecondFactorWrong), errors.Is(err, model.ErrorLoginThrottled):
+			default:
+				t.Errorf("LoginSecondFactor: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if sessions.Load() != 1 {
+		t.Errorf("%d sessions with one recovery code, want 1", sessions.Load())
+	}
+
+	user, err := logic.User().GetByEmail(ctx, "dave@example.com")
+	if err != nil || len(user.Recovery) != model.RecoveryCodes-1 {
+		t.Errorf("recovery codes left: %v %d", err, len(user.Recovery))
+	}
+}
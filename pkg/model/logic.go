@@ -71,6 +71,9 @@ var (
 
 	// ErrorCraftCreate means creating a craft failed.
 	ErrorCraftCreate = errors.New("craft create failed")
+
+	// ErrorTitleNotAllowed means the title contains censored words.
+	ErrorTitleNotAllowed = errors.New("please choose a friendlier title")
 )
 
 // Logic implements the model core business logic using abstracted interfaces.
@@ -100,39 +103,70 @@ func (l *Logic) NewSession(ctx Context, user User, sessionTimeout time.Duration)
 	// Delete old session ignoring any errors in case it didn't exist.
 	_ = l.Session().Delete(ctx, user.ID)
 
-	session, err = l.Session().Put(ctx, user.ID, session)
+	started := SessionStarted{UserID: user.ID, Start: session.Start, End: session.End}
+	err = l.Record(ctx, EventSessionStarted, user.ID, started, func() (err error) {
+		session, err = l.Session().Put(ctx, user.ID, session)
+		return err
+	})
 	if err != nil {
-		slog.Error("Cannot save session", "err", err, "user", user.ID, "email", user.Email)
+		slog.Error("Cannot save session", "err", err, "user", user.ID, "email", RedactEmail(user.Email))
 		return nil, ErrorSessionNotCreated
 	}
 	return &session, nil
 }
 
 // Login logs in a user by email and password and returns the user and session.
+// Failed attempts are counted per email address. After LoginFreeAttempts
+// failures further attempts are throttled with an exponential backoff, and
+// after LoginLockoutAttempts failures the account is locked for a while and
+// the user gets a mail about it.
+//
+// If the user has two factor authentication enabled, Login returns a
+// *SecondFactorError instead of a session, and the log in must be
+// completed with LoginSecondFactor.
 func (l *Logic) Login(ctx Context, email, password string, sessionTimeout time.Duration) (*User, *Session, error) {
 	_, err := mail.ParseAddress(email)
 	if err != nil {
-		slog.Error("mail.ParseAddress", "err", err, "email", email)
+		slog.Error("mail.ParseAddress", "err", err, "email", RedactEmail(email))
 		return nil, nil, ErrorEmailNotValid
 	}
 
+	err = l.checkAttempts(ctx, email)
+	if err != nil {
+		slog.Warn("Login throttled", "err", err, "email", RedactEmail(email))
+		l.Audit(ctx, AuditLoginFailed, "", "throttled: "+RedactEmail(email))
+		return nil, nil, err
+	}
+
 	existing, err := l.User().GetByEmail(ctx, email)
 	if err != nil || existing == nil || existing.Email != email {
-		slog.Error("User.GetForEmail", "err", err, "email", email)
+		slog.Error("User.GetForEmail", "err", err, "email", RedactEmail(email))
+		l.Audit(ctx, AuditLoginFailed, "", "not registered: "+RedactEmail(email))
+		l.failAttempt(ctx, email, nil)
 		return nil, nil, ErrorEmailNotRegistered
 	}
 
 	err = existing.CheckPassword(password)
 	if err != nil {
-		slog.Error("User.CheckPassword", "err", err, "email", email)
+		slog.Error("User.CheckPassword", "err", err, "email", RedactEmail(email))
+		l.Audit(ctx, AuditLoginFailed, existing.ID, "wrong password")
+		l.failAttempt(ctx, email, existing)
 		return nil, nil, ErrorEmailNotRegistered
 	}
+	l.rehash(ctx, existing, password)
+	if existing.HasTOTP() {
+		// The attempt stays counted until the second factor is right,
+		// so that codes cannot be guessed by logging in again.
+		return existing, nil, l.secondFactor(ctx, *existing)
+	}
+	l.resetAttempts(ctx, email)
 
 	session, err := l.NewSession(ctx, *existing, sessionTimeout)
 	if err != nil {
-		slog.Error("Logic.NewSession", "err", err, "email", email)
+		slog.Error("Logic.NewSession", "err", err, "email", RedactEmail(email))
 		return nil, nil, ErrorSessionNotCreated
 	}
+	l.Audit(ctx, AuditLogin, existing.ID, "")
 	return existing, session, nil
 }
 
@@ -151,16 +185,13 @@ func (l *Logic) Logout(ctx Context, session *Session) error {
 		slog.Error("could not delete session", "err", err)
 		return ErrorDeleteSession
 	}
+	l.Audit(ctx, AuditLogout, session.UserID, "")
 	session.UserID = ""
 	return nil
 }
 
-func (l *Logic) sendRegistrationMail(user User) error {
-	if l.Sender == nil {
-		slog.Warn("Mailer not available will not send registration email.")
-		return nil
-	}
-
+// registrationMail returns the mail that welcomes a new user.
+func registrationMail(user User) Mail {
 	msg := Mail{}
 	msg.To = user.Name + "<" + user.Email + ">"
 
@@ -168,13 +199,12 @@ func (l *Logic) sendRegistrationMail(user User) error {
 	msg.Println("Thank you for registering with Qrochet, the website for chroochet and hand crafts.")
 	msg.Println("This is a message to confirm your registration. You do not have to reply to it.")
 	msg.Println("Kind regards, Qrochet.")
+	return msg
+}
 
-	err := l.Sender.Send(msg)
-	if err != nil {
-		slog.Error("While sending mail", "err", err)
-		return err
-	}
-	return nil
+// QueueRegistrationMail queues the mail that welcomes a new user.
+func (l *Logic) QueueRegistrationMail(ctx Context, user User) {
+	l.QueueMail(ctx, registrationMail(user))
 }
 
 // Register registers a new user of Qrochet, sending an email if possible.
@@ -186,7 +216,7 @@ func (l *Logic) Register(ctx Context, name, email, password string) (*User, erro
 
 	_, err = mail.ParseAddress(email)
 	if err != nil {
-		slog.Error("mail.ParseAddress", "err", err, "email", email)
+		slog.Error("mail.ParseAddress", "err", err, "email", RedactEmail(email))
 		return nil, ErrorEmailNotValid
 	}
 
@@ -194,7 +224,10 @@ func (l *Logic) Register(ctx Context, name, email, password string) (*User, erro
 	user.ID = ulid.Make().String()
 	user.Email = email
 	user.Name = name
-	user.SetPassword(password)
+	err = user.SetPassword(password)
+	if err != nil {
+		return nil, err
+	}
 
 	existing, err := l.User().GetByEmail(ctx, user.Email)
 	if err != nil {
@@ -207,15 +240,20 @@ func (l *Logic) Register(ctx Context, name, email, password string) (*User, erro
 		return nil, ErrorEmailRegistered
 	}
 
-	created, err := l.User().Put(ctx, user.ID, user)
+	var created User
+	err = l.Record(ctx, EventUserRegistered, user.ID, UserRegistered{UserID: user.ID, Name: user.Name}, func() (err error) {
+		created, err = l.User().Put(ctx, user.ID, user)
+		return err
+	})
 	if err != nil {
 		slog.Error("User.Put", "err", err)
 		return nil, ErrorRegistrationFailed
 	}
+	l.Audit(ctx, AuditRegister, created.ID, "")
 
-	// Send mail but don't care if it worked or not.
+	// The mail is sent by a worker, registration does not wait for it.
 	// Maybe later if we have private messages then send one there.
-	go l.sendRegistrationMail(created)
+	l.QueueRegistrationMail(ctx, created)
 	return &created, nil
 }
 
@@ -251,38 +289,20 @@ func resizeImageJPEG(input io.Reader, width, height, quality int) (*bytes.Buffer
 
 // NewCraftForSession creates a new craft for the user that this session belongs to.
 func (l *Logic) NewCraftForSession(ctx Context, name, description string, file multipart.File, header *multipart.FileHeader, session *Session) (*Craft, error) {
-	var err error
-
 	if session == nil || session.UserID == "" {
 		return nil, ErrorPleaseLogIn
 	}
 
 	slog.Info("NewCraftForSession")
 
-	name = censor.Replace(name)
-	description = censor.Replace(description)
-
-	if header.Size > maxImageSize {
-		slog.Error("createCraft Size > maxImageSize", "err", err)
-		return nil, ErrorImageTooLarge
-	}
-
-	resized, err := resizeImageJPEG(file, 640, 640, 90)
-	if err != nil {
-		slog.Error("Image resizing failed", "err", err)
-		return nil, ErrorImageResize
-	}
-
-	upload := &Upload{
-		ID:         Reference(ulid.Make().String() + ".jpeg"),
-		UserID:     session.UserID,
-		ReadCloser: io.NopCloser(resized),
+	if censor.Check(name) != nil {
+		return nil, ErrorTitleNotAllowed
 	}
+	description = censor.Replace(description)
 
-	upload, err = l.Image().Put(ctx, upload)
+	upload, err := l.NewUpload(ctx, session, name, file, header.Size)
 	if err != nil {
-		slog.Error("Image upload failed", "err", err)
-		return nil, ErrorImageUpload
+		return nil, err
 	}
 
 	craft := &Craft{}
@@ -291,7 +311,11 @@ func (l *Logic) NewCraftForSession(ctx Context, name, description string, file m
 	craft.Detail = description
 	craft.Image = upload.ID
 	craft.UserID = session.UserID
-	created, err := l.Craft().Put(ctx, craft.ID, *craft)
+	var created Craft
+	err = l.Record(ctx, EventCraftCreated, craft.UserID, CraftCreated{Craft: *craft}, func() (err error) {
+		created, err = l.Craft().Put(ctx, craft.ID, *craft)
+		return err
+	})
 	if err != nil {
 		slog.Error("Craft.Put", "err", err)
 		// XXX should probably delete the uploaded image if the craft
@@ -303,11 +327,11 @@ func (l *Logic) NewCraftForSession(ctx Context, name, description string, file m
 }
 
 // CraftsForSession returns the crafts for the user that this session belongs to.
-func (l *Logic) CraftsForSession(ctx Context, session *Session) (chan Craft, error) {
+func (l *Logic) CraftsForSession(ctx Context, session *Session) ([]Craft, error) {
 	if session == nil || session.UserID == "" {
 		return nil, ErrorPleaseLogIn
 	}
-	return l.Craft().AllForUserID(ctx, session.UserID)
+	return Collect(l.Craft().AllForUserID(ctx, session.UserID))
 }
 
 // GetImage returns an uploaded image for the session and ID
@@ -3,11 +3,12 @@ package model
 import "fmt"
 import "io"
 import "time"
+import "sort"
 import "encoding"
 import "errors"
 import "encoding/base32"
 import "log/slog"
-import "golang.org/x/crypto/bcrypt"
+import "net/http"
 
 // Role is the role of a user. It also determines privileges.
 type Role int
@@ -90,6 +91,20 @@ type User struct {
 	Theme    Theme    `json:"theme"`
 	CraftIDs []string `json:"craft_ids"`
 	Hash     string   `json:"hash"` // password hash
+
+	// Two factor authentication: the TOTP secret, the secret that is
+	// being set up, the last time step whose code was used, and the
+	// bcrypt hashes of the unused recovery codes.
+	TOTP        string   `json:"totp,omitempty"`
+	TOTPPending string   `json:"totp_pending,omitempty"`
+	TOTPStep    int64    `json:"totp_step,omitempty"`
+	Recovery    []string `json:"recovery,omitempty"`
+
+	// Identities are the accounts of the user at OpenID Connect providers.
+	Identities []Identity `json:"identities,omitempty"`
+
+	// Avatar is the ID of the avatar image of the user.
+	Avatar Reference `json:"avatar,omitempty"`
 }
 
 var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
@@ -106,27 +121,29 @@ func KeyToID(key string) ([]byte, error) {
 	return b32.DecodeString(key)
 }
 
-func (u User) CheckPassword(pass string) error {
-	return bcrypt.CompareHashAndPassword([]byte(u.Hash), []byte(pass))
-}
-
-func (u *User) SetPassword(pass string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
-	if err != nil {
-		return err
-	}
-	u.Hash = string(hash)
-	return nil
-}
-
 func (u User) Redact() User {
 	u.Hash = "*REDACTED*"
+	u.TOTP = ""
+	u.TOTPPending = ""
+	u.TOTPStep = 0
+	u.Recovery = nil
+	u.Identities = nil
 	return u
 }
 
+// HasTOTP returns whether the user has two factor authentication enabled.
+func (u User) HasTOTP() bool {
+	return u.TOTP != ""
+}
+
 // Implement LogValuer on user for privacy and security.
 func (u User) LogValue() slog.Value {
-	return slog.AnyValue(u.Redact)
+	return slog.GroupValue(
+		slog.String("id", u.ID),
+		slog.String("name", u.Name),
+		slog.String("email", RedactEmail(u.Email)),
+		slog.String("role", u.Role.String()),
+	)
 }
 
 // Reference is a reference to an Referenceed file.
@@ -141,6 +158,7 @@ type Craft struct {
 	Image   Reference `json:"image"`
 	Pattern Reference `json:"pattern"`
 	Tags    []string  `json:"tags"`
+	Hidden  bool      `json:"hidden"` // Hidden by staff after a report.
 }
 
 // Login in a log in request
@@ -159,17 +177,89 @@ func (e Error) Error() string {
 	return fmt.Sprintf("error %d: %s", e.Code, e.Message)
 }
 
-// Accept is a response to accept a login.
+// ErrorStatus maps the errors of the model to HTTP status codes.
+// Errors that are not in here are internal server errors.
+var ErrorStatus = map[error]int{
+	ErrorPleaseLogIn:        http.StatusUnauthorized,
+	ErrorEmailNotRegistered: http.StatusUnauthorized,
+	ErrorAlreadyLoggedOut:   http.StatusUnauthorized,
+	ErrorNotOwner:           http.StatusForbidden,
+	ErrorNotStaff:           http.StatusForbidden,
+	ErrorCraftNotFound:      http.StatusNotFound,
+	ErrorImageNotFound:      http.StatusNotFound,
+	ErrorImageInUse:         http.StatusConflict,
+	ErrorUserNotFound:       http.StatusNotFound,
+	ErrorEmailRegistered:    http.StatusConflict,
+	ErrorEmailNotValid:      http.StatusBadRequest,
+	ErrorTitleEmpty:         http.StatusBadRequest,
+	ErrorTitleNotAllowed:    http.StatusBadRequest,
+	ErrorNameEmpty:          http.StatusBadRequest,
+	ErrorNameNotAllowed:     http.StatusBadRequest,
+	ErrorImageTooLarge:      http.StatusRequestEntityTooLarge,
+	ErrorImageResize:        http.StatusBadRequest,
+	ErrorUnknownRole:        http.StatusBadRequest,
+	ErrorLoginThrottled:     http.StatusTooManyRequests,
+	ErrorAccountLocked:      http.StatusTooManyRequests,
+	ErrorSecondFactor:       http.StatusUnauthorized,
+	ErrorSecondFactorWrong:  http.StatusUnauthorized,
+	ErrorLoginTicket:        http.StatusUnauthorized,
+	ErrorTOTPNotPending:     http.StatusBadRequest,
+	ErrorTOTPNotEnabled:     http.StatusBadRequest,
+	ErrorTOTPRequired:       http.StatusForbidden,
+	ErrorLoginLinkThrottled: http.StatusTooManyRequests,
+	ErrorLoginLink:          http.StatusUnauthorized,
+	ErrorEmailNotVerified:   http.StatusForbidden,
+	ErrorIdentityMismatch:   http.StatusUnauthorized,
+	ErrorPasswordShort:      http.StatusBadRequest,
+	ErrorPasswordLong:       http.StatusBadRequest,
+	ErrorPasswordCommon:     http.StatusBadRequest,
+	ErrorPasswordPersonal:   http.StatusBadRequest,
+	ErrorNameLong:           http.StatusBadRequest,
+	ErrorUnknownTheme:       http.StatusBadRequest,
+	ErrorPasswordWrong:      http.StatusUnauthorized,
+	ErrorEmailChange:        http.StatusUnauthorized,
+	ErrorFreshLogin:         http.StatusUnauthorized,
+}
+
+// AsError returns err as an Error with the status code of ErrorStatus,
+// or err itself if it already is an Error. If err is not known, AsError
+// returns an internal server error with a generic message and false, so
+// internal errors do not reach clients. The caller should log err.
+func AsError(err error) (Error, bool) {
+	for known, status := range ErrorStatus {
+		if errors.Is(err, known) {
+			return Error{Code: status, Message: known.Error()}, true
+		}
+	}
+	var merr Error
+	if errors.As(err, &merr) {
+		return merr, true
+	}
+	status := http.StatusInternalServerError
+	return Error{Code: status, Message: http.StatusText(status)}, false
+}
+
+// Accept is a response to accept a login. If the login needs a second
+// step, only Ticket is set, and must be sent back with the code.
 type Accept struct {
-	Self  User   `json:"self"`
-	Token string `json:"token"`
+	Self   User   `json:"self"`
+	Token  string `json:"token"`
+	Ticket string `json:"ticket,omitempty"`
 }
 
+const (
+	// PageAmount is the default amount of items on a page of a RangeResult.
+	PageAmount = 20
+
+	// PageMaximum is the maximum amount of items on a page of a RangeResult.
+	PageMaximum = 100
+)
+
 // RangeQuery is a generic range query request for a resource.
 type RangeQuery[T any] struct {
 	First  string `json:"first"`
 	Amount int    `json:"amount"`
-	Item   T
+	Item   T      `json:"item"`
 }
 
 // RangeResult is a generic range query result for a resource.
@@ -177,7 +267,39 @@ type RangeResult[T any] struct {
 	First  string `json:"first"`
 	Last   string `json:"last"`
 	Amount int    `json:"amount"`
-	Items  []T
+	Items  []T    `json:"items"`
+}
+
+// Paginate returns the page of the items that the query asks for, newest
+// first in ULID order. query.First is the ID of the last item of the
+// previous page, or empty for the first page. query.Amount is the amount
+// of items on the page, or PageAmount if not positive, and at most
+// PageMaximum.
+func Paginate[T any](items []T, query RangeQuery[T], id func(T) string) *RangeResult[T] {
+	amount := query.Amount
+	if amount <= 0 {
+		amount = PageAmount
+	}
+	amount = min(amount, PageMaximum)
+
+	var page []T
+	for _, item := range items {
+		if query.First == "" || id(item) < query.First {
+			page = append(page, item)
+		}
+	}
+
+	sort.Slice(page, func(i, j int) bool { return id(page[i]) > id(page[j]) })
+	if len(page) > amount {
+		page = page[:amount]
+	}
+
+	res := &RangeResult[T]{Amount: len(page), Items: page}
+	if len(page) > 0 {
+		res.First = id(page[0])
+		res.Last = id(page[len(page)-1])
+	}
+	return res
 }
 
 // GetQuery is a generic get query request for a resource.
@@ -202,6 +324,59 @@ type Upload struct {
 	Detail        string    `json:"detail"`
 	UserID        string    `json:"user_id"`
 	MIME          string    `json:"mime"`
+	Hidden        bool      `json:"hidden"` // Hidden by staff after a report.
+}
+
+// Like is a like, or heart, that a user gave to the craft of another user.
+type Like struct {
+	CraftID string    `json:"craft_id"`
+	OwnerID string    `json:"owner_id"` // OwnerID is the user who owns the craft.
+	UserID  string    `json:"user_id"`  // UserID is the user who likes the craft.
+	Created time.Time `json:"created"`
+}
+
+// Follow means a user follows another user to see their crafts in their feed.
+type Follow struct {
+	UserID   string    `json:"user_id"`   // UserID is the user who follows.
+	FollowID string    `json:"follow_id"` // FollowID is the user who is followed.
+	Created  time.Time `json:"created"`
+}
+
+// Comment is a comment by a user on a craft.
+// Comments may be replies to other comments on the same craft.
+type Comment struct {
+	ID       string    `json:"id"`
+	CraftID  string    `json:"craft_id"`
+	OwnerID  string    `json:"owner_id"`  // OwnerID is the user who owns the craft.
+	UserID   string    `json:"user_id"`   // UserID is the user who wrote the comment.
+	UserName string    `json:"user_name"` // UserName is the name of the user when they wrote the comment.
+	ParentID string    `json:"parent_id"` // ParentID is the comment this is a reply to, if any.
+	Text     string    `json:"text"`      // Text in doc format.
+	Created  time.Time `json:"created"`
+}
+
+// ReportStatus is the status of a report in the moderation queue.
+type ReportStatus string
+
+const (
+	ReportOpen      ReportStatus = "open"
+	ReportHidden    ReportStatus = "hidden"
+	ReportRestored  ReportStatus = "restored"
+	ReportDismissed ReportStatus = "dismissed"
+)
+
+// Report is a report of a craft or an image by a user for staff to moderate.
+type Report struct {
+	ID      string       `json:"id"`
+	UserID  string       `json:"user_id"`  // UserID is the user who reported.
+	OwnerID string       `json:"owner_id"` // OwnerID is the user who owns the content.
+	CraftID string       `json:"craft_id"` // CraftID is the reported craft, if any.
+	Image   Reference    `json:"image"`    // Image is the reported image, if any.
+	Reason  string       `json:"reason"`
+	Status  ReportStatus `json:"status"`
+	StaffID string       `json:"staff_id"` // StaffID is the staff member who handled the report.
+	Created time.Time    `json:"created"`
+	Handled time.Time    `json:"handled"`
 }
 
 // Mail is a mail to send.
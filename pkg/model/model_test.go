@@ -0,0 +1,33 @@
+package model_test
+
+import "context"
+import "testing"
+
+import "github.com/qrochet/qrochet/pkg/model"
+import "github.com/qrochet/qrochet/pkg/repo"
+
+// newLogic returns the logic of a new repository in a temporary
+// directory, and a context that is cancelled when the test ends.
+func newLogic(t *testing.T) (*model.Logic, context.Context) {
+	t.Helper()
+	r, err := repo.Open("nats+builtin://" + t.TempDir())
+	if err != nil {
+		t.Fatalf("repo.Open: %s", err)
+	}
+	t.Cleanup(r.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return model.NewLogic(r, nil), ctx
+}
+
+// register registers a user with the password "hook and needle", and
+// returns the user and a session for it.
+func register(t *testing.T, logic *model.Logic, ctx context.Context, name, email string) (*model.User, *model.Session) {
+	t.Helper()
+	user, err := logic.Register(ctx, name, email, "hook and needle")
+	if err != nil {
+		t.Fatalf("Register %s: %s", email, err)
+	}
+	return user, &model.Session{UserID: user.ID}
+}
@@ -0,0 +1,252 @@
+package model
+
+import "bufio"
+import "bytes"
+import "crypto/rand"
+import "crypto/subtle"
+import _ "embed"
+import "encoding/base64"
+import "errors"
+import "fmt"
+import "log/slog"
+import "strings"
+import "unicode/utf8"
+
+import "golang.org/x/crypto/argon2"
+import "golang.org/x/crypto/bcrypt"
+
+const (
+	// PasswordMinLength is the minimum length of passwords in characters.
+	PasswordMinLength = 10
+
+	// PasswordMaxLength is the maximum length of passwords in bytes,
+	// which is the most that bcrypt uses.
+	PasswordMaxLength = 72
+)
+
+// Password hashing schemes.
+const (
+	HashBcrypt   = "bcrypt"
+	HashArgon2id = "argon2id"
+)
+
+// PasswordHash is the scheme of new password hashes. When it or the
+// parameters of the scheme change, the hashes of users are rehashed when
+// they log in next.
+var PasswordHash = HashBcrypt
+
+// BcryptCost is the cost of new bcrypt password hashes.
+var BcryptCost = bcrypt.DefaultCost
+
+// Argon2Params are the parameters of argon2id password hashes.
+type Argon2Params struct {
+	Time    uint32
+	Memory  uint32 // Memory in KiB.
+	Threads uint8
+	KeyLen  uint32
+	SaltLen uint32
+}
+
+// Argon2 are the parameters of new argon2id password hashes, as
+// recommended by RFC 9106 for memory constrained servers.
+var Argon2 = Argon2Params{Time: 3, Memory: 64 * 1024, Threads: 4, KeyLen: 32, SaltLen: 16}
+
+var (
+	// ErrorPasswordShort means the password is too short.
+	ErrorPasswordShort = fmt.Errorf("the password must be at least %d characters long", PasswordMinLength)
+
+	// ErrorPasswordLong means the password is too long.
+	ErrorPasswordLong = fmt.Errorf("the password must be at most %d bytes long", PasswordMaxLength)
+
+	// ErrorPasswordCommon means the password is too common.
+	ErrorPasswordCommon = errors.New("this password is too common and easy to guess, please choose another one")
+
+	// ErrorPasswordPersonal means the password is the email address or
+	// name of the user.
+	ErrorPasswordPersonal = errors.New("the password must not be your email address or name")
+
+	// ErrorPasswordHash means the password hash is not valid.
+	ErrorPasswordHash = errors.New("password hash not valid")
+)
+
+//go:embed passwords.txt
+var passwordsTxt []byte
+
+// commonPasswords are the passwords of passwords.txt.
+var commonPasswords = loadPasswords(passwordsTxt)
+
+func loadPasswords(txt []byte) map[string]bool {
+	res := map[string]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(txt))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		res[strings.ToLower(line)] = true
+	}
+	return res
+}
+
+// isCommonPassword returns whether the password is a common password, or
+// one followed by digits or punctuation.
+func isCommonPassword(pass string) bool {
+	pass = strings.ToLower(pass)
+	if commonPasswords[pass] {
+		return true
+	}
+	stem := strings.TrimRight(pass, "0123456789!@#$%^&*()-_=+.,?~ ")
+	return len(stem) >= 4 && commonPasswords[stem]
+}
+
+// CheckPasswordPolicy returns an error if the password is not allowed for
+// a user with the email address and name.
+func CheckPasswordPolicy(pass, email, name string) error {
+	if utf8.RuneCountInString(pass) < PasswordMinLength {
+		return ErrorPasswordShort
+	}
+	if len(pass) > PasswordMaxLength {
+		return ErrorPasswordLong
+	}
+	if isCommonPassword(pass) {
+		return ErrorPasswordCommon
+	}
+	local, _, _ := strings.Cut(email, "@")
+	for _, personal := range []string{email, local, name} {
+		if personal != "" && strings.EqualFold(strings.TrimSpace(pass), strings.TrimSpace(personal)) {
+			return ErrorPasswordPersonal
+		}
+	}
+	return nil
+}
+
+// CheckPassword checks the password against the hash of the user, which
+// may be a bcrypt or an argon2id hash.
+func (u User) CheckPassword(pass string) error {
+	if strings.HasPrefix(u.Hash, "$"+HashArgon2id+"$") {
+		return checkArgon2(u.Hash, pass)
+	}
+	return bcrypt.CompareHashAndPassword([]byte(u.Hash), []byte(pass))
+}
+
+// SetPassword sets the password of the user if it is allowed by the
+// password policy. The email address and name of the user must be set
+// first.
+func (u *User) SetPassword(pass string) error {
+	err := CheckPasswordPolicy(pass, u.Email, u.Name)
+	if err != nil {
+		return err
+	}
+	return u.hashPassword(pass)
+}
+
+// hashPassword sets the hash of the password with the current scheme,
+// without checking the password policy.
+func (u *User) hashPassword(pass string) error {
+	if PasswordHash == HashArgon2id {
+		u.Hash = Argon2.hash(pass)
+		return nil
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(pass), BcryptCost)
+	if err != nil {
+		return err
+	}
+	u.Hash = string(hash)
+	return nil
+}
+
+// NeedsRehash returns whether the password hash of the user was made with
+// another scheme or other parameters than new hashes.
+func (u User) NeedsRehash() bool {
+	if u.Hash == "" {
+		return false
+	}
+	if PasswordHash == HashArgon2id {
+		params, _, _, err := parseArgon2(u.Hash)
+		return err != nil || params != Argon2
+	}
+	cost, err := bcrypt.Cost([]byte(u.Hash))
+	return err != nil || cost != BcryptCost
+}
+
+// errPasswordChanged stops rehash from replacing a password that was
+// changed since the user logged in.
+var errPasswordChanged = errors.New("password changed")
+
+// rehash hashes the password of the user again if NeedsRehash, after the
+// user logged in with it. Errors are only logged, the old hash still works.
+func (l *Logic) rehash(ctx Context, user *User, pass string) {
+	if !user.NeedsRehash() {
+		return
+	}
+	old := user.Hash
+	err := user.hashPassword(pass)
+	if err != nil {
+		slog.Error("User.hashPassword", "err", err, "user", user.ID)
+		return
+	}
+	_, err = l.updateUser(ctx, user.ID, ErrorUserNotSaved, func(stored *User) error {
+		// A password that was changed in the meantime is kept.
+		if stored.Hash != old {
+			return errPasswordChanged
+		}
+		stored.Hash = user.Hash
+		return nil
+	})
+	if err != nil {
+		slog.Error("password not rehashed", "err", err, "user", user.ID)
+		return
+	}
+	slog.Info("password rehashed", "user", user.ID, "scheme", PasswordHash)
+}
+
+// hash returns the argon2id hash of the password in the PHC string format.
+func (p Argon2Params) hash(pass string) string {
+	salt := make([]byte, p.SaltLen)
+	rand.Read(salt)
+	key := argon2.IDKey([]byte(pass), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", HashArgon2id, argon2.Version,
+		p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+// parseArgon2 parses an argon2id hash in the PHC string format.
+func parseArgon2(hash string) (params Argon2Params, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != HashArgon2id {
+		return params, nil, nil, ErrorPasswordHash
+	}
+	var version int
+	_, err = fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, ErrorPasswordHash
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads)
+	if err != nil {
+		return params, nil, nil, ErrorPasswordHash
+	}
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrorPasswordHash
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, ErrorPasswordHash
+	}
+	params.SaltLen = uint32(len(salt))
+	params.KeyLen = uint32(len(key))
+	return params, salt, key, nil
+}
+
+// checkArgon2 checks the password against the argon2id hash.
+func checkArgon2(hash, pass string) error {
+	params, salt, key, err := parseArgon2(hash)
+	if err != nil {
+		return err
+	}
+	other := argon2.IDKey([]byte(pass), salt, params.Time, params.Memory, params.Threads, params.KeyLen)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return bcrypt.ErrMismatchedHashAndPassword
+	}
+	return nil
+}
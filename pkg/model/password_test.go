@@ -0,0 +1,64 @@
+package model_test
+
+import "strings"
+import "testing"
+import "time"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+func TestPasswordPolicy(t *testing.T) {
+	bad := map[string]error{
+		"short":                 model.ErrorPasswordShort,
+		"password123!":          model.ErrorPasswordCommon,
+		"Qwertyuiop":            model.ErrorPasswordCommon,
+		"bob@example.com":       model.ErrorPasswordPersonal,
+		"Bob the Crocheter":     model.ErrorPasswordPersonal,
+		strings.Repeat("x", 73): model.ErrorPasswordLong,
+	}
+	for pass, want := range bad {
+		err := model.CheckPasswordPolicy(pass, "bob@example.com", "Bob the Crocheter")
+		if err != want {
+			t.Errorf("CheckPasswordPolicy(%q): %v", pass, err)
+		}
+	}
+	err := model.CheckPasswordPolicy("hook and needle", "bob@example.com", "Bob")
+	if err != nil {
+		t.Errorf("CheckPasswordPolicy: %v", err)
+	}
+}
+
+func TestRehash(t *testing.T) {
+	logic, ctx := newLogic(t)
+	_, err := logic.Register(ctx, "Bob", "bob@example.com", "")
+	if err != model.ErrorPasswordShort {
+		t.Errorf("Register with empty password: %v", err)
+	}
+	bob, _ := register(t, logic, ctx, "Bob", "bob@example.com")
+	if bob.NeedsRehash() {
+		t.Errorf("NeedsRehash of new hash")
+	}
+
+	defer func(scheme string, params model.Argon2Params) {
+		model.PasswordHash, model.Argon2 = scheme, params
+	}(model.PasswordHash, model.Argon2)
+	model.PasswordHash = model.HashArgon2id
+	model.Argon2 = model.Argon2Params{Time: 1, Memory: 1024, Threads: 1, KeyLen: 32, SaltLen: 16}
+	if !bob.NeedsRehash() {
+		t.Errorf("NeedsRehash of bcrypt hash")
+	}
+
+	for range 2 {
+		_, _, err = logic.Login(ctx, "bob@example.com", "hook and needle", time.Hour)
+		if err != nil {
+			t.Fatalf("Login: %s", err)
+		}
+		user, err := logic.User().Get(ctx, bob.ID)
+		if err != nil || !strings.HasPrefix(user.Hash, "$argon2id$") || user.NeedsRehash() {
+			t.Fatalf("rehashed: %v %q", err, user.Hash)
+		}
+	}
+	_, _, err = logic.Login(ctx, "bob@example.com", "hook and needles", time.Hour)
+	if err != model.ErrorEmailNotRegistered {
+		t.Errorf("Login with wrong password: %v", err)
+	}
+}
@@ -0,0 +1,362 @@
+package model
+
+import "errors"
+import "fmt"
+import "io"
+import "log/slog"
+import "net/mail"
+import "slices"
+import "strings"
+import "time"
+import "unicode/utf8"
+
+import "github.com/oklog/ulid/v2"
+
+import "github.com/qrochet/qrochet/pkg/censor"
+
+// Themes of the user interface. Each theme has a stylesheet and a logo in
+// the web resources of the app, named after it.
+const (
+	ThemeCute Theme = "cute"
+	ThemeCosy Theme = "cosy"
+)
+
+// DefaultTheme is the theme of visitors and of users who did not choose
+// one.
+const DefaultTheme = ThemeCute
+
+// Themes are the themes that users can choose.
+var Themes = []Theme{ThemeCute, ThemeCosy}
+
+// Valid returns whether the theme is one of Themes.
+func (t Theme) Valid() bool {
+	return slices.Contains(Themes, t)
+}
+
+// OrDefault returns the theme, or DefaultTheme if it is not valid.
+func (t Theme) OrDefault() Theme {
+	if t.Valid() {
+		return t
+	}
+	return DefaultTheme
+}
+
+const (
+	// NameMaxLength is the maximum length of display names in characters.
+	NameMaxLength = 64
+
+	// AvatarSize is the width and height of avatars in pixels.
+	AvatarSize = 256
+
+	// EmailChangeTTL is how long the link to confirm a new email address
+	// can be used.
+	EmailChangeTTL = 24 * time.Hour
+
+	// FreshLoginAge is how long after logging in a user without password
+	// can change their email address or delete their account.
+	FreshLoginAge = 10 * time.Minute
+)
+
+var (
+	// ErrorNameLong means the display name is too long.
+	ErrorNameLong = fmt.Errorf("please enter a name of at most %d characters", NameMaxLength)
+
+	// ErrorUnknownTheme means the theme is not one of Themes.
+	ErrorUnknownTheme = errors.New("unknown theme")
+
+	// ErrorPasswordWrong means the current password of a password change
+	// is not correct.
+	ErrorPasswordWrong = errors.New("the current password is not correct")
+
+	// ErrorEmailChange means the link to confirm a new email address is
+	// not valid, expired or was already used.
+	ErrorEmailChange = errors.New("this email confirmation link expired or was already used")
+
+	// ErrorFreshLogin means the user has to log in again for a change of
+	// their account.
+	ErrorFreshLogin = errors.New("please log in again to make this change")
+)
+
+// EmailChange is a change of the email address of a user that waits for
+// the user to confirm the new address with the link that was mailed to it.
+type EmailChange struct {
+	ID      string    `json:"id"`
+	UserID  string    `json:"user_id"`
+	Email   string    `json:"email"`
+	Expires time.Time `json:"expires"`
+}
+
+// EmailChangeMapper is a data mapper for email changes. Its entries expire
+// after EmailChangeTTL.
+type EmailChangeMapper interface {
+	BasicMapper[EmailChange]
+	// Take gets the email change and deletes it, so it can only be taken
+	// once, even by requests at the same time.
+	Take(ctx Context, id string) (EmailChange, error)
+}
+
+// emailConfirmMail returns the mail with the link to confirm the new email
+// address of the user.
+func emailConfirmMail(user User, email, link string) Mail {
+	msg := Mail{}
+	msg.To = user.Name + "<" + email + ">"
+	msg.Subject = "Please confirm your new Qrochet email address"
+
+	msg.Printf("Dear %s,\n\n", user.Name)
+	msg.Println("Open this link to use this email address for Qrochet from now on:")
+	msg.Println()
+	msg.Println(link)
+	msg.Println()
+	msg.Printf("The link works once, for %d hours.\n", int(EmailChangeTTL.Hours()))
+	msg.Println("If you did not ask for it, you can ignore this mail.")
+	msg.Println()
+	msg.Println("Kind regards, Qrochet.")
+	return msg
+}
+
+// emailChangedMail returns the mail that tells the user at their old email
+// address that it was changed.
+func emailChangedMail(user User, email string) Mail {
+	msg := Mail{}
+	msg.To = user.Name + "<" + user.Email + ">"
+	msg.Subject = "Your Qrochet email address was changed"
+
+	msg.Printf("Dear %s,\n\n", user.Name)
+	msg.Printf("The email address of your Qrochet account was changed to %s.\n", RedactEmail(email))
+	msg.Println("If you did not do this, please contact us.")
+	msg.Println()
+	msg.Println("Kind regards, Qrochet.")
+	return msg
+}
+
+// checkName returns the display name without surrounding space, or an
+// error if it is empty, too long or censored.
+func checkName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", ErrorNameEmpty
+	}
+	if utf8.RuneCountInString(name) > NameMaxLength {
+		return "", ErrorNameLong
+	}
+	if censor.Check(name) != nil {
+		return "", ErrorNameNotAllowed
+	}
+	return name, nil
+}
+
+// UpdateProfile changes the display name and the theme of the user of the
+// session.
+func (l *Logic) UpdateProfile(ctx Context, session *Session, name string, theme Theme) (*User, error) {
+	name, err := checkName(name)
+	if err != nil {
+		return nil, err
+	}
+	if !theme.Valid() {
+		return nil, ErrorUnknownTheme
+	}
+
+	detail := ""
+	user, err := l.updateSessionUser(ctx, session, ErrorProfileUpdate, func(user *User) error {
+		detail = ""
+		if name != user.Name {
+			detail = "name"
+		}
+		user.Name = name
+		user.Theme = theme
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	l.Audit(ctx, AuditProfileChange, user.ID, detail)
+	return user, nil
+}
+
+// ChangePassword changes the password of the user of the session. The
+// current password is needed, except for users without password, such as
+// users who signed in with an OpenID Connect provider.
+func (l *Logic) ChangePassword(ctx Context, session *Session, current, password string) error {
+	user, err := l.Profile(ctx, session)
+	if err != nil {
+		return err
+	}
+	if user.Hash != "" {
+		err = l.checkCurrentPassword(ctx, user, current, "wrong password for password change")
+		if err != nil {
+			return err
+		}
+	}
+	changed := *user
+	err = changed.SetPassword(password)
+	if err != nil {
+		return err
+	}
+	_, err = l.updateSessionUser(ctx, session, ErrorProfileUpdate, func(stored *User) error {
+		// The password must still be the one that was checked.
+		if stored.Hash != user.Hash {
+			return ErrorPasswordWrong
+		}
+		stored.Hash = changed.Hash
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	l.Audit(ctx, AuditPasswordChange, user.ID, "")
+	return nil
+}
+
+// checkCurrentPassword checks the current password of the user before a
+// change of their account. Wrong passwords count like failed log ins.
+func (l *Logic) checkCurrentPassword(ctx Context, user *User, current, reason string) error {
+	err := l.checkAttempts(ctx, user.Email)
+	if err != nil {
+		return err
+	}
+	if user.CheckPassword(current) != nil {
+		l.Audit(ctx, AuditLoginFailed, user.ID, reason)
+		l.failAttempt(ctx, user.Email, user)
+		return ErrorPasswordWrong
+	}
+	l.resetAttempts(ctx, user.Email)
+	return nil
+}
+
+// reauthenticate checks that the user of the session is still the one who
+// logged in, before a change that would let a stolen session take over or
+// destroy the account. The current password is needed, or for users
+// without password, a session that started less than FreshLoginAge ago.
+func (l *Logic) reauthenticate(ctx Context, session *Session, user *User, current, reason string) error {
+	if user.Hash != "" {
+		return l.checkCurrentPassword(ctx, user, current, reason)
+	}
+	if time.Since(session.Start) > FreshLoginAge {
+		return ErrorFreshLogin
+	}
+	return nil
+}
+
+// RequestEmailChange mails a link to confirm the new email address to it.
+// The user has to reauthenticate with the current password first. The
+// address of the user only changes when the link is opened with
+// ConfirmEmailChange. The link function returns
+// the URL of the link with a token for the ID of the change.
+func (l *Logic) RequestEmailChange(ctx Context, session *Session, current, email string, link func(id string) string) error {
+	user, err := l.Profile(ctx, session)
+	if err != nil {
+		return err
+	}
+	err = l.reauthenticate(ctx, session, user, current, "wrong password for email change")
+	if err != nil {
+		return err
+	}
+	_, err = mail.ParseAddress(email)
+	if err != nil {
+		return ErrorEmailNotValid
+	}
+	existing, err := l.User().GetByEmail(ctx, email)
+	if err != nil {
+		slog.Error("User.GetByEmail", "err", err)
+		return ErrorGetEmail
+	}
+	if existing != nil && existing.Email == email {
+		return ErrorEmailRegistered
+	}
+	// Confirmation mails count like log in links, so the form cannot be
+	// used to send many mails to an address.
+	err = l.countLoginLink(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	change := EmailChange{ID: ulid.Make().String(), UserID: user.ID, Email: email, Expires: time.Now().Add(EmailChangeTTL)}
+	_, err = l.EmailChange().Put(ctx, change.ID, change)
+	if err != nil {
+		slog.Error("EmailChange.Put", "err", err)
+		return ErrorProfileUpdate
+	}
+	l.QueueMail(ctx, emailConfirmMail(*user, email, link(change.ID)))
+	l.Audit(ctx, AuditEmailChange, user.ID, "requested: "+RedactEmail(email))
+	return nil
+}
+
+// ConfirmEmailChange changes the email address of the user to the one of
+// the email change with the ID, and returns the user.
+func (l *Logic) ConfirmEmailChange(ctx Context, id string) (*User, error) {
+	change, err := l.EmailChange().Take(ctx, id)
+	if err != nil || time.Now().After(change.Expires) {
+		return nil, ErrorEmailChange
+	}
+
+	existing, err := l.User().GetByEmail(ctx, change.Email)
+	if err != nil {
+		slog.Error("User.GetByEmail", "err", err)
+		return nil, ErrorGetEmail
+	}
+	if existing != nil && existing.Email == change.Email {
+		return nil, ErrorEmailRegistered
+	}
+
+	var old User
+	user, err := l.updateUser(ctx, change.UserID, ErrorProfileUpdate, func(user *User) error {
+		old = *user
+		user.Email = change.Email
+		return nil
+	})
+	if err == ErrorUserNotFound {
+		return nil, ErrorEmailChange
+	}
+	if err != nil {
+		return nil, err
+	}
+	l.QueueMail(ctx, emailChangedMail(old, change.Email))
+	l.Audit(ctx, AuditEmailChange, user.ID, "confirmed")
+	return user, nil
+}
+
+// SetAvatar sets the avatar of the user of the session to the image, which
+// is resized to AvatarSize. The old avatar is deleted.
+func (l *Logic) SetAvatar(ctx Context, session *Session, rd io.Reader, size int64) (*User, error) {
+	user, err := l.Profile(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxImageSize {
+		return nil, ErrorImageTooLarge
+	}
+	resized, err := resizeImageJPEG(rd, AvatarSize, AvatarSize, 90)
+	if err != nil {
+		slog.Error("Image resizing failed", "err", err)
+		return nil, ErrorImageResize
+	}
+
+	upload := &Upload{
+		ID:         Reference(ulid.Make().String() + ".jpeg"),
+		Title:      "avatar",
+		UserID:     user.ID,
+		MIME:       "image/jpeg",
+		ReadCloser: io.NopCloser(resized),
+	}
+	_, err = l.Avatar().Put(ctx, upload)
+	if err != nil {
+		slog.Error("Avatar.Put", "err", err)
+		return nil, ErrorImageUpload
+	}
+
+	var old Reference
+	user, err = l.updateSessionUser(ctx, session, ErrorProfileUpdate, func(user *User) error {
+		old = user.Avatar
+		user.Avatar = upload.ID
+		return nil
+	})
+	if err != nil {
+		_ = l.Avatar().Delete(ctx, string(upload.ID))
+		return nil, err
+	}
+	if old != "" {
+		_ = l.Avatar().Delete(ctx, string(old))
+	}
+	l.Audit(ctx, AuditProfileChange, user.ID, "avatar")
+	return user, nil
+}
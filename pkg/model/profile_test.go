@@ -0,0 +1,127 @@
+package model_test
+
+import "testing"
+import "time"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+func TestProfile(t *testing.T) {
+	logic, ctx := newLogic(t)
+	_, session := register(t, logic, ctx, "Bob", "bob@example.com")
+
+	_, err := logic.UpdateProfile(ctx, session, "Bob", "neon")
+	if err != model.ErrorUnknownTheme {
+		t.Errorf("UpdateProfile unknown theme: %v", err)
+	}
+	user, err := logic.UpdateProfile(ctx, session, " Bobby ", model.ThemeCosy)
+	if err != nil || user.Name != "Bobby" || user.Theme != model.ThemeCosy {
+		t.Fatalf("UpdateProfile: %v %v", err, user)
+	}
+
+	err = logic.ChangePassword(ctx, session, "wrong password", "crochet hook 42")
+	if err != model.ErrorPasswordWrong {
+		t.Errorf("ChangePassword wrong password: %v", err)
+	}
+	err = logic.ChangePassword(ctx, session, "hook and needle", "crochet hook 42")
+	if err != nil {
+		t.Fatalf("ChangePassword: %v", err)
+	}
+	_, _, err = logic.Login(ctx, "bob@example.com", "crochet hook 42", time.Hour)
+	if err != nil {
+		t.Errorf("Login with new password: %v", err)
+	}
+
+	id := ""
+	link := func(changeID string) string {
+		id = changeID
+		return "http://localhost/my/email?token=" + changeID
+	}
+	err = logic.RequestEmailChange(ctx, session, "hook and needle", "bobby@example.com", link)
+	if err != model.ErrorPasswordWrong || id != "" {
+		t.Errorf("RequestEmailChange wrong password: %v %q", err, id)
+	}
+	err = logic.RequestEmailChange(ctx, session, "crochet hook 42", "bobby@example.com", link)
+	if err != nil || id == "" {
+		t.Fatalf("RequestEmailChange: %v %q", err, id)
+	}
+	user, err = logic.ConfirmEmailChange(ctx, id)
+	if err != nil || user.Email != "bobby@example.com" {
+		t.Fatalf("ConfirmEmailChange: %v %v", err, user)
+	}
+	_, err = logic.ConfirmEmailChange(ctx, id)
+	if err != model.ErrorEmailChange {
+		t.Errorf("ConfirmEmailChange again: %v", err)
+	}
+	_, _, err = logic.Login(ctx, "bobby@example.com", "crochet hook 42", time.Hour)
+	if err != nil {
+		t.Errorf("Login with new email: %v", err)
+	}
+}
+
+func TestEmailChangeFreshLogin(t *testing.T) {
+	logic, ctx := newLogic(t)
+	ext := model.ExternalUser{
+		Identity:      model.Identity{Issuer: "https://id.example.com", Subject: "42"},
+		Email:         "ann@example.com",
+		EmailVerified: true,
+	}
+	ann, err := logic.LoginExternal(ctx, ext)
+	if err != nil {
+		t.Fatalf("LoginExternal: %v", err)
+	}
+
+	// Users without password need a session that started recently.
+	link := func(changeID string) string { return changeID }
+	old := &model.Session{UserID: ann.ID, Start: time.Now().Add(-model.FreshLoginAge - time.Minute)}
+	err = logic.RequestEmailChange(ctx, old, "", "anne@example.com", link)
+	if err != model.ErrorFreshLogin {
+		t.Errorf("RequestEmailChange old session: %v", err)
+	}
+	fresh := &model.Session{UserID: ann.ID, Start: time.Now()}
+	err = logic.RequestEmailChange(ctx, fresh, "", "anne@example.com", link)
+	if err != nil {
+		t.Errorf("RequestEmailChange fresh session: %v", err)
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	logic, ctx := newLogic(t)
+	bob, session := register(t, logic, ctx, "Bob", "bob@example.com")
+
+	err := logic.DeleteAccount(ctx, session, "wrong password")
+	if err != model.ErrorPasswordWrong {
+		t.Errorf("DeleteAccount wrong password: %v", err)
+	}
+	err = logic.DeleteAccount(ctx, session, "hook and needle")
+	if err != nil {
+		t.Fatalf("DeleteAccount: %v", err)
+	}
+	_, err = logic.User().Get(ctx, bob.ID)
+	if err == nil {
+		t.Errorf("user of the deleted account left")
+	}
+	entries, err := logic.QueryAudits(ctx, model.AuditQuery{UserID: bob.ID})
+	if err != nil || len(entries) == 0 || entries[len(entries)-1].Action != model.AuditAccountDelete {
+		t.Errorf("DeleteAccount audit: %v %+v", err, entries)
+	}
+
+	// Users without password need a session that started recently.
+	ann, err := logic.LoginExternal(ctx, model.ExternalUser{
+		Identity:      model.Identity{Issuer: "https://id.example.com", Subject: "42"},
+		Email:         "ann@example.com",
+		EmailVerified: true,
+	})
+	if err != nil {
+		t.Fatalf("LoginExternal: %v", err)
+	}
+	old := &model.Session{UserID: ann.ID, Start: time.Now().Add(-model.FreshLoginAge - time.Minute)}
+	err = logic.DeleteAccount(ctx, old, "")
+	if err != model.ErrorFreshLogin {
+		t.Errorf("DeleteAccount old session: %v", err)
+	}
+	fresh := &model.Session{UserID: ann.ID, Start: time.Now()}
+	err = logic.DeleteAccount(ctx, fresh, "")
+	if err != nil {
+		t.Errorf("DeleteAccount fresh session: %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+package model
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+)
+
+import (
+	"github.com/oklog/ulid/v2"
+	"github.com/qrochet/qrochet/pkg/censor"
+)
+
+var (
+	// ErrorNotStaff indicates that the action is reserved for staff.
+	ErrorNotStaff = errors.New("this action is reserved for staff")
+
+	// ErrorReportNothing means a report did not name a craft or an image.
+	ErrorReportNothing = errors.New("please choose a craft or an image to report")
+
+	// ErrorReportCreate means creating a report failed.
+	ErrorReportCreate = errors.New("report create failed")
+
+	// ErrorReportNotFound means the report could not be found.
+	ErrorReportNotFound = errors.New("report not found")
+
+	// ErrorReportAction means the moderation action is not known.
+	ErrorReportAction = errors.New("unknown moderation action")
+
+	// ErrorReportHandle means handling a report failed.
+	ErrorReportHandle = errors.New("report handling failed")
+
+	// ErrorCraftNotFound means the craft could not be found.
+	ErrorCraftNotFound = errors.New("craft not found")
+)
+
+// Staff returns the user of the session if that user is staff,
+// or ErrorNotStaff if not.
+func (l *Logic) Staff(ctx Context, session *Session) (*User, error) {
+	if session == nil || session.UserID == "" {
+		return nil, ErrorPleaseLogIn
+	}
+
+	user, err := l.User().Get(ctx, session.UserID)
+	if err != nil {
+		slog.Error("User.Get", "err", err, "user", session.UserID)
+		return nil, ErrorPleaseLogIn
+	}
+
+	if user.Role < RoleStaff {
+		return nil, ErrorNotStaff
+	}
+	if l.RequiresTOTP(ctx, user) {
+		return nil, ErrorTOTPRequired
+	}
+	return &user, nil
+}
+
+// NewReport reports a craft or an image on behalf of the user of the session.
+// Either craftID or image must be set.
+func (l *Logic) NewReport(ctx Context, session *Session, craftID string, image Reference, reason string) (*Report, error) {
+	if session == nil || session.UserID == "" {
+		return nil, ErrorPleaseLogIn
+	}
+
+	report := Report{}
+	report.ID = ulid.Make().String()
+	report.UserID = session.UserID
+	report.Reason = censor.Replace(reason)
+	report.Status = ReportOpen
+	report.Created = time.Now()
+
+	if craftID != "" {
+		craft, err := l.Craft().GetByID(ctx, craftID)
+		if err != nil {
+			slog.Error("Craft.GetByID", "err", err, "craft", craftID)
+			return nil, ErrorCraftNotFound
+		}
+		report.CraftID = craft.ID
+		report.OwnerID = craft.UserID
+		report.Image = craft.Image
+	} else if image != "" {
+		upload, err := l.Image().Get(ctx, string(image))
+		if err != nil {
+			slog.Error("Image.Get", "err", err, "image", image)
+			return nil, ErrorImageGet
+		}
+		upload.ReadCloser.Close()
+		report.OwnerID = upload.UserID
+		report.Image = upload.ID
+	} else {
+		return nil, ErrorReportNothing
+	}
+
+	created, err := l.Report().Put(ctx, report.ID, report)
+	if err != nil {
+		slog.Error("Report.Put", "err", err)
+		return nil, ErrorReportCreate
+	}
+
+	slog.Info("NewReport", "report", created.ID, "craft", created.CraftID, "image", created.Image)
+	return &created, nil
+}
+
+// Reports returns all reports for the moderation queue.
+// Only staff may see the reports.
+func (l *Logic) Reports(ctx Context, session *Session) ([]Report, error) {
+	_, err := l.Staff(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	return Collect(l.Report().All(ctx))
+}
+
+// hideContent hides or restores the content a report refers to.
+func (l *Logic) hideContent(ctx Context, report Report, hidden bool) error {
+	if report.CraftID != "" {
+		craft, err := l.Craft().GetForUserID(ctx, report.CraftID, report.OwnerID)
+		if err != nil {
+			slog.Error("Craft.GetForUserID", "err", err, "craft", report.CraftID)
+			return err
+		}
+		craft.Hidden = hidden
+		_, err = l.Craft().Put(ctx, craft.ID, craft)
+		if err != nil {
+			slog.Error("Craft.Put", "err", err, "craft", report.CraftID)
+			return err
+		}
+	}
+
+	if report.Image != "" {
+		err := l.Image().Hide(ctx, string(report.Image), hidden)
+		if err != nil {
+			slog.Error("Image.Hide", "err", err, "image", report.Image)
+			return err
+		}
+		// The thumbnail may not have been rendered yet.
+		_ = l.Thumbnail().Hide(ctx, string(report.Image), hidden)
+	}
+	return nil
+}
+
+// hiddenMail returns the mail that tells the owner their content was hidden.
+func hiddenMail(owner User, report Report) Mail {
+	msg := Mail{}
+	msg.To = owner.Name + "<" + owner.Email + ">"
+	msg.Subject = "Your content on Qrochet was hidden"
+
+	msg.Printf("Dear %s,\n\n", owner.Name)
+	if report.CraftID != "" {
+		msg.Printf("Your craft %s was hidden by our staff after it was reported.\n", report.CraftID)
+	} else {
+		msg.Printf("Your image %s was hidden by our staff after it was reported.\n", report.Image)
+	}
+	msg.Printf("The reason given was: %s\n\n", report.Reason)
+	msg.Println("Please check that your content follows our terms and conditions.")
+	msg.Println("Kind regards, Qrochet.")
+
+	return msg
+}
+
+// Moderate handles a report with the given action, which must be one of
+// ReportHidden, ReportRestored or ReportDismissed.
+// Hiding content sends a mail to the owner of the content.
+// Only staff may moderate.
+func (l *Logic) Moderate(ctx Context, session *Session, reportID string, action ReportStatus) (*Report, error) {
+	staff, err := l.Staff(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := l.Report().Get(ctx, reportID)
+	if err != nil {
+		slog.Error("Report.Get", "err", err, "report", reportID)
+		return nil, ErrorReportNotFound
+	}
+
+	switch action {
+	case ReportHidden:
+		err = l.hideContent(ctx, report, true)
+	case ReportRestored:
+		err = l.hideContent(ctx, report, false)
+	case ReportDismissed:
+		err = nil
+	default:
+		return nil, ErrorReportAction
+	}
+	if err != nil {
+		return nil, ErrorReportHandle
+	}
+
+	report.Status = action
+	report.StaffID = staff.ID
+	report.Handled = time.Now()
+	report, err = l.Report().Put(ctx, report.ID, report)
+	if err != nil {
+		slog.Error("Report.Put", "err", err, "report", reportID)
+		return nil, ErrorReportHandle
+	}
+
+	slog.Info("Moderate", "report", report.ID, "action", action, "staff", staff.ID)
+	l.auditBy(ctx, AuditModerate, staff.ID, report.OwnerID, "report "+report.ID+" "+string(action))
+
+	if action == ReportHidden {
+		owner, err := l.User().Get(ctx, report.OwnerID)
+		if err != nil {
+			slog.Error("User.Get", "err", err, "user", report.OwnerID)
+		} else {
+			l.QueueMail(ctx, hiddenMail(owner, report))
+		}
+	}
+	return &report, nil
+}
@@ -0,0 +1,107 @@
+package model_test
+
+import "context"
+import "encoding/json"
+import "testing"
+import "time"
+
+import "github.com/oklog/ulid/v2"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+func TestModerate(t *testing.T) {
+	logic, ctx := newLogic(t)
+	alice, _ := register(t, logic, ctx, "Alice", "alice@example.com")
+	_, bob := register(t, logic, ctx, "Bob", "bob@example.com")
+	carol, staff := register(t, logic, ctx, "Carol", "carol@example.com")
+	carol.Role = model.RoleStaff
+	_, err := logic.User().Put(ctx, carol.ID, *carol)
+	if err != nil {
+		t.Fatalf("Put staff: %s", err)
+	}
+
+	// Mails are queued as jobs, so a worker shows what was sent.
+	mails := make(chan model.Mail, 10)
+	go logic.Jobs().Work(ctx, map[string]model.JobHandler{
+		model.JobMail: func(ctx context.Context, job model.Job) error {
+			var msg model.Mail
+			json.Unmarshal(job.Data, &msg)
+			mails <- msg
+			return nil
+		},
+	})
+
+	craft := model.Craft{ID: ulid.Make().String(), UserID: alice.ID, Title: "Scarf"}
+	_, err = logic.Craft().Put(ctx, craft.ID, craft)
+	if err != nil {
+		t.Fatalf("Put craft: %s", err)
+	}
+
+	_, err = logic.NewReport(ctx, nil, craft.ID, "", "spam")
+	if err != model.ErrorPleaseLogIn {
+		t.Errorf("NewReport without session: %v", err)
+	}
+	_, err = logic.NewReport(ctx, bob, "", "", "spam")
+	if err != model.ErrorReportNothing {
+		t.Errorf("NewReport of nothing: %v", err)
+	}
+	report, err := logic.NewReport(ctx, bob, craft.ID, "", "spam")
+	if err != nil || report.OwnerID != alice.ID || report.Status != model.ReportOpen {
+		t.Fatalf("NewReport: %v %+v", err, report)
+	}
+
+	// Only staff see and handle reports.
+	_, err = logic.Reports(ctx, bob)
+	if err != model.ErrorNotStaff {
+		t.Errorf("Reports by user: %v", err)
+	}
+	_, err = logic.Moderate(ctx, bob, report.ID, model.ReportHidden)
+	if err != model.ErrorNotStaff {
+		t.Errorf("Moderate by user: %v", err)
+	}
+	reports, err := logic.Reports(ctx, staff)
+	if err != nil || len(reports) != 1 || reports[0].ID != report.ID {
+		t.Fatalf("Reports: %v %v", err, reports)
+	}
+	_, err = logic.Moderate(ctx, staff, report.ID, model.ReportOpen)
+	if err != model.ErrorReportAction {
+		t.Errorf("Moderate unknown action: %v", err)
+	}
+
+	tests := []struct {
+		action model.ReportStatus
+		hidden bool
+	}{
+		{model.ReportHidden, true},
+		{model.ReportRestored, false},
+		{model.ReportHidden, true},
+		{model.ReportDismissed, true},
+	}
+	for _, test := range tests {
+		handled, err := logic.Moderate(ctx, staff, report.ID, test.action)
+		if err != nil || handled.Status != test.action || handled.StaffID != carol.ID {
+			t.Fatalf("Moderate %s: %v %+v", test.action, err, handled)
+		}
+		got, err := logic.Craft().GetByID(ctx, craft.ID)
+		if err != nil || got.Hidden != test.hidden {
+			t.Errorf("Moderate %s: craft hidden %t, want %t", test.action, got.Hidden, test.hidden)
+		}
+	}
+
+	// Hiding mails the owner, each time.
+	hidden := 0
+	timeout := time.After(10 * time.Second)
+	for hidden < 2 {
+		select {
+		case msg := <-mails:
+			if msg.Subject == "Your content on Qrochet was hidden" {
+				if msg.To != "Alice<alice@example.com>" {
+					t.Errorf("hidden mail to %s", msg.To)
+				}
+				hidden++
+			}
+		case <-timeout:
+			t.Fatalf("%d hidden mails sent, want 2", hidden)
+		}
+	}
+}
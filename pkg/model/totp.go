@@ -0,0 +1,507 @@
+package model
+
+import "crypto/hmac"
+import "crypto/rand"
+import "crypto/sha1"
+import "crypto/subtle"
+import "encoding/binary"
+import "errors"
+import "fmt"
+import "log/slog"
+import "net/url"
+import "slices"
+import "strings"
+import "time"
+
+import "github.com/oklog/ulid/v2"
+import "golang.org/x/crypto/bcrypt"
+
+const (
+	// TOTPIssuer is the issuer in the provisioning URIs of TOTP secrets.
+	TOTPIssuer = "Qrochet"
+
+	// TOTPDigits is the amount of digits of TOTP codes.
+	TOTPDigits = 6
+
+	// TOTPPeriod is how long a TOTP code is valid.
+	TOTPPeriod = 30 * time.Second
+
+	// TOTPSkew is the amount of periods before and after the current one
+	// whose codes are also accepted, for clocks that are a bit off.
+	TOTPSkew = 1
+
+	// RecoveryCodes is the amount of recovery codes a user gets.
+	RecoveryCodes = 10
+
+	// LoginTicketTTL is how long a user has for the second step of a log in.
+	LoginTicketTTL = 5 * time.Minute
+
+	// LoginTicketTries is how many codes may be tried with a log in ticket.
+	LoginTicketTries = 3
+
+	// policyKey is the key of the security policy.
+	policyKey = "security"
+)
+
+var (
+	// ErrorSecondFactor means the password was correct, but the user must
+	// also enter a code of their authenticator app or a recovery code.
+	ErrorSecondFactor = errors.New("please enter the code of your authenticator app or a recovery code")
+
+	// ErrorSecondFactorWrong means the code of the second step of a log in
+	// is not correct.
+	ErrorSecondFactorWrong = errors.New("the code is not correct")
+
+	// ErrorLoginTicket means the log in ticket of the second step of a log
+	// in is not valid anymore.
+	ErrorLoginTicket = errors.New("the log in expired, please log in again")
+
+	// ErrorTOTPNotPending means there is no TOTP enrollment to confirm.
+	ErrorTOTPNotPending = errors.New("please start setting up two factor authentication first")
+
+	// ErrorTOTPNotEnabled means the user does not have TOTP enabled.
+	ErrorTOTPNotEnabled = errors.New("two factor authentication is not enabled")
+
+	// ErrorTOTPRequired means the role of the user requires two factor
+	// authentication, but the user did not set it up yet, or wants to
+	// disable it.
+	ErrorTOTPRequired = errors.New("your role requires two factor authentication, please set it up first")
+
+	// ErrorUserNotSaved means saving the changes of a user failed.
+	ErrorUserNotSaved = errors.New("saving the user failed")
+
+	// ErrorPolicyChange means changing the security policy failed.
+	ErrorPolicyChange = errors.New("policy change failed")
+)
+
+// SecondFactorError is returned by Login when the password is correct,
+// but the user must also complete the second step with LoginSecondFactor
+// and the Ticket.
+type SecondFactorError struct {
+	Ticket string
+}
+
+func (e *SecondFactorError) Error() string {
+	return ErrorSecondFactor.Error()
+}
+
+func (e *SecondFactorError) Unwrap() error {
+	return ErrorSecondFactor
+}
+
+// LoginTicket is the state of a log in between its two steps.
+type LoginTicket struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+	Email  string `json:"email"`
+	Tries  int    `json:"tries"`
+}
+
+// TicketMapper is a data mapper for log in tickets. Its entries expire
+// after LoginTicketTTL.
+type TicketMapper interface {
+	BasicMapper[LoginTicket]
+}
+
+// Policy is the security policy of Qrochet that staff can set.
+type Policy struct {
+	// TOTPRoles are the roles that require two factor authentication.
+	TOTPRoles []Role `json:"totp_roles"`
+}
+
+// RequiresTOTP returns whether the role requires two factor authentication.
+func (p Policy) RequiresTOTP(role Role) bool {
+	return slices.Contains(p.TOTPRoles, role)
+}
+
+// PolicyMapper is a data mapper for the security policy.
+type PolicyMapper interface {
+	BasicMapper[Policy]
+}
+
+// NewTOTPSecret returns a new random TOTP secret, base32 encoded.
+func NewTOTPSecret() string {
+	buf := make([]byte, 20)
+	rand.Read(buf)
+	return b32.EncodeToString(buf)
+}
+
+// TOTPURI returns the provisioning URI of the TOTP secret for the account,
+// that authenticator apps can read from a QR code or a link.
+func TOTPURI(secret, account string) string {
+	label := url.PathEscape(TOTPIssuer + ":" + account)
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", TOTPIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(TOTPDigits))
+	query.Set("period", fmt.Sprint(int(TOTPPeriod.Seconds())))
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// TOTPCode returns the TOTP code of the secret for the time step, as in
+// RFC 6238 with HMAC-SHA1.
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := b32.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for range TOTPDigits {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, value%mod), nil
+}
+
+// TOTPStep returns the TOTP time step of the time.
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / int64(TOTPPeriod.Seconds())
+}
+
+// checkTOTP checks the code against the secret around the time, and returns
+// the step of the code. Codes of steps up to last were used already, and
+// are not accepted again.
+func checkTOTP(secret, code string, t time.Time, last int64) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != TOTPDigits {
+		return 0, false
+	}
+	now := TOTPStep(t)
+	for step := now - TOTPSkew; step <= now+TOTPSkew; step++ {
+		if step <= last {
+			continue
+		}
+		expected, err := TOTPCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// newRecoveryCodes returns new recovery codes and their bcrypt hashes.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, RecoveryCodes)
+	hashes := make([]string, RecoveryCodes)
+	for i := range codes {
+		buf := make([]byte, 5)
+		rand.Read(buf)
+		code := strings.ToLower(b32.EncodeToString(buf))
+		codes[i] = code[:4] + "-" + code[4:]
+		hash, err := bcrypt.GenerateFromPassword([]byte(codes[i]), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, nil, err
+		}
+		hashes[i] = string(hash)
+	}
+	return codes, hashes, nil
+}
+
+// checkSecondFactor checks the TOTP code or recovery code of the user, and
+// updates the user so the code cannot be used again. It returns whether a
+// recovery code was used. The caller must store the user with updateUser.
+func checkSecondFactor(user *User, code string) (recovery bool, ok bool) {
+	step, ok := checkTOTP(user.TOTP, code, time.Now(), user.TOTPStep)
+	if ok {
+		user.TOTPStep = step
+		return false, true
+	}
+
+	code = strings.ToLower(strings.TrimSpace(code))
+	for i, hash := range user.Recovery {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(code)) == nil {
+			user.Recovery = slices.Delete(user.Recovery, i, i+1)
+			return true, true
+		}
+	}
+	return false, false
+}
+
+// Policy returns the security policy.
+func (l *Logic) Policy(ctx Context) Policy {
+	policy, err := l.Repository.Policy().Get(ctx, policyKey)
+	if err != nil {
+		return Policy{}
+	}
+	return policy
+}
+
+// SetTOTPRequired sets whether the role requires two factor authentication.
+// Only staff may change the security policy.
+func (l *Logic) SetTOTPRequired(ctx Context, session *Session, role Role, required bool) (*Policy, error) {
+	staff, err := l.Staff(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := role.MarshalText(); err != nil {
+		return nil, err
+	}
+
+	policy := l.Policy(ctx)
+	policy.TOTPRoles = slices.DeleteFunc(policy.TOTPRoles, func(r Role) bool { return r == role })
+	if required {
+		policy.TOTPRoles = append(policy.TOTPRoles, role)
+		slices.Sort(policy.TOTPRoles)
+	}
+	policy, err = l.Repository.Policy().Put(ctx, policyKey, policy)
+	if err != nil {
+		slog.Error("Policy.Put", "err", err)
+		return nil, ErrorPolicyChange
+	}
+	l.auditBy(ctx, AuditPolicyChange, staff.ID, "", fmt.Sprintf("two factor authentication for %s required: %t", role, required))
+	return &policy, nil
+}
+
+// RequiresTOTP returns whether the user must set up two factor
+// authentication before doing anything else.
+func (l *Logic) RequiresTOTP(ctx Context, user User) bool {
+	return user.TOTP == "" && l.Policy(ctx).RequiresTOTP(user.Role)
+}
+
+// CheckSession returns the user of the session. It fails with
+// ErrorTOTPRequired if the role of the user requires two factor
+// authentication that the user did not set up yet, so entry points that
+// cannot set it up refuse such sessions.
+func (l *Logic) CheckSession(ctx Context, session *Session) (*User, error) {
+	user, err := l.sessionUser(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	if l.RequiresTOTP(ctx, *user) {
+		return nil, ErrorTOTPRequired
+	}
+	return user, nil
+}
+
+// sessionUser returns the user of the session.
+func (l *Logic) sessionUser(ctx Context, session *Session) (*User, error) {
+	if session == nil || session.UserID == "" {
+		return nil, ErrorPleaseLogIn
+	}
+	user, err := l.User().Get(ctx, session.UserID)
+	if err != nil {
+		slog.Error("User.Get", "err", err, "user", session.UserID)
+		return nil, ErrorPleaseLogIn
+	}
+	return &user, nil
+}
+
+// updateUser changes the user with the ID with compare and swap, so that
+// the checks that change makes hold for the user that is stored, and
+// changes made at the same time are not lost. It returns the error of
+// change, ErrorUserNotFound if there is no such user, or fail if the user
+// could not be stored.
+func (l *Logic) updateUser(ctx Context, id string, fail error, change func(user *User) error) (*User, error) {
+	var changeErr error
+	user, err := l.User().Update(ctx, id, func(user *User, found bool) error {
+		changeErr = ErrorUserNotFound
+		if found {
+			changeErr = change(user)
+		}
+		return changeErr
+	})
+	if changeErr != nil {
+		return nil, changeErr
+	}
+	if err != nil {
+		slog.Error("User.Update", "err", err, "user", id)
+		return nil, fail
+	}
+	return &user, nil
+}
+
+// updateSessionUser is updateUser for the user of the session.
+func (l *Logic) updateSessionUser(ctx Context, session *Session, fail error, change func(user *User) error) (*User, error) {
+	if session == nil || session.UserID == "" {
+		return nil, ErrorPleaseLogIn
+	}
+	user, err := l.updateUser(ctx, session.UserID, fail, change)
+	if err == ErrorUserNotFound {
+		return nil, ErrorPleaseLogIn
+	}
+	return user, err
+}
+
+// EnrollTOTP starts setting up two factor authentication for the user of
+// the session. It returns the new secret and its provisioning URI, which
+// the user must confirm with a code from their app with ConfirmTOTP.
+func (l *Logic) EnrollTOTP(ctx Context, session *Session) (string, string, error) {
+	secret := NewTOTPSecret()
+	user, err := l.updateSessionUser(ctx, session, ErrorUserNotSaved, func(user *User) error {
+		user.TOTPPending = secret
+		return nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return user.TOTPPending, TOTPURI(user.TOTPPending, user.Email), nil
+}
+
+// ConfirmTOTP enables two factor authentication for the user of the
+// session if the code matches the secret of EnrollTOTP. It returns the
+// recovery codes, which are only stored hashed, so the user must write
+// them down now.
+func (l *Logic) ConfirmTOTP(ctx Context, session *Session, code string) ([]string, error) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		slog.Error("newRecoveryCodes", "err", err)
+		return nil, ErrorUserNotSaved
+	}
+	user, err := l.updateSessionUser(ctx, session, ErrorUserNotSaved, func(user *User) error {
+		if user.TOTPPending == "" {
+			return ErrorTOTPNotPending
+		}
+		step, ok := checkTOTP(user.TOTPPending, code, time.Now(), 0)
+		if !ok {
+			return ErrorSecondFactorWrong
+		}
+		user.TOTP = user.TOTPPending
+		user.TOTPPending = ""
+		user.TOTPStep = step
+		user.Recovery = hashes
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	l.Audit(ctx, AuditTOTPEnable, user.ID, "")
+	return codes, nil
+}
+
+// NewRecoveryCodes replaces the recovery codes of the user of the session,
+// after checking a code of their app.
+func (l *Logic) NewRecoveryCodes(ctx Context, session *Session, code string) ([]string, error) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		slog.Error("newRecoveryCodes", "err", err)
+		return nil, ErrorUserNotSaved
+	}
+	user, err := l.updateSessionUser(ctx, session, ErrorUserNotSaved, func(user *User) error {
+		if user.TOTP == "" {
+			return ErrorTOTPNotEnabled
+		}
+		step, ok := checkTOTP(user.TOTP, code, time.Now(), user.TOTPStep)
+		if !ok {
+			return ErrorSecondFactorWrong
+		}
+		user.TOTPStep = step
+		user.Recovery = hashes
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	l.Audit(ctx, AuditRecoveryCodes, user.ID, "")
+	return codes, nil
+}
+
+// DisableTOTP disables two factor authentication for the user of the
+// session, after checking a code of their app or a recovery code. Users
+// whose role requires it cannot disable it.
+func (l *Logic) DisableTOTP(ctx Context, session *Session, code string) error {
+	policy := l.Policy(ctx)
+	user, err := l.updateSessionUser(ctx, session, ErrorUserNotSaved, func(user *User) error {
+		if user.TOTP == "" {
+			return ErrorTOTPNotEnabled
+		}
+		if policy.RequiresTOTP(user.Role) {
+			return ErrorTOTPRequired
+		}
+		if _, ok := checkSecondFactor(user, code); !ok {
+			return ErrorSecondFactorWrong
+		}
+		user.TOTP = ""
+		user.TOTPStep = 0
+		user.Recovery = nil
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	l.Audit(ctx, AuditTOTPDisable, user.ID, "")
+	return nil
+}
+
+// secondFactor returns the error that starts the second step of a log in
+// of the user.
+func (l *Logic) secondFactor(ctx Context, user User) error {
+	ticket := LoginTicket{ID: ulid.Make().String(), UserID: user.ID, Email: user.Email}
+	_, err := l.Ticket().Put(ctx, ticket.ID, ticket)
+	if err != nil {
+		slog.Error("Ticket.Put", "err", err, "user", user.ID)
+		return ErrorSessionNotCreated
+	}
+	return &SecondFactorError{Ticket: ticket.ID}
+}
+
+// LoginSecondFactor completes a log in that Login started with a
+// SecondFactorError, with a code of the authenticator app of the user or
+// one of their recovery codes. Wrong codes count as failed log ins.
+func (l *Logic) LoginSecondFactor(ctx Context, ticketID, code string, sessionTimeout time.Duration) (*User, *Session, error) {
+	// The try is counted before the code is checked, with compare and
+	// swap, so tries at the same time cannot exceed LoginTicketTries.
+	ticket, err := l.Ticket().Update(ctx, ticketID, func(ticket *LoginTicket, found bool) error {
+		if !found || ticket.Tries >= LoginTicketTries {
+			return ErrorLoginTicket
+		}
+		ticket.Tries++
+		return nil
+	})
+	if err != nil {
+		return nil, nil, ErrorLoginTicket
+	}
+	err = l.checkAttempts(ctx, ticket.Email)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The code is checked and used up in one compare and swap, so that
+	// requests at the same time cannot use it twice.
+	var recovery bool
+	var failed *User
+	user, err := l.updateUser(ctx, ticket.UserID, ErrorSessionNotCreated, func(user *User) error {
+		var ok bool
+		recovery, ok = checkSecondFactor(user, code)
+		if !ok {
+			failed = user
+			return ErrorSecondFactorWrong
+		}
+		return nil
+	})
+	if err == ErrorSecondFactorWrong {
+		l.Audit(ctx, AuditLoginFailed, failed.ID, "wrong second factor")
+		l.failAttempt(ctx, ticket.Email, failed)
+		return nil, nil, err
+	}
+	if err == ErrorUserNotFound {
+		return nil, nil, ErrorLoginTicket
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	l.Ticket().Delete(ctx, ticket.ID)
+	l.resetAttempts(ctx, ticket.Email)
+
+	if recovery {
+		l.Audit(ctx, AuditRecoveryUse, user.ID, fmt.Sprintf("%d recovery codes left", len(user.Recovery)))
+	}
+
+	session, err := l.NewSession(ctx, *user, sessionTimeout)
+	if err != nil {
+		slog.Error("Logic.NewSession", "err", err, "user", user.ID)
+		return nil, nil, ErrorSessionNotCreated
+	}
+	l.Audit(ctx, AuditLogin, user.ID, "second factor")
+	return user, session, nil
+}
@@ -0,0 +1,191 @@
+package oidc
+
+import "context"
+import "crypto"
+import "crypto/ecdsa"
+import "crypto/elliptic"
+import "crypto/rsa"
+import "crypto/sha256"
+import "encoding/base64"
+import "encoding/json"
+import "fmt"
+import "math/big"
+import "strings"
+import "time"
+
+// header is the header of a JWS.
+type header struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+// JWK is a public JSON web key.
+type JWK struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid,omitempty"`
+	Use     string `json:"use,omitempty"`
+	N       string `json:"n,omitempty"`
+	E       string `json:"e,omitempty"`
+	Curve   string `json:"crv,omitempty"`
+	X       string `json:"x,omitempty"`
+	Y       string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON web key set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicKey returns the RSA or P-256 public key of the JWK.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31 {
+			return nil, fmt.Errorf("RSA exponent not valid")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil, fmt.Errorf("curve %q not supported", k.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("point not on curve")
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("key type %q not supported", k.KeyType)
+}
+
+// key returns the key with the ID, fetching the keys again if the ID is
+// not known and the keys were not fetched recently.
+func (p *Provider) key(ctx context.Context, id string) (crypto.PublicKey, error) {
+	config, err := p.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.lookup(id); ok {
+		return key, nil
+	}
+	if time.Since(p.fetched) < keysMinAge {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrorIDToken, id)
+	}
+
+	jwks := JWKS{}
+	err = p.getJSON(ctx, config.JWKSURI, &jwks)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrorKeys, err)
+	}
+	p.fetched = time.Now()
+	p.keys = map[string]crypto.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		p.keys[jwk.KeyID] = key
+	}
+	if key, ok := p.lookup(id); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: unknown key %q", ErrorIDToken, id)
+}
+
+// lookup returns the key with the ID. A token without key ID can only be
+// verified if the provider has one key.
+func (p *Provider) lookup(id string) (crypto.PublicKey, bool) {
+	if key, ok := p.keys[id]; ok {
+		return key, true
+	}
+	if id == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// verifySignature verifies the signature of the compact JWS token with
+// the keys of the provider, and decodes its payload into claims.
+func (p *Provider) verifySignature(ctx context.Context, token string, claims any) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("%w: not a JWS", ErrorIDToken)
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return fmt.Errorf("%w: header: %w", ErrorIDToken, err)
+	}
+	head := header{}
+	err = json.Unmarshal(buf, &head)
+	if err != nil {
+		return fmt.Errorf("%w: header: %w", ErrorIDToken, err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("%w: signature: %w", ErrorIDToken, err)
+	}
+
+	key, err := p.key(ctx, head.KeyID)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+	// The algorithm must match the type of the key, otherwise a token
+	// could for example claim "none" or use a public key as HMAC secret.
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if head.Algorithm != "RS256" {
+			return fmt.Errorf("%w: algorithm %q", ErrorIDToken, head.Algorithm)
+		}
+		err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig)
+		if err != nil {
+			return fmt.Errorf("%w: signature: %w", ErrorIDToken, err)
+		}
+	case *ecdsa.PublicKey:
+		if head.Algorithm != "ES256" || len(sig) != 64 {
+			return fmt.Errorf("%w: algorithm %q", ErrorIDToken, head.Algorithm)
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(key, digest[:], r, s) {
+			return fmt.Errorf("%w: signature", ErrorIDToken)
+		}
+	default:
+		return fmt.Errorf("%w: key type", ErrorIDToken)
+	}
+
+	buf, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("%w: payload: %w", ErrorIDToken, err)
+	}
+	err = json.Unmarshal(buf, claims)
+	if err != nil {
+		return fmt.Errorf("%w: payload: %w", ErrorIDToken, err)
+	}
+	return nil
+}
@@ -0,0 +1,318 @@
+// Package oidc logs in users with an OpenID Connect provider, using the
+// authorization code flow with PKCE.
+//
+// A Provider discovers the endpoints of the issuer from its
+// /.well-known/openid-configuration document, and verifies the signature
+// of ID tokens with the keys of its JWKS. Only the standard library is
+// used, and only the RS256 and ES256 signatures are supported, which is
+// what providers use in practice.
+package oidc
+
+import "context"
+import "crypto"
+import "crypto/rand"
+import "crypto/sha256"
+import "encoding/base64"
+import "encoding/json"
+import "errors"
+import "fmt"
+import "io"
+import "net/http"
+import "net/url"
+import "strings"
+import "sync"
+import "time"
+
+const (
+	// DiscoveryPath is the path of the discovery document of an issuer.
+	DiscoveryPath = "/.well-known/openid-configuration"
+
+	// Skew is the clock skew that is allowed when checking the times of
+	// ID tokens.
+	Skew = time.Minute
+
+	// keysMinAge is how long the keys are kept before they are fetched
+	// again for an unknown key ID, so tokens with made up key IDs cannot
+	// make us fetch the keys all the time.
+	keysMinAge = time.Minute
+
+	// maxBody is the maximum size of the responses of the provider.
+	maxBody = 1 << 20
+)
+
+var (
+	// ErrorDiscovery means the discovery document could not be fetched
+	// or is not valid.
+	ErrorDiscovery = errors.New("oidc: discovery failed")
+
+	// ErrorKeys means the keys of the provider could not be fetched.
+	ErrorKeys = errors.New("oidc: fetching the keys failed")
+
+	// ErrorExchange means the code could not be exchanged for tokens.
+	ErrorExchange = errors.New("oidc: code exchange failed")
+
+	// ErrorIDToken means the ID token is not valid.
+	ErrorIDToken = errors.New("oidc: ID token not valid")
+)
+
+// Config is the part of the discovery document that is used.
+type Config struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported,omitempty"`
+}
+
+// Token is the response of the token endpoint.
+type Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	ExpiresIn   int    `json:"expires_in,omitempty"`
+
+	Error            string `json:"error,omitempty"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// Claims are the claims of an ID token that are used.
+type Claims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        audience `json:"aud"`
+	AuthorizedParty string   `json:"azp,omitempty"`
+	Expiry          int64    `json:"exp"`
+	IssuedAt        int64    `json:"iat"`
+	Nonce           string   `json:"nonce,omitempty"`
+	Email           string   `json:"email,omitempty"`
+	EmailVerified   boolean  `json:"email_verified,omitempty"`
+	Name            string   `json:"name,omitempty"`
+}
+
+// audience is the aud claim, which is a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(buf []byte) error {
+	var one string
+	if json.Unmarshal(buf, &one) == nil {
+		*a = audience{one}
+		return nil
+	}
+	return json.Unmarshal(buf, (*[]string)(a))
+}
+
+// boolean is a boolean claim, which some providers send as a string.
+type boolean bool
+
+func (b *boolean) UnmarshalJSON(buf []byte) error {
+	var s string
+	if json.Unmarshal(buf, &s) == nil {
+		*b = s == "true"
+		return nil
+	}
+	return json.Unmarshal(buf, (*bool)(b))
+}
+
+// Provider is an OpenID Connect provider with a registered client.
+// Its endpoints are discovered when they are first needed.
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string // ClientSecret is empty for public clients.
+	RedirectURL  string
+	Scopes       []string     // Scopes default to openid, email and profile.
+	Client       *http.Client // Client defaults to http.DefaultClient.
+
+	mu      sync.Mutex
+	config  *Config
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// Random returns a random string for the state, nonce and PKCE verifier.
+func Random() string {
+	buf := make([]byte, 32)
+	rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// Challenge returns the S256 PKCE code challenge of the verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (p *Provider) client() *http.Client {
+	if p.Client == nil {
+		return http.DefaultClient
+	}
+	return p.Client
+}
+
+// getJSON gets the JSON document at the URL into obj.
+func (p *Provider) getJSON(ctx context.Context, url string, obj any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := p.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, res.Status)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, maxBody)).Decode(obj)
+}
+
+// Discover returns the configuration of the provider, fetching the
+// discovery document the first time.
+func (p *Provider) Discover(ctx context.Context) (*Config, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.config != nil {
+		return p.config, nil
+	}
+
+	config := &Config{}
+	err := p.getJSON(ctx, strings.TrimSuffix(p.Issuer, "/")+DiscoveryPath, config)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrorDiscovery, err)
+	}
+	if config.Issuer != p.Issuer {
+		return nil, fmt.Errorf("%w: issuer is %q instead of %q", ErrorDiscovery, config.Issuer, p.Issuer)
+	}
+	if config.AuthorizationEndpoint == "" || config.TokenEndpoint == "" || config.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: endpoints missing", ErrorDiscovery)
+	}
+	p.config = config
+	return config, nil
+}
+
+// AuthURL returns the URL of the authorization endpoint to send the user
+// to. The state, nonce and verifier are made with Random, and must be
+// kept by the client for the callback.
+func (p *Provider) AuthURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	config, err := p.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	scopes := p.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.ClientID)
+	query.Set("redirect_uri", p.RedirectURL)
+	query.Set("scope", strings.Join(scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", Challenge(verifier))
+	query.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(config.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return config.AuthorizationEndpoint + sep + query.Encode(), nil
+}
+
+// Exchange exchanges the code of the callback for tokens.
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (*Token, error) {
+	config, err := p.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("code_verifier", verifier)
+	if p.ClientSecret == "" {
+		form.Set("client_id", p.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", config.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+	res, err := p.client().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrorExchange, err)
+	}
+	defer res.Body.Close()
+
+	token := &Token{}
+	err = json.NewDecoder(io.LimitReader(res.Body, maxBody)).Decode(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrorExchange, res.Status, err)
+	}
+	if res.StatusCode != http.StatusOK || token.Error != "" {
+		return nil, fmt.Errorf("%w: %s: %s %s", ErrorExchange, res.Status, token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return nil, fmt.Errorf("%w: no ID token", ErrorExchange)
+	}
+	return token, nil
+}
+
+// Verify verifies the signature and the claims of the ID token, and
+// returns its claims.
+func (p *Provider) Verify(ctx context.Context, idToken, nonce string) (*Claims, error) {
+	claims := &Claims{}
+	err := p.verifySignature(ctx, idToken, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	switch {
+	case claims.Issuer != p.Issuer:
+		return nil, fmt.Errorf("%w: issuer %q", ErrorIDToken, claims.Issuer)
+	case !claims.hasAudience(p.ClientID):
+		return nil, fmt.Errorf("%w: audience %q", ErrorIDToken, claims.Audience)
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.ClientID:
+		return nil, fmt.Errorf("%w: authorized party %q", ErrorIDToken, claims.AuthorizedParty)
+	case now.After(time.Unix(claims.Expiry, 0).Add(Skew)):
+		return nil, fmt.Errorf("%w: expired", ErrorIDToken)
+	case time.Unix(claims.IssuedAt, 0).After(now.Add(Skew)):
+		return nil, fmt.Errorf("%w: issued in the future", ErrorIDToken)
+	case claims.Nonce != nonce:
+		return nil, fmt.Errorf("%w: nonce", ErrorIDToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: no subject", ErrorIDToken)
+	}
+	return claims, nil
+}
+
+// Callback exchanges the code of the callback and verifies the ID token,
+// and returns its claims.
+func (p *Provider) Callback(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	token, err := p.Exchange(ctx, code, verifier)
+	if err != nil {
+		return nil, err
+	}
+	return p.Verify(ctx, token.IDToken, nonce)
+}
+
+func (c Claims) hasAudience(clientID string) bool {
+	for _, aud := range c.Audience {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// Verified returns whether the provider verified the email address.
+func (c Claims) Verified() bool {
+	return bool(c.EmailVerified)
+}
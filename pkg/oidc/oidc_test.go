@@ -0,0 +1,117 @@
+package oidc_test
+
+import "context"
+import "errors"
+import "net/http"
+import "net/url"
+import "strings"
+import "testing"
+
+import "github.com/qrochet/qrochet/pkg/oidc"
+import "github.com/qrochet/qrochet/pkg/oidc/oidctest"
+
+// authorize follows the auth URL to the stand-in provider and returns the
+// query of the redirect back.
+func authorize(t *testing.T, client *http.Client, authURL string) url.Values {
+	t.Helper()
+	noFollow := *client
+	noFollow.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	res, err := noFollow.Get(authURL)
+	if err != nil {
+		t.Fatalf("authorize: %s", err)
+	}
+	res.Body.Close()
+	back, err := url.Parse(res.Header.Get("Location"))
+	if err != nil || res.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: %s %v", res.Status, err)
+	}
+	return back.Query()
+}
+
+func TestProvider(t *testing.T) {
+	srv := oidctest.NewServer("qrochet")
+	defer srv.Close()
+	user := oidctest.User{Subject: "42", Email: "bob@example.com", EmailVerified: true, Name: "Bob"}
+	srv.SetUser(user)
+
+	ctx := context.Background()
+	p := &oidc.Provider{
+		Issuer:      srv.URL,
+		ClientID:    "qrochet",
+		RedirectURL: "http://localhost/login/oidc",
+		Client:      srv.Client(),
+	}
+
+	state, nonce, verifier := oidc.Random(), oidc.Random(), oidc.Random()
+	authURL, err := p.AuthURL(ctx, state, nonce, verifier)
+	if err != nil {
+		t.Fatalf("AuthURL: %s", err)
+	}
+	back := authorize(t, srv.Client(), authURL)
+	if back.Get("state") != state {
+		t.Fatalf("state: %q", back.Get("state"))
+	}
+
+	_, err = p.Callback(ctx, back.Get("code"), oidc.Random(), nonce)
+	if !errors.Is(err, oidc.ErrorExchange) {
+		t.Errorf("Callback with wrong verifier: %v", err)
+	}
+
+	back = authorize(t, srv.Client(), authURL)
+	claims, err := p.Callback(ctx, back.Get("code"), verifier, nonce)
+	if err != nil {
+		t.Fatalf("Callback: %s", err)
+	}
+	if claims.Subject != user.Subject || claims.Email != user.Email || !claims.Verified() || claims.Name != user.Name {
+		t.Errorf("claims: %+v", claims)
+	}
+
+	_, err = p.Callback(ctx, back.Get("code"), verifier, nonce)
+	if !errors.Is(err, oidc.ErrorExchange) {
+		t.Errorf("Callback with used code: %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	srv := oidctest.NewServer("qrochet")
+	defer srv.Close()
+	user := oidctest.User{Subject: "42", Email: "bob@example.com", EmailVerified: true}
+
+	ctx := context.Background()
+	p := &oidc.Provider{Issuer: srv.URL, ClientID: "qrochet", Client: srv.Client()}
+
+	_, err := p.Verify(ctx, srv.Sign(srv.Claims(user, "nonce")), "nonce")
+	if err != nil {
+		t.Fatalf("Verify: %s", err)
+	}
+
+	bad := map[string]func(map[string]any){
+		"nonce":    func(c map[string]any) { c["nonce"] = "other" },
+		"audience": func(c map[string]any) { c["aud"] = "other" },
+		"issuer":   func(c map[string]any) { c["iss"] = "https://evil.example.com" },
+		"expired":  func(c map[string]any) { c["exp"] = int64(1) },
+		"subject":  func(c map[string]any) { delete(c, "sub") },
+	}
+	for name, change := range bad {
+		claims := srv.Claims(user, "nonce")
+		change(claims)
+		_, err = p.Verify(ctx, srv.Sign(claims), "nonce")
+		if !errors.Is(err, oidc.ErrorIDToken) {
+			t.Errorf("Verify with bad %s: %v", name, err)
+		}
+	}
+
+	token := srv.Sign(srv.Claims(user, "nonce"))
+	parts := strings.Split(token, ".")
+	other := strings.Split(srv.Sign(srv.Claims(oidctest.User{Subject: "666"}, "nonce")), ".")
+	_, err = p.Verify(ctx, parts[0]+"."+other[1]+"."+parts[2], "nonce")
+	if !errors.Is(err, oidc.ErrorIDToken) {
+		t.Errorf("Verify with changed payload: %v", err)
+	}
+	_, err = p.Verify(ctx, `eyJhbGciOiJub25lIn0.`+other[1]+".", "nonce")
+	if !errors.Is(err, oidc.ErrorIDToken) {
+		t.Errorf("Verify without signature: %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+// Package oidctest is a stand-in OpenID Connect provider for tests.
+package oidctest
+
+import "crypto"
+import "crypto/rand"
+import "crypto/rsa"
+import "crypto/sha256"
+import "encoding/base64"
+import "encoding/json"
+import "math/big"
+import "net/http"
+import "net/http/httptest"
+import "net/url"
+import "sync"
+import "time"
+
+import "github.com/qrochet/qrochet/pkg/oidc"
+
+// KeyID is the ID of the signing key of the Server.
+const KeyID = "test"
+
+// User is the user that logs in at the Server.
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// grant is an issued authorization code.
+type grant struct {
+	nonce     string
+	challenge string
+	redirect  string
+	user      User
+}
+
+// Server is a stand-in OpenID Connect provider. Its authorization endpoint
+// logs in User without asking, and redirects back with a code.
+type Server struct {
+	*httptest.Server
+	ClientID string
+	Key      *rsa.PrivateKey
+
+	mu    sync.Mutex
+	user  User
+	codes map[string]grant
+}
+
+// NewServer starts a stand-in provider for the client ID. Close it when
+// done.
+func NewServer(clientID string) *Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	s := &Server{ClientID: clientID, Key: key, codes: map[string]grant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+oidc.DiscoveryPath, s.discovery)
+	mux.HandleFunc("GET /authorize", s.authorize)
+	mux.HandleFunc("POST /token", s.token)
+	mux.HandleFunc("GET /jwks", s.jwks)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// SetUser sets the user that logs in next.
+func (s *Server) SetUser(user User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.user = user
+}
+
+// Sign returns an RS256 JWS of the claims, signed with Key.
+func (s *Server) Sign(claims map[string]any) string {
+	head, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": KeyID})
+	body, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(head) + "." + base64.RawURLEncoding.EncodeToString(body)
+	digest := sha256.Sum256([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.Key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// Claims returns the claims of an ID token of the user for the nonce.
+func (s *Server) Claims(user User, nonce string) map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"iss":            s.URL,
+		"sub":            user.Subject,
+		"aud":            s.ClientID,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          nonce,
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+		"name":           user.Name,
+	}
+}
+
+func writeJSON(wr http.ResponseWriter, status int, obj any) {
+	wr.Header().Set("Content-Type", "application/json")
+	wr.WriteHeader(status)
+	json.NewEncoder(wr).Encode(obj)
+}
+
+func (s *Server) discovery(wr http.ResponseWriter, req *http.Request) {
+	writeJSON(wr, http.StatusOK, oidc.Config{
+		Issuer:                s.URL,
+		AuthorizationEndpoint: s.URL + "/authorize",
+		TokenEndpoint:         s.URL + "/token",
+		JWKSURI:               s.URL + "/jwks",
+		CodeChallengeMethods:  []string{"S256"},
+	})
+}
+
+func (s *Server) jwks(wr http.ResponseWriter, req *http.Request) {
+	pub := s.Key.PublicKey
+	writeJSON(wr, http.StatusOK, oidc.JWKS{Keys: []oidc.JWK{{
+		KeyType: "RSA",
+		KeyID:   KeyID,
+		Use:     "sig",
+		N:       base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}}})
+}
+
+func (s *Server) authorize(wr http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || query.Get("client_id") != s.ClientID || query.Get("response_type") != "code" ||
+		query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(wr, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	code := oidc.Random()
+	s.codes[code] = grant{
+		nonce:     query.Get("nonce"),
+		challenge: query.Get("code_challenge"),
+		redirect:  redirect.String(),
+		user:      s.user,
+	}
+	s.mu.Unlock()
+
+	back := redirect.Query()
+	back.Set("code", code)
+	back.Set("state", query.Get("state"))
+	redirect.RawQuery = back.Encode()
+	http.Redirect(wr, req, redirect.String(), http.StatusFound)
+}
+
+func (s *Server) token(wr http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	s.mu.Lock()
+	code := req.PostForm.Get("code")
+	g, ok := s.codes[code]
+	delete(s.codes, code)
+	s.mu.Unlock()
+
+	clientID, _, basic := req.BasicAuth()
+	if !basic {
+		clientID = req.PostForm.Get("client_id")
+	}
+	switch {
+	case req.PostForm.Get("grant_type") != "authorization_code",
+		clientID != s.ClientID:
+		writeJSON(wr, http.StatusBadRequest, oidc.Token{Error: "invalid_request"})
+	case !ok,
+		req.PostForm.Get("redirect_uri") != g.redirect,
+		oidc.Challenge(req.PostForm.Get("code_verifier")) != g.challenge:
+		writeJSON(wr, http.StatusBadRequest, oidc.Token{Error: "invalid_grant"})
+	default:
+		writeJSON(wr, http.StatusOK, oidc.Token{
+			AccessToken: oidc.Random(),
+			TokenType:   "Bearer",
+			IDToken:     s.Sign(s.Claims(g.user, g.nonce)),
+			ExpiresIn:   3600,
+		})
+	}
+}
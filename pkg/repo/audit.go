@@ -0,0 +1,116 @@
+package repo
+
+import "encoding/json"
+import "iter"
+import "time"
+
+import "github.com/nats-io/nats.go/jetstream"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+const (
+	// AuditSubject is the prefix of the subjects of audit entries.
+	// The user ID of the entry follows it.
+	AuditSubject = "qro.audit."
+
+	// auditMaxAge is how long audit entries are kept.
+	auditMaxAge = 2 * 365 * 24 * time.Hour
+
+	// auditWait is how long a query waits for the next entry.
+	auditWait = 5 * time.Second
+)
+
+// AuditStream is a model.AuditLog on a JetStream stream that does not allow
+// deleting or purging messages, so entries can only be appended.
+type AuditStream struct {
+	*Repository
+	jetstream.Stream
+}
+
+var _ model.AuditLog = &AuditStream{}
+
+// NewAuditStream returns the audit log of the repository, creating its
+// stream as needed.
+func NewAuditStream(ctx Context, r *Repository) (*AuditStream, error) {
+	var err error
+	a := &AuditStream{Repository: r}
+	a.Stream, err = r.JetStream.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:       MapperPrefix + "audit",
+		Subjects:   []string{AuditSubject + "*"},
+		MaxAge:     auditMaxAge,
+		DenyDelete: true,
+		DenyPurge:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Append appends the entry to the stream.
+func (a *AuditStream) Append(ctx Context, entry model.AuditEntry) error {
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = a.JetStream.Publish(ctx, AuditSubject+entry.UserID, buf, jetstream.WithMsgID(entry.ID))
+	return err
+}
+
+// Query returns an iterator over the entries that match the query, oldest
+// first, using an ordered consumer that starts at From.
+func (a *AuditStream) Query(ctx Context, query model.AuditQuery) iter.Seq2[model.AuditEntry, error] {
+	return func(yield func(model.AuditEntry, error) bool) {
+		config := jetstream.OrderedConsumerConfig{
+			FilterSubjects: []string{AuditSubject + "*"},
+			DeliverPolicy:  jetstream.DeliverAllPolicy,
+		}
+		if query.UserID != "" {
+			config.FilterSubjects = []string{AuditSubject + query.UserID}
+		}
+		if !query.From.IsZero() {
+			config.DeliverPolicy = jetstream.DeliverByStartTimePolicy
+			config.OptStartTime = &query.From
+		}
+
+		cons, err := a.Stream.OrderedConsumer(ctx, config)
+		if err != nil {
+			yield(model.AuditEntry{}, err)
+			return
+		}
+		if cons.CachedInfo().NumPending == 0 {
+			return
+		}
+
+		count := 0
+		for {
+			msg, err := cons.Next(jetstream.FetchMaxWait(auditWait))
+			if err != nil {
+				yield(model.AuditEntry{}, err)
+				return
+			}
+
+			var entry model.AuditEntry
+			err = json.Unmarshal(msg.Data(), &entry)
+			if err != nil {
+				yield(model.AuditEntry{}, err)
+				return
+			}
+			if !query.To.IsZero() && entry.Time.After(query.To) {
+				return
+			}
+			if !yield(entry, nil) {
+				return
+			}
+			count++
+			if query.Amount > 0 && count >= query.Amount {
+				return
+			}
+
+			md, err := msg.Metadata()
+			if err != nil || md.NumPending == 0 {
+				return
+			}
+		}
+	}
+}
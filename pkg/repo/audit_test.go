@@ -0,0 +1,59 @@
+package repo
+
+import "context"
+import "testing"
+import "time"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+func TestAuditStream(t *testing.T) {
+	r, err := Open("nats+builtin://" + t.TempDir())
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer r.Close()
+	ctx := context.Background()
+
+	start := time.Now()
+	entries := []model.AuditEntry{
+		{ID: "1", Time: start, Action: model.AuditRegister, UserID: "alice", ActorID: "alice"},
+		{ID: "2", Time: start, Action: model.AuditLoginFailed, UserID: "bob", ActorID: "bob", Detail: "wrong password"},
+		{ID: "3", Time: start, Action: model.AuditLogin, UserID: "alice", ActorID: "alice", IP: "192.0.2.1"},
+	}
+	for _, entry := range entries {
+		err = r.Audits().Append(ctx, entry)
+		if err != nil {
+			t.Fatalf("Append: %s", err)
+		}
+	}
+
+	all, err := model.Collect(r.Audits().Query(ctx, model.AuditQuery{}))
+	if err != nil || len(all) != 3 {
+		t.Fatalf("Query all: %v %+v", err, all)
+	}
+
+	alice, err := model.Collect(r.Audits().Query(ctx, model.AuditQuery{UserID: "alice"}))
+	if err != nil || len(alice) != 2 || alice[0].ID != "1" || alice[1].IP != "192.0.2.1" {
+		t.Fatalf("Query alice: %v %+v", err, alice)
+	}
+
+	first, err := model.Collect(r.Audits().Query(ctx, model.AuditQuery{Amount: 1}))
+	if err != nil || len(first) != 1 {
+		t.Fatalf("Query amount: %v %+v", err, first)
+	}
+
+	later, err := model.Collect(r.Audits().Query(ctx, model.AuditQuery{From: time.Now().Add(time.Hour)}))
+	if err != nil || len(later) != 0 {
+		t.Fatalf("Query later: %v %+v", err, later)
+	}
+
+	none, err := model.Collect(r.Audits().Query(ctx, model.AuditQuery{UserID: "carol"}))
+	if err != nil || len(none) != 0 {
+		t.Fatalf("Query carol: %v %+v", err, none)
+	}
+
+	err = r.audits.Stream.DeleteMsg(ctx, 1)
+	if err == nil {
+		t.Errorf("DeleteMsg: audit entry deleted")
+	}
+}
@@ -0,0 +1,42 @@
+package repo
+
+import "encoding/json"
+
+import "github.com/nats-io/nats.go/jetstream"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+// EmailChangeMapper is a mapper for email changes.
+type EmailChangeMapper struct {
+	// Inherit from BasicMapper
+	*BasicMapper[model.EmailChange]
+}
+
+func NewEmailChangeMapper(ctx Context, r *Repository, name string) (*EmailChangeMapper, error) {
+	var err error
+	res := &EmailChangeMapper{}
+	res.BasicMapper, err = NewTTLMapper[model.EmailChange](ctx, r, name, model.EmailChangeTTL)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Take gets the email change and deletes it at the revision it was read
+// at, like LinkMapper.Take.
+func (e *EmailChangeMapper) Take(ctx Context, id string) (model.EmailChange, error) {
+	var change model.EmailChange
+	entry, err := e.KeyValue.Get(ctx, id)
+	if err != nil {
+		return change, err
+	}
+	err = json.Unmarshal(entry.Value(), &change)
+	if err != nil {
+		return change, err
+	}
+	err = e.KeyValue.Delete(ctx, id, jetstream.LastRevision(entry.Revision()))
+	if err != nil {
+		return model.EmailChange{}, err
+	}
+	return change, nil
+}
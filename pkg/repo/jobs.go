@@ -0,0 +1,262 @@
+package repo
+
+import "context"
+import "encoding/json"
+import "errors"
+import "fmt"
+import "iter"
+import "log/slog"
+import "strconv"
+import "strings"
+import "time"
+
+import "github.com/nats-io/nats.go/jetstream"
+import "github.com/oklog/ulid/v2"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+const (
+	// JobSubject is the prefix of the subjects of queued jobs.
+	// The kind of the job follows it.
+	JobSubject = "qro.jobs."
+
+	// DeadSubject is the prefix of the subjects of dead letters.
+	DeadSubject = "qro.dead."
+
+	// JobMaxAttempts is how often a job is tried before it goes to the
+	// dead letters.
+	JobMaxAttempts = 6
+
+	// JobAckWait is how long a worker may work on a job before it is
+	// given to another worker.
+	JobAckWait = 5 * time.Minute
+
+	// deadMaxAge is how long dead letters are kept.
+	deadMaxAge = 30 * 24 * time.Hour
+)
+
+// JobBackoff are the delays before a failed job is tried again, by the
+// number of the attempt that failed. The last one is used for later ones.
+var JobBackoff = []time.Duration{
+	10 * time.Second,
+	30 * time.Second,
+	2 * time.Minute,
+	10 * time.Minute,
+	30 * time.Minute,
+}
+
+// JobQueue is a model.JobQueue on a JetStream work queue stream. Jobs that
+// fail too often or permanently are moved to a stream of dead letters.
+type JobQueue struct {
+	*Repository
+	jobs jetstream.Stream
+	dead jetstream.Stream
+}
+
+var _ model.JobQueue = &JobQueue{}
+
+// NewJobQueue returns the job queue of the repository, creating or
+// updating its streams as needed.
+func NewJobQueue(ctx Context, r *Repository) (*JobQueue, error) {
+	var err error
+	q := &JobQueue{Repository: r}
+
+	q.jobs, err = r.JetStream.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:       MapperPrefix + "jobs",
+		Subjects:   []string{JobSubject + "*"},
+		Retention:  jetstream.WorkQueuePolicy,
+		Duplicates: 10 * time.Minute,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	q.dead, err = r.JetStream.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:     MapperPrefix + "jobs-dead",
+		Subjects: []string{DeadSubject + "*"},
+		MaxAge:   deadMaxAge,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Enqueue queues a job of the kind with data encoded as JSON. The ID of
+// the job is used as the message ID, so JetStream drops duplicates.
+func (q *JobQueue) Enqueue(ctx Context, kind string, data any) (*model.Job, error) {
+	if kind == "" || strings.ContainsAny(kind, ".*> \t\r\n") {
+		return nil, fmt.Errorf("%w: kind %q not valid", model.ErrorJobEnqueue, kind)
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, errors.Join(model.ErrorJobEnqueue, err)
+	}
+	job := &model.Job{
+		ID:      ulid.Make().String(),
+		Kind:    kind,
+		Data:    raw,
+		Created: time.Now(),
+	}
+	buf, err := json.Marshal(job)
+	if err != nil {
+		return nil, errors.Join(model.ErrorJobEnqueue, err)
+	}
+
+	_, err = q.JetStream.Publish(ctx, JobSubject+kind, buf, jetstream.WithMsgID(job.ID))
+	if err != nil {
+		return nil, errors.Join(model.ErrorJobEnqueue, err)
+	}
+	return job, nil
+}
+
+// Work handles queued jobs with the handler for their kind until the
+// context is done. Several workers may work at the same time, each job is
+// given to one of them.
+func (q *JobQueue) Work(ctx Context, handlers map[string]model.JobHandler) error {
+	cons, err := q.jobs.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
+		Durable:   MapperPrefix + "worker",
+		AckPolicy: jetstream.AckExplicitPolicy,
+		AckWait:   JobAckWait,
+	})
+	if err != nil {
+		return err
+	}
+
+	cc, err := cons.Consume(func(msg jetstream.Msg) {
+		q.handle(ctx, msg, handlers)
+	})
+	if err != nil {
+		return err
+	}
+	<-ctx.Done()
+	cc.Drain()
+	return nil
+}
+
+// backoff returns how long to wait before trying a job again after the
+// attempt failed.
+func backoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	if attempt > len(JobBackoff) {
+		return JobBackoff[len(JobBackoff)-1]
+	}
+	return JobBackoff[attempt-1]
+}
+
+// handle handles one queued job.
+func (q *JobQueue) handle(ctx Context, msg jetstream.Msg, handlers map[string]model.JobHandler) {
+	var job model.Job
+	err := json.Unmarshal(msg.Data(), &job)
+	if err != nil {
+		job.Kind = strings.TrimPrefix(msg.Subject(), JobSubject)
+		job.Data = msg.Data()
+		q.bury(ctx, msg, job, errors.Join(model.ErrorJobPermanent, err))
+		return
+	}
+
+	md, err := msg.Metadata()
+	if err == nil {
+		job.Attempts = int(md.NumDelivered)
+	}
+	// A job that keeps timing out, for example because it crashes the
+	// worker, is not tried forever either.
+	if job.Attempts > JobMaxAttempts {
+		q.bury(ctx, msg, job, errors.New("too many attempts"))
+		return
+	}
+
+	handler, ok := handlers[job.Kind]
+	if !ok {
+		q.bury(ctx, msg, job, fmt.Errorf("%w: no handler for kind %q", model.ErrorJobPermanent, job.Kind))
+		return
+	}
+
+	hctx, cancel := context.WithTimeout(ctx, JobAckWait)
+	defer cancel()
+	err = handler(hctx, job)
+	if err == nil {
+		err = msg.Ack()
+		if err != nil {
+			slog.Error("job ack", "err", err, "job", job.ID, "kind", job.Kind)
+		}
+		return
+	}
+
+	if errors.Is(err, model.ErrorJobPermanent) || job.Attempts >= JobMaxAttempts {
+		q.bury(ctx, msg, job, err)
+		return
+	}
+
+	delay := backoff(job.Attempts)
+	slog.Warn("job failed, will retry", "err", err, "job", job.ID, "kind", job.Kind,
+		"attempt", job.Attempts, "delay", delay)
+	err = msg.NakWithDelay(delay)
+	if err != nil {
+		slog.Error("job nak", "err", err, "job", job.ID, "kind", job.Kind)
+	}
+}
+
+// bury moves the job to the dead letters with the error and removes it
+// from the queue.
+func (q *JobQueue) bury(ctx Context, msg jetstream.Msg, job model.Job, cause error) {
+	slog.Error("job failed permanently", "err", cause, "job", job.ID, "kind", job.Kind, "attempts", job.Attempts)
+	job.Error = cause.Error()
+	buf, err := json.Marshal(job)
+	if err != nil {
+		slog.Error("job dead letter", "err", err, "job", job.ID)
+		return
+	}
+
+	kind := job.Kind
+	if kind == "" {
+		kind = "unknown"
+	}
+	msgID := job.ID + "." + strconv.Itoa(job.Attempts)
+	_, err = q.JetStream.Publish(ctx, DeadSubject+kind, buf, jetstream.WithMsgID(msgID))
+	if err != nil {
+		// Leave the job in the queue to be tried again rather than lose it.
+		slog.Error("job dead letter", "err", err, "job", job.ID)
+		_ = msg.NakWithDelay(backoff(job.Attempts))
+		return
+	}
+
+	err = msg.Term()
+	if err != nil {
+		slog.Error("job term", "err", err, "job", job.ID)
+	}
+}
+
+// Dead returns an iterator over the dead letters, oldest first.
+func (q *JobQueue) Dead(ctx Context) iter.Seq2[model.Job, error] {
+	return func(yield func(model.Job, error) bool) {
+		info, err := q.dead.Info(ctx)
+		if err != nil {
+			yield(model.Job{}, err)
+			return
+		}
+
+		for seq := info.State.FirstSeq; seq > 0 && seq <= info.State.LastSeq; seq++ {
+			msg, err := q.dead.GetMsg(ctx, seq)
+			if errors.Is(err, jetstream.ErrMsgNotFound) {
+				continue
+			}
+			if err != nil {
+				yield(model.Job{}, err)
+				return
+			}
+
+			var job model.Job
+			err = json.Unmarshal(msg.Data, &job)
+			if err != nil {
+				err = fmt.Errorf("dead letter %d: %w", seq, err)
+			}
+			if !yield(job, err) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package repo
+
+import "context"
+import "errors"
+import "sync/atomic"
+import "testing"
+import "time"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+func TestJobQueue(t *testing.T) {
+	r, err := Open("nats+builtin://" + t.TempDir())
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer r.Close()
+
+	old := JobBackoff
+	JobBackoff = []time.Duration{10 * time.Millisecond}
+	defer func() { JobBackoff = old }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var flaky atomic.Int32
+	done := make(chan string, 3)
+	handlers := map[string]model.JobHandler{
+		"flaky": func(ctx context.Context, job model.Job) error {
+			if flaky.Add(1) < 3 {
+				return errors.New("not yet")
+			}
+			done <- string(job.Data)
+			return nil
+		},
+		"broken": func(ctx context.Context, job model.Job) error {
+			return errors.Join(model.ErrorJobPermanent, errors.New("broken"))
+		},
+	}
+
+	wctx, stop := context.WithCancel(ctx)
+	defer stop()
+	go r.Jobs().Work(wctx, handlers)
+
+	_, err = r.Jobs().Enqueue(ctx, "flaky", "wool")
+	if err != nil {
+		t.Fatalf("Enqueue flaky: %s", err)
+	}
+	broken, err := r.Jobs().Enqueue(ctx, "broken", nil)
+	if err != nil {
+		t.Fatalf("Enqueue broken: %s", err)
+	}
+	unknown, err := r.Jobs().Enqueue(ctx, "unknown", nil)
+	if err != nil {
+		t.Fatalf("Enqueue unknown: %s", err)
+	}
+	_, err = r.Jobs().Enqueue(ctx, "bad.kind", nil)
+	if !errors.Is(err, model.ErrorJobEnqueue) {
+		t.Errorf("Enqueue bad kind: %v", err)
+	}
+
+	select {
+	case data := <-done:
+		if data != `"wool"` {
+			t.Errorf("flaky data: %s", data)
+		}
+	case <-ctx.Done():
+		t.Fatalf("flaky job not done")
+	}
+	if flaky.Load() != 3 {
+		t.Errorf("flaky attempts: %d", flaky.Load())
+	}
+
+	var dead []model.Job
+	for len(dead) < 2 && ctx.Err() == nil {
+		dead, err = model.Collect(r.Jobs().Dead(ctx))
+		if err != nil {
+			t.Fatalf("Dead: %s", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(dead) != 2 {
+		t.Fatalf("Dead: %+v", dead)
+	}
+	ids := map[string]bool{dead[0].ID: true, dead[1].ID: true}
+	if !ids[broken.ID] || !ids[unknown.ID] || dead[0].Error == "" || dead[1].Error == "" {
+		t.Errorf("Dead jobs: %+v", dead)
+	}
+
+	info, err := r.jobs.jobs.Info(ctx)
+	if err != nil {
+		t.Fatalf("Info: %s", err)
+	}
+	if info.State.Msgs != 0 {
+		t.Errorf("jobs left in queue: %d", info.State.Msgs)
+	}
+}
@@ -0,0 +1,42 @@
+package repo
+
+import "encoding/json"
+
+import "github.com/nats-io/nats.go/jetstream"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+// LinkMapper is a mapper for log in links.
+type LinkMapper struct {
+	// Inherit from BasicMapper
+	*BasicMapper[model.LoginLink]
+}
+
+func NewLinkMapper(ctx Context, r *Repository, name string) (*LinkMapper, error) {
+	var err error
+	res := &LinkMapper{}
+	res.BasicMapper, err = NewTTLMapper[model.LoginLink](ctx, r, name, model.LoginLinkWindow)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Take gets the link and deletes it at the revision it was read at, so when
+// two requests take the same link at the same time, only one of them gets it.
+func (l *LinkMapper) Take(ctx Context, id string) (model.LoginLink, error) {
+	var link model.LoginLink
+	entry, err := l.KeyValue.Get(ctx, id)
+	if err != nil {
+		return link, err
+	}
+	err = json.Unmarshal(entry.Value(), &link)
+	if err != nil {
+		return link, err
+	}
+	err = l.KeyValue.Delete(ctx, id, jetstream.LastRevision(entry.Revision()))
+	if err != nil {
+		return model.LoginLink{}, err
+	}
+	return link, nil
+}
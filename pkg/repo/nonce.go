@@ -0,0 +1,47 @@
+package repo
+
+import "errors"
+import "time"
+
+import "github.com/nats-io/nats.go/jetstream"
+
+// NonceTTL is how long a NonceStore remembers used nonces. It must be at
+// least the TTL of the challenge tokens.
+const NonceTTL = time.Hour
+
+// NonceStore remembers the nonces of used challenge tokens in a key value
+// bucket whose entries expire after NonceTTL, so that a token can be used
+// only once for all instances that share it. It is a challenge.NonceStore.
+type NonceStore struct {
+	*Repository
+	jetstream.KeyValue
+}
+
+// NewNonceStore returns the nonce store of the repository, creating its
+// bucket as needed.
+func NewNonceStore(ctx Context, r *Repository) (*NonceStore, error) {
+	var err error
+	s := &NonceStore{Repository: r}
+	s.KeyValue, err = r.BucketTTL(ctx, "nonce", NonceTTL)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Use marks the nonce as used, and returns false if it already was. The
+// nonce is created in the bucket, so when two requests use the same nonce
+// at the same time, only one of them succeeds.
+func (s *NonceStore) Use(ctx Context, nonce string) (bool, error) {
+	if !validToken(nonce) {
+		return false, jetstream.ErrInvalidKey
+	}
+	_, err := s.KeyValue.Create(ctx, nonce, []byte{})
+	if errors.Is(err, jetstream.ErrKeyExists) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
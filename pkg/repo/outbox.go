@@ -0,0 +1,268 @@
+package repo
+
+import "encoding/json"
+import "errors"
+import "log/slog"
+import "time"
+
+import "github.com/nats-io/nats.go/jetstream"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+const (
+	// EventSubject is the prefix of the subjects of published domain
+	// events. The type of the event follows it.
+	EventSubject = "qro.events."
+
+	// eventDuplicates is how long JetStream remembers the IDs of events,
+	// so events that are relayed twice are only published once.
+	eventDuplicates = time.Hour
+
+	// eventMaxAge is how long published events are kept.
+	eventMaxAge = 90 * 24 * time.Hour
+
+	// outboxPurge is how often the relay purges the delete markers of
+	// relayed events from the outbox.
+	outboxPurge = 10 * time.Minute
+)
+
+// These are variables so tests can shorten them.
+var (
+	// outboxRetry is how long the relay waits before it tries to publish
+	// events again after publishing failed, and before it checks pending
+	// events again.
+	outboxRetry = 30 * time.Second
+
+	// outboxPending is how long an event may stay pending before the relay
+	// checks whether the change it is about was made.
+	outboxPending = time.Minute
+)
+
+// EventOutbox is a model.Outbox. Events are first stored in a key value
+// bucket, with the event ID as the key, and then relayed to a stream with
+// the event ID as the message ID, after which they are deleted from the
+// bucket. An event that was published but not deleted yet is published
+// again by the next relay, and dropped by JetStream as a duplicate.
+// Pending events are only relayed once they are committed, or once the
+// check of the relay finds that their change was made.
+type EventOutbox struct {
+	*Repository
+	jetstream.KeyValue
+	events jetstream.Stream
+}
+
+var _ model.Outbox = &EventOutbox{}
+
+// NewEventOutbox returns the outbox of the repository, creating its
+// bucket and stream as needed.
+func NewEventOutbox(ctx Context, r *Repository) (*EventOutbox, error) {
+	var err error
+	o := &EventOutbox{Repository: r}
+
+	o.KeyValue, err = r.Bucket(ctx, "outbox")
+	if err != nil {
+		return nil, err
+	}
+
+	o.events, err = r.JetStream.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:       MapperPrefix + "events",
+		Subjects:   []string{EventSubject + ">"},
+		Duplicates: eventDuplicates,
+		MaxAge:     eventMaxAge,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Record stores the pending event in the outbox.
+func (o *EventOutbox) Record(ctx Context, event model.Event) error {
+	event.Pending = true
+	buf, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = o.KeyValue.Create(ctx, event.ID, buf)
+	return err
+}
+
+// Commit marks the event in the outbox as made. If the relay dropped the
+// event in the meantime, it is stored again.
+func (o *EventOutbox) Commit(ctx Context, event model.Event) error {
+	event.Pending = false
+	buf, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = o.KeyValue.Put(ctx, event.ID, buf)
+	return err
+}
+
+// Cancel deletes the event from the outbox.
+func (o *EventOutbox) Cancel(ctx Context, event model.Event) error {
+	return o.KeyValue.Delete(ctx, event.ID)
+}
+
+// Relay publishes the committed events in the outbox, and the events that
+// are committed later, until ctx is done. Events that are pending for
+// longer than outboxPending are published or dropped by the check.
+func (o *EventOutbox) Relay(ctx Context, check model.EventCheck) error {
+	watcher, err := o.KeyValue.WatchAll(ctx, jetstream.IgnoreDeletes())
+	if err != nil {
+		return err
+	}
+	defer watcher.Stop()
+
+	purge := time.NewTicker(outboxPurge)
+	defer purge.Stop()
+	retry := time.NewTicker(outboxRetry)
+	defer retry.Stop()
+	failed := map[string]bool{}
+	pending := map[string]time.Time{}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-purge.C:
+			err = o.KeyValue.PurgeDeletes(ctx, jetstream.DeleteMarkersOlderThan(outboxPurge))
+			if err != nil {
+				slog.Error("outbox purge", "err", err)
+			}
+		case <-retry.C:
+			for key := range failed {
+				entry, err := o.KeyValue.Get(ctx, key)
+				if err == nil {
+					err = o.relay(ctx, entry)
+				}
+				if err == nil || errors.Is(err, jetstream.ErrKeyNotFound) {
+					delete(failed, key)
+				}
+			}
+			for key, since := range pending {
+				if time.Since(since) < outboxPending {
+					continue
+				}
+				err = o.settle(ctx, key, check)
+				if err != nil {
+					slog.Error("outbox check", "err", err, "key", key)
+					continue
+				}
+				delete(pending, key)
+			}
+		case entry, ok := <-watcher.Updates():
+			if !ok {
+				return nil
+			}
+			if entry == nil {
+				continue
+			}
+			var event model.Event
+			if json.Unmarshal(entry.Value(), &event) == nil && event.Pending {
+				pending[entry.Key()] = entry.Created()
+				continue
+			}
+			delete(pending, entry.Key())
+			err = o.relay(ctx, entry)
+			if err != nil {
+				// The event stays in the outbox to be tried again.
+				slog.Error("outbox relay", "err", err, "key", entry.Key())
+				failed[entry.Key()] = true
+			}
+		}
+	}
+}
+
+// settle publishes the event of the outbox with the key that is still
+// pending if the check finds its change was made, or else drops it. An
+// event that was committed in the meantime is left to the watch of Relay.
+func (o *EventOutbox) settle(ctx Context, key string, check model.EventCheck) error {
+	entry, err := o.KeyValue.Get(ctx, key)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var event model.Event
+	err = json.Unmarshal(entry.Value(), &event)
+	if err != nil || !event.Pending {
+		return nil
+	}
+
+	made, err := check(ctx, event)
+	if err != nil {
+		return err
+	}
+	if made {
+		return o.relay(ctx, entry)
+	}
+	slog.Warn("outbox event change not made, dropped", "key", key, "type", event.Type)
+	// Only drop it if it was not committed since.
+	err = o.KeyValue.Delete(ctx, key, jetstream.LastRevision(entry.Revision()))
+	if conflict(err) {
+		return nil
+	}
+	return err
+}
+
+// relay publishes one event of the outbox and deletes it from the outbox.
+func (o *EventOutbox) relay(ctx Context, entry jetstream.KeyValueEntry) error {
+	var event model.Event
+	var buf []byte
+	err := json.Unmarshal(entry.Value(), &event)
+	if err == nil {
+		event.Pending = false
+		buf, err = json.Marshal(event)
+	}
+	if err != nil {
+		slog.Error("outbox event not valid, dropped", "err", err, "key", entry.Key())
+		return o.KeyValue.Delete(ctx, entry.Key())
+	}
+
+	_, err = o.JetStream.Publish(ctx, EventSubject+string(event.Type), buf,
+		jetstream.WithMsgID(event.ID))
+	if err != nil {
+		return err
+	}
+	// Committed events are not updated again, so if another relay
+	// deleted the event first, this only adds another delete marker.
+	return o.KeyValue.Delete(ctx, entry.Key())
+}
+
+// Subscribe calls the handler for every published event with a durable
+// consumer with the name, until ctx is done. Events for which the handler
+// fails are delivered again later.
+func (o *EventOutbox) Subscribe(ctx Context, name string, handler model.EventHandler) error {
+	cons, err := o.events.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
+		Durable:   MapperPrefix + name,
+		AckPolicy: jetstream.AckExplicitPolicy,
+	})
+	if err != nil {
+		return err
+	}
+
+	cc, err := cons.Consume(func(msg jetstream.Msg) {
+		var event model.Event
+		err := json.Unmarshal(msg.Data(), &event)
+		if err != nil {
+			slog.Error("event not valid", "err", err, "subscriber", name)
+			_ = msg.Term()
+			return
+		}
+		err = handler(ctx, event)
+		if err != nil {
+			slog.Warn("event handler failed, will retry", "err", err, "subscriber", name, "event", event.ID)
+			_ = msg.NakWithDelay(backoff(1))
+			return
+		}
+		_ = msg.Ack()
+	})
+	if err != nil {
+		return err
+	}
+	<-ctx.Done()
+	cc.Drain()
+	return nil
+}
@@ -0,0 +1,156 @@
+package repo
+
+import "context"
+import "testing"
+import "time"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+// receive returns the next event of the channel, failing the test if none
+// arrives in time.
+func receive(t *testing.T, got chan model.Event) model.Event {
+	t.Helper()
+	select {
+	case event := <-got:
+		return event
+	case <-time.After(10 * time.Second):
+		t.Fatalf("no event received")
+	}
+	return model.Event{}
+}
+
+func TestEventOutbox(t *testing.T) {
+	r, err := Open("nats+builtin://" + t.TempDir())
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	got := make(chan model.Event, 10)
+	go r.Outbox().Subscribe(ctx, "test", func(ctx context.Context, event model.Event) error {
+		got <- event
+		return nil
+	})
+	check := func(ctx context.Context, event model.Event) (bool, error) {
+		return false, nil
+	}
+
+	first := model.Event{ID: "1", Type: model.EventUserRegistered, UserID: "alice", Data: []byte(`{"name":"Alice"}`)}
+	second := model.Event{ID: "2", Type: model.EventCraftDeleted, UserID: "alice", Data: []byte(`{}`)}
+	cancelled := model.Event{ID: "3", Type: model.EventCraftCreated, UserID: "alice", Data: []byte(`{}`)}
+	err = r.Outbox().Record(ctx, first)
+	if err != nil {
+		t.Fatalf("Record: %s", err)
+	}
+	go r.Outbox().Relay(ctx, check)
+
+	// A pending event is not published before it is committed.
+	select {
+	case event := <-got:
+		t.Fatalf("pending event published: %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+	err = r.Outbox().Commit(ctx, first)
+	if err != nil {
+		t.Fatalf("Commit: %s", err)
+	}
+
+	event := receive(t, got)
+	if event.ID != "1" || event.Type != model.EventUserRegistered || event.Pending {
+		t.Fatalf("first event: %+v", event)
+	}
+	var payload model.UserRegistered
+	if event.Decode(&payload) != nil || payload.Name != "Alice" {
+		t.Errorf("first payload: %+v", payload)
+	}
+
+	// An event that is relayed again is published only once, and a
+	// cancelled event is not published.
+	err = r.Outbox().Commit(ctx, first)
+	if err != nil {
+		t.Fatalf("Commit again: %s", err)
+	}
+	err = r.Outbox().Record(ctx, cancelled)
+	if err != nil {
+		t.Fatalf("Record cancelled: %s", err)
+	}
+	err = r.Outbox().Cancel(ctx, cancelled)
+	if err != nil {
+		t.Fatalf("Cancel: %s", err)
+	}
+	err = r.Outbox().Record(ctx, second)
+	if err != nil {
+		t.Fatalf("Record second: %s", err)
+	}
+	err = r.Outbox().Commit(ctx, second)
+	if err != nil {
+		t.Fatalf("Commit second: %s", err)
+	}
+	event = receive(t, got)
+	if event.ID != "2" {
+		t.Fatalf("second event: %+v", event)
+	}
+
+	select {
+	case event = <-got:
+		t.Fatalf("unexpected event: %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestEventOutboxPending(t *testing.T) {
+	retry, pending := outboxRetry, outboxPending
+	outboxRetry, outboxPending = 50*time.Millisecond, 100*time.Millisecond
+	defer func() { outboxRetry, outboxPending = retry, pending }()
+
+	r, err := Open("nats+builtin://" + t.TempDir())
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	got := make(chan model.Event, 10)
+	go r.Outbox().Subscribe(ctx, "test", func(ctx context.Context, event model.Event) error {
+		got <- event
+		return nil
+	})
+
+	// Events left pending, as if the process stopped before Commit, are
+	// published if their change was made, and dropped if not.
+	made := model.Event{ID: "1", Type: model.EventCraftCreated, UserID: "alice", Data: []byte(`{}`)}
+	failed := model.Event{ID: "2", Type: model.EventCraftCreated, UserID: "bob", Data: []byte(`{}`)}
+	for _, event := range []model.Event{made, failed} {
+		err = r.Outbox().Record(ctx, event)
+		if err != nil {
+			t.Fatalf("Record: %s", err)
+		}
+	}
+	checked := make(chan string, 10)
+	go r.Outbox().Relay(ctx, func(ctx context.Context, event model.Event) (bool, error) {
+		checked <- event.ID
+		return event.UserID == "alice", nil
+	})
+
+	event := receive(t, got)
+	if event.ID != "1" || event.Pending {
+		t.Fatalf("pending event: %+v", event)
+	}
+	select {
+	case event = <-got:
+		t.Fatalf("unexpected event: %+v", event)
+	case <-time.After(500 * time.Millisecond):
+	}
+	if len(checked) != 2 {
+		t.Errorf("checked %d events", len(checked))
+	}
+	keys, _ := r.outbox.KeyValue.Keys(ctx)
+	if len(keys) != 0 {
+		t.Errorf("left in outbox: %v", keys)
+	}
+}
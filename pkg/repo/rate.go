@@ -0,0 +1,114 @@
+package repo
+
+import "crypto/sha256"
+import "encoding/hex"
+import "encoding/json"
+import "errors"
+import "time"
+
+import "github.com/nats-io/nats.go/jetstream"
+import "golang.org/x/time/rate"
+
+const (
+	// RateTTL is how long unused token buckets are kept.
+	RateTTL = 10 * time.Minute
+
+	// rateRetries is how often Take retries when another instance took a
+	// token from the same bucket at the same time.
+	rateRetries = 5
+)
+
+// ErrorRateConflict means a token could not be taken because other
+// instances kept updating the same bucket.
+var ErrorRateConflict = errors.New("rate bucket update conflict")
+
+// tokenBucket is a token bucket in the key value bucket of a RateStore.
+type tokenBucket struct {
+	Tokens float64   `json:"tokens"`
+	Time   time.Time `json:"time"`
+}
+
+// RateStore keeps token buckets for rate limiting in a key value bucket
+// whose entries expire after RateTTL, so that the rate limits hold for all
+// instances that share it. Buckets are updated with compare and swap.
+type RateStore struct {
+	*Repository
+	jetstream.KeyValue
+}
+
+// NewRateStore returns the rate store of the repository, creating its
+// bucket as needed.
+func NewRateStore(ctx Context, r *Repository) (*RateStore, error) {
+	var err error
+	s := &RateStore{Repository: r}
+	s.KeyValue, err = r.BucketTTL(ctx, "rate", RateTTL)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// rateKey returns the key of the bucket of a client. Keys are hashed,
+// since client keys may contain characters that keys may not.
+func rateKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// conflict returns whether err means the bucket was changed by someone else.
+func conflict(err error) bool {
+	var apiErr *jetstream.APIError
+	return errors.Is(err, jetstream.ErrKeyExists) ||
+		(errors.As(err, &apiErr) && apiErr.ErrorCode == jetstream.JSErrCodeStreamWrongLastSequence)
+}
+
+// Take takes a token from the bucket with the key, which refills at r
+// tokens per second up to burst tokens. If there is no token, it returns
+// how long to wait for one.
+func (s *RateStore) Take(ctx Context, key string, r rate.Limit, burst int) (time.Duration, error) {
+	key = rateKey(key)
+	for range rateRetries {
+		now := time.Now()
+		bucket := tokenBucket{Tokens: float64(burst), Time: now}
+		var revision uint64
+
+		entry, err := s.KeyValue.Get(ctx, key)
+		if err == nil {
+			err = json.Unmarshal(entry.Value(), &bucket)
+			if err != nil {
+				return 0, err
+			}
+			revision = entry.Revision()
+			elapsed := max(now.Sub(bucket.Time), 0)
+			bucket.Tokens = min(float64(burst), bucket.Tokens+elapsed.Seconds()*float64(r))
+			bucket.Time = now
+		} else if !errors.Is(err, jetstream.ErrKeyNotFound) {
+			return 0, err
+		}
+
+		if bucket.Tokens < 1 {
+			if r <= 0 {
+				return RateTTL, nil
+			}
+			return time.Duration((1 - bucket.Tokens) / float64(r) * float64(time.Second)), nil
+		}
+		bucket.Tokens--
+
+		buf, err := json.Marshal(bucket)
+		if err != nil {
+			return 0, err
+		}
+		if revision == 0 {
+			_, err = s.KeyValue.Create(ctx, key, buf)
+		} else {
+			_, err = s.KeyValue.Update(ctx, key, buf, revision)
+		}
+		if err == nil {
+			return 0, nil
+		}
+		if !conflict(err) {
+			return 0, err
+		}
+	}
+	return 0, ErrorRateConflict
+}
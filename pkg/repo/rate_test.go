@@ -0,0 +1,51 @@
+package repo
+
+import "context"
+import "sync"
+import "testing"
+
+func TestRateStore(t *testing.T) {
+	r, err := Open("nats+builtin://" + t.TempDir())
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer r.Close()
+	ctx := context.Background()
+
+	for i := range 3 {
+		wait, err := r.Rates().Take(ctx, "/login 192.0.2.1", 0.1, 3)
+		if err != nil || wait != 0 {
+			t.Fatalf("Take %d: %v %s", i, err, wait)
+		}
+	}
+	wait, err := r.Rates().Take(ctx, "/login 192.0.2.1", 0.1, 3)
+	if err != nil || wait <= 0 {
+		t.Errorf("Take after burst: %v %s", err, wait)
+	}
+
+	wait, err = r.Rates().Take(ctx, "/login 2001:db8::", 0.1, 3)
+	if err != nil || wait != 0 {
+		t.Errorf("Take other client: %v %s", err, wait)
+	}
+
+	// Concurrent takes may not take more tokens than the burst.
+	mu := sync.Mutex{}
+	allowed := 0
+	wg := sync.WaitGroup{}
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait, err := r.Rates().Take(ctx, "/ 192.0.2.2", 0.001, 4)
+			if err == nil && wait == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed > 4 {
+		t.Errorf("Take concurrently: %d allowed", allowed)
+	}
+}
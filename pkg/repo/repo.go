@@ -3,6 +3,12 @@
 package repo
 
 import "io"
+import "iter"
+import "fmt"
+import "errors"
+import "slices"
+import "strconv"
+import "time"
 import "context"
 import "net/url"
 import "encoding/json"
@@ -22,10 +28,34 @@ type Repository struct {
 	jetstream.JetStream
 	user    *UserMapper
 	session *BasicMapper[model.Session]
+	attempt *BasicMapper[model.LoginAttempt]
+	ticket  *BasicMapper[model.LoginTicket]
+	policy  *BasicMapper[model.Policy]
+	links   *LinkMapper
+	rate    *BasicMapper[model.LinkRate]
+	email   *EmailChangeMapper
 	craft   *CraftMapper
 	image   *UploadMapper
+	thumb   *UploadMapper
+	avatar  *UploadMapper
+	report  *BasicMapper[model.Report]
+	like    *LikeMapper
+	comment *CommentMapper
+	follow  *FollowMapper
+	jobs    *JobQueue
+	outbox  *EventOutbox
+	audits  *AuditStream
+	rates   *RateStore
+	nonces  *NonceStore
 }
 
+// builtinTimeout is how long Open waits for a built in NATS server to start.
+const builtinTimeout = 10 * time.Second
+
+// Open opens the repository at the NATS URL. With the scheme nats+builtin,
+// the repository starts a NATS server in process that stores its data in
+// the path of the URL. If the URL has a host, the built in server also
+// listens there, so other processes such as workers can connect to it.
 func Open(nurl string) (r *Repository, err error) {
 	u, err := url.Parse(nurl)
 	if err != nil {
@@ -36,15 +66,29 @@ func Open(nurl string) (r *Repository, err error) {
 
 	if u.Scheme == "nats+builtin" {
 		opts := nsrv.Options{
-			JetStream: true,
-			StoreDir:  u.Path,
+			JetStream:  true,
+			StoreDir:   u.Path,
+			DontListen: u.Host == "",
+		}
+		if u.Host != "" {
+			opts.Host = u.Hostname()
+			opts.Port, err = strconv.Atoi(u.Port())
+			if err != nil {
+				return nil, fmt.Errorf("port of built in NATS server: %w", err)
+			}
 		}
 		r.Server, err = nsrv.NewServer(&opts)
 		if err != nil {
 			return nil, err
 		}
+		go r.Server.Start()
+		if !r.Server.ReadyForConnections(builtinTimeout) {
+			r.Server.Shutdown()
+			return nil, fmt.Errorf("built in NATS server not ready after %s", builtinTimeout)
+		}
 		r.Conn, err = nats.Connect("", nats.InProcessServer(r.Server))
 		if err != nil {
+			r.Server.Shutdown()
 			return nil, err
 		}
 	} else {
@@ -77,6 +121,30 @@ func (r *Repository) Setup(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	r.attempt, err = NewTTLMapper[model.LoginAttempt](ctx, r, "attempt", model.LoginAttemptTTL)
+	if err != nil {
+		return err
+	}
+	r.ticket, err = NewTTLMapper[model.LoginTicket](ctx, r, "ticket", model.LoginTicketTTL)
+	if err != nil {
+		return err
+	}
+	r.policy, err = NewBasicMapper[model.Policy](ctx, r, "policy")
+	if err != nil {
+		return err
+	}
+	r.links, err = NewLinkMapper(ctx, r, "link")
+	if err != nil {
+		return err
+	}
+	r.rate, err = NewTTLMapper[model.LinkRate](ctx, r, "linkrate", model.LoginLinkWindow)
+	if err != nil {
+		return err
+	}
+	r.email, err = NewEmailChangeMapper(ctx, r, "emailchange")
+	if err != nil {
+		return err
+	}
 	r.craft, err = NewCraftMapper(ctx, r, "craft")
 	if err != nil {
 		return err
@@ -87,12 +155,71 @@ func (r *Repository) Setup(ctx context.Context) error {
 		return err
 	}
 
+	r.thumb, err = NewUploadMapper(ctx, r, "thumbnail")
+	if err != nil {
+		return err
+	}
+	r.avatar, err = NewUploadMapper(ctx, r, "avatar")
+	if err != nil {
+		return err
+	}
+
+	r.report, err = NewBasicMapper[model.Report](ctx, r, "report")
+	if err != nil {
+		return err
+	}
+
+	r.like, err = NewLikeMapper(ctx, r, "like")
+	if err != nil {
+		return err
+	}
+
+	r.comment, err = NewCommentMapper(ctx, r, "comment")
+	if err != nil {
+		return err
+	}
+
+	r.follow, err = NewFollowMapper(ctx, r, "follow")
+	if err != nil {
+		return err
+	}
+
+	r.jobs, err = NewJobQueue(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	r.outbox, err = NewEventOutbox(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	r.audits, err = NewAuditStream(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	r.rates, err = NewRateStore(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	r.nonces, err = NewNonceStore(ctx, r)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
 func (r *Repository) Close() {
-	r.Conn.Close()
-	r.Server.Shutdown()
+	if r.Conn != nil {
+		r.Conn.Close()
+	}
+	if r.Server != nil {
+		r.Server.Shutdown()
+		r.Server.WaitForShutdown()
+	}
 }
 
 type BasicMapper[T any] struct {
@@ -110,21 +237,45 @@ func NewBasicMapper[T any](ctx Context, r *Repository, name string) (*BasicMappe
 		Repository: r,
 		Name:       MapperPrefix + name,
 	}
-	bm.KeyValue, err = bm.Repository.JetStream.KeyValue(ctx, bm.Name)
+	bm.KeyValue, err = r.Bucket(ctx, name)
 	if err != nil {
-		if err == jetstream.ErrBucketNotFound {
-			kvc := jetstream.KeyValueConfig{Bucket: bm.Name}
-			bm.KeyValue, err = bm.Repository.JetStream.CreateKeyValue(ctx, kvc)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			return nil, err
-		}
+		return nil, err
 	}
 	return bm, nil
 }
 
+// NewTTLMapper returns a basic mapper whose entries expire after ttl.
+func NewTTLMapper[T any](ctx Context, r *Repository, name string, ttl time.Duration) (*BasicMapper[T], error) {
+	var err error
+
+	bm := &BasicMapper[T]{
+		Repository: r,
+		Name:       MapperPrefix + name,
+	}
+	bm.KeyValue, err = r.BucketTTL(ctx, name, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return bm, nil
+}
+
+// Bucket returns the key value bucket with the given name prefixed with
+// MapperPrefix, creating it if it does not exist yet.
+func (r *Repository) Bucket(ctx Context, name string) (jetstream.KeyValue, error) {
+	return r.BucketTTL(ctx, name, 0)
+}
+
+// BucketTTL is like Bucket, but entries of a bucket it creates expire after
+// ttl. A ttl of 0 means entries do not expire.
+func (r *Repository) BucketTTL(ctx Context, name string, ttl time.Duration) (jetstream.KeyValue, error) {
+	kv, err := r.JetStream.KeyValue(ctx, MapperPrefix+name)
+	if err == jetstream.ErrBucketNotFound {
+		kvc := jetstream.KeyValueConfig{Bucket: MapperPrefix + name, TTL: ttl}
+		return r.JetStream.CreateKeyValue(ctx, kvc)
+	}
+	return kv, err
+}
+
 func (b *BasicMapper[T]) Get(ctx Context, key string) (T, error) {
 	var obj T
 	entry, err := b.KeyValue.Get(ctx, key)
@@ -155,6 +306,90 @@ func (b *BasicMapper[T]) Put(ctx Context, key string, obj T) (T, error) {
 	return obj, nil
 }
 
+// updateRetries is how often Update tries again when the entry was
+// changed by someone else at the same time.
+const updateRetries = 5
+
+// ErrorUpdateConflict means an entry could not be updated because others
+// kept changing it at the same time.
+var ErrorUpdateConflict = errors.New("entry update conflict")
+
+// Update gets the value with the key, changes it with the update function
+// and stores it at the revision it was read at, with UpdateKey. If someone
+// else changed it in the meantime, it tries again, so concurrent updates
+// are not lost. The update function gets the zero value and false if there
+// is no entry. If it returns an error, nothing is stored and Update returns
+// that error.
+func (b *BasicMapper[T]) Update(ctx Context, key string, update func(obj *T, found bool) error) (T, error) {
+	var res T
+	err := UpdateKey(ctx, b.KeyValue, key, func(old []byte) ([]byte, error) {
+		var obj T
+		if old != nil {
+			err := json.Unmarshal(old, &obj)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %w: %w", b.Name, key, model.ErrorDecode, err)
+			}
+		}
+		err := update(&obj, old != nil)
+		if err != nil {
+			return nil, err
+		}
+		res = obj
+		return json.Marshal(obj)
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return res, nil
+}
+
+// UpdateKey gets the value with the key from the bucket, changes it with
+// the update function and stores it at the revision it was read at, with
+// compare and swap like RateStore.Take. If someone else changed it in the
+// meantime, it tries again. The update function gets nil if there is no
+// entry, and the entry is deleted if it returns nil. If it returns an
+// error, nothing is stored and UpdateKey returns that error.
+func UpdateKey(ctx Context, kv jetstream.KeyValue, key string, update func(old []byte) ([]byte, error)) error {
+	for range updateRetries {
+		var old []byte
+		var revision uint64
+
+		entry, err := kv.Get(ctx, key)
+		if err == nil {
+			old = entry.Value()
+			if old == nil {
+				old = []byte{}
+			}
+			revision = entry.Revision()
+		} else if !errors.Is(err, jetstream.ErrKeyNotFound) {
+			return err
+		}
+
+		buf, err := update(old)
+		if err != nil {
+			return err
+		}
+		switch {
+		case buf == nil && revision == 0:
+			return nil
+		case buf == nil:
+			err = kv.Delete(ctx, key, jetstream.LastRevision(revision))
+		case revision == 0:
+			_, err = kv.Create(ctx, key, buf)
+		default:
+			_, err = kv.Update(ctx, key, buf, revision)
+		}
+		if err == nil {
+			return nil
+		}
+		if !conflict(err) {
+			return err
+		}
+	}
+	return ErrorUpdateConflict
+}
+
 func (b *BasicMapper[T]) Purge(ctx Context, key string) error {
 	return b.KeyValue.Purge(ctx, key)
 }
@@ -163,110 +398,190 @@ func (b *BasicMapper[T]) Delete(ctx Context, key string) error {
 	return b.KeyValue.Delete(ctx, key)
 }
 
-func (b *BasicMapper[T]) Keys(ctx Context, keys ...string) (chan (string), error) {
-	lister, err := b.KeyValue.ListKeysFiltered(ctx, keys...)
-	if err != nil {
-		return nil, err
-	}
-	ch := make(chan (string))
-	go func() {
-		for res := range lister.Keys() {
-			ch <- res
+// Keys returns an iterator over the keys that match the filters, or all
+// keys if there are none. The lister is stopped when the loop ends, and
+// the context error is yielded if the context is done before all keys
+// were seen.
+func (b *BasicMapper[T]) Keys(ctx Context, keys ...string) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		lister, err := b.KeyValue.ListKeysFiltered(ctx, slices.Clone(keys)...)
+		if err != nil {
+			yield("", err)
+			return
 		}
-		close(ch)
-	}()
-
-	return ch, nil
+		defer lister.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				yield("", ctx.Err())
+				return
+			case key, ok := <-lister.Keys():
+				// The lister also ends when the context is done.
+				if !ok {
+					if ctx.Err() != nil {
+						yield("", ctx.Err())
+					}
+					return
+				}
+				if !yield(key, nil) {
+					return
+				}
+			}
+		}
+	}
 }
 
-func (b *BasicMapper[T]) Watch(ctx Context, keys ...string) (chan (T), error) {
-	watcher, err := b.KeyValue.WatchFiltered(ctx, keys,
-		jetstream.UpdatesOnly(), jetstream.IgnoreDeletes())
+// decode decodes the value of the entry. The error mentions the bucket
+// and the key so a broken value can be found.
+func (b *BasicMapper[T]) decode(entry jetstream.KeyValueEntry) (T, error) {
+	var obj T
+	err := json.Unmarshal(entry.Value(), &obj)
 	if err != nil {
-		return nil, err
+		return obj, fmt.Errorf("%s: %s: %w: %w", b.Name, entry.Key(), model.ErrorDecode, err)
 	}
+	return obj, nil
+}
 
-	ch := make(chan (T))
-	go func() {
-		for res := range watcher.Updates() {
-			var obj T
-			if res == nil {
-				watcher.Stop()
-				break
-			}
-			err = json.Unmarshal(res.Value(), &obj)
-			if err != nil {
-				watcher.Stop()
-				break
+// Watch returns an iterator over the values that are put after the loop
+// starts for the keys that match the filters, or all keys if there are
+// none. It runs until the context is done or the loop ends, after which
+// the watcher is stopped. Values that cannot be decoded are yielded as
+// errors, and the loop may continue after them.
+func (b *BasicMapper[T]) Watch(ctx Context, keys ...string) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		watcher, err := b.KeyValue.WatchFiltered(ctx, slices.Clone(keys),
+			jetstream.UpdatesOnly(), jetstream.IgnoreDeletes())
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+		defer watcher.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case entry, ok := <-watcher.Updates():
+				if !ok {
+					return
+				}
+				if entry == nil {
+					continue
+				}
+				if !yield(b.decode(entry)) {
+					return
+				}
 			}
-			ch <- obj
 		}
-		close(ch)
-	}()
-
-	return ch, nil
+	}
 }
 
-func (b *BasicMapper[T]) All(ctx Context, keys ...string) (chan (T), error) {
-	watcher, err := b.KeyValue.WatchFiltered(ctx, keys, jetstream.IgnoreDeletes())
-	if err != nil {
-		slog.Error("BasicMapper.All", "err", err)
-		return nil, err
+// Changes is like Watch, but it also yields deletes, and the keys of the
+// changes. If current is true, the current values are yielded first, so
+// no change is missed between reading the values and watching them.
+func (b *BasicMapper[T]) Changes(ctx Context, current bool, keys ...string) iter.Seq2[model.Change[T], error] {
+	return func(yield func(model.Change[T], error) bool) {
+		var opts []jetstream.WatchOpt
+		if !current {
+			opts = append(opts, jetstream.UpdatesOnly())
+		}
+		watcher, err := b.KeyValue.WatchFiltered(ctx, slices.Clone(keys), opts...)
+		if err != nil {
+			yield(model.Change[T]{}, err)
+			return
+		}
+		defer watcher.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case entry, ok := <-watcher.Updates():
+				if !ok {
+					return
+				}
+				// The nil marker means all current values were seen.
+				if entry == nil {
+					continue
+				}
+				change := model.Change[T]{Key: entry.Key()}
+				if entry.Operation() != jetstream.KeyValuePut {
+					change.Deleted = true
+				} else {
+					change.Value, err = b.decode(entry)
+				}
+				if !yield(change, err) {
+					return
+				}
+			}
+		}
 	}
-	slog.Debug("BasicMapper.All", "keys", keys)
-
-	ch := make(chan (T))
-	go func() {
-		for res := range watcher.Updates() {
-
-			slog.Debug("BasicMapper.All Updates", "res", res)
+}
 
-			var obj T
-			if res == nil {
-				watcher.Stop()
-				break
-			}
-			err = json.Unmarshal(res.Value(), &obj)
-			if err != nil {
-				watcher.Stop()
-				break
+// All returns an iterator over the current values for the keys that
+// match the filters, or all keys if there are none. It ends once all
+// values were seen, when the loop ends or when the context is done, in
+// which case the context error is yielded. The watcher is always
+// stopped. Values that cannot be decoded are yielded as errors, and the
+// loop may continue after them.
+func (b *BasicMapper[T]) All(ctx Context, keys ...string) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		watcher, err := b.KeyValue.WatchFiltered(ctx, slices.Clone(keys),
+			jetstream.IgnoreDeletes())
+		if err != nil {
+			slog.Error("BasicMapper.All", "err", err)
+			yield(zero, err)
+			return
+		}
+		defer watcher.Stop()
+		slog.Debug("BasicMapper.All", "keys", keys)
+
+		for {
+			select {
+			case <-ctx.Done():
+				yield(zero, ctx.Err())
+				return
+			case entry, ok := <-watcher.Updates():
+				if !ok && ctx.Err() != nil {
+					yield(zero, ctx.Err())
+					return
+				}
+				// The nil marker means all current values were seen.
+				if !ok || entry == nil {
+					return
+				}
+				if !yield(b.decode(entry)) {
+					return
+				}
+				if entry.Delta() == 0 {
+					return
+				}
 			}
-			ch <- obj
 		}
-		slog.Debug("BasicMapper.All closed")
-		close(ch)
-	}()
-
-	return ch, nil
+	}
 }
 
 // Watches the mapper and returns the first value where the matcher returns true.
-// Return nil if not found, or an error on error.
+// Return nil if not found, or an error on error. Values that cannot be
+// decoded are logged and skipped.
 // As this is a simple linear scan it isn't very performant yet, but it will do as
 // a first implementation.
 func (b *BasicMapper[T]) GetFirstMatch(ctx Context, matcher func(t *T) bool) (*T, error) {
-	watcher, err := b.KeyValue.WatchAll(ctx, jetstream.IgnoreDeletes())
-	if err != nil {
-		return nil, err
-	}
-	defer watcher.Stop()
-
-	for res := range watcher.Updates() {
-		var obj T
-		if res == nil {
-			return nil, nil
+	for obj, err := range b.All(ctx) {
+		if errors.Is(err, model.ErrorDecode) {
+			slog.Error("BasicMapper.GetFirstMatch", "err", err)
+			continue
 		}
-
-		err = json.Unmarshal(res.Value(), &obj)
 		if err != nil {
 			return nil, err
 		}
-
 		if matcher(&obj) {
 			return &obj, nil
 		}
 	}
-
 	return nil, nil
 }
 
@@ -305,6 +620,7 @@ func infoToUpload(info *jetstream.ObjectInfo, rd io.ReadCloser) (*model.Upload,
 	up.Detail = info.Description
 	up.UserID = info.Metadata["user_id"]
 	up.Title = info.Metadata["title"]
+	up.Hidden = info.Metadata["hidden"] == "true"
 
 	up.ReadCloser = rd
 	return &up, nil
@@ -323,6 +639,7 @@ func entryToUpload(entry jetstream.ObjectResult) (*model.Upload, error) {
 	up.Detail = info.Description
 	up.UserID = info.Metadata["user_id"]
 	up.Title = info.Metadata["title"]
+	up.Hidden = info.Metadata["hidden"] == "true"
 
 	return &up, nil
 }
@@ -357,46 +674,74 @@ func (b *UploadMapper) Delete(ctx Context, key string) error {
 	return b.ObjectStore.Delete(ctx, key)
 }
 
-func (b *UploadMapper) List(ctx Context, userId string) (chan (string), error) {
-	lister, err := b.ObjectStore.List(ctx)
+// Hide hides or shows the upload with the given key by updating its metadata.
+func (b *UploadMapper) Hide(ctx Context, key string, hidden bool) error {
+	info, err := b.ObjectStore.GetInfo(ctx, key)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	meta := info.ObjectMeta
+	if meta.Metadata == nil {
+		meta.Metadata = map[string]string{}
 	}
-	ch := make(chan (string))
-	go func() {
+	meta.Metadata["hidden"] = strconv.FormatBool(hidden)
+	return b.ObjectStore.UpdateMeta(ctx, key, meta)
+}
+
+// List returns an iterator over the names of the uploads of the user,
+// or of all uploads if userId is empty.
+func (b *UploadMapper) List(ctx Context, userId string) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		lister, err := b.ObjectStore.List(ctx)
+		if errors.Is(err, jetstream.ErrNoObjectsFound) {
+			return
+		}
+		if err != nil {
+			yield("", err)
+			return
+		}
 		for _, info := range lister {
 			if userId != "" && userId != info.Metadata["user_id"] {
 				continue
 			}
-			ch <- info.Name
+			if !yield(info.Name, nil) {
+				return
+			}
 		}
-		close(ch)
-	}()
-
-	return ch, nil
-}
-
-func (b *UploadMapper) Watch(ctx Context) (chan (*model.Upload), error) {
-	watcher, err := b.ObjectStore.Watch(ctx,
-		jetstream.UpdatesOnly(), jetstream.IgnoreDeletes())
-	if err != nil {
-		return nil, err
 	}
+}
 
-	ch := make(chan (*model.Upload))
-	go func() {
-		for info := range watcher.Updates() {
-			up, err := infoToUpload(info, nil)
-			if err != nil {
-				watcher.Stop()
-				break
+// Watch returns an iterator over the uploads that are put after the loop
+// starts. It runs until the context is done or the loop ends, after
+// which the watcher is stopped.
+func (b *UploadMapper) Watch(ctx Context) iter.Seq2[*model.Upload, error] {
+	return func(yield func(*model.Upload, error) bool) {
+		watcher, err := b.ObjectStore.Watch(ctx,
+			jetstream.UpdatesOnly(), jetstream.IgnoreDeletes())
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer watcher.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case info, ok := <-watcher.Updates():
+				if !ok {
+					return
+				}
+				if info == nil {
+					continue
+				}
+				if !yield(infoToUpload(info, nil)) {
+					return
+				}
 			}
-			ch <- up
 		}
-		close(ch)
-	}()
-
-	return ch, nil
+	}
 }
 
 // CraftMapper is a mapper for cafts.
@@ -425,11 +770,181 @@ func (c *CraftMapper) GetForUserID(ctx Context, key string, UserID string) (mode
 	return c.BasicMapper.Get(ctx, key)
 }
 
-func (c *CraftMapper) AllForUserID(ctx Context, UserID string) (chan model.Craft, error) {
+func (c *CraftMapper) AllForUserID(ctx Context, UserID string) iter.Seq2[model.Craft, error] {
 	key := UserID + ".>"
 	return c.BasicMapper.All(ctx, key)
 }
 
+// validToken returns whether the key is a single token of a key, without
+// dots or wildcards, so it can be used in a key filter.
+func validToken(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, c := range key {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// GetByID gets a craft by its ID only, without knowing the user ID.
+func (c *CraftMapper) GetByID(ctx Context, key string) (model.Craft, error) {
+	var zero model.Craft
+	if !validToken(key) {
+		return zero, jetstream.ErrInvalidKey
+	}
+
+	found := ""
+	for k, err := range c.BasicMapper.Keys(ctx, "*."+key) {
+		if err != nil {
+			return zero, err
+		}
+		found = k
+		break
+	}
+	if found == "" {
+		return zero, jetstream.ErrKeyNotFound
+	}
+	return c.BasicMapper.Get(ctx, found)
+}
+
+// LikeMapper is a mapper for likes, keyed by craftID.userID.
+type LikeMapper struct {
+	// Inherit from BasicMapper
+	*BasicMapper[model.Like]
+}
+
+func NewLikeMapper(ctx Context, r *Repository, name string) (*LikeMapper, error) {
+	var err error
+	res := &LikeMapper{}
+	res.BasicMapper, err = NewBasicMapper[model.Like](ctx, r, name)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (l *LikeMapper) PutLike(ctx Context, like model.Like) (model.Like, error) {
+	key := like.CraftID + "." + like.UserID
+	return l.BasicMapper.Put(ctx, key, like)
+}
+
+func (l *LikeMapper) DeleteLike(ctx Context, craftID, userID string) error {
+	key := craftID + "." + userID
+	return l.BasicMapper.Delete(ctx, key)
+}
+
+func (l *LikeMapper) Has(ctx Context, craftID, userID string) (bool, error) {
+	key := craftID + "." + userID
+	_, err := l.BasicMapper.Get(ctx, key)
+	if err == jetstream.ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Count returns the amount of likes of the craft. It returns an error
+// rather than a short count if not all likes could be counted.
+func (l *LikeMapper) Count(ctx Context, craftID string) (int, error) {
+	count := 0
+	for _, err := range l.BasicMapper.Keys(ctx, craftID+".*") {
+		if err != nil {
+			return 0, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (l *LikeMapper) AllForUserID(ctx Context, userID string) iter.Seq2[model.Like, error] {
+	return l.BasicMapper.All(ctx, "*."+userID)
+}
+
+// CommentMapper is a mapper for comments, keyed by craftID.commentID.
+type CommentMapper struct {
+	// Inherit from BasicMapper
+	*BasicMapper[model.Comment]
+}
+
+func NewCommentMapper(ctx Context, r *Repository, name string) (*CommentMapper, error) {
+	var err error
+	res := &CommentMapper{}
+	res.BasicMapper, err = NewBasicMapper[model.Comment](ctx, r, name)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *CommentMapper) PutComment(ctx Context, comment model.Comment) (model.Comment, error) {
+	key := comment.CraftID + "." + comment.ID
+	return c.BasicMapper.Put(ctx, key, comment)
+}
+
+func (c *CommentMapper) GetComment(ctx Context, craftID, commentID string) (model.Comment, error) {
+	key := craftID + "." + commentID
+	return c.BasicMapper.Get(ctx, key)
+}
+
+func (c *CommentMapper) DeleteComment(ctx Context, craftID, commentID string) error {
+	key := craftID + "." + commentID
+	return c.BasicMapper.Delete(ctx, key)
+}
+
+func (c *CommentMapper) AllForCraftID(ctx Context, craftID string) iter.Seq2[model.Comment, error] {
+	return c.BasicMapper.All(ctx, craftID+".*")
+}
+
+// FollowMapper is a mapper for follows, keyed by userID.followID.
+type FollowMapper struct {
+	// Inherit from BasicMapper
+	*BasicMapper[model.Follow]
+}
+
+func NewFollowMapper(ctx Context, r *Repository, name string) (*FollowMapper, error) {
+	var err error
+	res := &FollowMapper{}
+	res.BasicMapper, err = NewBasicMapper[model.Follow](ctx, r, name)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (f *FollowMapper) PutFollow(ctx Context, follow model.Follow) (model.Follow, error) {
+	key := follow.UserID + "." + follow.FollowID
+	return f.BasicMapper.Put(ctx, key, follow)
+}
+
+func (f *FollowMapper) DeleteFollow(ctx Context, userID, followID string) error {
+	key := userID + "." + followID
+	return f.BasicMapper.Delete(ctx, key)
+}
+
+func (f *FollowMapper) Has(ctx Context, userID, followID string) (bool, error) {
+	key := userID + "." + followID
+	_, err := f.BasicMapper.Get(ctx, key)
+	if err == jetstream.ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (f *FollowMapper) AllForUserID(ctx Context, userID string) iter.Seq2[model.Follow, error] {
+	return f.BasicMapper.All(ctx, userID+".*")
+}
+
 // UserMapper is a mapper for cafts.
 type UserMapper struct {
 	// Inherit from BasicMapper
@@ -450,6 +965,10 @@ func (c *UserMapper) GetByEmail(ctx Context, email string) (*model.User, error)
 	return c.BasicMapper.GetFirstMatch(ctx, func(u *model.User) bool { return u.Email == email })
 }
 
+func (c *UserMapper) GetByIdentity(ctx Context, id model.Identity) (*model.User, error) {
+	return c.BasicMapper.GetFirstMatch(ctx, func(u *model.User) bool { return slices.Contains(u.Identities, id) })
+}
+
 func (r *Repository) User() model.UserMapper {
 	return r.user
 }
@@ -458,6 +977,30 @@ func (r *Repository) Session() model.SessionMapper {
 	return r.session
 }
 
+func (r *Repository) Attempt() model.AttemptMapper {
+	return r.attempt
+}
+
+func (r *Repository) Ticket() model.TicketMapper {
+	return r.ticket
+}
+
+func (r *Repository) Links() model.LinkMapper {
+	return r.links
+}
+
+func (r *Repository) LinkRate() model.LinkRateMapper {
+	return r.rate
+}
+
+func (r *Repository) EmailChange() model.EmailChangeMapper {
+	return r.email
+}
+
+func (r *Repository) Policy() model.PolicyMapper {
+	return r.policy
+}
+
 func (r *Repository) Craft() model.CraftMapper {
 	return r.craft
 }
@@ -465,3 +1008,49 @@ func (r *Repository) Craft() model.CraftMapper {
 func (r *Repository) Image() model.UploadMapper {
 	return r.image
 }
+
+func (r *Repository) Thumbnail() model.UploadMapper {
+	return r.thumb
+}
+
+func (r *Repository) Avatar() model.UploadMapper {
+	return r.avatar
+}
+
+func (r *Repository) Jobs() model.JobQueue {
+	return r.jobs
+}
+
+func (r *Repository) Outbox() model.Outbox {
+	return r.outbox
+}
+
+func (r *Repository) Audits() model.AuditLog {
+	return r.audits
+}
+
+func (r *Repository) Report() model.ReportMapper {
+	return r.report
+}
+
+func (r *Repository) Like() model.LikeMapper {
+	return r.like
+}
+
+func (r *Repository) Comment() model.CommentMapper {
+	return r.comment
+}
+
+func (r *Repository) Follow() model.FollowMapper {
+	return r.follow
+}
+
+// Rates returns the token buckets for rate limiting across instances.
+func (r *Repository) Rates() *RateStore {
+	return r.rates
+}
+
+// Nonces returns the used nonces of challenge tokens across instances.
+func (r *Repository) Nonces() *NonceStore {
+	return r.nonces
+}
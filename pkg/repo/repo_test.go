@@ -0,0 +1,252 @@
+package repo
+
+import "context"
+import "errors"
+import "sync"
+import "sync/atomic"
+import "testing"
+import "time"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+func TestCraftGetByID(t *testing.T) {
+	r, err := Open("nats+builtin://" + t.TempDir())
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer r.Close()
+	ctx := context.Background()
+
+	craft := model.Craft{ID: "01craft", UserID: "alice", Title: "Scarf"}
+	_, err = r.Craft().Put(ctx, craft.ID, craft)
+	if err != nil {
+		t.Fatalf("Put: %s", err)
+	}
+
+	got, err := r.Craft().GetByID(ctx, "01craft")
+	if err != nil || got.Title != "Scarf" {
+		t.Fatalf("GetByID: %v %+v", err, got)
+	}
+	for _, id := range []string{"", "*", ">", "alice.01craft", "01*", "missing"} {
+		_, err = r.Craft().GetByID(ctx, id)
+		if err == nil {
+			t.Errorf("GetByID %q: no error", id)
+		}
+	}
+}
+
+// consumers returns the amount of consumers of the stream of the bucket,
+// which includes the watchers of the bucket.
+func consumers(t *testing.T, r *Repository, name string) int {
+	t.Helper()
+	stream, err := r.JetStream.Stream(context.Background(), "KV_"+MapperPrefix+name)
+	if err != nil {
+		t.Fatalf("Stream: %s", err)
+	}
+	info, err := stream.Info(context.Background())
+	if err != nil {
+		t.Fatalf("Stream.Info: %s", err)
+	}
+	return info.State.Consumers
+}
+
+func TestMapperIterators(t *testing.T) {
+	r, err := Open("nats+builtin://" + t.TempDir())
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer r.Close()
+	ctx := context.Background()
+
+	users, err := NewBasicMapper[model.User](ctx, r, "itertest")
+	if err != nil {
+		t.Fatalf("NewBasicMapper: %s", err)
+	}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		_, err = users.Put(ctx, name, model.User{ID: name, Email: name + "@example.com"})
+		if err != nil {
+			t.Fatalf("Put: %s", err)
+		}
+	}
+	_, err = users.KeyValue.Put(ctx, "broken", []byte("{not json"))
+	if err != nil {
+		t.Fatalf("KeyValue.Put: %s", err)
+	}
+	_, err = users.Put(ctx, "dave", model.User{ID: "dave", Email: "dave@example.com"})
+	if err != nil {
+		t.Fatalf("Put: %s", err)
+	}
+
+	broken := 0
+	for _, err := range users.All(ctx) {
+		if errors.Is(err, model.ErrorDecode) {
+			broken++
+		} else if err != nil {
+			t.Fatalf("All: %s", err)
+		}
+	}
+	if broken != 1 {
+		t.Errorf("All yielded %d decode errors, want 1", broken)
+	}
+
+	all, err := model.Collect(users.All(ctx))
+	if err != nil || len(all) != 4 {
+		t.Errorf("Collect: %v %d", err, len(all))
+	}
+	dave, err := users.GetFirstMatch(ctx, func(u *model.User) bool { return u.ID == "dave" })
+	if err != nil || dave == nil {
+		t.Errorf("GetFirstMatch after a broken value: %v %v", err, dave)
+	}
+
+	// Watchers and listers are stopped when the loop ends early.
+	for range users.All(ctx) {
+		break
+	}
+	for range users.Keys(ctx) {
+		break
+	}
+	watchCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	go users.Put(ctx, "erin", model.User{ID: "erin"})
+	for range users.Watch(watchCtx) {
+		break
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for consumers(t, r, "itertest") > 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if n := consumers(t, r, "itertest"); n > 0 {
+		t.Errorf("%d watchers left after the loops ended", n)
+	}
+
+	// A cancelled context is an error, not a short result.
+	cancelled, cancelNow := context.WithCancel(ctx)
+	cancelNow()
+	_, err = model.Collect(users.Keys(cancelled))
+	if err == nil {
+		t.Errorf("Keys with a cancelled context: no error")
+	}
+	_, err = model.Collect(users.All(cancelled))
+	if err == nil {
+		t.Errorf("All with a cancelled context: no error")
+	}
+	_, err = r.Like().Count(cancelled, "craft")
+	if err == nil {
+		t.Errorf("Count with a cancelled context: no error")
+	}
+}
+
+func TestMapperUpdate(t *testing.T) {
+	r, err := Open("nats+builtin://" + t.TempDir())
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer r.Close()
+	ctx := context.Background()
+
+	// Updates at the same time are either all stored or fail, but none
+	// of them is lost.
+	var wg sync.WaitGroup
+	var stored atomic.Int32
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 10 {
+				_, err := r.LinkRate().Update(ctx, "key", func(rate *model.LinkRate, found bool) error {
+					rate.Count++
+					time.Sleep(time.Millisecond)
+					return nil
+				})
+				if err == nil {
+					stored.Add(1)
+				} else if !errors.Is(err, ErrorUpdateConflict) {
+					t.Errorf("Update: %s", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	rate, err := r.LinkRate().Get(ctx, "key")
+	if err != nil || rate.Count != int(stored.Load()) || rate.Count == 0 {
+		t.Errorf("count %d after %d updates: %v", rate.Count, stored.Load(), err)
+	}
+
+	stop := errors.New("stop")
+	_, err = r.LinkRate().Update(ctx, "key", func(rate *model.LinkRate, found bool) error {
+		if !found {
+			t.Errorf("Update of existing entry not found")
+		}
+		rate.Count = 0
+		return stop
+	})
+	if err != stop {
+		t.Errorf("Update with error: %v", err)
+	}
+	rate, _ = r.LinkRate().Get(ctx, "key")
+	if rate.Count == 0 {
+		t.Errorf("Update with error stored the change")
+	}
+}
+
+func TestNonceStore(t *testing.T) {
+	r, err := Open("nats+builtin://" + t.TempDir())
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer r.Close()
+	ctx := context.Background()
+
+	fresh, err := r.Nonces().Use(ctx, "0123abcd")
+	if err != nil || !fresh {
+		t.Errorf("Use: %t %v", fresh, err)
+	}
+	fresh, err = r.Nonces().Use(ctx, "0123abcd")
+	if err != nil || fresh {
+		t.Errorf("Use again: %t %v", fresh, err)
+	}
+	_, err = r.Nonces().Use(ctx, "bad.nonce")
+	if err == nil {
+		t.Errorf("Use of a bad nonce passed")
+	}
+}
+
+func TestEmailChangeTake(t *testing.T) {
+	r, err := Open("nats+builtin://" + t.TempDir())
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer r.Close()
+	ctx := context.Background()
+
+	change := model.EmailChange{ID: "change", UserID: "bob", Email: "bobby@example.com", Expires: time.Now().Add(time.Hour)}
+	_, err = r.EmailChange().Put(ctx, change.ID, change)
+	if err != nil {
+		t.Fatalf("Put: %s", err)
+	}
+
+	// Only one of the requests that take the change at once gets it.
+	var taken atomic.Int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			got, err := r.EmailChange().Take(ctx, change.ID)
+			if err == nil && got.Email == change.Email {
+				taken.Add(1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if taken.Load() != 1 {
+		t.Errorf("Take concurrently: taken %d times", taken.Load())
+	}
+	_, err = r.EmailChange().Take(ctx, change.ID)
+	if err == nil {
+		t.Errorf("Take after taken passed")
+	}
+}
@@ -0,0 +1,105 @@
+package roh
+
+import "context"
+import "net/http"
+import "strings"
+import "time"
+
+import "aidanwoods.dev/go-paseto"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+// Authenticator validates bearer tokens.
+type Authenticator interface {
+	// Authenticate returns the subject of the token, such as a user ID,
+	// or an error if the token is not valid.
+	Authenticate(ctx context.Context, token string) (string, error)
+}
+
+// AuthenticatorFunc is a function that is an Authenticator.
+type AuthenticatorFunc func(ctx context.Context, token string) (string, error)
+
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, token string) (string, error) {
+	return f(ctx, token)
+}
+
+// PASETO is an Authenticator for PASETO v4 local tokens made with Key.
+// These are the same tokens that pkg/app uses for its sessions, so with
+// the same key internal services accept the tokens of Qrochet users.
+type PASETO struct {
+	Key paseto.V4SymmetricKey
+}
+
+// Authenticate returns the subject of the token if it is valid and not
+// expired.
+func (p PASETO) Authenticate(ctx context.Context, token string) (string, error) {
+	tok, err := paseto.NewParser().ParseV4Local(p.Key, token, []byte{})
+	if err != nil {
+		return "", err
+	}
+	return tok.GetSubject()
+}
+
+// Token returns a new token for the subject that expires after ttl,
+// for example for a service that calls another service.
+func (p PASETO) Token(subject string, ttl time.Duration) string {
+	tok := paseto.NewToken()
+	tok.SetNotBefore(time.Now())
+	tok.SetExpiration(time.Now().Add(ttl))
+	tok.SetSubject(subject)
+	return tok.V4Encrypt(p.Key, []byte{})
+}
+
+type subjectKey struct{}
+
+// WithSubject returns a context with the authenticated subject.
+func WithSubject(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, subjectKey{}, subject)
+}
+
+// Subject returns the authenticated subject of the context of a request,
+// or "" if the request was not authenticated.
+func Subject(ctx context.Context) string {
+	subject, _ := ctx.Value(subjectKey{}).(string)
+	return subject
+}
+
+// BearerToken returns the bearer token of the request, or "" if none.
+func BearerToken(req *http.Request) string {
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// Auth authenticates every request with the bearer token of the request
+// and puts the subject in the context of the request. Requests without a
+// valid token are refused, except for the list of methods at WellKnown.
+func Auth(a Authenticator) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+			if req.Method == http.MethodGet && req.URL.Path == WellKnown {
+				next.ServeHTTP(wr, req)
+				return
+			}
+
+			token := BearerToken(req)
+			if token == "" {
+				WriteError(wr, http.StatusUnauthorized, model.Error{Code: http.StatusUnauthorized, Message: "bearer token missing"})
+				return
+			}
+			subject, err := a.Authenticate(req.Context(), token)
+			if err != nil || subject == "" {
+				WriteError(wr, http.StatusUnauthorized, model.Error{Code: http.StatusUnauthorized, Message: "bearer token not valid"})
+				return
+			}
+			next.ServeHTTP(wr, req.WithContext(WithSubject(req.Context(), subject)))
+		})
+	}
+}
+
+// SetToken makes the client authenticate with the bearer token.
+func (c *Client) SetToken(token string) {
+	c.Header.Set("Authorization", "Bearer "+token)
+}
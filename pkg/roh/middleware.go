@@ -0,0 +1,78 @@
+package roh
+
+import "log/slog"
+import "math"
+import "net/http"
+import "strconv"
+import "time"
+
+import "github.com/qrochet/qrochet/pkg/limit"
+import "github.com/qrochet/qrochet/pkg/model"
+
+// Middleware wraps a handler in another handler, for example to log,
+// rate limit or authenticate the requests.
+type Middleware func(next http.Handler) http.Handler
+
+// Use adds middleware to the server. The middleware that is added first
+// is the outermost one, so it sees the requests first.
+func (s *Server) Use(mw ...Middleware) {
+	s.middleware = append(s.middleware, mw...)
+	var handler http.Handler = s.ServeMux
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		handler = s.middleware[i](handler)
+	}
+	s.Server.Handler = handler
+}
+
+// statusWriter remembers the status that was written.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusWriter) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Logger logs every request with its status and duration.
+func Logger() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: wr, status: http.StatusOK}
+			next.ServeHTTP(sw, req)
+			slog.Info("roh", "method", req.Method, "path", req.URL.Path,
+				"remote", req.RemoteAddr, "status", sw.status,
+				"duration", time.Since(start), "subject", Subject(req.Context()))
+		})
+	}
+}
+
+// RateLimit limits the rate of requests with the limiter, per client and
+// with the policy of the path. The limiter knows the trusted proxies, and
+// forgets the clients it did not see for a while. Too many requests are
+// answered with status 429 and a Retry-After header that says how many
+// seconds the client should wait.
+func RateLimit(l *limit.Limiter) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+			policy, ok := l.Policy(req.URL.Path)
+			addr, known := l.Proxies.ClientAddr(req)
+			if !ok || !known {
+				next.ServeHTTP(wr, req)
+				return
+			}
+
+			if wait := l.Reserve(req.Context(), policy, addr); wait > 0 {
+				wr.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				WriteError(wr, http.StatusTooManyRequests, model.Error{
+					Code:    http.StatusTooManyRequests,
+					Message: http.StatusText(http.StatusTooManyRequests),
+				})
+				return
+			}
+			next.ServeHTTP(wr, req)
+		})
+	}
+}
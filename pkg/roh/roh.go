@@ -3,20 +3,51 @@
 // For normal RPC calls, only POST is used (since QUERY is too new yet).
 // The POST posts a JSON body with the query to a method path,
 // And the response is equally a JSON body, even on error.
+// On error the body is a model.Error with the HTTP status code as code.
 // GET queries are allowed to serve files.
 package roh
 
 import "bytes"
+import "context"
+import "errors"
+import "fmt"
+import "io"
+import "mime"
 import "net/http"
 import "net/url"
 import "net"
 import "encoding/json"
 import "log/slog"
+import "slices"
+import "sort"
+import "time"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+const (
+	// DefaultMaxBody is the default maximum size of a request or response body.
+	DefaultMaxBody = 1 << 20
+
+	// DefaultTimeout is the default timeout of a call of a client.
+	DefaultTimeout = 30 * time.Second
+
+	// stateBuffer is the size of the buffer of Server.State.
+	stateBuffer = 16
+)
 
 type Server struct {
 	*http.Server
 	*http.ServeMux
+	// State receives the changes of the states of the connections.
+	// It is buffered, and changes are dropped when nobody receives them.
 	State chan (http.ConnState)
+	// MaxBody is the maximum size of request bodies of methods that are
+	// registered after it is set.
+	MaxBody int64
+	// Methods are the registered methods, which are listed at WellKnown.
+	Methods []Method
+
+	middleware []Middleware
 }
 
 func NewServer(hostport string) *Server {
@@ -25,7 +56,9 @@ func NewServer(hostport string) *Server {
 	s.Addr = hostport
 	s.ServeMux = http.NewServeMux()
 	s.Server.Handler = s.ServeMux
-	s.State = make(chan (http.ConnState))
+	s.MaxBody = DefaultMaxBody
+	s.ServeMux.HandleFunc("GET "+WellKnown, s.discover)
+	s.State = make(chan (http.ConnState), stateBuffer)
 	s.Server.ConnState = func(conn net.Conn, c http.ConnState) {
 		select {
 		case s.State <- c:
@@ -35,99 +68,159 @@ func NewServer(hostport string) *Server {
 	return s
 }
 
+// WriteError writes err as a JSON model.Error. If err is a model.Error
+// its code is used as the HTTP status, otherwise status is used.
+func WriteError(wr http.ResponseWriter, status int, err error) {
+	var merr model.Error
+	if !errors.As(err, &merr) {
+		merr = model.Error{Code: status, Message: err.Error()}
+	}
+	if merr.Code < 400 || merr.Code > 599 {
+		merr.Code = http.StatusInternalServerError
+	}
+	buf, _ := json.Marshal(merr)
+	wr.Header().Set("Content-Type", "application/json")
+	wr.WriteHeader(merr.Code)
+	wr.Write(buf)
+}
+
+// writeMethodError writes the error that a method returned. Errors of the
+// model keep their status code, like model.AsError does. Other errors are
+// logged and answered with a generic internal server error, so internal
+// errors do not reach clients.
+func writeMethodError(wr http.ResponseWriter, req *http.Request, err error) {
+	merr, ok := model.AsError(err)
+	if !ok {
+		slog.Error("roh method", "path", req.URL.Path, "err", err)
+	}
+	WriteError(wr, merr.Code, merr)
+}
+
+// WriteJSON writes buf as a JSON response with status OK.
+func WriteJSON(wr http.ResponseWriter, buf []byte) {
+	wr.Header().Set("Content-Type", "application/json")
+	wr.WriteHeader(http.StatusOK)
+	_, err := wr.Write(buf)
+	if err != nil {
+		slog.Error("roh WriteJSON", "err", err)
+	}
+}
+
+// ReadBody reads the JSON body of the request, up to max bytes.
+// The errors it returns are model.Error with a suitable status code.
+func ReadBody(wr http.ResponseWriter, req *http.Request, max int64) ([]byte, error) {
+	mt, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || mt != "application/json" {
+		return nil, model.Error{Code: http.StatusUnsupportedMediaType, Message: "content type must be application/json"}
+	}
+
+	buf, err := io.ReadAll(http.MaxBytesReader(wr, req.Body, max))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, model.Error{Code: http.StatusRequestEntityTooLarge, Message: err.Error()}
+		}
+		return nil, model.Error{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	return buf, nil
+}
+
 type JSONHandler struct {
-	cb func(body []byte) (result []byte, err error)
+	cb      func(ctx context.Context, body []byte) (result []byte, err error)
+	maxBody int64
 }
 
 func (j JSONHandler) ServeHTTP(wr http.ResponseWriter, req *http.Request) {
-	ct := req.Header.Get("Content-Type")
-	if ct != "application/json" {
-		wr.WriteHeader(http.StatusBadRequest)
+	buf, err := ReadBody(wr, req, j.maxBody)
+	if err != nil {
+		WriteError(wr, http.StatusBadRequest, err)
 		return
 	}
 
-	var buf []byte
-	_, err := req.Body.Read(buf)
+	result, err := j.cb(req.Context(), buf)
 	if err != nil {
-		wr.WriteHeader(http.StatusBadRequest)
+		writeMethodError(wr, req, err)
 		return
 	}
+	WriteJSON(wr, result)
+}
 
-	result, err := j.cb(buf)
-	if err != nil {
-		wr.WriteHeader(http.StatusBadRequest)
-	}
-	wr.Header().Set("Content-Type", "application/json")
-	_, err = wr.Write(result)
+// discover lists the methods of the server.
+func (s *Server) discover(wr http.ResponseWriter, req *http.Request) {
+	methods := slices.Clone(s.Methods)
+	sort.Slice(methods, func(i, j int) bool { return methods[i].Path < methods[j].Path })
+	buf, err := json.Marshal(Discovery{Methods: methods})
 	if err != nil {
-		wr.WriteHeader(http.StatusInternalServerError)
+		WriteError(wr, http.StatusInternalServerError, err)
+		return
 	}
+	WriteJSON(wr, buf)
 }
 
-func (s *Server) RegisterJSON(path string, cb func(body []byte) (result []byte, err error)) {
-	handler := &JSONHandler{cb: cb}
+// RegisterJSON registers a method with a raw JSON request and response.
+// Their schemas allow any JSON.
+func (s *Server) RegisterJSON(path string, cb func(ctx context.Context, body []byte) (result []byte, err error)) {
+	handler := &JSONHandler{cb: cb, maxBody: s.MaxBody}
 	s.ServeMux.Handle("POST "+path, handler)
+	s.Methods = append(s.Methods, Method{Path: path, Request: &Schema{}, Response: &Schema{}})
 }
 
 type TypeHandler[T, U any] struct {
-	cb func(body T) (result U, err error)
+	cb      func(ctx context.Context, body T) (result U, err error)
+	maxBody int64
 }
 
 func (t TypeHandler[T, U]) ServeHTTP(wr http.ResponseWriter, req *http.Request) {
-	slog.Info("Request", "req", req)
-	ct := req.Header.Get("Content-Type")
-	if ct != "application/json" {
-		wr.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	var buf []byte
-	_, err := req.Body.Read(buf)
+	slog.Debug("roh request", "path", req.URL.Path, "remote", req.RemoteAddr)
+	buf, err := ReadBody(wr, req, t.maxBody)
 	if err != nil {
-		wr.WriteHeader(http.StatusBadRequest)
-		wr.Write([]byte(err.Error()))
+		WriteError(wr, http.StatusBadRequest, err)
 		return
 	}
+
 	var request T
 	err = json.Unmarshal(buf, &request)
 	if err != nil {
-		wr.WriteHeader(http.StatusBadRequest)
-		wr.Write([]byte(err.Error()))
+		WriteError(wr, http.StatusBadRequest, err)
 		return
 	}
 
-	response, err := t.cb(request)
+	response, err := t.cb(req.Context(), request)
 	if err != nil {
-		wr.WriteHeader(http.StatusBadRequest)
-		wr.Write([]byte(err.Error()))
+		writeMethodError(wr, req, err)
 		return
 	}
 
 	result, err := json.Marshal(response)
 	if err != nil {
-		wr.WriteHeader(http.StatusInternalServerError)
-		wr.Write([]byte(err.Error()))
+		writeMethodError(wr, req, err)
 		return
 	}
-
-	wr.Header().Set("Content-Type", "application/json")
-
-	_, err = wr.Write(result)
-	if err != nil {
-		wr.WriteHeader(http.StatusInternalServerError)
-	}
+	WriteJSON(wr, result)
 }
 
-func Register[T, U any](s *Server, path string, cb func(T) (U, error)) {
-	handler := &TypeHandler[T, U]{cb: cb}
+// Register registers a method at the path. The context passed to cb is
+// the context of the request, which is done when the client goes away,
+// and which has the Subject if the request was authenticated. The schemas
+// of the request type T and the response type U are listed at WellKnown.
+func Register[T, U any](s *Server, path string, cb func(context.Context, T) (U, error)) {
+	handler := &TypeHandler[T, U]{cb: cb, maxBody: s.MaxBody}
 	s.ServeMux.Handle("POST "+path, handler)
+	s.Methods = append(s.Methods, Method{Path: path, Request: SchemaFor[T](), Response: SchemaFor[U]()})
 }
 
 type Client struct {
 	http.Client
 	target *url.URL
+	// MaxBody is the maximum size of a response body.
+	MaxBody int64
+	// Header is added to every request, for example for authorization.
+	Header http.Header
 }
 
+// NewClient returns a client for the server at the target URL.
+// Calls time out after DefaultTimeout, which can be changed
+// through the Timeout field.
 func NewClient(target string) (*Client, error) {
 	var err error
 	c := &Client{}
@@ -135,10 +228,65 @@ func NewClient(target string) (*Client, error) {
 	if err != nil {
 		return nil, err
 	}
+	c.Timeout = DefaultTimeout
+	c.MaxBody = DefaultMaxBody
+	c.Header = http.Header{}
 	return c, nil
 }
 
-func Call[U, T any](c *Client, path string, request T) (U, error) {
+// do does the request and returns the response body. Responses with a
+// status that is not 2xx are returned as model.Error.
+func (c *Client) do(req *http.Request) ([]byte, error) {
+	for key, values := range c.Header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	buf, err := io.ReadAll(io.LimitReader(res.Body, c.MaxBody+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(buf)) > c.MaxBody {
+		return nil, fmt.Errorf("roh: response of %s larger than %d bytes", req.URL.Path, c.MaxBody)
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		merr := model.Error{}
+		err = json.Unmarshal(buf, &merr)
+		if err != nil || merr.Code == 0 {
+			merr = model.Error{Code: res.StatusCode, Message: http.StatusText(res.StatusCode)}
+		}
+		return nil, merr
+	}
+	return buf, nil
+}
+
+// Discover returns the methods of the server.
+func (c *Client) Discover(ctx context.Context) (*Discovery, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.target.JoinPath(WellKnown).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	res := &Discovery{}
+	err = json.Unmarshal(buf, res)
+	return res, err
+}
+
+// Call calls the method at the path of the server with the request
+// and returns the response. If the server returns an error, the error
+// is a model.Error. Use json.RawMessage for T and U to call a method
+// with raw JSON.
+func Call[U, T any](ctx context.Context, c *Client, path string, request T) (U, error) {
 	var result U
 	target := c.target.JoinPath(path)
 
@@ -146,14 +294,18 @@ func Call[U, T any](c *Client, path string, request T) (U, error) {
 	if err != nil {
 		return result, err
 	}
-	sbuf := bytes.NewBuffer(rbuf)
 
-	res, err := c.Post(target.String(), "application/json", sbuf)
-	var buf []byte
-	_, err = res.Body.Read(buf)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.String(), bytes.NewReader(rbuf))
+	if err != nil {
+		return result, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	buf, err := c.do(req)
 	if err != nil {
 		return result, err
 	}
+
 	err = json.Unmarshal(buf, &result)
 	if err != nil {
 		return result, err
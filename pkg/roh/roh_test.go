@@ -1,12 +1,45 @@
 package roh
 
+import "context"
+import "errors"
+import "net"
+import "net/http"
+import "strings"
 import "testing"
+import "time"
 
-func TestNewServer(t *testing.T) {
-	srv := NewServer("localhost:2044")
+import "aidanwoods.dev/go-paseto"
+
+import "github.com/qrochet/qrochet/pkg/limit"
+import "github.com/qrochet/qrochet/pkg/model"
+
+// serve serves the server on a free local port until the test ends
+// and returns the URL of the server.
+func serve(t *testing.T, srv *Server) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %s", err)
+	}
 	t.Cleanup(func() { _ = srv.Close() })
-	go srv.ListenAndServe()
-	_ = <-srv.State
+	go srv.Serve(l)
+	return "http://" + l.Addr().String()
+}
+
+func TestNewServer(t *testing.T) {
+	srv := NewServer("localhost:0")
+	url := serve(t, srv)
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatalf("Dial: %s", err)
+	}
+	defer conn.Close()
+
+	state := <-srv.State
+	if state != http.StateNew {
+		t.Errorf("State: %s", state)
+	}
 }
 
 func TestNewClient(t *testing.T) {
@@ -14,46 +47,235 @@ func TestNewClient(t *testing.T) {
 	if err != nil {
 		t.Fatalf("NewClient: %s", err)
 	}
-	_ = client
+	if client.Timeout != DefaultTimeout {
+		t.Errorf("Timeout: %s", client.Timeout)
+	}
 }
 
 type RequestTest struct {
-	Name string `json:name`
-	ID   int    `json:id`
+	Name string `json:"name"`
+	ID   int    `json:"id"`
 }
 
 type ResponseTest struct {
-	Names []string `json:names`
-	IDs   []int    `json:ids`
+	Names []string `json:"names"`
+	IDs   []int    `json:"ids"`
 }
 
 func TestInteraction(t *testing.T) {
-	ready := make(chan (struct{}))
+	srv := NewServer("localhost:0")
+	Register(srv, "/request", func(ctx context.Context, request RequestTest) (ResponseTest, error) {
+		if request.Name == "" {
+			return ResponseTest{}, model.Error{Code: http.StatusUnprocessableEntity, Message: "name missing"}
+		}
+		if request.Name == "broken" {
+			return ResponseTest{}, errors.New("bucket qro-secret unavailable")
+		}
+		if request.Name == "unknown" {
+			return ResponseTest{}, model.ErrorCraftNotFound
+		}
+		return ResponseTest{Names: []string{request.Name, "two"}, IDs: []int{request.ID}}, nil
+	})
+	url := serve(t, srv)
 
-	srv := NewServer("localhost:2044")
-	t.Cleanup(func() { _ = srv.Close() })
+	client, err := NewClient(url)
+	if err != nil {
+		t.Fatalf("NewClient: %s", err)
+	}
+	ctx := context.Background()
+
+	re, err := Call[ResponseTest](ctx, client, "/request", RequestTest{Name: "one", ID: 7})
+	if err != nil {
+		t.Fatalf("Call: %s", err)
+	}
+	if len(re.Names) != 2 || re.Names[0] != "one" || re.Names[1] != "two" || len(re.IDs) != 1 || re.IDs[0] != 7 {
+		t.Fatalf("Call response: %v", re)
+	}
+
+	_, err = Call[ResponseTest](ctx, client, "/request", RequestTest{})
+	var merr model.Error
+	if !errors.As(err, &merr) || merr.Code != http.StatusUnprocessableEntity || merr.Message != "name missing" {
+		t.Fatalf("Call error: %v", err)
+	}
+
+	// Internal errors are not shown to clients.
+	_, err = Call[ResponseTest](ctx, client, "/request", RequestTest{Name: "broken"})
+	if !errors.As(err, &merr) || merr.Code != http.StatusInternalServerError || strings.Contains(merr.Message, "secret") {
+		t.Fatalf("Call internal error: %v", err)
+	}
+	_, err = Call[ResponseTest](ctx, client, "/request", RequestTest{Name: "unknown"})
+	if !errors.As(err, &merr) || merr.Code != http.StatusNotFound || merr.Message != model.ErrorCraftNotFound.Error() {
+		t.Fatalf("Call model error: %v", err)
+	}
+
+	_, err = Call[ResponseTest](ctx, client, "/nothing", RequestTest{})
+	if !errors.As(err, &merr) || merr.Code != http.StatusNotFound {
+		t.Fatalf("Call not found: %v", err)
+	}
+}
 
-	Register(srv, "/request", func(request RequestTest) (ResponseTest, error) {
-		return ResponseTest{Names: []string{"one", "two"}}, nil
+func TestMaxBody(t *testing.T) {
+	srv := NewServer("localhost:0")
+	srv.MaxBody = 16
+	Register(srv, "/request", func(ctx context.Context, request RequestTest) (ResponseTest, error) {
+		return ResponseTest{}, nil
 	})
+	url := serve(t, srv)
 
-	go func() {
-		go srv.ListenAndServe()
-		ready <- struct{}{}
-	}()
-	_ = <-ready
+	client, err := NewClient(url)
+	if err != nil {
+		t.Fatalf("NewClient: %s", err)
+	}
 
-	client, err := NewClient("http://localhost:2044")
+	_, err = Call[ResponseTest](context.Background(), client, "/request", RequestTest{Name: strings.Repeat("x", 32)})
+	var merr model.Error
+	if !errors.As(err, &merr) || merr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Call too large: %v", err)
+	}
+}
+
+func TestContext(t *testing.T) {
+	srv := NewServer("localhost:0")
+	url := serve(t, srv)
+
+	client, err := NewClient(url)
+	if err != nil {
+		t.Fatalf("NewClient: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = Call[ResponseTest](ctx, client, "/request", RequestTest{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Call canceled: %v", err)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	srv := NewServer("localhost:0")
+	Register(srv, "/request", func(ctx context.Context, request RequestTest) (ResponseTest, error) {
+		return ResponseTest{}, nil
+	})
+	url := serve(t, srv)
+
+	client, err := NewClient(url)
 	if err != nil {
 		t.Fatalf("NewClient: %s", err)
 	}
-	request := RequestTest{}
-	re, err := Call[ResponseTest](client, "/request", request)
+
+	disc, err := client.Discover(context.Background())
 	if err != nil {
-		t.Fatalf("Call: %s", err)
+		t.Fatalf("Discover: %s", err)
 	}
-	if len(re.Names) != 2 || re.Names[0] != "one " || re.Names[1] != "two" {
-		t.Fatalf("Call response: %v", re)
+	if len(disc.Methods) != 1 || disc.Methods[0].Path != "/request" {
+		t.Fatalf("Discover methods: %v", disc.Methods)
+	}
+	req := disc.Methods[0].Request
+	if req.Type != "object" || req.Properties["name"].Type != "string" || req.Properties["id"].Type != "integer" {
+		t.Errorf("Discover request: %+v", req)
+	}
+	res := disc.Methods[0].Response
+	if res.Properties["names"].Type != "array" || res.Properties["names"].Items.Type != "string" {
+		t.Errorf("Discover response: %+v", res)
+	}
+}
+
+func TestSchemaFor(t *testing.T) {
+	s := SchemaFor[model.Craft]()
+	if s.Title != "Craft" || s.Properties["tags"].Type != "array" || s.Properties["image"].Type != "string" {
+		t.Errorf("SchemaFor Craft: %+v", s)
+	}
+
+	s = SchemaFor[model.User]()
+	if s.Properties["role"].Type != "string" {
+		t.Errorf("SchemaFor User role: %+v", s.Properties["role"])
+	}
+
+	s = SchemaFor[model.Session]()
+	if s.Properties["start"].Format != "date-time" {
+		t.Errorf("SchemaFor Session start: %+v", s.Properties["start"])
+	}
+}
+
+func TestAuth(t *testing.T) {
+	auth := PASETO{Key: paseto.NewV4SymmetricKey()}
+	srv := NewServer("localhost:0")
+	srv.Use(Logger(), Auth(auth))
+	Register(srv, "/whoami", func(ctx context.Context, request RequestTest) (ResponseTest, error) {
+		return ResponseTest{Names: []string{Subject(ctx)}}, nil
+	})
+	url := serve(t, srv)
+
+	client, err := NewClient(url)
+	if err != nil {
+		t.Fatalf("NewClient: %s", err)
 	}
+	ctx := context.Background()
 
+	_, err = Call[ResponseTest](ctx, client, "/whoami", RequestTest{})
+	var merr model.Error
+	if !errors.As(err, &merr) || merr.Code != http.StatusUnauthorized {
+		t.Fatalf("Call without token: %v", err)
+	}
+
+	_, err = client.Discover(ctx)
+	if err != nil {
+		t.Fatalf("Discover without token: %s", err)
+	}
+
+	client.SetToken(PASETO{Key: paseto.NewV4SymmetricKey()}.Token("mallory", time.Minute))
+	_, err = Call[ResponseTest](ctx, client, "/whoami", RequestTest{})
+	if !errors.As(err, &merr) || merr.Code != http.StatusUnauthorized {
+		t.Fatalf("Call with wrong key: %v", err)
+	}
+
+	client.SetToken(auth.Token("alice", time.Minute))
+	re, err := Call[ResponseTest](ctx, client, "/whoami", RequestTest{})
+	if err != nil {
+		t.Fatalf("Call with token: %s", err)
+	}
+	if len(re.Names) != 1 || re.Names[0] != "alice" {
+		t.Fatalf("Call subject: %v", re)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	proxies, err := limit.ParseProxies("127.0.0.1")
+	if err != nil {
+		t.Fatalf("ParseProxies: %s", err)
+	}
+	srv := NewServer("localhost:0")
+	srv.Use(RateLimit(limit.New([]limit.Policy{{Prefix: "/", Rate: 0.1, Burst: 1}}, proxies)))
+	Register(srv, "/request", func(ctx context.Context, request RequestTest) (ResponseTest, error) {
+		return ResponseTest{}, nil
+	})
+	url := serve(t, srv)
+
+	// The server trusts the local proxy, so the clients are told apart by
+	// their X-Forwarded-For header.
+	client := func(addr string) *Client {
+		client, err := NewClient(url)
+		if err != nil {
+			t.Fatalf("NewClient: %s", err)
+		}
+		client.Header.Set("X-Forwarded-For", addr)
+		return client
+	}
+	alice := client("192.0.2.1")
+	bob := client("192.0.2.2")
+	ctx := context.Background()
+
+	_, err = Call[ResponseTest](ctx, alice, "/request", RequestTest{})
+	if err != nil {
+		t.Fatalf("first Call: %s", err)
+	}
+	_, err = Call[ResponseTest](ctx, alice, "/request", RequestTest{})
+	var merr model.Error
+	if !errors.As(err, &merr) || merr.Code != http.StatusTooManyRequests {
+		t.Fatalf("second Call: %v", err)
+	}
+	_, err = Call[ResponseTest](ctx, bob, "/request", RequestTest{})
+	if err != nil {
+		t.Errorf("Call of another client: %s", err)
+	}
 }
@@ -0,0 +1,134 @@
+package roh
+
+import "encoding"
+import "encoding/json"
+import "reflect"
+import "sort"
+import "strings"
+import "time"
+
+// WellKnown is the path where a Server lists its methods.
+const WellKnown = "/.well-known/roh"
+
+// Schema is a JSON schema of a request or response type.
+// It only has the parts of JSON schema that roh generates.
+type Schema struct {
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// Method is a method of a Server with the schemas of its request and
+// response.
+type Method struct {
+	Path     string  `json:"path"`
+	Request  *Schema `json:"request"`
+	Response *Schema `json:"response"`
+}
+
+// Discovery is what a Server returns at WellKnown.
+type Discovery struct {
+	Methods []Method `json:"methods"`
+}
+
+var (
+	timeType            = reflect.TypeFor[time.Time]()
+	rawMessageType      = reflect.TypeFor[json.RawMessage]()
+	textMarshalerType   = reflect.TypeFor[encoding.TextMarshaler]()
+	jsonMarshalerType   = reflect.TypeFor[json.Marshaler]()
+	emptyInterfaceTypes = reflect.TypeFor[any]()
+)
+
+// SchemaFor returns the JSON schema of the type T, as encoding/json
+// would encode it.
+func SchemaFor[T any]() *Schema {
+	return schemaOf(reflect.TypeFor[T](), map[reflect.Type]bool{})
+}
+
+// schemaOf returns the schema of the type t. Types that are in seen
+// are recursive, and get an empty schema, which allows anything.
+func schemaOf(t reflect.Type, seen map[reflect.Type]bool) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t == rawMessageType || t == emptyInterfaceTypes:
+		return &Schema{}
+	case t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType):
+		return &Schema{Title: t.Name()}
+	case t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType):
+		return &Schema{Type: "string", Title: t.Name()}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: schemaOf(t.Elem(), seen)}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaOf(t.Elem(), seen)}
+	case reflect.Struct:
+		if seen[t] {
+			return &Schema{Title: t.Name()}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+
+		s := &Schema{Type: "object", Title: t.Name(), Properties: map[string]*Schema{}}
+		addFields(s, t, seen)
+		sort.Strings(s.Required)
+		return s
+	}
+	return &Schema{}
+}
+
+// addFields adds the exported fields of the struct type t to the schema,
+// following the rules of encoding/json for names and embedded structs.
+// Fields without omitempty are required.
+func addFields(s *Schema, t reflect.Type, seen map[reflect.Type]bool) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		ft := field.Type
+		for ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if field.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			addFields(s, ft, seen)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		s.Properties[name] = schemaOf(field.Type, seen)
+		if !strings.Contains(opts, "omitempty") && !strings.Contains(opts, "omitzero") {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
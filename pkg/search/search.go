@@ -0,0 +1,352 @@
+// Package search is a full-text search index for crafts.
+//
+// The index is an inverted index stored in a NATS key value bucket, so
+// all Qrochet servers that use the same NATS share it. For every term
+// there is a key with the postings, the crafts that contain the term and
+// how much weight it has in them. For every craft there is a key with
+// its terms, so the craft can be removed from the postings again when it
+// changes. Terms in the title weigh more than terms in the tags, which
+// weigh more than terms in the details.
+package search
+
+import "context"
+import "encoding/base64"
+import "encoding/json"
+import "errors"
+import "log/slog"
+import "math"
+import "sort"
+import "strings"
+
+import "github.com/nats-io/nats.go/jetstream"
+
+import "github.com/qrochet/qrochet/pkg/model"
+import "github.com/qrochet/qrochet/pkg/repo"
+
+const (
+	// TitleWeight is the weight of a term in the title of a craft.
+	TitleWeight = 3
+	// TagWeight is the weight of a term in the tags of a craft.
+	TagWeight = 2
+	// DetailWeight is the weight of a term in the details of a craft.
+	DetailWeight = 1
+)
+
+var (
+	// ErrorQueryEmpty means the query had no terms to search for.
+	ErrorQueryEmpty = errors.New("please enter some words to search for")
+)
+
+// Posting is an entry of the postings of a term.
+type Posting struct {
+	UserID string  `json:"user_id"`
+	Weight float64 `json:"weight"`
+}
+
+// document is what the index knows about an indexed craft.
+type document struct {
+	UserID string             `json:"user_id"`
+	Terms  map[string]float64 `json:"terms"`
+}
+
+// Result is a craft that matches a query.
+type Result struct {
+	ID     string  // ID is the ID of the craft.
+	UserID string  // UserID is the ID of the user who made the craft.
+	Score  float64 // Score is how well the craft matches, higher is better.
+}
+
+// Index is a full-text search index for crafts.
+type Index struct {
+	kv jetstream.KeyValue
+}
+
+// New returns an index that is stored in the key value bucket.
+func New(kv jetstream.KeyValue) *Index {
+	return &Index{kv: kv}
+}
+
+func termKey(term string) string {
+	return "t." + base64.RawURLEncoding.EncodeToString([]byte(term))
+}
+
+func docKey(craftID string) string {
+	return "d." + craftID
+}
+
+// weigh returns the weights of the terms of the craft.
+func weigh(craft model.Craft) map[string]float64 {
+	res := map[string]float64{}
+	for _, term := range Terms(craft.Title) {
+		res[term] += TitleWeight
+	}
+	for _, tag := range craft.Tags {
+		for _, term := range Terms(tag) {
+			res[term] += TagWeight
+		}
+	}
+	for _, term := range Terms(craft.Detail) {
+		res[term] += DetailWeight
+	}
+	return res
+}
+
+// updatePostings updates the postings of the term with fn.
+func (ix *Index) updatePostings(ctx context.Context, term string, fn func(map[string]Posting)) error {
+	return repo.UpdateKey(ctx, ix.kv, termKey(term), func(old []byte) ([]byte, error) {
+		postings := map[string]Posting{}
+		if old != nil {
+			err := json.Unmarshal(old, &postings)
+			if err != nil {
+				return nil, err
+			}
+		}
+		fn(postings)
+		if len(postings) == 0 {
+			return nil, nil
+		}
+		return json.Marshal(postings)
+	})
+}
+
+// getDocument returns what the index knows about the craft, or nil if
+// the craft is not indexed.
+func (ix *Index) getDocument(ctx context.Context, craftID string) (*document, error) {
+	entry, err := ix.kv.Get(ctx, docKey(craftID))
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	doc := &document{}
+	err = json.Unmarshal(entry.Value(), doc)
+	return doc, err
+}
+
+// Put adds the craft to the index, or updates it if it was indexed
+// before. Hidden crafts are removed from the index.
+func (ix *Index) Put(ctx context.Context, craft model.Craft) error {
+	if craft.Hidden {
+		return ix.Remove(ctx, craft.ID)
+	}
+
+	old, err := ix.getDocument(ctx, craft.ID)
+	if err != nil {
+		return err
+	}
+
+	doc := document{UserID: craft.UserID, Terms: weigh(craft)}
+	if old != nil {
+		for term := range old.Terms {
+			if _, ok := doc.Terms[term]; ok {
+				continue
+			}
+			err = ix.updatePostings(ctx, term, func(postings map[string]Posting) {
+				delete(postings, craft.ID)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for term, weight := range doc.Terms {
+		err = ix.updatePostings(ctx, term, func(postings map[string]Posting) {
+			postings[craft.ID] = Posting{UserID: craft.UserID, Weight: weight}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	buf, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	_, err = ix.kv.Put(ctx, docKey(craft.ID), buf)
+	return err
+}
+
+// Remove removes the craft with the ID from the index.
+func (ix *Index) Remove(ctx context.Context, craftID string) error {
+	doc, err := ix.getDocument(ctx, craftID)
+	if err != nil || doc == nil {
+		return err
+	}
+
+	for term := range doc.Terms {
+		err = ix.updatePostings(ctx, term, func(postings map[string]Posting) {
+			delete(postings, craftID)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return ix.kv.Purge(ctx, docKey(craftID))
+}
+
+// Count returns the amount of indexed crafts.
+func (ix *Index) Count(ctx context.Context) (int, error) {
+	lister, err := ix.kv.ListKeysFiltered(ctx, "d.*")
+	if err != nil {
+		return 0, err
+	}
+	defer lister.Stop()
+
+	count := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return count, ctx.Err()
+		case _, ok := <-lister.Keys():
+			if !ok {
+				return count, nil
+			}
+			count++
+		}
+	}
+}
+
+// Search returns at most amount crafts that match the query, best match
+// first. Crafts that contain more of the terms of the query rank higher,
+// as do crafts with rarer terms and crafts that contain the terms in more
+// important places.
+func (ix *Index) Search(ctx context.Context, query string, amount int) ([]Result, error) {
+	terms := Terms(query)
+	if len(terms) == 0 {
+		return nil, ErrorQueryEmpty
+	}
+
+	total, err := ix.Count(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	found := map[string]*Result{}
+	matched := map[string]int{}
+	seen := map[string]bool{}
+	for _, term := range terms {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+
+		entry, err := ix.kv.Get(ctx, termKey(term))
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		postings := map[string]Posting{}
+		err = json.Unmarshal(entry.Value(), &postings)
+		if err != nil {
+			slog.Error("search postings", "term", term, "err", err)
+			continue
+		}
+
+		idf := math.Log(1 + float64(total)/float64(len(postings)))
+		for id, posting := range postings {
+			res, ok := found[id]
+			if !ok {
+				res = &Result{ID: id, UserID: posting.UserID}
+				found[id] = res
+			}
+			res.Score += (1 + math.Log(posting.Weight)) * idf
+			matched[id]++
+		}
+	}
+
+	res := make([]Result, 0, len(found))
+	for id, r := range found {
+		r.Score *= float64(matched[id]) / float64(len(seen))
+		res = append(res, *r)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Score != res[j].Score {
+			return res[i].Score > res[j].Score
+		}
+		return res[i].ID > res[j].ID
+	})
+	if amount > 0 && len(res) > amount {
+		res = res[:amount]
+	}
+	return res, nil
+}
+
+// Watch keeps the index up to date with the crafts until the context is
+// done. Deleted and hidden crafts are removed from the index. If rebuild
+// is true, the index is cleared and all crafts are indexed again first.
+// The index is cleared before the watch starts, and the watch then yields
+// the current crafts before their changes, so no craft is missed.
+func (ix *Index) Watch(ctx context.Context, crafts model.CraftMapper, rebuild bool) {
+	if rebuild {
+		err := ix.clear(ctx)
+		if err != nil {
+			slog.Error("search clear", "err", err)
+		}
+	}
+	for change, err := range crafts.Changes(ctx, rebuild) {
+		if err != nil {
+			slog.Error("search Watch", "err", err)
+			continue
+		}
+		if change.Deleted {
+			// Crafts are keyed by userID.craftID.
+			_, craftID, _ := strings.Cut(change.Key, ".")
+			err = ix.Remove(ctx, craftID)
+		} else {
+			err = ix.Put(ctx, change.Value)
+		}
+		if err != nil {
+			slog.Error("search update", "key", change.Key, "err", err)
+		}
+	}
+}
+
+// clear removes everything from the index.
+func (ix *Index) clear(ctx context.Context) error {
+	lister, err := ix.kv.ListKeys(ctx)
+	if err != nil {
+		return err
+	}
+	var keys []string
+	for key := range lister.Keys() {
+		keys = append(keys, key)
+	}
+	lister.Stop()
+
+	for _, key := range keys {
+		err = ix.kv.Purge(ctx, key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rebuild removes everything from the index and indexes all crafts again.
+// It returns the amount of indexed crafts.
+func (ix *Index) Rebuild(ctx context.Context, crafts model.CraftMapper) (int, error) {
+	err := ix.clear(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for craft, err := range crafts.All(ctx) {
+		if err != nil {
+			return count, err
+		}
+		if craft.Hidden {
+			continue
+		}
+		err = ix.Put(ctx, craft)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,160 @@
+package search
+
+import "context"
+import "testing"
+import "time"
+
+import "github.com/qrochet/qrochet/pkg/model"
+import "github.com/qrochet/qrochet/pkg/repo"
+
+// newIndex returns an index and the crafts of a new repository in a
+// temporary directory.
+func newIndex(t *testing.T) (*Index, model.CraftMapper) {
+	t.Helper()
+	r, err := repo.Open("nats+builtin://" + t.TempDir())
+	if err != nil {
+		t.Fatalf("repo.Open: %s", err)
+	}
+	t.Cleanup(r.Close)
+	kv, err := r.Bucket(context.Background(), "search")
+	if err != nil {
+		t.Fatalf("Bucket: %s", err)
+	}
+	return New(kv), r.Craft()
+}
+
+// ids returns the IDs of the results.
+func ids(results []Result) []string {
+	var res []string
+	for _, r := range results {
+		res = append(res, r.ID)
+	}
+	return res
+}
+
+func TestIndex(t *testing.T) {
+	ix, _ := newIndex(t)
+	ctx := context.Background()
+
+	crafts := []model.Craft{
+		{ID: "1", UserID: "alice", Title: "Red scarf", Tags: []string{"wool"}},
+		{ID: "2", UserID: "bob", Title: "Blue hat", Detail: "Goes with a scarf."},
+		{ID: "3", UserID: "carol", Title: "Scarf and hat"},
+		{ID: "4", UserID: "dave", Title: "Secret scarf", Hidden: true},
+	}
+	for _, craft := range crafts {
+		err := ix.Put(ctx, craft)
+		if err != nil {
+			t.Fatalf("Put: %s", err)
+		}
+	}
+	count, err := ix.Count(ctx)
+	if err != nil || count != 3 {
+		t.Fatalf("Count: %v %d", err, count)
+	}
+
+	res, err := ix.Search(ctx, "scarf", 10)
+	if err != nil || len(res) != 3 || res[2].ID != "2" {
+		t.Errorf("Search scarf: %v %v, the title matches should rank first", err, ids(res))
+	}
+	res, err = ix.Search(ctx, "scarf hat", 10)
+	if err != nil || len(res) != 3 || res[0].ID != "3" {
+		t.Errorf("Search scarf hat: %v %v, the craft with both terms should rank first", err, ids(res))
+	}
+	res, err = ix.Search(ctx, "scarf", 1)
+	if err != nil || len(res) != 1 {
+		t.Errorf("Search with amount: %v %v", err, ids(res))
+	}
+	_, err = ix.Search(ctx, "the", 10)
+	if err != ErrorQueryEmpty {
+		t.Errorf("Search without terms: %v", err)
+	}
+
+	// Changed terms are removed from the postings.
+	err = ix.Put(ctx, model.Craft{ID: "1", UserID: "alice", Title: "Red mittens"})
+	if err != nil {
+		t.Fatalf("Put changed: %s", err)
+	}
+	res, _ = ix.Search(ctx, "wool", 10)
+	if len(res) != 0 {
+		t.Errorf("Search old term: %v", ids(res))
+	}
+	err = ix.Put(ctx, model.Craft{ID: "3", UserID: "carol", Title: "Scarf and hat", Hidden: true})
+	if err != nil {
+		t.Fatalf("Put hidden: %s", err)
+	}
+	err = ix.Remove(ctx, "2")
+	if err != nil {
+		t.Fatalf("Remove: %s", err)
+	}
+	res, _ = ix.Search(ctx, "scarf hat", 10)
+	if len(res) != 0 {
+		t.Errorf("Search after hiding and removing: %v", ids(res))
+	}
+}
+
+// waitFor waits until the search for the query finds the IDs.
+func waitFor(t *testing.T, ix *Index, query string, want ...string) {
+	t.Helper()
+	var got []string
+	for range 100 {
+		res, err := ix.Search(context.Background(), query, 10)
+		got = ids(res)
+		if err == nil && len(got) == len(want) {
+			match := true
+			for i := range want {
+				match = match && got[i] == want[i]
+			}
+			if match {
+				return
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("Search %q: %v, want %v", query, got, want)
+}
+
+func TestWatchRebuild(t *testing.T) {
+	ix, crafts := newIndex(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	put := func(craft model.Craft) {
+		_, err := crafts.Put(ctx, craft.ID, craft)
+		if err != nil {
+			t.Fatalf("Put: %s", err)
+		}
+	}
+	put(model.Craft{ID: "1", UserID: "alice", Title: "Red scarf"})
+	put(model.Craft{ID: "2", UserID: "bob", Title: "Blue scarf", Hidden: true})
+	// A stale entry that the rebuild should remove.
+	err := ix.Put(ctx, model.Craft{ID: "9", UserID: "zed", Title: "Old scarf"})
+	if err != nil {
+		t.Fatalf("Put stale: %s", err)
+	}
+
+	count, err := ix.Rebuild(ctx, crafts)
+	if err != nil || count != 1 {
+		t.Fatalf("Rebuild: %v %d", err, count)
+	}
+	waitFor(t, ix, "scarf", "1")
+
+	err = ix.Put(ctx, model.Craft{ID: "9", UserID: "zed", Title: "Old scarf"})
+	if err != nil {
+		t.Fatalf("Put stale: %s", err)
+	}
+	go ix.Watch(ctx, crafts, true)
+	waitFor(t, ix, "scarf", "1")
+
+	put(model.Craft{ID: "3", UserID: "carol", Title: "Green scarf"})
+	waitFor(t, ix, "scarf", "3", "1")
+
+	err = crafts.Delete(ctx, "alice.1")
+	if err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+	waitFor(t, ix, "scarf", "3")
+
+	put(model.Craft{ID: "3", UserID: "carol", Title: "Green scarf", Hidden: true})
+	waitFor(t, ix, "scarf")
+}
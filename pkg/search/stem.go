@@ -0,0 +1,174 @@
+package search
+
+import "strings"
+import "unicode"
+
+import "golang.org/x/text/unicode/norm"
+
+// stopwords are common English words that are not indexed.
+var stopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true,
+	"at": true, "be": true, "by": true, "for": true, "from": true,
+	"in": true, "is": true, "it": true, "its": true, "of": true,
+	"on": true, "or": true, "that": true, "the": true, "this": true,
+	"to": true, "was": true, "with": true, "my": true, "i": true,
+}
+
+// Normalize normalises a word for indexing: compatibility characters are
+// decomposed, accents are removed and letters are lower cased.
+func Normalize(word string) string {
+	b := strings.Builder{}
+	for _, r := range norm.NFKD.String(word) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// Terms splits the text into words, and returns the stemmed terms of the
+// words in order, leaving out stop words.
+func Terms(text string) []string {
+	words := strings.FieldsFunc(Normalize(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var res []string
+	for _, word := range words {
+		if stopwords[word] {
+			continue
+		}
+		res = append(res, Stem(word))
+	}
+	return res
+}
+
+func isVowel(word []rune, i int) bool {
+	switch word[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return true
+	case 'y':
+		return i > 0 && !isVowel(word, i-1)
+	}
+	return false
+}
+
+// measure returns the amount of vowel consonant sequences in the word,
+// the m of Porter's algorithm.
+func measure(word []rune) int {
+	m := 0
+	vowel := false
+	for i := range word {
+		if isVowel(word, i) {
+			vowel = true
+		} else if vowel {
+			m++
+			vowel = false
+		}
+	}
+	return m
+}
+
+func hasVowel(word []rune) bool {
+	for i := range word {
+		if isVowel(word, i) {
+			return true
+		}
+	}
+	return false
+}
+
+// doubleConsonant returns true if the word ends in a double consonant.
+func doubleConsonant(word []rune) bool {
+	n := len(word)
+	return n > 1 && word[n-1] == word[n-2] && !isVowel(word, n-1)
+}
+
+// cvc returns true if the word ends in consonant vowel consonant, where
+// the last consonant is not w, x or y.
+func cvc(word []rune) bool {
+	n := len(word)
+	if n < 3 || isVowel(word, n-3) || !isVowel(word, n-2) || isVowel(word, n-1) {
+		return false
+	}
+	return !strings.ContainsRune("wxy", word[n-1])
+}
+
+// suffixes are the suffixes of the second steps of Porter's algorithm
+// and what they are replaced with, when the rest has a measure above 0.
+var suffixes = [][2]string{
+	{"ational", "ate"}, {"tional", "tion"}, {"enci", "ence"},
+	{"anci", "ance"}, {"izer", "ize"}, {"abli", "able"},
+	{"alli", "al"}, {"entli", "ent"}, {"eli", "e"}, {"ousli", "ous"},
+	{"ization", "ize"}, {"ation", "ate"}, {"ator", "ate"},
+	{"alism", "al"}, {"iveness", "ive"}, {"fulness", "ful"},
+	{"ousness", "ous"}, {"aliti", "al"}, {"iviti", "ive"},
+	{"biliti", "ble"}, {"icate", "ic"}, {"ative", ""}, {"alize", "al"},
+	{"iciti", "ic"}, {"ical", "ic"}, {"ful", ""}, {"ness", ""},
+}
+
+// Stem returns the stem of a normalised English word, so that for example
+// "knitting", "knitted" and "knits" all become "knit". It implements the
+// first and second steps of Porter's stemming algorithm, which is enough
+// for search and keeps stems readable. Words with other letters than a to
+// z are returned as they are.
+func Stem(word string) string {
+	if len(word) < 3 || strings.IndexFunc(word, func(r rune) bool { return r < 'a' || r > 'z' }) >= 0 {
+		return word
+	}
+
+	// Step 1a: plurals.
+	switch {
+	case strings.HasSuffix(word, "sses"):
+		word = word[:len(word)-2]
+	case strings.HasSuffix(word, "ies"):
+		word = word[:len(word)-2]
+	case strings.HasSuffix(word, "ss"):
+	case strings.HasSuffix(word, "s"):
+		word = word[:len(word)-1]
+	}
+
+	// Step 1b: past tenses and gerunds.
+	w := []rune(word)
+	if strings.HasSuffix(word, "eed") {
+		if measure(w[:len(w)-3]) > 0 {
+			word = word[:len(word)-1]
+		}
+	} else {
+		for _, suffix := range []string{"ed", "ing"} {
+			stem, ok := strings.CutSuffix(word, suffix)
+			if !ok || !hasVowel([]rune(stem)) {
+				continue
+			}
+			word = stem
+			s := []rune(stem)
+			switch {
+			case strings.HasSuffix(stem, "at"), strings.HasSuffix(stem, "bl"), strings.HasSuffix(stem, "iz"):
+				word += "e"
+			case doubleConsonant(s) && !strings.ContainsRune("lsz", s[len(s)-1]):
+				word = word[:len(word)-1]
+			case measure(s) == 1 && cvc(s):
+				word += "e"
+			}
+			break
+		}
+	}
+
+	// Step 1c: y to i.
+	if stem, ok := strings.CutSuffix(word, "y"); ok && hasVowel([]rune(stem)) {
+		word = stem + "i"
+	}
+
+	// Step 2: double suffixes.
+	for _, suffix := range suffixes {
+		stem, ok := strings.CutSuffix(word, suffix[0])
+		if !ok {
+			continue
+		}
+		if measure([]rune(stem)) > 0 {
+			word = stem + suffix[1]
+		}
+		break
+	}
+	return word
+}
@@ -0,0 +1,39 @@
+package search
+
+import "slices"
+import "testing"
+
+func TestStem(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{"knitting", "knit"},
+		{"knitted", "knit"},
+		{"knits", "knit"},
+		{"crocheted", "crochet"},
+		{"crocheting", "crochet"},
+		{"ponies", "poni"},
+		{"pony", "poni"},
+		{"hopping", "hop"},
+		{"hoping", "hope"},
+		{"caresses", "caress"},
+		{"relational", "relate"},
+		{"yarn", "yarn"},
+		{"übergröße", "übergröße"},
+	}
+	for _, c := range cases {
+		out := Stem(c.in)
+		if out != c.out {
+			t.Errorf("Stem(%q) = %q, expected %q", c.in, out, c.out)
+		}
+	}
+}
+
+func TestTerms(t *testing.T) {
+	out := Terms("The Knitted Scarves, for a cosy Café!")
+	expected := []string{"knit", "scarve", "cosi", "cafe"}
+	if !slices.Equal(out, expected) {
+		t.Errorf("Terms: %v, expected %v", out, expected)
+	}
+}
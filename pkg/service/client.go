@@ -0,0 +1,112 @@
+package service
+
+import "context"
+import "encoding/json"
+import "net/http"
+import "strconv"
+import "time"
+
+import nats "github.com/nats-io/nats.go"
+import "github.com/nats-io/nats.go/micro"
+
+import "github.com/qrochet/qrochet/pkg/model"
+
+// Client calls the logic service over NATS.
+type Client struct {
+	*nats.Conn
+	// Token is the bearer token that the client authenticates with.
+	Token string
+	// Timeout is the timeout of a request if ctx has no deadline.
+	Timeout time.Duration
+}
+
+// NewClient returns a client that calls the logic service over nc.
+func NewClient(nc *nats.Conn) *Client {
+	return &Client{Conn: nc, Timeout: DefaultTimeout}
+}
+
+// Call sends the request as JSON to the subject and decodes the JSON
+// response. Service errors are returned as a model.Error. The client of
+// ctx, see model.WithClient, is sent along in the headers.
+func Call[U, T any](ctx context.Context, c *Client, subject string, request T) (U, error) {
+	var response U
+	body, err := json.Marshal(request)
+	if err != nil {
+		return response, err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	msg := nats.NewMsg(subject)
+	msg.Data = body
+	if c.Token != "" {
+		msg.Header.Set(AuthorizationHeader, "Bearer "+c.Token)
+	}
+	client := model.ClientOf(ctx)
+	if client.IP != "" {
+		msg.Header.Set(ClientIPHeader, client.IP)
+	}
+	if client.UserAgent != "" {
+		msg.Header.Set(ClientUserAgentHeader, client.UserAgent)
+	}
+	reply, err := c.Conn.RequestMsgWithContext(ctx, msg)
+	if err != nil {
+		return response, err
+	}
+
+	if code := reply.Header.Get(micro.ErrorCodeHeader); code != "" {
+		merr := model.Error{Message: reply.Header.Get(micro.ErrorHeader)}
+		merr.Code, err = strconv.Atoi(code)
+		if err != nil {
+			merr.Code = http.StatusInternalServerError
+		}
+		return response, merr
+	}
+
+	err = json.Unmarshal(reply.Data, &response)
+	return response, err
+}
+
+// Register registers a new user.
+func (c *Client) Register(ctx context.Context, name, email, password string) (model.User, error) {
+	return Call[model.User](ctx, c, SubjectRegister, Registration{Name: name, Email: email, Password: password})
+}
+
+// Login logs in and sets the token of the client to the token of the
+// new session. If the user has two factor authentication enabled, the
+// accept only has a ticket, and the log in must be completed with
+// LoginCode.
+func (c *Client) Login(ctx context.Context, email, password string) (model.Accept, error) {
+	accept, err := Call[model.Accept](ctx, c, SubjectLogin, Credentials{Email: email, Password: password})
+	if err != nil {
+		return accept, err
+	}
+	c.Token = accept.Token
+	return accept, nil
+}
+
+// LoginCode completes a log in with the ticket of Login and a code of the
+// authenticator app or a recovery code.
+func (c *Client) LoginCode(ctx context.Context, ticket, code string) (model.Accept, error) {
+	accept, err := Call[model.Accept](ctx, c, SubjectLogin, Credentials{Ticket: ticket, Code: code})
+	if err != nil {
+		return accept, err
+	}
+	c.Token = accept.Token
+	return accept, nil
+}
+
+// NewCraft creates a craft for the logged in user. The image of the craft
+// must be an upload of the user.
+func (c *Client) NewCraft(ctx context.Context, craft model.Craft) (model.Craft, error) {
+	return Call[model.Craft](ctx, c, SubjectCraftCreate, craft)
+}
+
+// Crafts lists the public crafts, newest first.
+func (c *Client) Crafts(ctx context.Context, query model.RangeQuery[model.Craft]) (model.RangeResult[model.Craft], error) {
+	return Call[model.RangeResult[model.Craft]](ctx, c, SubjectCraftList, query)
+}
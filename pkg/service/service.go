@@ -0,0 +1,231 @@
+// package service exposes the operations of model.Logic as a NATS micro
+// service with JSON requests and responses, so several web front ends and
+// workers can share one logic tier.
+package service
+
+import "context"
+import "encoding/json"
+import "errors"
+import "log/slog"
+import "net/http"
+import "strconv"
+import "strings"
+import "time"
+
+import nats "github.com/nats-io/nats.go"
+import "github.com/nats-io/nats.go/micro"
+
+import "github.com/qrochet/qrochet/pkg/model"
+import "github.com/qrochet/qrochet/pkg/roh"
+
+const (
+	// Name is the name of the service.
+	Name = "qrochet-logic"
+
+	// Version is the version of the service.
+	Version = "1.0.0"
+
+	// Prefix is the prefix of the subjects of the service.
+	Prefix = "qrochet.logic"
+
+	// SessionTimeout is how long a session started by login lasts.
+	SessionTimeout = 24 * time.Hour
+
+	// DefaultTimeout is the default timeout of a Client request.
+	DefaultTimeout = 30 * time.Second
+)
+
+// Subjects of the endpoints of the service.
+const (
+	SubjectRegister    = Prefix + ".register"
+	SubjectLogin       = Prefix + ".login"
+	SubjectCraftCreate = Prefix + ".craft.create"
+	SubjectCraftList   = Prefix + ".craft.list"
+)
+
+// AuthorizationHeader is the header with the bearer token of a request.
+const AuthorizationHeader = "Authorization"
+
+// Headers with the client of the request that the caller serves, such as a
+// web front end, so the audit log shows who logged in or registered. Any
+// NATS user may set them, so they are only as trusted as the NATS server.
+const (
+	ClientIPHeader        = "Qrochet-Client-IP"
+	ClientUserAgentHeader = "Qrochet-Client-User-Agent"
+)
+
+// Registration is the request to register a new user.
+type Registration struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// Credentials is the request to log in.
+type Credentials struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Ticket   string `json:"ticket,omitempty"` // Ticket of the second step.
+	Code     string `json:"code,omitempty"`   // Code of the second step.
+}
+
+// Auth authenticates the tokens of requests and makes the tokens of new
+// sessions. *app.Qrochet is an Auth, so the tokens of the service are the
+// same as the tokens of the web application.
+type Auth interface {
+	roh.Authenticator
+	SessionToken(session model.Session) string
+}
+
+// Service is the logic service.
+type Service struct {
+	micro.Service
+	logic *model.Logic
+	auth  Auth
+}
+
+// New adds the logic service to the NATS connection. The service handles
+// requests until ctx is done or Stop is called.
+func New(ctx context.Context, nc *nats.Conn, logic *model.Logic, auth Auth) (*Service, error) {
+	svc, err := micro.AddService(nc, micro.Config{
+		Name:        Name,
+		Version:     Version,
+		Description: "Qrochet logic: register, login, create and list crafts.",
+	})
+	if err != nil {
+		return nil, err
+	}
+	s := &Service{Service: svc, logic: logic, auth: auth}
+
+	group := svc.AddGroup(Prefix)
+	endpoints := []struct {
+		name    string
+		subject string
+		handler micro.Handler
+	}{
+		{"register", "register", handle(ctx, s.register)},
+		{"login", "login", handle(ctx, s.login)},
+		{"craft-create", "craft.create", handle(ctx, s.createCraft)},
+		{"craft-list", "craft.list", handle(ctx, s.listCrafts)},
+	}
+	for _, ep := range endpoints {
+		err = group.AddEndpoint(ep.name, ep.handler, micro.WithEndpointSubject(ep.subject))
+		if err != nil {
+			_ = svc.Stop()
+			return nil, err
+		}
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = svc.Stop()
+	}()
+	return s, nil
+}
+
+// handle returns a handler that decodes the JSON request, calls cb and
+// responds with the JSON response, or with the error as a service error
+// with the status code and message of a model.Error. The client of the
+// headers of the request is put in the context of cb.
+func handle[T, U any](ctx context.Context, cb func(ctx context.Context, req micro.Request, request T) (U, error)) micro.Handler {
+	return micro.ContextHandler(ctx, func(ctx context.Context, req micro.Request) {
+		ctx = model.WithClient(ctx, model.Client{
+			IP:        req.Headers().Get(ClientIPHeader),
+			UserAgent: req.Headers().Get(ClientUserAgentHeader),
+		})
+
+		var request T
+		err := json.Unmarshal(req.Data(), &request)
+		if err != nil {
+			respondError(req, model.Error{Code: http.StatusBadRequest, Message: "invalid JSON request: " + err.Error()})
+			return
+		}
+		response, err := cb(ctx, req, request)
+		if err != nil {
+			respondError(req, err)
+			return
+		}
+		err = req.RespondJSON(response)
+		if err != nil {
+			slog.Error("service respond", "subject", req.Subject(), "err", err)
+		}
+	})
+}
+
+// respondError responds with the error as a service error.
+func respondError(req micro.Request, err error) {
+	merr, ok := model.AsError(err)
+	if !ok {
+		slog.Error("service", "subject", req.Subject(), "err", err)
+	}
+	body, _ := json.Marshal(merr)
+	err = req.Error(strconv.Itoa(merr.Code), merr.Message, body)
+	if err != nil {
+		slog.Error("service respond error", "subject", req.Subject(), "err", err)
+	}
+}
+
+// session returns the session of the bearer token of the request.
+func (s *Service) session(ctx context.Context, req micro.Request) (*model.Session, error) {
+	token, ok := strings.CutPrefix(req.Headers().Get(AuthorizationHeader), "Bearer ")
+	if !ok {
+		return nil, model.ErrorPleaseLogIn
+	}
+	userID, err := s.auth.Authenticate(ctx, strings.TrimSpace(token))
+	if err != nil || userID == "" {
+		return nil, model.ErrorPleaseLogIn
+	}
+	session := &model.Session{UserID: userID}
+	_, err = s.logic.CheckSession(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (s *Service) register(ctx context.Context, req micro.Request, reg Registration) (model.User, error) {
+	user, err := s.logic.Register(ctx, reg.Name, reg.Email, reg.Password)
+	if err != nil {
+		return model.User{}, err
+	}
+	return user.Redact(), nil
+}
+
+func (s *Service) login(ctx context.Context, req micro.Request, creds Credentials) (model.Accept, error) {
+	var user *model.User
+	var session *model.Session
+	var err error
+	if creds.Ticket != "" {
+		user, session, err = s.logic.LoginSecondFactor(ctx, creds.Ticket, creds.Code, SessionTimeout)
+	} else {
+		user, session, err = s.logic.Login(ctx, creds.Email, creds.Password, SessionTimeout)
+	}
+	var second *model.SecondFactorError
+	if errors.As(err, &second) {
+		return model.Accept{Ticket: second.Ticket}, nil
+	}
+	if err != nil {
+		return model.Accept{}, err
+	}
+	return model.Accept{Self: user.Redact(), Token: s.auth.SessionToken(*session)}, nil
+}
+
+func (s *Service) createCraft(ctx context.Context, req micro.Request, craft model.Craft) (model.Craft, error) {
+	session, err := s.session(ctx, req)
+	if err != nil {
+		return model.Craft{}, err
+	}
+	created, err := s.logic.NewCraft(ctx, session, craft)
+	if err != nil {
+		return model.Craft{}, err
+	}
+	return *created, nil
+}
+
+func (s *Service) listCrafts(ctx context.Context, req micro.Request, query model.RangeQuery[model.Craft]) (model.RangeResult[model.Craft], error) {
+	result, err := s.logic.PublicCrafts(ctx, query)
+	if err != nil {
+		return model.RangeResult[model.Craft]{}, err
+	}
+	return *result, nil
+}
@@ -0,0 +1,285 @@
+package service
+
+import "bytes"
+import "context"
+import "errors"
+import "image"
+import "image/png"
+import "net/http"
+import "testing"
+import "time"
+
+import "aidanwoods.dev/go-paseto"
+
+import "github.com/qrochet/qrochet/pkg/model"
+import "github.com/qrochet/qrochet/pkg/repo"
+import "github.com/qrochet/qrochet/pkg/roh"
+
+// testAuth makes and checks PASETO tokens like the app does.
+type testAuth struct {
+	roh.PASETO
+}
+
+func (a testAuth) SessionToken(session model.Session) string {
+	return a.Token(session.UserID, time.Until(session.End))
+}
+
+func TestService(t *testing.T) {
+	r, err := repo.Open("nats+builtin://" + t.TempDir())
+	if err != nil {
+		t.Fatalf("repo.Open: %s", err)
+	}
+	defer r.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logic := model.NewLogic(r, nil)
+	auth := testAuth{roh.PASETO{Key: paseto.NewV4SymmetricKey()}}
+	_, err = New(ctx, r.Conn, logic, auth)
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+	client := NewClient(r.Conn)
+
+	user, err := client.Register(ctx, "Alice", "alice@example.com", "wool and yarn")
+	if err != nil {
+		t.Fatalf("Register: %s", err)
+	}
+	if user.Name != "Alice" || user.Hash != "*REDACTED*" {
+		t.Errorf("Register user: %+v", user)
+	}
+
+	_, err = client.Register(ctx, "Alice", "alice@example.com", "wool and yarn")
+	var merr model.Error
+	if !errors.As(err, &merr) || merr.Code != http.StatusConflict {
+		t.Errorf("Register twice: %v", err)
+	}
+
+	_, err = client.Login(ctx, "alice@example.com", "wrong")
+	if !errors.As(err, &merr) || merr.Code != http.StatusUnauthorized {
+		t.Errorf("Login with wrong password: %v", err)
+	}
+
+	_, err = client.NewCraft(ctx, model.Craft{Title: "Scarf"})
+	if !errors.As(err, &merr) || merr.Code != http.StatusUnauthorized {
+		t.Errorf("NewCraft without login: %v", err)
+	}
+
+	accept, err := client.Login(ctx, "alice@example.com", "wool and yarn")
+	if err != nil {
+		t.Fatalf("Login: %s", err)
+	}
+	if accept.Self.ID != user.ID || accept.Token == "" || client.Token != accept.Token {
+		t.Fatalf("Login accept: %+v", accept)
+	}
+
+	buf := &bytes.Buffer{}
+	err = png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 4, 4)))
+	if err != nil {
+		t.Fatalf("png.Encode: %s", err)
+	}
+	upload, err := logic.NewUpload(ctx, &model.Session{UserID: user.ID}, "scarf", buf, int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("NewUpload: %s", err)
+	}
+
+	craft, err := client.NewCraft(ctx, model.Craft{Title: "Scarf", Image: upload.ID})
+	if err != nil {
+		t.Fatalf("NewCraft: %s", err)
+	}
+	if craft.ID == "" || craft.UserID != user.ID {
+		t.Errorf("NewCraft craft: %+v", craft)
+	}
+
+	result, err := client.Crafts(ctx, model.RangeQuery[model.Craft]{})
+	if err != nil {
+		t.Fatalf("Crafts: %s", err)
+	}
+	if len(result.Items) != 1 || result.Items[0].ID != craft.ID {
+		t.Errorf("Crafts result: %+v", result)
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	r, err := repo.Open("nats+builtin://" + t.TempDir())
+	if err != nil {
+		t.Fatalf("repo.Open: %s", err)
+	}
+	defer r.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logic := model.NewLogic(r, nil)
+	auth := testAuth{roh.PASETO{Key: paseto.NewV4SymmetricKey()}}
+	_, err = New(ctx, r.Conn, logic, auth)
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+	client := NewClient(r.Conn)
+
+	_, err = client.Register(ctx, "Bob", "bob@example.com", "hook and needle")
+	if err != nil {
+		t.Fatalf("Register: %s", err)
+	}
+
+	var merr model.Error
+	for range model.LoginFreeAttempts {
+		_, err = client.Login(ctx, "bob@example.com", "wrong")
+		if !errors.As(err, &merr) || merr.Code != http.StatusUnauthorized {
+			t.Fatalf("Login with wrong password: %v", err)
+		}
+	}
+
+	_, err = client.Login(ctx, "bob@example.com", "hook and needle")
+	if !errors.As(err, &merr) || merr.Code != http.StatusTooManyRequests {
+		t.Errorf("Login while throttled: %v", err)
+	}
+}
+
+func TestTwoFactor(t *testing.T) {
+	// RFC 6238 test vector for SHA1 at 59 seconds, with 6 digits.
+	secret := model.IDToKey([]byte("12345678901234567890"))
+	code, err := model.TOTPCode(secret, model.TOTPStep(time.Unix(59, 0)))
+	if err != nil || code != "287082" {
+		t.Fatalf("TOTPCode: %v %s", err, code)
+	}
+
+	r, err := repo.Open("nats+builtin://" + t.TempDir())
+	if err != nil {
+		t.Fatalf("repo.Open: %s", err)
+	}
+	defer r.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logic := model.NewLogic(r, nil)
+	auth := testAuth{roh.PASETO{Key: paseto.NewV4SymmetricKey()}}
+	_, err = New(ctx, r.Conn, logic, auth)
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+	client := NewClient(r.Conn)
+
+	user, err := client.Register(ctx, "Carol", "carol@example.com", "loops and chains")
+	if err != nil {
+		t.Fatalf("Register: %s", err)
+	}
+	session := &model.Session{UserID: user.ID}
+	secret, uri, err := logic.EnrollTOTP(ctx, session)
+	if err != nil || secret == "" || uri == "" {
+		t.Fatalf("EnrollTOTP: %v %s %s", err, secret, uri)
+	}
+	code, _ = model.TOTPCode(secret, model.TOTPStep(time.Now()))
+	recovery, err := logic.ConfirmTOTP(ctx, session, code)
+	if err != nil || len(recovery) != model.RecoveryCodes {
+		t.Fatalf("ConfirmTOTP: %v %v", err, recovery)
+	}
+
+	accept, err := client.Login(ctx, "carol@example.com", "loops and chains")
+	if err != nil || accept.Ticket == "" || accept.Token != "" {
+		t.Fatalf("Login: %v %+v", err, accept)
+	}
+
+	var merr model.Error
+	_, err = client.LoginCode(ctx, accept.Ticket, code)
+	if !errors.As(err, &merr) || merr.Code != http.StatusUnauthorized {
+		t.Errorf("LoginCode with used code: %v", err)
+	}
+
+	accept, err = client.LoginCode(ctx, accept.Ticket, recovery[0])
+	if err != nil || accept.Token == "" || accept.Self.ID != user.ID {
+		t.Fatalf("LoginCode with recovery code: %v %+v", err, accept)
+	}
+	if accept.Self.TOTP != "" || accept.Self.Recovery != nil {
+		t.Errorf("LoginCode user not redacted: %+v", accept.Self)
+	}
+}
+
+func TestTOTPRequired(t *testing.T) {
+	r, err := repo.Open("nats+builtin://" + t.TempDir())
+	if err != nil {
+		t.Fatalf("repo.Open: %s", err)
+	}
+	defer r.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logic := model.NewLogic(r, nil)
+	auth := testAuth{roh.PASETO{Key: paseto.NewV4SymmetricKey()}}
+	_, err = New(ctx, r.Conn, logic, auth)
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+	client := NewClient(r.Conn)
+
+	staff, err := client.Register(ctx, "Dave", "dave@example.com", "hooks and needles")
+	if err != nil {
+		t.Fatalf("Register: %s", err)
+	}
+	_, err = logic.ChangeRole(ctx, "", staff.ID, model.RoleStaff)
+	if err != nil {
+		t.Fatalf("ChangeRole: %s", err)
+	}
+	_, err = client.Register(ctx, "Erin", "erin@example.com", "purl and knit")
+	if err != nil {
+		t.Fatalf("Register: %s", err)
+	}
+	_, err = client.Login(ctx, "erin@example.com", "purl and knit")
+	if err != nil {
+		t.Fatalf("Login: %s", err)
+	}
+
+	_, err = logic.SetTOTPRequired(ctx, &model.Session{UserID: staff.ID}, model.RoleNone, true)
+	if err != nil {
+		t.Fatalf("SetTOTPRequired: %s", err)
+	}
+	var merr model.Error
+	_, err = client.NewCraft(ctx, model.Craft{Title: "Scarf"})
+	if !errors.As(err, &merr) || merr.Code != http.StatusForbidden || merr.Message != model.ErrorTOTPRequired.Error() {
+		t.Errorf("NewCraft without TOTP: %v", err)
+	}
+}
+
+func TestAuditClient(t *testing.T) {
+	r, err := repo.Open("nats+builtin://" + t.TempDir())
+	if err != nil {
+		t.Fatalf("repo.Open: %s", err)
+	}
+	defer r.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logic := model.NewLogic(r, nil)
+	auth := testAuth{roh.PASETO{Key: paseto.NewV4SymmetricKey()}}
+	_, err = New(ctx, r.Conn, logic, auth)
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+	client := NewClient(r.Conn)
+
+	// The front end passes on the client it serves.
+	web := model.Client{IP: "192.0.2.1", UserAgent: "Knitting Browser"}
+	user, err := client.Register(model.WithClient(ctx, web), "Carol", "carol@example.com", "hook and needle")
+	if err != nil {
+		t.Fatalf("Register: %s", err)
+	}
+	_, err = client.Login(model.WithClient(ctx, web), "carol@example.com", "hook and needle")
+	if err != nil {
+		t.Fatalf("Login: %s", err)
+	}
+
+	entries, err := logic.QueryAudits(ctx, model.AuditQuery{UserID: user.ID})
+	if err != nil || len(entries) < 2 {
+		t.Fatalf("QueryAudits: %v %+v", err, entries)
+	}
+	for _, entry := range entries {
+		if entry.IP != web.IP || entry.UserAgent != web.UserAgent {
+			t.Errorf("audit entry %s without the client: %+v", entry.Action, entry)
+		}
+	}
+}